@@ -0,0 +1,104 @@
+package hdr_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ffmpeg "github.com/veloxpack/tools/ffmpeg-lite"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+func TestHDR_ToneMap(t *testing.T) {
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	ctx := context.Background()
+
+	for _, transfer := range []string{ffmpeg.TransferPQ, ffmpeg.TransferHLG} {
+		t.Run(transfer, func(t *testing.T) {
+			// Given: A synthetic 10-bit BT.2020 clip tagged as HDR
+			input, err := fixture.Generate(ctx, outputPath, fixture.Video{
+				Name:     transfer + "-source.mp4",
+				Duration: 3,
+				OutputArgs: []string{
+					"-c:v", "libx265", "-preset", "ultrafast", "-pix_fmt", "yuv420p10le",
+					"-color_primaries", "bt2020", "-color_trc", transfer, "-colorspace", "bt2020nc",
+					"-x265-params", "log-level=error",
+				},
+			})
+			require.NoError(t, err)
+
+			// When: Encoding it to H.264 with tone mapping
+			path, err := ffmpeg.Encode(ctx, input, ffmpeg.EncodeOptions{
+				OutputDir: outputPath,
+				Name:      transfer + "-sdr.mp4",
+				HDR:       ffmpeg.HDRToneMap,
+			})
+			require.NoError(t, err)
+
+			// Then: The output is 8-bit SDR tagged BT.709 throughout
+			probe, err := ffprobe.Probe(ctx, path)
+			require.NoError(t, err)
+			video := probe.VideoStream()
+			require.NotNil(t, video)
+			assert.Equal(t, "h264", video.CodecName)
+			assert.Equal(t, "yuv420p", video.PixFmt)
+			assert.Equal(t, "bt709", video.ColorPrimaries)
+			assert.Equal(t, "bt709", video.ColorTransfer)
+			assert.Equal(t, "bt709", video.ColorSpace)
+			assert.Equal(t, "tv", video.ColorRange)
+			assert.Nil(t, ffmpeg.DetectHDR(video))
+			assert.Equal(t, 640, video.Width)
+			require.NoError(t, ffmpeg.Decode(ctx, path))
+		})
+	}
+}
+
+func TestHDR_ToneMap_SDRSource(t *testing.T) {
+	// Given: An SDR clip
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	input, err := fixture.Generate(ctx, outputPath, fixture.Video{Name: "sdr.mp4", Duration: 2})
+	require.NoError(t, err)
+
+	// When: Asking for tone mapping
+	path, err := ffmpeg.Encode(ctx, input, ffmpeg.EncodeOptions{
+		OutputDir: outputPath,
+		Name:      "out.mp4",
+		HDR:       ffmpeg.HDRToneMap,
+	})
+	require.NoError(t, err)
+
+	// Then: Nothing is converted and no colour tags are invented
+	probe, err := ffprobe.Probe(ctx, path)
+	require.NoError(t, err)
+	video := probe.VideoStream()
+	require.NotNil(t, video)
+	assert.Equal(t, "yuv420p", video.PixFmt)
+	assert.NotEqual(t, "bt709", video.ColorTransfer)
+}
+
+func createTempDir(t *testing.T) string {
+	outputPath, err := filepath.Abs(filepath.Join("..", "testdata", strings.ReplaceAll(uuid.NewString(), "-", "")))
+	require.NoError(t, err)
+
+	err = os.MkdirAll(outputPath, 0755)
+	require.NoError(t, err)
+
+	return outputPath
+}
+
+func cleanupFiles(t *testing.T, path string) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		t.Logf("failed to remove output directory: %s", path)
+	}
+}
@@ -0,0 +1,314 @@
+// Package audio measures and normalises loudness and transcodes audio with
+// the lite FFmpeg image.
+package audio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// Image is the lite FFmpeg image.
+const Image = "ghcr.io/veloxpack/ffmpeg:8.0-lite"
+
+// Integrated loudness targets in LUFS.
+const (
+	// TargetStreaming is the level most streaming and podcast platforms
+	// normalise to.
+	TargetStreaming = -16.0
+	// TargetBroadcast is the EBU R128 programme level.
+	TargetBroadcast = -23.0
+)
+
+// Loudness is an EBU R128 measurement.
+type Loudness struct {
+	// Integrated is the programme loudness in LUFS.
+	Integrated float64 `json:"integrated"`
+	// TruePeak is the highest inter-sample peak in dBTP.
+	TruePeak float64 `json:"true_peak"`
+	// LRA is the loudness range in LU.
+	LRA float64 `json:"lra"`
+	// Threshold is the relative gating threshold in LUFS.
+	Threshold float64 `json:"threshold"`
+}
+
+// LoudnormStats is the JSON summary the loudnorm filter prints at the end
+// of a run: the input measurement, the output estimate and the gain offset
+// still needed to hit the target.
+type LoudnormStats struct {
+	Input  Loudness `json:"input"`
+	Output Loudness `json:"output"`
+	// NormalizationType is "linear" or "dynamic"; loudnorm falls back to
+	// dynamic when a linear gain would overshoot the true peak target.
+	NormalizationType string  `json:"normalization_type"`
+	TargetOffset      float64 `json:"target_offset"`
+}
+
+// loudnormMaxLRA is the largest loudness range target loudnorm accepts.
+const loudnormMaxLRA = 50.0
+
+// Target is the loudness a file is normalised to.
+type Target struct {
+	// Integrated in LUFS; defaults to TargetStreaming.
+	Integrated float64 `json:"integrated"`
+	// TruePeak ceiling in dBTP; defaults to -1.5.
+	TruePeak float64 `json:"true_peak"`
+	// LRA is the loudness range target in LU; defaults to 11. Normalize
+	// raises it to the measured range so the gain stays linear, up to
+	// loudnorm's maximum of 50.
+	LRA float64 `json:"lra"`
+}
+
+func (t Target) withDefaults() Target {
+	if t.Integrated == 0 {
+		t.Integrated = TargetStreaming
+	}
+	if t.TruePeak == 0 {
+		t.TruePeak = -1.5
+	}
+	if t.LRA == 0 {
+		t.LRA = 11
+	}
+	return t
+}
+
+// filter returns the loudnorm filter for t, with LRA clamped to what
+// loudnorm accepts.
+func (t Target) filter() string {
+	lra := min(t.LRA, loudnormMaxLRA)
+	return fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s", formatFloat(t.Integrated), formatFloat(t.TruePeak), formatFloat(lra))
+}
+
+// MeasureArgs returns the FFmpeg arguments of the loudnorm analysis pass
+// over the first audio stream of the container path input. The summary is
+// printed to stderr.
+func MeasureArgs(input string, target Target) []string {
+	return []string{
+		"-hide_banner", "-nostats", "-i", input, "-map", "0:a:0", "-vn",
+		"-af", target.withDefaults().filter() + ":print_format=json",
+		"-f", "null", "-",
+	}
+}
+
+// NormalizeOptions configures Normalize.
+type NormalizeOptions struct {
+	// OutputDir is the host directory the output is written to.
+	OutputDir string
+	// Name is the output file name; defaults to "normalized.m4a". The
+	// extension picks the codec as for Transcode.
+	Name string
+	// Target defaults to TargetStreaming at -1.5 dBTP.
+	Target Target
+	// SampleRate of the output in Hz; defaults to 48000. loudnorm works at
+	// 192 kHz internally and would otherwise keep that rate.
+	SampleRate int
+	// Bitrate in kbit/s; defaults to 128.
+	Bitrate int
+}
+
+func (o NormalizeOptions) withDefaults() NormalizeOptions {
+	if o.Name == "" {
+		o.Name = "normalized.m4a"
+	}
+	o.Target = o.Target.withDefaults()
+	if o.SampleRate == 0 {
+		o.SampleRate = 48000
+	}
+	if o.Bitrate == 0 {
+		o.Bitrate = 128
+	}
+	return o
+}
+
+// NormalizeArgs returns the FFmpeg arguments of the second, linear loudnorm
+// pass, which applies the gain computed from the first pass measurement and
+// writes the result to /output. Silent input, which measures as -inf, has
+// no gain to apply and is an error.
+func NormalizeArgs(input string, measured *LoudnormStats, opts NormalizeOptions) ([]string, error) {
+	opts = opts.withDefaults()
+	c, err := containerFor(opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	m := measured.Input
+	for _, v := range []float64{m.Integrated, m.TruePeak, m.LRA, m.Threshold, measured.TargetOffset} {
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("audio: input is silent or too quiet to normalise (integrated %v LUFS, true peak %v dBTP)",
+				m.Integrated, m.TruePeak)
+		}
+	}
+	af := fmt.Sprintf("%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true:print_format=json",
+		opts.Target.filter(),
+		formatFloat(measured.Input.Integrated), formatFloat(measured.Input.TruePeak),
+		formatFloat(measured.Input.LRA), formatFloat(measured.Input.Threshold),
+		formatFloat(measured.TargetOffset))
+	return []string{
+		"-hide_banner", "-nostats", "-i", input, "-map", "0:a:0", "-vn",
+		"-af", af, "-ar", strconv.Itoa(opts.SampleRate),
+		"-c:a", c.codecs[0].encoder(), "-b:a", strconv.Itoa(opts.Bitrate) + "k",
+		"-f", c.format, "/output/" + opts.Name,
+	}, nil
+}
+
+// NormalizeResult is the outcome of Normalize.
+type NormalizeResult struct {
+	// Path is the host path of the normalised file.
+	Path string `json:"path"`
+	// Before and After are measured on the input and on the written
+	// output.
+	Before Loudness `json:"before"`
+	After  Loudness `json:"after"`
+	// Linear is false when loudnorm had to compress dynamically to stay
+	// under the true peak ceiling.
+	Linear bool `json:"linear"`
+}
+
+// Measure runs the loudnorm analysis pass over the first audio stream of
+// the host file at input.
+func Measure(ctx context.Context, input string) (*Loudness, error) {
+	stats, err := measure(ctx, input, Target{})
+	if err != nil {
+		return nil, err
+	}
+	return &stats.Input, nil
+}
+
+func measure(ctx context.Context, input string, target Target) (*LoudnormStats, error) {
+	containerInput := "/input/" + filepath.Base(input)
+	res, err := runner.Run(ctx, runner.Request{
+		Image: Image,
+		Cmd:   MeasureArgs(containerInput, target),
+		Files: []testcontainers.ContainerFile{runner.File(input, containerInput)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("audio: measure %s: %w", filepath.Base(input), err)
+	}
+	stats, err := ParseLoudnorm(res.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("audio: measure %s: %w", filepath.Base(input), err)
+	}
+	return stats, nil
+}
+
+// Normalize brings the first audio stream of the host file at input to the
+// target loudness in two passes: loudnorm measures the input, then applies
+// a single linear gain computed from that measurement, so the dynamics are
+// untouched. The output is measured again for the result.
+func Normalize(ctx context.Context, input string, opts NormalizeOptions) (*NormalizeResult, error) {
+	if opts.OutputDir == "" {
+		return nil, errors.New("audio: output directory is required")
+	}
+	opts = opts.withDefaults()
+
+	before, err := measure(ctx, input, opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	if before.Input.LRA > opts.Target.LRA {
+		// loudnorm only stays linear when the target range covers the
+		// measured one.
+		opts.Target.LRA = min(before.Input.LRA, loudnormMaxLRA)
+	}
+
+	containerInput := "/input/" + filepath.Base(input)
+	args, err := NormalizeArgs(containerInput, before, opts)
+	if err != nil {
+		return nil, err
+	}
+	res, err := runner.Run(ctx, runner.Request{
+		Image:  Image,
+		Cmd:    args,
+		Files:  []testcontainers.ContainerFile{runner.File(input, containerInput)},
+		Mounts: []mount.Mount{runner.Bind(opts.OutputDir, "/output")},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("audio: normalize: %w", err)
+	}
+	applied, err := ParseLoudnorm(res.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("audio: normalize: %w", err)
+	}
+
+	path := filepath.Join(opts.OutputDir, opts.Name)
+	after, err := Measure(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &NormalizeResult{
+		Path:   path,
+		Before: before.Input,
+		After:  *after,
+		Linear: applied.NormalizationType == "linear",
+	}, nil
+}
+
+// loudnormJSON mirrors the summary printed by loudnorm, which quotes every
+// number.
+type loudnormJSON struct {
+	InputI            string `json:"input_i"`
+	InputTP           string `json:"input_tp"`
+	InputLRA          string `json:"input_lra"`
+	InputThresh       string `json:"input_thresh"`
+	OutputI           string `json:"output_i"`
+	OutputTP          string `json:"output_tp"`
+	OutputLRA         string `json:"output_lra"`
+	OutputThresh      string `json:"output_thresh"`
+	NormalizationType string `json:"normalization_type"`
+	TargetOffset      string `json:"target_offset"`
+}
+
+// ParseLoudnorm extracts the loudnorm summary from FFmpeg's stderr, where
+// it follows the filter's log prefix as the last JSON object.
+func ParseLoudnorm(stderr string) (*LoudnormStats, error) {
+	end := strings.LastIndex(stderr, "}")
+	start := strings.LastIndex(stderr[:max(end, 0)], "{")
+	if start < 0 || end < 0 {
+		return nil, errors.New("no loudnorm summary in output")
+	}
+	var raw loudnormJSON
+	if err := json.Unmarshal([]byte(stderr[start:end+1]), &raw); err != nil {
+		return nil, fmt.Errorf("decode loudnorm summary: %w", err)
+	}
+
+	var errs []error
+	num := func(field, v string) float64 {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("loudnorm %s: %q", field, v))
+		}
+		return f
+	}
+	stats := &LoudnormStats{
+		Input: Loudness{
+			Integrated: num("input_i", raw.InputI),
+			TruePeak:   num("input_tp", raw.InputTP),
+			LRA:        num("input_lra", raw.InputLRA),
+			Threshold:  num("input_thresh", raw.InputThresh),
+		},
+		Output: Loudness{
+			Integrated: num("output_i", raw.OutputI),
+			TruePeak:   num("output_tp", raw.OutputTP),
+			LRA:        num("output_lra", raw.OutputLRA),
+			Threshold:  num("output_thresh", raw.OutputThresh),
+		},
+		NormalizationType: raw.NormalizationType,
+		TargetOffset:      num("target_offset", raw.TargetOffset),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,157 @@
+package audio_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/ffmpeg-lite/audio"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+const loudnormStderr = `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from '/input/in.mp4':
+  Duration: 00:00:10.00, start: 0.000000, bitrate: 138 kb/s
+[Parsed_loudnorm_0 @ 0x7f3c2c0]
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-16.58",
+	"output_tp" : "-1.50",
+	"output_lra" : "14.78",
+	"output_thresh" : "-27.71",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.58"
+}
+[out#0/null @ 0x7f3c300] video:0KiB audio:1875KiB
+`
+
+func TestParseLoudnorm(t *testing.T) {
+	stats, err := audio.ParseLoudnorm(loudnormStderr)
+	require.NoError(t, err)
+
+	assert.Equal(t, audio.Loudness{Integrated: -27.61, TruePeak: -4.47, LRA: 18.06, Threshold: -39.2}, stats.Input)
+	assert.Equal(t, audio.Loudness{Integrated: -16.58, TruePeak: -1.5, LRA: 14.78, Threshold: -27.71}, stats.Output)
+	assert.Equal(t, "dynamic", stats.NormalizationType)
+	assert.Equal(t, 0.58, stats.TargetOffset)
+
+	_, err = audio.ParseLoudnorm("Error opening input")
+	assert.Error(t, err)
+	_, err = audio.ParseLoudnorm(`{"input_i" : "n/a"}`)
+	assert.Error(t, err)
+}
+
+func TestNormalizeArgs(t *testing.T) {
+	stats, err := audio.ParseLoudnorm(loudnormStderr)
+	require.NoError(t, err)
+
+	// Given: A broadcast target and the first pass measurement
+	args, err := audio.NormalizeArgs("/input/in.mp4", stats, audio.NormalizeOptions{
+		Name:   "out.mp3",
+		Target: audio.Target{Integrated: audio.TargetBroadcast, TruePeak: -1, LRA: 20},
+	})
+	require.NoError(t, err)
+
+	// Then: The second pass feeds the measurement back and stays linear
+	assert.Equal(t, []string{
+		"-hide_banner", "-nostats", "-i", "/input/in.mp4", "-map", "0:a:0", "-vn",
+		"-af", "loudnorm=I=-23:TP=-1:LRA=20:measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:measured_thresh=-39.2:offset=0.58:linear=true:print_format=json",
+		"-ar", "48000", "-c:a", "libmp3lame", "-b:a", "128k", "-f", "mp3", "/output/out.mp3",
+	}, args)
+
+	_, err = audio.NormalizeArgs("/input/in.mp4", stats, audio.NormalizeOptions{Name: "out.flac"})
+	assert.Error(t, err)
+
+	// And: A range target above loudnorm's maximum is clamped to 50
+	args, err = audio.NormalizeArgs("/input/in.mp4", stats, audio.NormalizeOptions{Target: audio.Target{LRA: 64}})
+	require.NoError(t, err)
+	assert.Contains(t, args, "loudnorm=I=-16:TP=-1.5:LRA=50:measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:"+
+		"measured_thresh=-39.2:offset=0.58:linear=true:print_format=json")
+}
+
+func TestNormalizeArgs_Silence(t *testing.T) {
+	// Given: The measurement of a silent track
+	stats, err := audio.ParseLoudnorm(strings.NewReplacer(
+		`"-27.61"`, `"-inf"`, `"-4.47"`, `"-inf"`, `"18.06"`, `"0.00"`, `"-39.20"`, `"-70.00"`,
+	).Replace(loudnormStderr))
+	require.NoError(t, err)
+
+	// Then: There is no gain to compute
+	_, err = audio.NormalizeArgs("/input/in.mp4", stats, audio.NormalizeOptions{})
+	assert.ErrorContains(t, err, "silent")
+}
+
+func TestAudio_Normalize(t *testing.T) {
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name   string
+		volume string
+		target float64
+	}{
+		{"quiet-to-streaming", "volume=-20dB", audio.TargetStreaming},
+		{"loud-to-broadcast", "volume=10dB", audio.TargetBroadcast},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given: A tone far from the target loudness
+			input, err := fixture.Generate(ctx, outputPath, fixture.Video{
+				Name:        tc.name + ".mp4",
+				Duration:    6,
+				Audio:       "sine=frequency=440:sample_rate=48000",
+				AudioFilter: tc.volume,
+			})
+			require.NoError(t, err)
+
+			// When: Normalising it
+			res, err := audio.Normalize(ctx, input, audio.NormalizeOptions{
+				OutputDir: outputPath,
+				Name:      tc.name + ".m4a",
+				Target:    audio.Target{Integrated: tc.target},
+			})
+			require.NoError(t, err)
+
+			// Then: The input was off target and the output is on it,
+			// with a linear gain under the true peak ceiling
+			assert.Greater(t, abs(res.Before.Integrated-tc.target), 5.0)
+			assert.InDelta(t, tc.target, res.After.Integrated, 1.0)
+			assert.LessOrEqual(t, res.After.TruePeak, -1.0)
+			assert.True(t, res.Linear)
+			assert.FileExists(t, res.Path)
+
+			// And: A steady tone has almost no loudness range either way
+			assert.Less(t, res.After.LRA, 2.0)
+		})
+	}
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func createTempDir(t *testing.T) string {
+	outputPath, err := filepath.Abs(filepath.Join("..", "..", "testdata", strings.ReplaceAll(uuid.NewString(), "-", "")))
+	require.NoError(t, err)
+
+	err = os.MkdirAll(outputPath, 0755)
+	require.NoError(t, err)
+
+	return outputPath
+}
+
+func cleanupFiles(t *testing.T, path string) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		t.Logf("failed to remove output directory: %s", path)
+	}
+}
@@ -0,0 +1,301 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// Codec is an audio encoder.
+type Codec string
+
+const (
+	// CodecAAC is AAC-LC with the native FFmpeg encoder.
+	CodecAAC Codec = "aac"
+	// CodecHEAAC is HE-AAC (AAC with SBR) with libfdk_aac, which only
+	// FDKImage includes.
+	CodecHEAAC Codec = "he-aac"
+	// CodecMP3 is MP3 with LAME.
+	CodecMP3 Codec = "mp3"
+	// CodecOpus is Opus with libopus.
+	CodecOpus Codec = "opus"
+)
+
+// FDKImage is the lite image built with libfdk_aac, the only HE-AAC
+// encoder. Its licence does not allow redistributing a GPL build with it,
+// so the image is never published; build it locally with
+//
+//	docker build --build-arg ENABLE_FDK_AAC=true -t veloxpack/ffmpeg:8.0-lite-fdk ./ffmpeg-lite
+const FDKImage = "veloxpack/ffmpeg:8.0-lite-fdk"
+
+// encoder is the FFmpeg encoder of the codec.
+func (c Codec) encoder() string {
+	switch c {
+	case CodecHEAAC:
+		return "libfdk_aac"
+	case CodecMP3:
+		return "libmp3lame"
+	case CodecOpus:
+		return "libopus"
+	}
+	return "aac"
+}
+
+// image is the FFmpeg image that can encode the codec.
+func (c Codec) image() string {
+	if c == CodecHEAAC {
+		return FDKImage
+	}
+	return Image
+}
+
+// container is how an output extension is written: its muxer, the codecs
+// it can hold with the default first, and whether it can embed cover art.
+type container struct {
+	format string
+	codecs []Codec
+	cover  bool
+}
+
+var containers = map[string]container{
+	// The ipod muxer is MP4 with the M4A brand Apple players expect.
+	".m4a":  {format: "ipod", codecs: []Codec{CodecAAC, CodecHEAAC}, cover: true},
+	".mp4":  {format: "mp4", codecs: []Codec{CodecAAC, CodecHEAAC}, cover: true},
+	".mp3":  {format: "mp3", codecs: []Codec{CodecMP3}, cover: true},
+	".ogg":  {format: "ogg", codecs: []Codec{CodecOpus}},
+	".opus": {format: "ogg", codecs: []Codec{CodecOpus}},
+	".webm": {format: "webm", codecs: []Codec{CodecOpus}},
+}
+
+func containerFor(name string) (container, error) {
+	c, ok := containers[strings.ToLower(filepath.Ext(name))]
+	if !ok {
+		return container{}, fmt.Errorf("audio: unsupported output %q", name)
+	}
+	return c, nil
+}
+
+// opusRates are the sample rates libopus accepts.
+var opusRates = []int{8000, 12000, 16000, 24000, 48000}
+
+// TranscodeOptions configures Transcode. Zero values pick defaults.
+type TranscodeOptions struct {
+	// OutputDir is the host directory the output is written to.
+	OutputDir string
+	// Name is the output file name; defaults to "audio.m4a". The extension
+	// picks the container: .m4a or .mp4 for AAC, .mp3, and .ogg, .opus or
+	// .webm for Opus.
+	Name string
+	// Codec defaults to the only codec of the container.
+	Codec Codec
+	// Bitrate in kbit/s; defaults to 128, 96 for Opus or 64 for HE-AAC.
+	// MP3 is constant bitrate unless VBR is set.
+	Bitrate int
+	// VBR encodes MP3 at the LAME quality level Quality, from 0 (best,
+	// around 245 kbit/s) to 9, instead of a constant bitrate.
+	VBR     bool
+	Quality int
+	// Channels downmixes to 1 (mono) or 2 (stereo); zero keeps the source
+	// layout.
+	Channels int
+	// SampleRate resamples to the given rate in Hz; zero keeps the source
+	// rate. Opus only accepts 8, 12, 16, 24 and 48 kHz.
+	SampleRate int
+	// Tags are written as container metadata, e.g. "title", "artist",
+	// "album", "date", "genre", "track" and "comment". MP3 gets ID3v2.3.
+	Tags map[string]string
+	// CoverArt is the host path of a JPEG or PNG embedded as the attached
+	// picture. Only M4A, MP4 and MP3 can carry it.
+	CoverArt string
+}
+
+func (o TranscodeOptions) withDefaults() TranscodeOptions {
+	if o.Name == "" {
+		o.Name = "audio.m4a"
+	}
+	if o.Codec == "" {
+		if c, err := containerFor(o.Name); err == nil {
+			o.Codec = c.codecs[0]
+		}
+	}
+	if o.Bitrate == 0 {
+		switch o.Codec {
+		case CodecOpus:
+			o.Bitrate = 96
+		case CodecHEAAC:
+			o.Bitrate = 64
+		default:
+			o.Bitrate = 128
+		}
+	}
+	return o
+}
+
+func (o TranscodeOptions) validate() (container, error) {
+	c, err := containerFor(o.Name)
+	if err != nil {
+		return c, err
+	}
+	if !slices.Contains(c.codecs, o.Codec) {
+		return c, fmt.Errorf("audio: %s cannot hold %s", filepath.Ext(o.Name), o.Codec)
+	}
+	if o.VBR && o.Codec != CodecMP3 {
+		return c, errors.New("audio: VBR quality levels are for mp3; set a bitrate instead")
+	}
+	if o.VBR && (o.Quality < 0 || o.Quality > 9) {
+		return c, fmt.Errorf("audio: LAME quality %d is not 0 to 9", o.Quality)
+	}
+	if o.Channels < 0 || o.Channels > 2 {
+		return c, fmt.Errorf("audio: cannot downmix to %d channels", o.Channels)
+	}
+	if o.Codec == CodecOpus && o.SampleRate != 0 && !slices.Contains(opusRates, o.SampleRate) {
+		return c, fmt.Errorf("audio: opus does not support %d Hz", o.SampleRate)
+	}
+	if o.CoverArt != "" && !c.cover {
+		return c, fmt.Errorf("audio: %s cannot embed cover art", filepath.Ext(o.Name))
+	}
+	return c, nil
+}
+
+// TranscodeArgs returns the FFmpeg arguments that transcode the first audio
+// stream of the container path input to /output. cover is the container
+// path of the cover art, used when opts.CoverArt is set.
+func TranscodeArgs(input, cover string, opts TranscodeOptions) ([]string, error) {
+	opts = opts.withDefaults()
+	c, err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-hide_banner", "-i", input}
+	if opts.CoverArt != "" {
+		args = append(args, "-i", cover, "-map", "0:a:0", "-map", "1:v:0",
+			"-c:v", "copy", "-disposition:v:0", "attached_pic")
+		if opts.Codec == CodecMP3 {
+			args = append(args, "-metadata:s:v", "title=Album cover", "-metadata:s:v", "comment=Cover (front)")
+		}
+	} else {
+		args = append(args, "-map", "0:a:0", "-vn")
+	}
+
+	args = append(args, "-c:a", opts.Codec.encoder())
+	switch opts.Codec {
+	case CodecMP3:
+		if opts.VBR {
+			args = append(args, "-q:a", strconv.Itoa(opts.Quality))
+		} else {
+			args = append(args, "-b:a", strconv.Itoa(opts.Bitrate)+"k")
+		}
+	case CodecOpus:
+		args = append(args, "-b:a", strconv.Itoa(opts.Bitrate)+"k", "-vbr", "on")
+	case CodecHEAAC:
+		args = append(args, "-profile:a", "aac_he", "-b:a", strconv.Itoa(opts.Bitrate)+"k")
+	default:
+		args = append(args, "-profile:a", "aac_low", "-b:a", strconv.Itoa(opts.Bitrate)+"k")
+	}
+	if opts.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(opts.Channels))
+	}
+	if opts.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(opts.SampleRate))
+	}
+
+	// Drop the source tags so only the requested ones are written.
+	args = append(args, "-map_metadata", "-1")
+	keys := make([]string, 0, len(opts.Tags))
+	for k := range opts.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "-metadata", k+"="+opts.Tags[k])
+	}
+	switch c.format {
+	case "mp3":
+		args = append(args, "-id3v2_version", "3")
+	case "ipod", "mp4":
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, "-f", c.format, "/output/"+opts.Name), nil
+}
+
+// Transcode converts the first audio stream of the host file at input, which
+// may be a video, into an audio-only file and returns its host path. HE-AAC
+// runs on FDKImage, which has to be built locally first.
+func Transcode(ctx context.Context, input string, opts TranscodeOptions) (string, error) {
+	if opts.OutputDir == "" {
+		return "", errors.New("audio: output directory is required")
+	}
+	opts = opts.withDefaults()
+	containerInput := "/input/" + filepath.Base(input)
+	files := []testcontainers.ContainerFile{runner.File(input, containerInput)}
+	var cover string
+	if opts.CoverArt != "" {
+		cover = "/input/cover/" + filepath.Base(opts.CoverArt)
+		files = append(files, runner.File(opts.CoverArt, cover))
+	}
+	args, err := TranscodeArgs(containerInput, cover, opts)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = runner.Run(ctx, runner.Request{
+		Image:  opts.Codec.image(),
+		Cmd:    args,
+		Files:  files,
+		Mounts: []mount.Mount{runner.Bind(opts.OutputDir, "/output")},
+	})
+	if err != nil {
+		return "", fmt.Errorf("audio: transcode: %w", err)
+	}
+	return filepath.Join(opts.OutputDir, opts.Name), nil
+}
+
+// Profile names a curated set of TranscodeOptions.
+type Profile string
+
+const (
+	// ProfilePodcastMP3 is 128 kbit/s CBR stereo MP3 at 44.1 kHz, which
+	// every podcast app and feed validator accepts.
+	ProfilePodcastMP3 Profile = "podcast-mp3"
+	// ProfilePodcastVoice is 64 kbit/s mono MP3 at 44.1 kHz for
+	// speech-only shows.
+	ProfilePodcastVoice Profile = "podcast-voice"
+	// ProfilePodcastAAC is 96 kbit/s stereo AAC-LC in M4A at 44.1 kHz.
+	ProfilePodcastAAC Profile = "podcast-aac"
+	// ProfileMusicMP3 is LAME V0 VBR stereo MP3.
+	ProfileMusicMP3 Profile = "music-mp3"
+	// ProfileOpus is 96 kbit/s stereo Opus in Ogg at 48 kHz.
+	ProfileOpus Profile = "opus"
+	// ProfileOpusWebM is 96 kbit/s stereo Opus in WebM at 48 kHz, for
+	// browser playback.
+	ProfileOpusWebM Profile = "opus-webm"
+)
+
+var profiles = map[Profile]TranscodeOptions{
+	ProfilePodcastMP3:   {Name: "audio.mp3", Codec: CodecMP3, Bitrate: 128, Channels: 2, SampleRate: 44100},
+	ProfilePodcastVoice: {Name: "audio.mp3", Codec: CodecMP3, Bitrate: 64, Channels: 1, SampleRate: 44100},
+	ProfilePodcastAAC:   {Name: "audio.m4a", Codec: CodecAAC, Bitrate: 96, Channels: 2, SampleRate: 44100},
+	ProfileMusicMP3:     {Name: "audio.mp3", Codec: CodecMP3, VBR: true, Quality: 0, Channels: 2},
+	ProfileOpus:         {Name: "audio.ogg", Codec: CodecOpus, Bitrate: 96, Channels: 2, SampleRate: 48000},
+	ProfileOpusWebM:     {Name: "audio.webm", Codec: CodecOpus, Bitrate: 96, Channels: 2, SampleRate: 48000},
+}
+
+// Options returns the options of the profile. Callers set OutputDir and
+// may override any field, such as Name, Tags or CoverArt.
+func (p Profile) Options() (TranscodeOptions, error) {
+	opts, ok := profiles[p]
+	if !ok {
+		return TranscodeOptions{}, fmt.Errorf("audio: unknown profile %q", p)
+	}
+	return opts, nil
+}
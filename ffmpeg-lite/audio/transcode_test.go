@@ -0,0 +1,234 @@
+package audio_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/ffmpeg-lite/audio"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+func TestTranscodeArgs(t *testing.T) {
+	// Given: A VBR MP3 with tags and cover art, downmixed to mono
+	args, err := audio.TranscodeArgs("/input/in.mp4", "/input/cover/cover.png", audio.TranscodeOptions{
+		Name:     "episode.mp3",
+		VBR:      true,
+		Quality:  2,
+		Channels: 1,
+		Tags:     map[string]string{"title": "Pilot", "artist": "Veloxpack"},
+		CoverArt: "cover.png",
+	})
+	require.NoError(t, err)
+
+	// Then: LAME runs at V2, the cover is an attached picture and the
+	// tags are written as ID3v2.3 in a stable order
+	assert.Equal(t, []string{
+		"-hide_banner", "-i", "/input/in.mp4",
+		"-i", "/input/cover/cover.png", "-map", "0:a:0", "-map", "1:v:0",
+		"-c:v", "copy", "-disposition:v:0", "attached_pic",
+		"-metadata:s:v", "title=Album cover", "-metadata:s:v", "comment=Cover (front)",
+		"-c:a", "libmp3lame", "-q:a", "2", "-ac", "1",
+		"-map_metadata", "-1", "-metadata", "artist=Veloxpack", "-metadata", "title=Pilot",
+		"-id3v2_version", "3", "-f", "mp3", "/output/episode.mp3",
+	}, args)
+
+	// And: M4A is AAC-LC through the ipod muxer
+	args, err = audio.TranscodeArgs("/input/in.mp4", "", audio.TranscodeOptions{SampleRate: 44100})
+	require.NoError(t, err)
+	assert.Subset(t, args, []string{"-vn", "aac", "aac_low", "128k", "-ar", "44100", "ipod", "/output/audio.m4a"})
+
+	// And: HE-AAC is libfdk_aac with SBR at a lower default bitrate
+	args, err = audio.TranscodeArgs("/input/in.mp4", "", audio.TranscodeOptions{Codec: audio.CodecHEAAC})
+	require.NoError(t, err)
+	assert.Subset(t, args, []string{"-c:a", "libfdk_aac", "-profile:a", "aac_he", "-b:a", "64k", "ipod", "/output/audio.m4a"})
+}
+
+func TestTranscodeArgs_Errors(t *testing.T) {
+	for name, opts := range map[string]audio.TranscodeOptions{
+		"he-aac in mp3":     {Name: "audio.mp3", Codec: audio.CodecHEAAC},
+		"mp3 in m4a":        {Codec: audio.CodecMP3},
+		"unknown container": {Name: "audio.flac"},
+		"opus at 44.1 kHz":  {Name: "audio.ogg", SampleRate: 44100},
+		"cover art in ogg":  {Name: "audio.ogg", CoverArt: "cover.png"},
+		"vbr opus":          {Name: "audio.opus", VBR: true},
+		"lame quality 10":   {Name: "audio.mp3", VBR: true, Quality: 10},
+		"upmix to surround": {Channels: 6},
+		"negative channels": {Channels: -1},
+	} {
+		_, err := audio.TranscodeArgs("/input/in.mp4", "/input/cover/cover.png", opts)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestProfile_Options(t *testing.T) {
+	opts, err := audio.ProfilePodcastVoice.Options()
+	require.NoError(t, err)
+	args, err := audio.TranscodeArgs("/input/in.mp4", "", opts)
+	require.NoError(t, err)
+	assert.Subset(t, args, []string{"libmp3lame", "64k", "-ac", "1", "-ar", "44100", "/output/audio.mp3"})
+
+	_, err = audio.Profile("flac").Options()
+	assert.Error(t, err)
+}
+
+// writeCover writes a small PNG to use as cover art.
+func writeCover(t *testing.T, dir string) string {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	path := filepath.Join(dir, "cover.png")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+	return path
+}
+
+// allTags merges the format and stream tags; Ogg keeps its comments on the
+// stream.
+func allTags(probe *ffprobe.Output) map[string]string {
+	tags := map[string]string{}
+	for _, s := range probe.Streams {
+		for k, v := range s.Tags {
+			tags[k] = v
+		}
+	}
+	for k, v := range probe.Format.Tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+func TestAudio_Transcode(t *testing.T) {
+	// Given: A video with 5.1 audio at 44.1 kHz, and a cover image
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	input, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:        "surround.mp4",
+		Duration:    4,
+		Audio:       "sine=frequency=440:sample_rate=44100",
+		AudioFilter: "aformat=channel_layouts=5.1",
+	})
+	require.NoError(t, err)
+	cover := writeCover(t, outputPath)
+	tags := map[string]string{"title": "Pilot", "artist": "Veloxpack", "album": "Season 1"}
+
+	for _, tc := range []struct {
+		name       string
+		opts       audio.TranscodeOptions
+		codec      string
+		format     string
+		channels   int
+		sampleRate string
+		cover      bool
+	}{
+		{"mp3-cbr", audio.TranscodeOptions{Name: "cbr.mp3", Bitrate: 192, Channels: 2, Tags: tags, CoverArt: cover}, "mp3", "mp3", 2, "44100", true},
+		{"mp3-vbr", audio.TranscodeOptions{Name: "vbr.mp3", VBR: true, Quality: 4, Channels: 1, SampleRate: 22050, Tags: tags}, "mp3", "mp3", 1, "22050", false},
+		{"aac-m4a", audio.TranscodeOptions{Name: "aac.m4a", Bitrate: 96, Channels: 2, SampleRate: 48000, Tags: tags, CoverArt: cover}, "aac", "mov,mp4,m4a,3gp,3g2,mj2", 2, "48000", true},
+		{"opus-ogg", audio.TranscodeOptions{Name: "opus.ogg", Channels: 2, SampleRate: 48000, Tags: tags}, "opus", "ogg", 2, "48000", false},
+		{"opus-webm", audio.TranscodeOptions{Name: "opus.webm", Channels: 1, Tags: tags}, "opus", "matroska,webm", 1, "48000", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// When: Transcoding to the profile
+			opts := tc.opts
+			opts.OutputDir = outputPath
+			path, err := audio.Transcode(ctx, input, opts)
+			require.NoError(t, err)
+
+			// Then: The codec, container, layout and rate match
+			probe, err := ffprobe.Probe(ctx, path)
+			require.NoError(t, err)
+			assert.Equal(t, tc.format, probe.Format.FormatName)
+			a := probe.AudioStream()
+			require.NotNil(t, a)
+			assert.Equal(t, tc.codec, a.CodecName)
+			assert.Equal(t, tc.channels, a.Channels)
+			assert.Equal(t, tc.sampleRate, a.SampleRate)
+			if tc.codec == "aac" {
+				assert.Equal(t, "LC", a.Profile)
+			}
+
+			// And: The tags are written and the source's are not
+			got := allTags(probe)
+			assert.Equal(t, "Pilot", got["title"])
+			assert.Equal(t, "Veloxpack", got["artist"])
+			assert.Equal(t, "Season 1", got["album"])
+
+			// And: Cover art is an attached picture, and the only video
+			v := probe.VideoStream()
+			if tc.cover {
+				require.NotNil(t, v)
+				assert.Equal(t, "png", v.CodecName)
+				assert.Equal(t, 1, v.Disposition["attached_pic"])
+			} else {
+				assert.Nil(t, v)
+			}
+		})
+	}
+
+	// And: LAME marks CBR files with an Info header and VBR with Xing
+	cbr, err := os.ReadFile(filepath.Join(outputPath, "cbr.mp3"))
+	require.NoError(t, err)
+	vbr, err := os.ReadFile(filepath.Join(outputPath, "vbr.mp3"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Contains(cbr[:min(len(cbr), 64<<10)], []byte("Info")))
+	assert.True(t, bytes.Contains(vbr[:min(len(vbr), 8<<10)], []byte("Xing")))
+}
+
+func TestAudio_Transcode_HEAAC(t *testing.T) {
+	// Given: The locally built libfdk_aac image, which is never published
+	ctx := context.Background()
+	cli, err := testcontainers.NewDockerClientWithOpts(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+	if _, _, err := cli.ImageInspectWithRaw(ctx, audio.FDKImage); err != nil {
+		t.Skipf("%s is not built; see the README to build it", audio.FDKImage)
+	}
+
+	// And: A video with stereo audio
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	input, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:     "stereo.mp4",
+		Duration: 4,
+		Audio:    "sine=frequency=440:sample_rate=48000",
+	})
+	require.NoError(t, err)
+
+	// When: Transcoding to HE-AAC in M4A with tags and cover art
+	path, err := audio.Transcode(ctx, input, audio.TranscodeOptions{
+		OutputDir: outputPath,
+		Name:      "he.m4a",
+		Codec:     audio.CodecHEAAC,
+		Channels:  2,
+		Tags:      map[string]string{"title": "Pilot"},
+		CoverArt:  writeCover(t, outputPath),
+	})
+	require.NoError(t, err)
+
+	// Then: The audio is AAC with the HE profile in an M4A
+	probe, err := ffprobe.Probe(ctx, path)
+	require.NoError(t, err)
+	assert.Equal(t, "mov,mp4,m4a,3gp,3g2,mj2", probe.Format.FormatName)
+	a := probe.AudioStream()
+	require.NotNil(t, a)
+	assert.Equal(t, "aac", a.CodecName)
+	assert.Equal(t, "HE-AAC", a.Profile)
+	assert.Equal(t, 2, a.Channels)
+	assert.Equal(t, "Pilot", allTags(probe)["title"])
+	require.NotNil(t, probe.VideoStream())
+}
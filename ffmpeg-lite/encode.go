@@ -0,0 +1,385 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// RateControl is how an encode spends its bits.
+type RateControl string
+
+const (
+	// RateCRF encodes at constant quality. With MaxRate set the encode is
+	// capped by the VBV, so complex scenes lose quality instead of
+	// overshooting the delivery bandwidth.
+	RateCRF RateControl = "crf"
+	// RateTwoPass analyses the whole video in a first pass and spends
+	// Bitrate on average in the second, moving bits to where they are
+	// needed, within MaxRate and BufSize when set.
+	RateTwoPass RateControl = "2pass"
+)
+
+// EncodeOptions configures Encode. Zero values pick defaults.
+type EncodeOptions struct {
+	// OutputDir is the host directory the output is written to.
+	OutputDir string
+	// Name is the output file name; defaults to "output.mp4", or
+	// "output.webm" for VP9. The extension picks the container.
+	Name string
+	// Codec defaults to CodecH264.
+	Codec Codec
+	// Profile is the H.264 or HEVC profile; defaults to "high" for H.264,
+	// and "main" or "main10" for HEVC depending on BitDepth.
+	Profile string
+	// BitDepth is 8 or 10; defaults to 8, or 10 for the main10 profile.
+	// Only HEVC and AV1 encode 10-bit.
+	BitDepth int
+	// RateControl defaults to RateCRF. AV1 only supports RateCRF.
+	RateControl RateControl
+	// CRF defaults to 23 for H.264, 28 for HEVC, 32 for VP9 and 35 for AV1.
+	CRF int
+	// Bitrate is the two-pass target in kbit/s.
+	Bitrate int
+	// MaxRate and BufSize are the VBV cap in kbit/s; BufSize defaults to
+	// twice MaxRate.
+	MaxRate int
+	BufSize int
+	// Preset is the x264 and x265 preset, defaulting to "veryfast", or the
+	// SVT-AV1 preset from 0 (slowest) to 13, defaulting to 8.
+	Preset string
+	// Width and Height scale the video; a zero side follows the aspect
+	// ratio and both zero keep the source size.
+	Width  int
+	Height int
+	// AudioBitrate in kbit/s; defaults to 128. Audio is AAC, or Opus in
+	// WebM. Use a negative value to drop audio.
+	AudioBitrate int
+	// HDR is how an HDR source is handled. Empty encodes it like SDR,
+	// which drops its colour signalling.
+	HDR HDRMode
+	// Source is the HDR signalling of the input; Encode probes it when HDR
+	// is set. It stays nil for SDR sources, which HDR leaves untouched.
+	Source *HDR
+}
+
+func (o EncodeOptions) withDefaults() EncodeOptions {
+	if o.Codec == "" {
+		o.Codec = CodecH264
+	}
+	if o.Name == "" {
+		o.Name = "output.mp4"
+		if o.Codec == CodecVP9 {
+			o.Name = "output.webm"
+		}
+	}
+	if o.RateControl == "" {
+		o.RateControl = RateCRF
+	}
+	if o.CRF == 0 {
+		switch o.Codec {
+		case CodecHEVC:
+			o.CRF = 28
+		case CodecVP9:
+			o.CRF = 32
+		case CodecAV1:
+			o.CRF = 35
+		default:
+			o.CRF = 23
+		}
+	}
+	if o.MaxRate > 0 && o.BufSize == 0 {
+		o.BufSize = 2 * o.MaxRate
+	}
+	if o.Preset == "" {
+		o.Preset = "veryfast"
+		if o.Codec == CodecAV1 {
+			o.Preset = "8"
+		}
+	}
+	if o.BitDepth == 0 {
+		o.BitDepth = 8
+		if o.Profile == "main10" || o.preserveHDR() {
+			o.BitDepth = 10
+		}
+	}
+	if o.Profile == "" {
+		switch o.Codec {
+		case CodecH264:
+			o.Profile = "high"
+		case CodecHEVC:
+			o.Profile = "main"
+			if o.BitDepth == 10 {
+				o.Profile = "main10"
+			}
+		}
+	}
+	if o.AudioBitrate == 0 {
+		o.AudioBitrate = 128
+	}
+	return o
+}
+
+func (o EncodeOptions) validate() error {
+	switch o.Codec {
+	case CodecH264, CodecHEVC, CodecVP9, CodecAV1:
+	default:
+		return fmt.Errorf("encode: unsupported codec %q", o.Codec)
+	}
+	switch o.BitDepth {
+	case 8:
+	case 10:
+		if o.Codec != CodecHEVC && o.Codec != CodecAV1 {
+			return fmt.Errorf("encode: %s does not encode 10-bit", o.Codec)
+		}
+	default:
+		return fmt.Errorf("encode: unsupported bit depth %d", o.BitDepth)
+	}
+	if o.Codec == CodecAV1 {
+		if n, err := strconv.Atoi(o.Preset); err != nil || n < 0 || n > 13 {
+			return fmt.Errorf("encode: SVT-AV1 preset %q is not 0 to 13", o.Preset)
+		}
+	}
+	switch o.RateControl {
+	case RateCRF:
+	case RateTwoPass:
+		if o.Codec == CodecAV1 {
+			return errors.New("encode: two-pass is not supported for av1")
+		}
+		if o.Bitrate <= 0 {
+			return errors.New("encode: two-pass needs a bitrate")
+		}
+		if o.MaxRate > 0 && o.MaxRate < o.Bitrate {
+			return fmt.Errorf("encode: max rate %dk is below the bitrate %dk", o.MaxRate, o.Bitrate)
+		}
+	default:
+		return fmt.Errorf("encode: unsupported rate control %q", o.RateControl)
+	}
+	switch o.HDR {
+	case "", HDRToneMap:
+	case HDRPreserve:
+		if o.Source != nil && o.Codec != CodecHEVC && o.Codec != CodecAV1 {
+			return fmt.Errorf("encode: %s cannot carry HDR; use hevc or av1", o.Codec)
+		}
+		if o.Source != nil && o.BitDepth != 10 {
+			return errors.New("encode: HDR needs a bit depth of 10")
+		}
+	default:
+		return fmt.Errorf("encode: unsupported HDR mode %q", o.HDR)
+	}
+	if o.MaxRate < 0 || o.BufSize < 0 || o.Width < 0 || o.Height < 0 {
+		return errors.New("encode: negative rate or size")
+	}
+	return nil
+}
+
+// passLog is the pass log prefix inside the scratch volume.
+const passLog = "/scratch/pass"
+
+// EncodeArgs returns the FFmpeg arguments of every run of the encode, one
+// list per run, reading the container path input and writing to /output.
+// Two-pass encodes share their pass logs through a volume at /scratch.
+func EncodeArgs(input string, opts EncodeOptions) ([][]string, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.RateControl == RateCRF {
+		return [][]string{opts.args(input, 0)}, nil
+	}
+	return [][]string{opts.args(input, 1), opts.args(input, 2)}, nil
+}
+
+// args builds one run; pass is 0 for single-pass encodes.
+func (o EncodeOptions) args(input string, pass int) []string {
+	args := []string{"-i", input, "-map", "0:v:0"}
+	var filters []string
+	if o.toneMap() {
+		filters = append(filters, toneMapFilter(o.Source))
+	}
+	if o.Width != 0 || o.Height != 0 {
+		filters = append(filters, fmt.Sprintf("scale=%d:%d", sizeOrAuto(o.Width), sizeOrAuto(o.Height)))
+	}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+
+	switch o.Codec {
+	case CodecHEVC:
+		args = append(args, "-c:v", "libx265", "-profile:v", o.Profile, "-preset", o.Preset, "-tag:v", "hvc1")
+	case CodecVP9:
+		args = append(args, "-c:v", "libvpx-vp9", "-deadline", "good", "-cpu-used", "4", "-row-mt", "1")
+	case CodecAV1:
+		args = append(args, "-c:v", "libsvtav1", "-preset", o.Preset)
+	default:
+		args = append(args, "-c:v", "libx264", "-profile:v", o.Profile, "-preset", o.Preset)
+	}
+
+	switch {
+	case o.RateControl == RateTwoPass:
+		args = append(args, "-b:v", strconv.Itoa(o.Bitrate)+"k")
+	case o.Codec == CodecVP9 && o.MaxRate > 0:
+		// In constrained quality mode libvpx treats -b:v as the cap.
+		args = append(args, "-crf", strconv.Itoa(o.CRF), "-b:v", strconv.Itoa(o.MaxRate)+"k")
+	case o.Codec == CodecVP9:
+		args = append(args, "-crf", strconv.Itoa(o.CRF), "-b:v", "0")
+	default:
+		args = append(args, "-crf", strconv.Itoa(o.CRF))
+	}
+	if o.MaxRate > 0 {
+		args = append(args, "-maxrate", strconv.Itoa(o.MaxRate)+"k", "-bufsize", strconv.Itoa(o.BufSize)+"k")
+	}
+
+	var x265Params, svtParams []string
+	switch {
+	case o.preserveHDR():
+		args = append(args, "-color_primaries", "bt2020", "-color_trc", o.Source.Transfer,
+			"-colorspace", "bt2020nc", "-color_range", "tv")
+		if o.Source.Transfer == TransferPQ {
+			x265Params = append(x265Params, "hdr10=1", "hdr10-opt=1")
+			svtParams = append(svtParams, "enable-hdr=1")
+			if m := o.Source.Mastering; m != nil {
+				x265Params = append(x265Params, "master-display="+m.x265())
+				svtParams = append(svtParams, "mastering-display="+m.svtAV1())
+			}
+			if cl := o.Source.ContentLight; cl != nil {
+				x265Params = append(x265Params, fmt.Sprintf("max-cll=%d,%d", cl.MaxCLL, cl.MaxFALL))
+				svtParams = append(svtParams, fmt.Sprintf("content-light=%d,%d", cl.MaxCLL, cl.MaxFALL))
+			}
+		}
+	case o.toneMap():
+		args = append(args, "-color_primaries", "bt709", "-color_trc", "bt709",
+			"-colorspace", "bt709", "-color_range", "tv")
+	}
+	if pass > 0 {
+		if o.Codec == CodecHEVC {
+			// The FFmpeg CLI only manages pass logs for x264 and libvpx;
+			// x265 writes its own stats and cutree files.
+			x265Params = append(x265Params, fmt.Sprintf("pass=%d:stats=%s.x265.log", pass, passLog))
+		} else {
+			args = append(args, "-pass", strconv.Itoa(pass), "-passlogfile", passLog)
+		}
+	}
+	if len(x265Params) > 0 && o.Codec == CodecHEVC {
+		args = append(args, "-x265-params", strings.Join(x265Params, ":"))
+	}
+	if len(svtParams) > 0 && o.Codec == CodecAV1 {
+		args = append(args, "-svtav1-params", strings.Join(svtParams, ":"))
+	}
+	args = append(args, "-pix_fmt", o.pixFmt())
+
+	if pass == 1 {
+		// The first pass only needs the statistics.
+		return append(args, "-an", "-f", "null", "-")
+	}
+	if o.AudioBitrate > 0 {
+		codec := "aac"
+		if strings.EqualFold(filepath.Ext(o.Name), ".webm") {
+			codec = "libopus"
+		}
+		args = append(args, "-map", "0:a:0?", "-c:a", codec, "-b:a", strconv.Itoa(o.AudioBitrate)+"k")
+	}
+	if strings.EqualFold(filepath.Ext(o.Name), ".mp4") {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, "/output/"+o.Name)
+}
+
+func (o EncodeOptions) preserveHDR() bool {
+	return o.HDR == HDRPreserve && o.Source != nil
+}
+
+func (o EncodeOptions) toneMap() bool {
+	return o.HDR == HDRToneMap && o.Source != nil
+}
+
+func (o EncodeOptions) pixFmt() string {
+	if o.BitDepth == 10 {
+		return "yuv420p10le"
+	}
+	return "yuv420p"
+}
+
+func sizeOrAuto(v int) int {
+	if v == 0 {
+		return -2
+	}
+	return v
+}
+
+// Encode transcodes the video at input with the lite image and returns the
+// host path of the output. Two-pass encodes run two containers that share
+// their pass logs through a scratch volume, removed afterwards. With HDR
+// set the input is probed first, and HDR sources are tone mapped on
+// HDRImage.
+func Encode(ctx context.Context, input string, opts EncodeOptions) (string, error) {
+	if opts.OutputDir == "" {
+		return "", errors.New("encode: output directory is required")
+	}
+	if opts.HDR != "" && opts.Source == nil {
+		probe, err := ffprobe.Probe(ctx, input)
+		if err != nil {
+			return "", fmt.Errorf("encode: %w", err)
+		}
+		opts.Source = DetectHDR(probe.VideoStream())
+	}
+	opts = opts.withDefaults()
+	containerInput := "/input/" + filepath.Base(input)
+	runs, err := EncodeArgs(containerInput, opts)
+	if err != nil {
+		return "", err
+	}
+	image := Image
+	if opts.toneMap() {
+		image = HDRImage
+	}
+
+	mounts := []mount.Mount{runner.Bind(opts.OutputDir, "/output")}
+	if len(runs) > 1 {
+		scratch, err := runner.NewScratch(ctx)
+		if err != nil {
+			return "", fmt.Errorf("encode: %w", err)
+		}
+		defer scratch.Remove(context.Background())
+		mounts = append(mounts, scratch.Mount("/scratch"))
+	}
+	for i, cmd := range runs {
+		_, err := runner.Run(ctx, runner.Request{
+			Image:  image,
+			Cmd:    cmd,
+			Files:  []testcontainers.ContainerFile{runner.File(input, containerInput)},
+			Mounts: mounts,
+		})
+		if err != nil {
+			if len(runs) > 1 {
+				return "", fmt.Errorf("encode: pass %d: %w", i+1, err)
+			}
+			return "", fmt.Errorf("encode: %w", err)
+		}
+	}
+	return filepath.Join(opts.OutputDir, opts.Name), nil
+}
+
+// Decode decodes every video frame of the host file at path with the lite
+// image, failing on the first corrupt frame. It checks that an encode plays
+// back, which probing the headers alone does not.
+func Decode(ctx context.Context, path string) error {
+	input := "/input/" + filepath.Base(path)
+	_, err := runner.Run(ctx, runner.Request{
+		Image: Image,
+		Cmd:   []string{"-v", "error", "-xerror", "-i", input, "-map", "0:v:0", "-f", "null", "-"},
+		Files: []testcontainers.ContainerFile{runner.File(path, input)},
+	})
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package ffmpeg_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ffmpeg "github.com/veloxpack/tools/ffmpeg-lite"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+func TestEncodeArgs_CRF(t *testing.T) {
+	// Given: A capped H.264 CRF encode scaled to 720p
+	runs, err := ffmpeg.EncodeArgs("/input/in.mp4", ffmpeg.EncodeOptions{MaxRate: 3000, Height: 720})
+	require.NoError(t, err)
+
+	// Then: It runs once with the VBV cap and default buffer
+	require.Len(t, runs, 1)
+	assert.Equal(t, []string{
+		"-i", "/input/in.mp4", "-map", "0:v:0", "-vf", "scale=-2:720",
+		"-c:v", "libx264", "-profile:v", "high", "-preset", "veryfast", "-crf", "23",
+		"-maxrate", "3000k", "-bufsize", "6000k",
+		"-pix_fmt", "yuv420p",
+		"-map", "0:a:0?", "-c:a", "aac", "-b:a", "128k",
+		"-movflags", "+faststart", "/output/output.mp4",
+	}, runs[0])
+
+	// And: VP9 constrained quality uses the cap as its bitrate, in WebM
+	runs, err = ffmpeg.EncodeArgs("/input/in.mp4", ffmpeg.EncodeOptions{Codec: ffmpeg.CodecVP9, MaxRate: 2000})
+	require.NoError(t, err)
+	assert.Subset(t, runs[0], []string{"libvpx-vp9", "-crf", "32", "-b:v", "2000k", "libopus", "/output/output.webm"})
+	assert.NotContains(t, runs[0], "+faststart")
+}
+
+func TestEncodeArgs_TwoPass(t *testing.T) {
+	for _, tc := range []struct {
+		codec ffmpeg.Codec
+		pass1 []string
+		pass2 []string
+	}{
+		{ffmpeg.CodecH264, []string{"-pass", "1", "-passlogfile", "/scratch/pass"}, []string{"-pass", "2", "-passlogfile", "/scratch/pass"}},
+		{ffmpeg.CodecVP9, []string{"-pass", "1", "-passlogfile", "/scratch/pass"}, []string{"-pass", "2", "-passlogfile", "/scratch/pass"}},
+		{ffmpeg.CodecHEVC, []string{"-x265-params", "pass=1:stats=/scratch/pass.x265.log"}, []string{"-x265-params", "pass=2:stats=/scratch/pass.x265.log"}},
+	} {
+		// Given: A two-pass encode at 1500k capped at 2000k
+		runs, err := ffmpeg.EncodeArgs("/input/in.mp4", ffmpeg.EncodeOptions{
+			Codec:       tc.codec,
+			Name:        "out.mp4",
+			RateControl: ffmpeg.RateTwoPass,
+			Bitrate:     1500,
+			MaxRate:     2000,
+			BufSize:     1000,
+		})
+		require.NoError(t, err, tc.codec)
+		require.Len(t, runs, 2, tc.codec)
+
+		// Then: Both passes share the pass log and rate settings
+		for _, run := range runs {
+			assert.Subset(t, run, []string{"-b:v", "1500k", "-maxrate", "2000k", "-bufsize", "1000k"}, tc.codec)
+			assert.NotContains(t, run, "-crf", tc.codec)
+		}
+		assert.Subset(t, runs[0], tc.pass1, tc.codec)
+		assert.Subset(t, runs[1], tc.pass2, tc.codec)
+
+		// And: The first pass writes nothing but statistics
+		assert.Equal(t, []string{"-an", "-f", "null", "-"}, runs[0][len(runs[0])-4:], tc.codec)
+		assert.Equal(t, "/output/out.mp4", runs[1][len(runs[1])-1], tc.codec)
+	}
+}
+
+func TestEncodeArgs_Errors(t *testing.T) {
+	for name, opts := range map[string]ffmpeg.EncodeOptions{
+		"unknown codec":      {Codec: "mpeg2"},
+		"unknown mode":       {RateControl: "cbr"},
+		"two-pass bitrate":   {RateControl: ffmpeg.RateTwoPass},
+		"cap below bitrate":  {RateControl: ffmpeg.RateTwoPass, Bitrate: 3000, MaxRate: 2000},
+		"negative dimension": {Width: -1},
+		"10-bit h264":        {BitDepth: 10},
+		"12-bit hevc":        {Codec: ffmpeg.CodecHEVC, BitDepth: 12},
+		"av1 two-pass":       {Codec: ffmpeg.CodecAV1, RateControl: ffmpeg.RateTwoPass, Bitrate: 1000},
+		"av1 x264 preset":    {Codec: ffmpeg.CodecAV1, Preset: "medium"},
+	} {
+		_, err := ffmpeg.EncodeArgs("/input/in.mp4", opts)
+		assert.Error(t, err, name)
+	}
+}
+
+// vbvLimit is the highest bitrate a VBV-compliant stream can average over a
+// window: the buffer can drain on top of maxrate once per window.
+func vbvLimit(maxRate, bufSize int, window float64) float64 {
+	return (float64(maxRate)*window + float64(bufSize)) / window * 1000
+}
+
+func TestFFmpeg_Encode_VBV(t *testing.T) {
+	// Given: 10s of heavy noise, which wants far more than the cap at a low
+	// CRF
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	input, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:        "noise.mp4",
+		VideoFilter: "noise=alls=30:allf=t",
+	})
+	require.NoError(t, err)
+
+	const maxRate, bufSize, window = 1500, 750, 2.0
+	for _, tc := range []struct {
+		name string
+		opts ffmpeg.EncodeOptions
+	}{
+		{"h264-crf", ffmpeg.EncodeOptions{Codec: ffmpeg.CodecH264, CRF: 18}},
+		{"hevc-crf", ffmpeg.EncodeOptions{Codec: ffmpeg.CodecHEVC, CRF: 20}},
+		{"vp9-crf", ffmpeg.EncodeOptions{Codec: ffmpeg.CodecVP9, CRF: 20}},
+		{"h264-2pass", ffmpeg.EncodeOptions{Codec: ffmpeg.CodecH264, RateControl: ffmpeg.RateTwoPass, Bitrate: 1200}},
+		{"hevc-2pass", ffmpeg.EncodeOptions{Codec: ffmpeg.CodecHEVC, RateControl: ffmpeg.RateTwoPass, Bitrate: 1200}},
+		{"vp9-2pass", ffmpeg.EncodeOptions{Codec: ffmpeg.CodecVP9, RateControl: ffmpeg.RateTwoPass, Bitrate: 1200}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// When: Encoding with a 1500k cap and a half second buffer, in
+			// MP4 so the sample sizes can be read back
+			opts := tc.opts
+			opts.OutputDir = outputPath
+			opts.Name = tc.name + ".mp4"
+			opts.MaxRate = maxRate
+			opts.BufSize = bufSize
+			path, err := ffmpeg.Encode(ctx, input, opts)
+			require.NoError(t, err)
+
+			// Then: No 2s window exceeds what the VBV allows, with 5% for
+			// encoder slack
+			st := videoSamples(t, path)
+			assert.LessOrEqual(t, st.PeakBitrate(window), vbvLimit(maxRate, bufSize, window)*1.05)
+
+			// And: Two-pass lands near its target
+			if opts.RateControl == ffmpeg.RateTwoPass {
+				assert.InDelta(t, 1200_000, st.Bitrate(), 1200_000*0.15)
+			}
+
+			probe, err := ffprobe.Probe(ctx, path)
+			require.NoError(t, err)
+			require.NotNil(t, probe.VideoStream())
+			assert.Equal(t, map[ffmpeg.Codec]string{
+				ffmpeg.CodecH264: "h264", ffmpeg.CodecHEVC: "hevc", ffmpeg.CodecVP9: "vp9",
+			}[opts.Codec], probe.VideoStream().CodecName)
+		})
+	}
+
+	// And: Without the cap the noise overshoots it, so the checks above
+	// are meaningful
+	path, err := ffmpeg.Encode(ctx, input, ffmpeg.EncodeOptions{OutputDir: outputPath, Name: "uncapped.mp4", CRF: 18})
+	require.NoError(t, err)
+	assert.Greater(t, videoSamples(t, path).PeakBitrate(window), vbvLimit(maxRate, bufSize, window))
+}
@@ -0,0 +1,11 @@
+// Package ffmpeg encodes video for streaming with the lite FFmpeg image.
+package ffmpeg
+
+import "strconv"
+
+// Image is the lite FFmpeg image.
+const Image = "ghcr.io/veloxpack/ffmpeg:8.0-lite"
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,128 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/veloxpack/tools/ffprobe"
+)
+
+// HDRImage is the lite build with zimg, whose zscale filter tone maps HDR
+// to SDR. Encode uses it for HDRToneMap.
+const HDRImage = "ghcr.io/veloxpack/ffmpeg:8.0-hdr"
+
+// Transfer characteristics, as ffprobe names them, that mark a stream as
+// HDR.
+const (
+	// TransferPQ is SMPTE ST 2084, used by HDR10.
+	TransferPQ = "smpte2084"
+	// TransferHLG is hybrid log-gamma, used by broadcast and phone HDR.
+	TransferHLG = "arib-std-b67"
+)
+
+// HDRMode is how Encode treats an HDR source.
+type HDRMode string
+
+const (
+	// HDRPreserve keeps the source HDR: a 10-bit HEVC or AV1 encode with
+	// BT.2020 colour tags, the source transfer and its HDR10 mastering
+	// display and content light levels.
+	HDRPreserve HDRMode = "preserve"
+	// HDRToneMap converts to SDR BT.709 with the Hable curve, using
+	// HDRImage.
+	HDRToneMap HDRMode = "tonemap"
+)
+
+// HDR is the HDR signalling of a video stream.
+type HDR struct {
+	// Transfer is TransferPQ or TransferHLG.
+	Transfer string `json:"transfer"`
+	// Primaries and Matrix are the ffprobe colour primaries and space,
+	// normally "bt2020" and "bt2020nc".
+	Primaries string `json:"primaries"`
+	Matrix    string `json:"matrix"`
+	// Mastering and ContentLight are the HDR10 static metadata, nil when
+	// the source does not carry them.
+	Mastering    *MasteringDisplay `json:"mastering,omitempty"`
+	ContentLight *ContentLight     `json:"content_light,omitempty"`
+}
+
+// MasteringDisplay is the colour volume of the display an HDR video was
+// graded on (SMPTE ST 2086).
+type MasteringDisplay struct {
+	// Red, Green, Blue and WhitePoint are CIE 1931 xy chromaticities.
+	Red        [2]float64 `json:"red"`
+	Green      [2]float64 `json:"green"`
+	Blue       [2]float64 `json:"blue"`
+	WhitePoint [2]float64 `json:"white_point"`
+	// MinLuminance and MaxLuminance are in cd/m².
+	MinLuminance float64 `json:"min_luminance"`
+	MaxLuminance float64 `json:"max_luminance"`
+}
+
+// ContentLight is the brightest pixel (MaxCLL) and brightest frame average
+// (MaxFALL) of an HDR video, in cd/m².
+type ContentLight struct {
+	MaxCLL  int `json:"max_cll"`
+	MaxFALL int `json:"max_fall"`
+}
+
+// DetectHDR returns the HDR signalling of a video stream from its transfer
+// characteristics and side data, or nil for SDR.
+func DetectHDR(s *ffprobe.Stream) *HDR {
+	if s == nil || (s.ColorTransfer != TransferPQ && s.ColorTransfer != TransferHLG) {
+		return nil
+	}
+	h := &HDR{Transfer: s.ColorTransfer, Primaries: s.ColorPrimaries, Matrix: s.ColorSpace}
+	if h.Primaries == "" || h.Primaries == "unknown" {
+		h.Primaries = "bt2020"
+	}
+	if h.Matrix == "" || h.Matrix == "unknown" {
+		h.Matrix = "bt2020nc"
+	}
+	if sd := s.SideData("Mastering display metadata"); sd != nil && sd.MaxLuminance != "" {
+		r := ffprobe.ParseRational
+		h.Mastering = &MasteringDisplay{
+			Red:          [2]float64{r(sd.RedX), r(sd.RedY)},
+			Green:        [2]float64{r(sd.GreenX), r(sd.GreenY)},
+			Blue:         [2]float64{r(sd.BlueX), r(sd.BlueY)},
+			WhitePoint:   [2]float64{r(sd.WhitePointX), r(sd.WhitePointY)},
+			MinLuminance: r(sd.MinLuminance),
+			MaxLuminance: r(sd.MaxLuminance),
+		}
+	}
+	if sd := s.SideData("Content light level metadata"); sd != nil {
+		h.ContentLight = &ContentLight{MaxCLL: sd.MaxContent, MaxFALL: sd.MaxAverage}
+	}
+	return h
+}
+
+// x265 formats the mastering display for x265's master-display option,
+// which takes chromaticities in units of 0.00002 and luminance in units of
+// 0.0001 cd/m².
+func (m *MasteringDisplay) x265() string {
+	c := func(v float64) int { return int(math.Round(v * 50000)) }
+	l := func(v float64) int { return int(math.Round(v * 10000)) }
+	return fmt.Sprintf("G(%d,%d)B(%d,%d)R(%d,%d)WP(%d,%d)L(%d,%d)",
+		c(m.Green[0]), c(m.Green[1]), c(m.Blue[0]), c(m.Blue[1]), c(m.Red[0]), c(m.Red[1]),
+		c(m.WhitePoint[0]), c(m.WhitePoint[1]), l(m.MaxLuminance), l(m.MinLuminance))
+}
+
+// svtAV1 formats the mastering display for SVT-AV1's mastering-display
+// option, which takes plain chromaticities and cd/m².
+func (m *MasteringDisplay) svtAV1() string {
+	f := formatFloat
+	return fmt.Sprintf("G(%s,%s)B(%s,%s)R(%s,%s)WP(%s,%s)L(%s,%s)",
+		f(m.Green[0]), f(m.Green[1]), f(m.Blue[0]), f(m.Blue[1]), f(m.Red[0]), f(m.Red[1]),
+		f(m.WhitePoint[0]), f(m.WhitePoint[1]), f(m.MaxLuminance), f(m.MinLuminance))
+}
+
+// toneMapFilter converts the source to linear light, compresses its
+// highlights with the Hable curve at a 100 cd/m² SDR peak and converts the
+// result to 8-bit BT.709. The input colour is given explicitly so untagged
+// decoder output is still read as HDR.
+func toneMapFilter(h *HDR) string {
+	return fmt.Sprintf("zscale=tin=%s:pin=%s:min=%s:t=linear:npl=100,format=gbrpf32le,"+
+		"zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p",
+		h.Transfer, h.Primaries, h.Matrix)
+}
@@ -0,0 +1,214 @@
+package ffmpeg_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ffmpeg "github.com/veloxpack/tools/ffmpeg-lite"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+// hdr10Params is a P3-D65 1000 cd/m² grade, the common HDR10 mastering
+// display, with MaxCLL 1000 and MaxFALL 400.
+const hdr10Params = "hdr10=1:master-display=G(13250,34500)B(7500,3000)R(34000,16000)WP(15635,16450)L(10000000,50):max-cll=1000,400"
+
+// hdrFixture renders a 10-bit HEVC clip tagged with the given transfer.
+func hdrFixture(t *testing.T, ctx context.Context, dir, name, transfer string) string {
+	params := "log-level=error"
+	if transfer == ffmpeg.TransferPQ {
+		params = hdr10Params
+	}
+	path, err := fixture.Generate(ctx, dir, fixture.Video{
+		Name:     name,
+		Duration: 3,
+		OutputArgs: []string{
+			"-c:v", "libx265", "-preset", "ultrafast", "-pix_fmt", "yuv420p10le",
+			"-color_primaries", "bt2020", "-color_trc", transfer, "-colorspace", "bt2020nc",
+			"-x265-params", params, "-tag:v", "hvc1",
+		},
+	})
+	require.NoError(t, err)
+	return path
+}
+
+func TestDetectHDR(t *testing.T) {
+	// Given: An HDR10 stream with mastering display and content light
+	// side data, as ffprobe reports it
+	pq := &ffprobe.Stream{
+		ColorTransfer: "smpte2084", ColorPrimaries: "bt2020", ColorSpace: "bt2020nc",
+		SideDataList: []ffprobe.SideData{
+			{
+				SideDataType: "Mastering display metadata",
+				RedX:         "34000/50000", RedY: "16000/50000",
+				GreenX: "13250/50000", GreenY: "34500/50000",
+				BlueX: "7500/50000", BlueY: "3000/50000",
+				WhitePointX: "15635/50000", WhitePointY: "16450/50000",
+				MinLuminance: "50/10000", MaxLuminance: "10000000/10000",
+			},
+			{SideDataType: "Content light level metadata", MaxContent: 1000, MaxAverage: 400},
+		},
+	}
+
+	// When: Detecting HDR
+	h := ffmpeg.DetectHDR(pq)
+
+	// Then: The transfer and static metadata are read
+	require.NotNil(t, h)
+	assert.Equal(t, ffmpeg.TransferPQ, h.Transfer)
+	require.NotNil(t, h.Mastering)
+	assert.InDelta(t, 0.68, h.Mastering.Red[0], 1e-9)
+	assert.InDelta(t, 0.3127, h.Mastering.WhitePoint[0], 1e-9)
+	assert.Equal(t, 1000.0, h.Mastering.MaxLuminance)
+	assert.Equal(t, 0.005, h.Mastering.MinLuminance)
+	assert.Equal(t, &ffmpeg.ContentLight{MaxCLL: 1000, MaxFALL: 400}, h.ContentLight)
+
+	// And: HLG without side data defaults to BT.2020, and SDR is not HDR
+	h = ffmpeg.DetectHDR(&ffprobe.Stream{ColorTransfer: "arib-std-b67"})
+	require.NotNil(t, h)
+	assert.Equal(t, "bt2020", h.Primaries)
+	assert.Nil(t, h.Mastering)
+	assert.Nil(t, ffmpeg.DetectHDR(&ffprobe.Stream{ColorTransfer: "bt709"}))
+	assert.Nil(t, ffmpeg.DetectHDR(nil))
+}
+
+func TestEncodeArgs_HDR(t *testing.T) {
+	source := &ffmpeg.HDR{
+		Transfer: ffmpeg.TransferPQ, Primaries: "bt2020", Matrix: "bt2020nc",
+		Mastering: &ffmpeg.MasteringDisplay{
+			Red: [2]float64{0.68, 0.32}, Green: [2]float64{0.265, 0.69},
+			Blue: [2]float64{0.15, 0.06}, WhitePoint: [2]float64{0.3127, 0.329},
+			MinLuminance: 0.005, MaxLuminance: 1000,
+		},
+		ContentLight: &ffmpeg.ContentLight{MaxCLL: 1000, MaxFALL: 400},
+	}
+
+	// Given: An HDR10 source kept as HDR in HEVC
+	runs, err := ffmpeg.EncodeArgs("/input/in.mp4", ffmpeg.EncodeOptions{
+		Codec: ffmpeg.CodecHEVC, HDR: ffmpeg.HDRPreserve, Source: source,
+	})
+	require.NoError(t, err)
+
+	// Then: It is 10-bit Main10 with BT.2020 PQ tags and the HDR10 SEI
+	assert.Subset(t, runs[0], []string{
+		"main10", "yuv420p10le",
+		"-color_primaries", "bt2020", "-color_trc", "smpte2084", "-colorspace", "bt2020nc",
+		"-x265-params", "hdr10=1:hdr10-opt=1:master-display=G(13250,34500)B(7500,3000)R(34000,16000)WP(15635,16450)L(10000000,50):max-cll=1000,400",
+	})
+
+	// And: SVT-AV1 takes the same metadata in real units
+	runs, err = ffmpeg.EncodeArgs("/input/in.mp4", ffmpeg.EncodeOptions{
+		Codec: ffmpeg.CodecAV1, HDR: ffmpeg.HDRPreserve, Source: source,
+	})
+	require.NoError(t, err)
+	assert.Subset(t, runs[0], []string{
+		"yuv420p10le",
+		"-svtav1-params", "enable-hdr=1:mastering-display=G(0.265,0.69)B(0.15,0.06)R(0.68,0.32)WP(0.3127,0.329)L(1000,0.005):content-light=1000,400",
+	})
+
+	// And: Tone mapping linearises, maps and tags the output BT.709
+	runs, err = ffmpeg.EncodeArgs("/input/in.mp4", ffmpeg.EncodeOptions{
+		HDR: ffmpeg.HDRToneMap, Source: source, Height: 720,
+	})
+	require.NoError(t, err)
+	assert.Subset(t, runs[0], []string{
+		"-vf", "zscale=tin=smpte2084:pin=bt2020:min=bt2020nc:t=linear:npl=100,format=gbrpf32le," +
+			"zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p,scale=-2:720",
+		"-color_primaries", "bt709", "-color_trc", "bt709", "yuv420p",
+	})
+
+	// And: SDR sources are left alone, and H.264 cannot keep HDR
+	runs, err = ffmpeg.EncodeArgs("/input/in.mp4", ffmpeg.EncodeOptions{HDR: ffmpeg.HDRToneMap})
+	require.NoError(t, err)
+	assert.NotContains(t, runs[0], "-color_trc")
+	_, err = ffmpeg.EncodeArgs("/input/in.mp4", ffmpeg.EncodeOptions{HDR: ffmpeg.HDRPreserve, Source: source})
+	assert.Error(t, err)
+}
+
+func TestFFmpeg_Encode_HDRPreserve(t *testing.T) {
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name     string
+		transfer string
+		codec    ffmpeg.Codec
+	}{
+		{"hdr10-hevc", ffmpeg.TransferPQ, ffmpeg.CodecHEVC},
+		{"hdr10-av1", ffmpeg.TransferPQ, ffmpeg.CodecAV1},
+		{"hlg-hevc", ffmpeg.TransferHLG, ffmpeg.CodecHEVC},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given: A synthetic HDR-tagged source that is detected as HDR
+			input := hdrFixture(t, ctx, outputPath, tc.name+"-source.mp4", tc.transfer)
+			probe, err := ffprobe.Probe(ctx, input)
+			require.NoError(t, err)
+			source := ffmpeg.DetectHDR(probe.VideoStream())
+			require.NotNil(t, source)
+			assert.Equal(t, tc.transfer, source.Transfer)
+
+			// And: The HDR10 clips carry the mastering display and content
+			// light levels of hdr10Params
+			if tc.transfer == ffmpeg.TransferPQ {
+				require.NotNil(t, source.Mastering)
+				require.NotNil(t, source.ContentLight)
+				assert.InDelta(t, 0.68, source.Mastering.Red[0], 1e-4)
+				assert.InDelta(t, 1000, source.Mastering.MaxLuminance, 1e-3)
+				assert.Equal(t, ffmpeg.ContentLight{MaxCLL: 1000, MaxFALL: 400}, *source.ContentLight)
+			}
+
+			// When: Transcoding it with HDR preserved
+			path, err := ffmpeg.Encode(ctx, input, ffmpeg.EncodeOptions{
+				OutputDir: outputPath,
+				Name:      tc.name + ".mp4",
+				Codec:     tc.codec,
+				HDR:       ffmpeg.HDRPreserve,
+				Height:    240,
+			})
+			require.NoError(t, err)
+
+			// Then: The output keeps 10 bits and the BT.2020 colour tags
+			probe, err = ffprobe.Probe(ctx, path)
+			require.NoError(t, err)
+			video := probe.VideoStream()
+			require.NotNil(t, video)
+			assert.Equal(t, "yuv420p10le", video.PixFmt)
+			assert.Equal(t, "bt2020", video.ColorPrimaries)
+			assert.Equal(t, tc.transfer, video.ColorTransfer)
+			assert.Equal(t, "bt2020nc", video.ColorSpace)
+			assert.Equal(t, "tv", video.ColorRange)
+			output := ffmpeg.DetectHDR(video)
+			require.NotNil(t, output)
+
+			// And: The HDR10 static metadata is carried over unchanged
+			if tc.transfer == ffmpeg.TransferPQ {
+				require.NotNil(t, output.Mastering)
+				assertSameMastering(t, source.Mastering, output.Mastering)
+				require.NotNil(t, output.ContentLight)
+				assert.Equal(t, *source.ContentLight, *output.ContentLight)
+			} else {
+				assert.Nil(t, output.Mastering)
+				assert.Nil(t, output.ContentLight)
+			}
+			require.NoError(t, ffmpeg.Decode(ctx, path))
+		})
+	}
+}
+
+// assertSameMastering compares mastering displays to the precision AV1
+// stores them at: 16 fractional bits for chromaticities, 8 for the peak and
+// 14 for the black level.
+func assertSameMastering(t *testing.T, want, got *ffmpeg.MasteringDisplay) {
+	t.Helper()
+	for i := range 2 {
+		assert.InDelta(t, want.Red[i], got.Red[i], 1e-4, "red")
+		assert.InDelta(t, want.Green[i], got.Green[i], 1e-4, "green")
+		assert.InDelta(t, want.Blue[i], got.Blue[i], 1e-4, "blue")
+		assert.InDelta(t, want.WhitePoint[i], got.WhitePoint[i], 1e-4, "white point")
+	}
+	assert.InDelta(t, want.MaxLuminance, got.MaxLuminance, 0.01, "max luminance")
+	assert.InDelta(t, want.MinLuminance, got.MinLuminance, 1e-4, "min luminance")
+}
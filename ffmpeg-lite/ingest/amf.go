@@ -0,0 +1,194 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// AMF0 type markers used by RTMP commands and metadata.
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+// decodeAMF decodes every AMF0 value in b. Numbers decode to float64,
+// objects and ECMA arrays to map[string]any, and null and undefined to nil.
+func decodeAMF(b []byte) ([]any, error) {
+	r := bytes.NewReader(b)
+	var values []any
+	for r.Len() > 0 {
+		v, err := readAMF(r)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func readAMF(r *bytes.Reader) (any, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch marker {
+	case amfNumber:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(bits), nil
+	case amfBoolean:
+		v, err := r.ReadByte()
+		return v != 0, err
+	case amfString:
+		return readAMFString(r)
+	case amfLongString:
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		s := make([]byte, n)
+		_, err := io.ReadFull(r, s)
+		return string(s), err
+	case amfNull, amfUndefined:
+		return nil, nil
+	case amfECMAArray:
+		// The count is a hint; the entries end like an object's.
+		if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return readAMFObject(r)
+	case amfObject:
+		return readAMFObject(r)
+	case amfStrictArray:
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		if int(n) > r.Len() {
+			return nil, errors.New("amf: strict array longer than its message")
+		}
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = readAMF(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	case amfDate:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		// The time zone is reserved and always zero.
+		_, err := r.Seek(2, io.SeekCurrent)
+		return math.Float64frombits(bits), err
+	}
+	return nil, fmt.Errorf("amf: unsupported type 0x%02x", marker)
+}
+
+func readAMFString(r *bytes.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	s := make([]byte, n)
+	_, err := io.ReadFull(r, s)
+	return string(s), err
+}
+
+func readAMFObject(r *bytes.Reader) (map[string]any, error) {
+	obj := map[string]any{}
+	for {
+		key, err := readAMFString(r)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			end, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if end != amfObjectEnd {
+				return nil, errors.New("amf: object key is empty")
+			}
+			return obj, nil
+		}
+		if obj[key], err = readAMF(r); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// encodeAMF encodes values as AMF0. It supports the types decodeAMF
+// returns, plus int.
+func encodeAMF(values ...any) []byte {
+	var b bytes.Buffer
+	for _, v := range values {
+		writeAMF(&b, v)
+	}
+	return b.Bytes()
+}
+
+func writeAMF(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte(amfNull)
+	case bool:
+		b.WriteByte(amfBoolean)
+		if v {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case int:
+		writeAMF(b, float64(v))
+	case float64:
+		b.WriteByte(amfNumber)
+		binary.Write(b, binary.BigEndian, math.Float64bits(v))
+	case string:
+		b.WriteByte(amfString)
+		writeAMFString(b, v)
+	case map[string]any:
+		b.WriteByte(amfObject)
+		// Sorted keys keep the encoding stable.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeAMFString(b, k)
+			writeAMF(b, v[k])
+		}
+		b.Write([]byte{0, 0, amfObjectEnd})
+	case []any:
+		b.WriteByte(amfStrictArray)
+		binary.Write(b, binary.BigEndian, uint32(len(v)))
+		for _, e := range v {
+			writeAMF(b, e)
+		}
+	default:
+		b.WriteByte(amfUndefined)
+	}
+}
+
+func writeAMFString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
@@ -0,0 +1,220 @@
+// Package ingest pushes synthetic live streams to RTMP and RTSP servers and
+// records live streams from them with the lite FFmpeg image, and provides
+// local stand-in servers to test both against.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// Image is the lite FFmpeg image.
+const Image = "ghcr.io/veloxpack/ffmpeg:8.0-lite"
+
+// HostAlias is the host name under which containers reach host ports
+// exposed through HostAccessPorts, such as a stand-in server's.
+const HostAlias = "host.testcontainers.internal"
+
+// PushOptions configures Push. Zero values pick defaults.
+type PushOptions struct {
+	// Duration of the stream; defaults to 10 seconds.
+	Duration time.Duration
+	// Width and Height of the test pattern; default to 640x360.
+	Width  int
+	Height int
+	// FrameRate defaults to 25. A key frame is sent every second.
+	FrameRate int
+	// VideoBitrate and AudioBitrate in kbit/s; default to 800 and 96.
+	VideoBitrate int
+	AudioBitrate int
+	// HostAccessPorts exposes host ports, such as a stand-in server's, to
+	// the container as HostAlias.
+	HostAccessPorts []int
+}
+
+func (o PushOptions) withDefaults() PushOptions {
+	if o.Duration == 0 {
+		o.Duration = 10 * time.Second
+	}
+	if o.Width == 0 {
+		o.Width = 640
+	}
+	if o.Height == 0 {
+		o.Height = 360
+	}
+	if o.FrameRate == 0 {
+		o.FrameRate = 25
+	}
+	if o.VideoBitrate == 0 {
+		o.VideoBitrate = 800
+	}
+	if o.AudioBitrate == 0 {
+		o.AudioBitrate = 96
+	}
+	return o
+}
+
+// PushArgs returns the FFmpeg arguments that send a test pattern and tone
+// in real time to target, as H.264 and AAC in FLV for rtmp:// URLs or over
+// interleaved RTP for rtsp:// URLs.
+func PushArgs(target string, opts PushOptions) ([]string, error) {
+	output, err := outputFormat(target)
+	if err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+	rate := strconv.Itoa(opts.FrameRate)
+	args := []string{
+		"-hide_banner",
+		"-re",
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc2=size=%dx%d:rate=%s", opts.Width, opts.Height, rate),
+		"-f", "lavfi", "-i", "sine=frequency=1000:sample_rate=48000",
+		"-t", formatSeconds(opts.Duration),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-tune", "zerolatency",
+		"-pix_fmt", "yuv420p",
+		"-b:v", strconv.Itoa(opts.VideoBitrate) + "k",
+		"-g", rate,
+		"-keyint_min", rate,
+		"-sc_threshold", "0",
+		"-c:a", "aac",
+		"-b:a", strconv.Itoa(opts.AudioBitrate) + "k",
+	}
+	return append(append(args, output...), target), nil
+}
+
+// Push streams a synthetic source to target for opts.Duration and returns
+// once the stream ends.
+func Push(ctx context.Context, target string, opts PushOptions) error {
+	args, err := PushArgs(target, opts)
+	if err != nil {
+		return err
+	}
+	_, err = runner.Run(ctx, runner.Request{
+		Image:           Image,
+		Cmd:             args,
+		HostAccessPorts: opts.HostAccessPorts,
+	})
+	if err != nil {
+		return fmt.Errorf("ingest: push: %w", err)
+	}
+	return nil
+}
+
+// RecordOptions configures Record.
+type RecordOptions struct {
+	// OutputDir is the host directory the recording is written to.
+	OutputDir string
+	// Name is the output file name; defaults to "recording.mp4". The
+	// extension picks the container: .mp4, .mkv, .ts or .flv.
+	Name string
+	// Duration limits the recording; zero records until the stream ends.
+	Duration time.Duration
+	// HostAccessPorts exposes host ports, such as a stand-in server's, to
+	// the container as HostAlias.
+	HostAccessPorts []int
+}
+
+// recordFormats maps recording extensions to muxers.
+var recordFormats = map[string]string{
+	".mp4": "mp4",
+	".mkv": "matroska",
+	".ts":  "mpegts",
+	".flv": "flv",
+}
+
+// RecordArgs returns the FFmpeg arguments that copy the live stream at
+// source into /output without re-encoding. RTSP is pulled over TCP.
+func RecordArgs(source string, opts RecordOptions) ([]string, error) {
+	if _, err := outputFormat(source); err != nil {
+		return nil, err
+	}
+	if opts.Name == "" {
+		opts.Name = "recording.mp4"
+	}
+	format, ok := recordFormats[strings.ToLower(filepath.Ext(opts.Name))]
+	if !ok {
+		return nil, fmt.Errorf("ingest: unsupported recording %q", opts.Name)
+	}
+
+	args := []string{"-hide_banner"}
+	if strings.HasPrefix(source, "rtsp://") {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	args = append(args, "-i", source, "-map", "0:v:0", "-map", "0:a:0?", "-c", "copy")
+	if opts.Duration > 0 {
+		args = append(args, "-t", formatSeconds(opts.Duration))
+	}
+	if format == "mp4" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, "-f", format, "/output/"+opts.Name), nil
+}
+
+// Record copies the live stream at source into a file in opts.OutputDir
+// and returns its host path.
+func Record(ctx context.Context, source string, opts RecordOptions) (string, error) {
+	if opts.OutputDir == "" {
+		return "", errors.New("ingest: output directory is required")
+	}
+	if opts.Name == "" {
+		opts.Name = "recording.mp4"
+	}
+	args, err := RecordArgs(source, opts)
+	if err != nil {
+		return "", err
+	}
+	_, err = runner.Run(ctx, runner.Request{
+		Image:           Image,
+		Cmd:             args,
+		Mounts:          []mount.Mount{runner.Bind(opts.OutputDir, "/output")},
+		HostAccessPorts: opts.HostAccessPorts,
+	})
+	if err != nil {
+		return "", fmt.Errorf("ingest: record: %w", err)
+	}
+	return filepath.Join(opts.OutputDir, opts.Name), nil
+}
+
+// outputFormat returns the muxer arguments for a live URL.
+func outputFormat(target string) ([]string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("ingest: %w", err)
+	}
+	switch u.Scheme {
+	case "rtmp":
+		return []string{"-f", "flv"}, nil
+	case "rtsp":
+		return []string{"-f", "rtsp", "-rtsp_transport", "tcp"}, nil
+	}
+	return nil, fmt.Errorf("ingest: unsupported URL %q; use rtmp:// or rtsp://", target)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// poll calls done every 100ms until it returns true or ctx ends.
+func poll(ctx context.Context, done func() bool) error {
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package ingest_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/ffmpeg-lite/ingest"
+	"github.com/veloxpack/tools/ffprobe"
+)
+
+func TestPushArgs(t *testing.T) {
+	// Given: Default options
+	opts := ingest.PushOptions{Duration: 5 * time.Second}
+
+	// When: Pushing to RTMP and RTSP
+	rtmp, err := ingest.PushArgs("rtmp://server/live/cam", opts)
+	require.NoError(t, err)
+	rtsp, err := ingest.PushArgs("rtsp://server:8554/live/cam", opts)
+	require.NoError(t, err)
+
+	// Then: A real-time test pattern with a key frame every second is sent
+	assert.Subset(t, rtmp, []string{"-re", "testsrc2=size=640x360:rate=25", "libx264", "800k", "aac", "96k"})
+	assert.Equal(t, []string{"-t", "5"}, rtmp[10:12])
+	assert.Equal(t, []string{"-f", "flv", "rtmp://server/live/cam"}, rtmp[len(rtmp)-3:])
+
+	// And: RTSP interleaves RTP on the control connection
+	assert.Equal(t, []string{"-f", "rtsp", "-rtsp_transport", "tcp", "rtsp://server:8554/live/cam"}, rtsp[len(rtsp)-5:])
+
+	// And: Other URLs are rejected
+	_, err = ingest.PushArgs("srt://server:9000", opts)
+	assert.ErrorContains(t, err, "unsupported URL")
+}
+
+func TestRecordArgs(t *testing.T) {
+	// When: Recording three seconds of an RTSP stream to MKV
+	args, err := ingest.RecordArgs("rtsp://server/live/cam", ingest.RecordOptions{Name: "cam.mkv", Duration: 3 * time.Second})
+	require.NoError(t, err)
+
+	// Then: The stream is pulled over TCP and copied
+	assert.Equal(t, []string{"-hide_banner", "-rtsp_transport", "tcp", "-i", "rtsp://server/live/cam",
+		"-map", "0:v:0", "-map", "0:a:0?", "-c", "copy", "-t", "3", "-f", "matroska", "/output/cam.mkv"}, args)
+
+	// When: Recording RTMP with the default name
+	args, err = ingest.RecordArgs("rtmp://server/live/cam", ingest.RecordOptions{})
+	require.NoError(t, err)
+
+	// Then: The MP4 is made progressive
+	assert.NotContains(t, args, "-rtsp_transport")
+	assert.Equal(t, []string{"-movflags", "+faststart", "-f", "mp4", "/output/recording.mp4"}, args[len(args)-5:])
+
+	// And: Unknown containers are rejected
+	_, err = ingest.RecordArgs("rtmp://server/live/cam", ingest.RecordOptions{Name: "cam.avi"})
+	assert.ErrorContains(t, err, "unsupported recording")
+}
+
+// liveServer is a stand-in server as the end-to-end tests use it.
+type liveServer interface {
+	Port() int
+	URL(name string) string
+	WaitPublished(ctx context.Context, name string) error
+}
+
+// pushAndRecord pushes an 8s stream to server and records 3s of it back
+// while it runs, returning the recording.
+func pushAndRecord(t *testing.T, server liveServer, outputPath string) string {
+	t.Helper()
+	ctx := context.Background()
+	ports := []int{server.Port()}
+
+	pushed := make(chan error, 1)
+	go func() {
+		pushed <- ingest.Push(ctx, server.URL("cam"), ingest.PushOptions{
+			Duration:        8 * time.Second,
+			HostAccessPorts: ports,
+		})
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	require.NoError(t, server.WaitPublished(waitCtx, "cam"))
+
+	recording, err := ingest.Record(ctx, server.URL("cam"), ingest.RecordOptions{
+		OutputDir:       outputPath,
+		Duration:        3 * time.Second,
+		HostAccessPorts: ports,
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-pushed)
+	return recording
+}
+
+// verifyRecording checks that path holds H.264 and AAC of about duration
+// seconds.
+func verifyRecording(t *testing.T, path string, duration float64) {
+	t.Helper()
+	probe, err := ffprobe.Probe(context.Background(), path)
+	require.NoError(t, err)
+	video := probe.VideoStream()
+	require.NotNil(t, video, path)
+	assert.Equal(t, "h264", video.CodecName, path)
+	assert.Equal(t, 640, video.Width, path)
+	assert.Equal(t, 360, video.Height, path)
+	audio := probe.AudioStream()
+	require.NotNil(t, audio, path)
+	assert.Equal(t, "aac", audio.CodecName, path)
+	assert.InDelta(t, duration, probe.Duration(), 0.6, path)
+}
+
+func TestIngest_RTMP(t *testing.T) {
+	// Given: A local RTMP server
+	server := &ingest.RTMPServer{}
+	require.NoError(t, server.Start())
+	defer server.Close()
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Pushing a stream from the lite image and recording it back
+	recording := pushAndRecord(t, server, outputPath)
+
+	// Then: The recording is a playable copy of part of the stream
+	verifyRecording(t, recording, 3)
+
+	// And: The server received the whole stream with a key frame a second
+	st, ok := server.Stream("cam")
+	require.True(t, ok)
+	assert.False(t, st.Live)
+	assert.Equal(t, 7, st.VideoCodec)
+	assert.Equal(t, 10, st.AudioCodec)
+	assert.InDelta(t, 200, st.VideoFrames, 5)
+	assert.InDelta(t, 8, st.KeyFrames, 1)
+	assert.InDelta(t, 8, st.Duration.Seconds(), 0.5)
+	assert.Equal(t, 640.0, st.Metadata["width"])
+
+	// And: What it received is a valid FLV
+	flv := filepath.Join(outputPath, "cam.flv")
+	require.NoError(t, server.WriteFLV("cam", flv))
+	verifyRecording(t, flv, 8)
+}
+
+func TestIngest_RTSP(t *testing.T) {
+	// Given: A local RTSP server
+	server := &ingest.RTSPServer{}
+	require.NoError(t, server.Start())
+	defer server.Close()
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Pushing a stream from the lite image and recording it back
+	recording := pushAndRecord(t, server, outputPath)
+
+	// Then: The recording is a playable copy of part of the stream
+	verifyRecording(t, recording, 3)
+
+	// And: The publisher announced H.264 and AAC and sent RTP on both
+	st, ok := server.Stream("cam")
+	require.True(t, ok)
+	assert.False(t, st.Live)
+	assert.Contains(t, st.SDP, "H264/90000")
+	assert.Contains(t, st.SDP, "MPEG4-GENERIC/48000")
+	require.Len(t, st.Packets, 2)
+	assert.Greater(t, st.Packets[0], 200)
+	assert.Greater(t, st.Packets[1], 100)
+}
+
+func createTempDir(t *testing.T) string {
+	outputPath, err := filepath.Abs(filepath.Join("..", "..", "testdata", strings.ReplaceAll(uuid.NewString(), "-", "")))
+	require.NoError(t, err)
+
+	err = os.MkdirAll(outputPath, 0755)
+	require.NoError(t, err)
+
+	return outputPath
+}
+
+func cleanupFiles(t *testing.T, path string) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		t.Logf("failed to remove output directory: %s", path)
+	}
+}
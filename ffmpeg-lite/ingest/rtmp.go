@@ -0,0 +1,724 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RTMP message types.
+const (
+	rtmpSetChunkSize  = 1
+	rtmpUserControl   = 4
+	rtmpWindowAckSize = 5
+	rtmpSetPeerBW     = 6
+	rtmpAudio         = 8
+	rtmpVideo         = 9
+	rtmpDataAMF3      = 15
+	rtmpCommandAMF3   = 17
+	rtmpDataAMF0      = 18
+	rtmpCommandAMF0   = 20
+)
+
+// Chunk streams the server writes on, following the usual split of
+// protocol control, commands, status and media.
+const (
+	rtmpControlChunk = 2
+	rtmpCommandChunk = 3
+	rtmpAudioChunk   = 4
+	rtmpStatusChunk  = 5
+	rtmpVideoChunk   = 6
+)
+
+const (
+	rtmpHandshakeSize = 1536
+	rtmpDefaultChunk  = 128
+	rtmpOutChunkSize  = 4096
+	rtmpWindowSize    = 2500000
+	rtmpExtendedStamp = 0xffffff
+	rtmpStreamBegin   = 0
+	// rtmpMediaStreamID is the message stream createStream hands out.
+	rtmpMediaStreamID = 1
+	// rtmpSetDataFrameLen is the size of the "@setDataFrame" string that
+	// publishers put before onMetaData.
+	rtmpSetDataFrameLen = 1 + 2 + len("@setDataFrame")
+)
+
+// FLV audio and video tag fields, which RTMP media messages carry as is.
+const (
+	flvCodecAVC       = 7
+	flvCodecAAC       = 10
+	flvKeyFrame       = 1
+	flvSequenceHeader = 0
+)
+
+// RTMPStream is what a publisher has sent to an RTMPServer.
+type RTMPStream struct {
+	Name string
+	// Metadata is the onMetaData object of the publisher, e.g. width,
+	// height, framerate and encoder.
+	Metadata map[string]any
+	// VideoCodec and AudioCodec are FLV codec IDs: 7 for H.264 and 10 for
+	// AAC.
+	VideoCodec  int
+	AudioCodec  int
+	VideoFrames int
+	KeyFrames   int
+	AudioFrames int
+	// Duration spans the first to the last media timestamp.
+	Duration time.Duration
+	// Live is true while the publisher is connected.
+	Live bool
+}
+
+// RTMPServer is a local stand-in for an RTMP ingest server. It accepts one
+// publisher per stream name under any application, keeps what it sends for
+// inspection and relays it to players, who join at the next key frame.
+// Streams are keyed by name alone, so rtmp://host/live/cam and
+// rtmp://host/app/cam are the same stream.
+type RTMPServer struct {
+	mu       sync.Mutex
+	streams  map[string]*rtmpStream
+	conns    map[*rtmpConn]struct{}
+	listener net.Listener
+}
+
+type rtmpStream struct {
+	info RTMPStream
+	// meta is the onMetaData payload without @setDataFrame, and the
+	// headers are the codec configurations, all sent to players first.
+	meta        []byte
+	videoHeader *rtmpMessage
+	audioHeader *rtmpMessage
+	messages    []*rtmpMessage
+	first, last uint32
+	players     map[*rtmpConn]struct{}
+	publisher   *rtmpConn
+}
+
+type rtmpMessage struct {
+	typeID    byte
+	streamID  uint32
+	timestamp uint32
+	payload   []byte
+}
+
+// Start listens on a random local port and serves connections in the
+// background.
+func (s *RTMPServer) Start() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("rtmp server: listen: %w", err)
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.streams = map[string]*rtmpStream{}
+	s.conns = map[*rtmpConn]struct{}{}
+	s.mu.Unlock()
+	go s.serve(l)
+	return nil
+}
+
+// Port returns the port the server listens on.
+func (s *RTMPServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// URL returns the URL of a stream as seen from a container that was given
+// access to Port through HostAccessPorts.
+func (s *RTMPServer) URL(name string) string {
+	return "rtmp://" + HostAlias + ":" + strconv.Itoa(s.Port()) + "/live/" + name
+}
+
+// Close stops the server and drops every connection.
+func (s *RTMPServer) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	return err
+}
+
+// Stream returns what the publisher of name has sent so far.
+func (s *RTMPServer) Stream(name string) (RTMPStream, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.streams[name]
+	if !ok {
+		return RTMPStream{}, false
+	}
+	info := st.info
+	info.Duration = time.Duration(st.last-st.first) * time.Millisecond
+	return info, true
+}
+
+// WaitPublished blocks until name has a live publisher that has sent its
+// first key frame, so players can join.
+func (s *RTMPServer) WaitPublished(ctx context.Context, name string) error {
+	return poll(ctx, func() bool {
+		st, ok := s.Stream(name)
+		return ok && st.Live && st.KeyFrames > 0
+	})
+}
+
+// WriteFLV writes everything the publisher of name sent to path as an FLV
+// file, for probing or playback.
+func (s *RTMPServer) WriteFLV(name, path string) error {
+	s.mu.Lock()
+	st, ok := s.streams[name]
+	var messages []*rtmpMessage
+	var meta []byte
+	if ok {
+		messages, meta = st.messages, st.meta
+	}
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("rtmp server: no stream %q", name)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("rtmp server: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	w.Write([]byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0})
+	if meta != nil {
+		writeFLVTag(w, &rtmpMessage{typeID: rtmpDataAMF0, payload: meta})
+	}
+	for _, m := range messages {
+		writeFLVTag(w, m)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("rtmp server: %w", err)
+	}
+	return f.Close()
+}
+
+// writeFLVTag writes m as an FLV tag, whose type IDs match RTMP's, followed
+// by the previous tag size.
+func writeFLVTag(w io.Writer, m *rtmpMessage) {
+	var hdr [11]byte
+	hdr[0] = m.typeID
+	putUint24(hdr[1:], uint32(len(m.payload)))
+	putUint24(hdr[4:], m.timestamp&0xffffff)
+	hdr[7] = byte(m.timestamp >> 24)
+	w.Write(hdr[:])
+	w.Write(m.payload)
+	binary.Write(w, binary.BigEndian, uint32(len(hdr)+len(m.payload)))
+}
+
+func (s *RTMPServer) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := &rtmpConn{
+			server:  s,
+			conn:    conn,
+			r:       bufio.NewReader(conn),
+			inChunk: rtmpDefaultChunk,
+			chunks:  map[uint32]*chunkState{},
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// rtmpConn is one client connection, either publishing or playing.
+type rtmpConn struct {
+	server  *RTMPServer
+	conn    net.Conn
+	r       *bufio.Reader
+	inChunk int
+	chunks  map[uint32]*chunkState
+
+	wmu sync.Mutex
+
+	// Set once the connection publishes or plays.
+	stream string
+	player bool
+
+	// Player state, guarded by wmu: relaying starts at a key frame and
+	// timestamps are shifted to start at zero.
+	started bool
+	base    uint32
+}
+
+// chunkState is the header of the last chunk of a chunk stream, which later
+// chunks on it compress against.
+type chunkState struct {
+	timestamp uint32
+	field     uint32
+	length    uint32
+	typeID    byte
+	streamID  uint32
+	extended  bool
+	buf       []byte
+}
+
+func (c *rtmpConn) serve() {
+	defer c.close()
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := c.handshake(); err != nil {
+		return
+	}
+	c.conn.SetDeadline(time.Time{})
+	for {
+		m, err := c.readMessage()
+		if err != nil {
+			return
+		}
+		if err := c.handle(m); err != nil {
+			return
+		}
+	}
+}
+
+func (c *rtmpConn) close() {
+	c.conn.Close()
+	s := c.server
+	s.mu.Lock()
+	delete(s.conns, c)
+	var players []*rtmpConn
+	if st, ok := s.streams[c.stream]; ok {
+		if st.publisher == c {
+			st.publisher = nil
+			st.info.Live = false
+			for p := range st.players {
+				players = append(players, p)
+			}
+		}
+		delete(st.players, c)
+	}
+	s.mu.Unlock()
+	// Players get end of stream when their publisher leaves.
+	for _, p := range players {
+		p.conn.Close()
+	}
+}
+
+// handshake performs the plain RTMP handshake. S1 carries a zero version,
+// which tells clients not to expect the digest variant.
+func (c *rtmpConn) handshake() error {
+	c0c1 := make([]byte, 1+rtmpHandshakeSize)
+	if _, err := io.ReadFull(c.r, c0c1); err != nil {
+		return err
+	}
+	if c0c1[0] != 3 {
+		return fmt.Errorf("rtmp: unsupported version %d", c0c1[0])
+	}
+	reply := make([]byte, 1+2*rtmpHandshakeSize)
+	reply[0] = 3
+	for i := 9; i < 1+rtmpHandshakeSize; i++ {
+		reply[i] = byte(i * 7)
+	}
+	copy(reply[1+rtmpHandshakeSize:], c0c1[1:])
+	if _, err := c.conn.Write(reply); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(c.r, make([]byte, rtmpHandshakeSize))
+	return err
+}
+
+// readMessage reads chunks until a message is complete.
+func (c *rtmpConn) readMessage() (*rtmpMessage, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		format := b >> 6
+		csid := uint32(b & 0x3f)
+		switch csid {
+		case 0:
+			b, err := c.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			csid = 64 + uint32(b)
+		case 1:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+				return nil, err
+			}
+			csid = 64 + uint32(ext[0]) + uint32(ext[1])<<8
+		}
+		st := c.chunks[csid]
+		if st == nil {
+			if format != 0 {
+				return nil, fmt.Errorf("rtmp: chunk stream %d starts without a full header", csid)
+			}
+			st = &chunkState{}
+			c.chunks[csid] = st
+		}
+
+		headerSize := [...]int{11, 7, 3, 0}[format]
+		var hdr [11]byte
+		if _, err := io.ReadFull(c.r, hdr[:headerSize]); err != nil {
+			return nil, err
+		}
+		if format < 3 {
+			st.field = uint24(hdr[0:])
+			st.extended = st.field == rtmpExtendedStamp
+		}
+		if format < 2 {
+			st.length = uint24(hdr[3:])
+			st.typeID = hdr[6]
+		}
+		if format == 0 {
+			st.streamID = binary.LittleEndian.Uint32(hdr[7:])
+		}
+		if st.extended {
+			var ext [4]byte
+			if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+				return nil, err
+			}
+			if format < 3 {
+				st.field = binary.BigEndian.Uint32(ext[:])
+			}
+		}
+		// A type 0 header carries the absolute timestamp; the others and
+		// type 3 chunks starting a new message carry a delta.
+		switch {
+		case format == 0:
+			st.timestamp = st.field
+		case len(st.buf) == 0:
+			st.timestamp += st.field
+		}
+
+		n := min(c.inChunk, int(st.length)-len(st.buf))
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(c.r, chunk); err != nil {
+			return nil, err
+		}
+		st.buf = append(st.buf, chunk...)
+		if len(st.buf) < int(st.length) {
+			continue
+		}
+		m := &rtmpMessage{typeID: st.typeID, streamID: st.streamID, timestamp: st.timestamp, payload: st.buf}
+		st.buf = nil
+		return m, nil
+	}
+}
+
+// writeMessage writes m on chunk stream csid, with a full header on the
+// first chunk and type 3 headers on the rest.
+func (c *rtmpConn) writeMessage(csid byte, m *rtmpMessage) error {
+	field := min(m.timestamp, rtmpExtendedStamp)
+	extended := field == rtmpExtendedStamp
+	b := make([]byte, 0, 12+len(m.payload)+len(m.payload)/rtmpOutChunkSize*5)
+	b = append(b, csid)
+	b = appendUint24(b, field)
+	b = appendUint24(b, uint32(len(m.payload)))
+	b = append(b, m.typeID)
+	b = binary.LittleEndian.AppendUint32(b, m.streamID)
+	if extended {
+		b = binary.BigEndian.AppendUint32(b, m.timestamp)
+	}
+	for i := 0; ; {
+		n := min(rtmpOutChunkSize, len(m.payload)-i)
+		b = append(b, m.payload[i:i+n]...)
+		i += n
+		if i >= len(m.payload) {
+			break
+		}
+		b = append(b, 0xc0|csid)
+		if extended {
+			b = binary.BigEndian.AppendUint32(b, m.timestamp)
+		}
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(b)
+	return err
+}
+
+func (c *rtmpConn) writeControl(typeID byte, payload []byte) error {
+	return c.writeMessage(rtmpControlChunk, &rtmpMessage{typeID: typeID, payload: payload})
+}
+
+func (c *rtmpConn) writeCommand(csid byte, streamID uint32, values ...any) error {
+	return c.writeMessage(csid, &rtmpMessage{typeID: rtmpCommandAMF0, streamID: streamID, payload: encodeAMF(values...)})
+}
+
+func (c *rtmpConn) writeStatus(streamID uint32, level, code, description string) error {
+	return c.writeCommand(rtmpStatusChunk, streamID, "onStatus", 0, nil, map[string]any{
+		"level":       level,
+		"code":        code,
+		"description": description,
+	})
+}
+
+func (c *rtmpConn) handle(m *rtmpMessage) error {
+	switch m.typeID {
+	case rtmpSetChunkSize:
+		if len(m.payload) < 4 {
+			return errors.New("rtmp: short set chunk size")
+		}
+		c.inChunk = int(binary.BigEndian.Uint32(m.payload) & 0x7fffffff)
+	case rtmpCommandAMF3:
+		// AMF3 commands from AMF0 clients are AMF0 after a format byte.
+		if len(m.payload) > 0 {
+			return c.command(m.streamID, m.payload[1:])
+		}
+	case rtmpCommandAMF0:
+		return c.command(m.streamID, m.payload)
+	case rtmpDataAMF0, rtmpDataAMF3, rtmpAudio, rtmpVideo:
+		c.server.publish(c, m)
+	}
+	return nil
+}
+
+func (c *rtmpConn) command(streamID uint32, payload []byte) error {
+	values, err := decodeAMF(payload)
+	if err != nil || len(values) < 2 {
+		return fmt.Errorf("rtmp: malformed command: %v", err)
+	}
+	name, _ := values[0].(string)
+	txn := values[1]
+	arg := func(i int) string {
+		if i < len(values) {
+			s, _ := values[i].(string)
+			return s
+		}
+		return ""
+	}
+
+	switch name {
+	case "connect":
+		c.writeControl(rtmpWindowAckSize, binary.BigEndian.AppendUint32(nil, rtmpWindowSize))
+		c.writeControl(rtmpSetPeerBW, append(binary.BigEndian.AppendUint32(nil, rtmpWindowSize), 2))
+		c.writeControl(rtmpSetChunkSize, binary.BigEndian.AppendUint32(nil, rtmpOutChunkSize))
+		return c.writeCommand(rtmpCommandChunk, 0, "_result", txn,
+			map[string]any{"fmsVer": "FMS/3,0,1,123", "capabilities": 31},
+			map[string]any{
+				"level":          "status",
+				"code":           "NetConnection.Connect.Success",
+				"description":    "Connection succeeded.",
+				"objectEncoding": 0,
+			})
+	case "createStream":
+		return c.writeCommand(rtmpCommandChunk, 0, "_result", txn, nil, rtmpMediaStreamID)
+	case "publish":
+		stream := arg(3)
+		if !c.server.startPublishing(c, stream) {
+			c.writeStatus(streamID, "error", "NetStream.Publish.BadName", stream+" is already published.")
+			return fmt.Errorf("rtmp: %s is already published", stream)
+		}
+		return c.writeStatus(streamID, "status", "NetStream.Publish.Start", stream+" is now published.")
+	case "play":
+		stream := arg(3)
+		meta, headers, ok := c.server.startPlaying(c, stream)
+		if !ok {
+			c.writeStatus(streamID, "error", "NetStream.Play.StreamNotFound", stream+" is not published.")
+			return fmt.Errorf("rtmp: %s is not published", stream)
+		}
+		begin := binary.BigEndian.AppendUint32([]byte{0, rtmpStreamBegin}, streamID)
+		c.writeControl(rtmpUserControl, begin)
+		c.writeStatus(streamID, "status", "NetStream.Play.Reset", "Playing and resetting "+stream+".")
+		if err := c.writeStatus(streamID, "status", "NetStream.Play.Start", "Started playing "+stream+"."); err != nil {
+			return err
+		}
+		if meta != nil {
+			c.writeMessage(rtmpStatusChunk, &rtmpMessage{typeID: rtmpDataAMF0, streamID: streamID, payload: meta})
+		}
+		for _, h := range headers {
+			c.relay(h)
+		}
+		return c.server.addPlayer(c, stream)
+	case "deleteStream", "FCUnpublish", "closeStream":
+		if !c.player {
+			c.server.stopPublishing(c)
+		}
+	}
+	// releaseStream, FCPublish, getStreamLength and the like need no
+	// answer.
+	return nil
+}
+
+func (s *RTMPServer) startPublishing(c *rtmpConn, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.streams[name]
+	if ok && st.publisher != nil {
+		return false
+	}
+	// A new publisher replaces what an earlier one sent.
+	s.streams[name] = &rtmpStream{
+		info:      RTMPStream{Name: name, Live: true},
+		players:   map[*rtmpConn]struct{}{},
+		publisher: c,
+	}
+	c.stream = name
+	return true
+}
+
+func (s *RTMPServer) stopPublishing(c *rtmpConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.streams[c.stream]; ok && st.publisher == c {
+		st.info.Live = false
+	}
+}
+
+// startPlaying returns the metadata and codec headers a new player of name
+// needs before the media.
+func (s *RTMPServer) startPlaying(c *rtmpConn, name string) ([]byte, []*rtmpMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.streams[name]
+	if !ok || st.publisher == nil {
+		return nil, nil, false
+	}
+	c.stream, c.player = name, true
+	var headers []*rtmpMessage
+	for _, h := range []*rtmpMessage{st.videoHeader, st.audioHeader} {
+		if h != nil {
+			headers = append(headers, h)
+		}
+	}
+	return st.meta, headers, true
+}
+
+func (s *RTMPServer) addPlayer(c *rtmpConn, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.streams[name]
+	if !ok || st.publisher == nil {
+		return fmt.Errorf("rtmp: %s ended", name)
+	}
+	st.players[c] = struct{}{}
+	return nil
+}
+
+// publish records a data or media message from a publisher and relays it
+// to the stream's players. Media messages too short to carry an FLV tag
+// header are dropped.
+func (s *RTMPServer) publish(c *rtmpConn, m *rtmpMessage) {
+	s.mu.Lock()
+	st, ok := s.streams[c.stream]
+	if !ok || st.publisher != c {
+		s.mu.Unlock()
+		return
+	}
+	switch m.typeID {
+	case rtmpDataAMF0, rtmpDataAMF3:
+		payload := m.payload
+		if m.typeID == rtmpDataAMF3 && len(payload) > 0 {
+			payload = payload[1:]
+		}
+		values, _ := decodeAMF(payload)
+		if len(values) > 0 && values[0] == "@setDataFrame" {
+			payload = payload[rtmpSetDataFrameLen:]
+			values = values[1:]
+		}
+		if len(values) > 1 && values[0] == "onMetaData" {
+			st.meta = payload
+			st.info.Metadata, _ = values[1].(map[string]any)
+		}
+		s.mu.Unlock()
+		return
+	case rtmpVideo:
+		if len(m.payload) < 2 {
+			s.mu.Unlock()
+			return
+		}
+		st.info.VideoCodec = int(m.payload[0] & 0x0f)
+		if st.info.VideoCodec == flvCodecAVC && m.payload[1] == flvSequenceHeader {
+			st.videoHeader = m
+			break
+		}
+		st.info.VideoFrames++
+		if m.payload[0]>>4 == flvKeyFrame {
+			st.info.KeyFrames++
+		}
+		st.stamp(m.timestamp)
+	case rtmpAudio:
+		if len(m.payload) < 2 {
+			s.mu.Unlock()
+			return
+		}
+		st.info.AudioCodec = int(m.payload[0] >> 4)
+		if st.info.AudioCodec == flvCodecAAC && m.payload[1] == flvSequenceHeader {
+			st.audioHeader = m
+			break
+		}
+		st.info.AudioFrames++
+		st.stamp(m.timestamp)
+	}
+	st.messages = append(st.messages, m)
+	players := make([]*rtmpConn, 0, len(st.players))
+	for p := range st.players {
+		players = append(players, p)
+	}
+	s.mu.Unlock()
+
+	for _, p := range players {
+		if err := p.relay(m); err != nil {
+			p.conn.Close()
+		}
+	}
+}
+
+func (st *rtmpStream) stamp(ts uint32) {
+	if st.info.VideoFrames+st.info.AudioFrames == 1 {
+		st.first = ts
+	}
+	st.last = max(st.last, ts)
+}
+
+// relay sends a publisher's media message to a player. Codec headers always
+// go through; media starts at the first video key frame.
+func (c *rtmpConn) relay(m *rtmpMessage) error {
+	if len(m.payload) < 2 {
+		return nil
+	}
+	csid := byte(rtmpAudioChunk)
+	if m.typeID == rtmpVideo {
+		csid = rtmpVideoChunk
+	}
+	header := len(m.payload) > 1 && m.payload[1] == flvSequenceHeader
+	c.wmu.Lock()
+	if !c.started && !header {
+		if m.typeID != rtmpVideo || m.payload[0]>>4 != flvKeyFrame {
+			c.wmu.Unlock()
+			return nil
+		}
+		c.started, c.base = true, m.timestamp
+	}
+	var ts uint32
+	if c.started && m.timestamp > c.base {
+		ts = m.timestamp - c.base
+	}
+	c.wmu.Unlock()
+	return c.writeMessage(csid, &rtmpMessage{typeID: m.typeID, streamID: rtmpMediaStreamID, timestamp: ts, payload: m.payload})
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+func appendUint24(b []byte, v uint32) []byte {
+	return append(b, byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,252 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialRTMP connects to s, performs the handshake and raises the chunk size,
+// returning a client that reuses the server's chunk reader and writer.
+func dialRTMP(t *testing.T, s *RTMPServer) *rtmpConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	_, err = conn.Write(append([]byte{3}, make([]byte, rtmpHandshakeSize)...))
+	require.NoError(t, err)
+	s0s1s2 := make([]byte, 1+2*rtmpHandshakeSize)
+	_, err = io.ReadFull(conn, s0s1s2)
+	require.NoError(t, err)
+	assert.Equal(t, byte(3), s0s1s2[0])
+	_, err = conn.Write(s0s1s2[1 : 1+rtmpHandshakeSize])
+	require.NoError(t, err)
+
+	c := &rtmpConn{conn: conn, r: bufio.NewReader(conn), inChunk: rtmpDefaultChunk, chunks: map[uint32]*chunkState{}}
+	require.NoError(t, c.writeControl(rtmpSetChunkSize, binary.BigEndian.AppendUint32(nil, rtmpOutChunkSize)))
+	return c
+}
+
+// next returns the next message that is not protocol control, applying the
+// server's chunk size.
+func (c *rtmpConn) next(t *testing.T) *rtmpMessage {
+	t.Helper()
+	for {
+		m, err := c.readMessage()
+		require.NoError(t, err)
+		switch m.typeID {
+		case rtmpSetChunkSize:
+			c.inChunk = int(binary.BigEndian.Uint32(m.payload))
+		case rtmpWindowAckSize, rtmpSetPeerBW, rtmpUserControl:
+		default:
+			return m
+		}
+	}
+}
+
+// call sends a command and returns the decoded reply.
+func (c *rtmpConn) call(t *testing.T, streamID uint32, values ...any) []any {
+	t.Helper()
+	require.NoError(t, c.writeCommand(rtmpCommandChunk, streamID, values...))
+	return c.reply(t)
+}
+
+func (c *rtmpConn) reply(t *testing.T) []any {
+	t.Helper()
+	m := c.next(t)
+	require.Equal(t, byte(rtmpCommandAMF0), m.typeID)
+	values, err := decodeAMF(m.payload)
+	require.NoError(t, err)
+	return values
+}
+
+func statusCode(t *testing.T, values []any) string {
+	t.Helper()
+	require.Len(t, values, 4)
+	require.Equal(t, "onStatus", values[0])
+	return values[3].(map[string]any)["code"].(string)
+}
+
+// open connects, creates a stream and publishes or plays name.
+func (c *rtmpConn) open(t *testing.T, command, name string) []any {
+	t.Helper()
+	connected := c.call(t, 0, "connect", 1, map[string]any{"app": "live", "tcUrl": "rtmp://localhost/live"})
+	require.Equal(t, "_result", connected[0])
+	assert.Equal(t, "NetConnection.Connect.Success", connected[3].(map[string]any)["code"])
+
+	created := c.call(t, 0, "createStream", 2, nil)
+	require.Equal(t, []any{"_result", 2.0, nil, 1.0}, created)
+	return c.call(t, rtmpMediaStreamID, command, 0, nil, name, "live")
+}
+
+func (c *rtmpConn) send(t *testing.T, typeID byte, timestamp uint32, payload ...byte) {
+	t.Helper()
+	require.NoError(t, c.writeMessage(rtmpVideoChunk, &rtmpMessage{
+		typeID: typeID, streamID: rtmpMediaStreamID, timestamp: timestamp, payload: payload,
+	}))
+}
+
+func TestRTMPServer_PublishAndPlay(t *testing.T) {
+	// Given: A running server and a publisher
+	s := &RTMPServer{}
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	pub := dialRTMP(t, s)
+	assert.Equal(t, "NetStream.Publish.Start", statusCode(t, pub.open(t, "publish", "cam")))
+
+	// When: The publisher sends metadata, codec headers and media
+	meta := encodeAMF("@setDataFrame", "onMetaData", map[string]any{"width": 640, "height": 360})
+	pub.send(t, rtmpDataAMF0, 0, meta...)
+	pub.send(t, rtmpVideo, 0, 0x17, 0, 0, 0, 0, 1, 0x64)
+	pub.send(t, rtmpAudio, 0, 0xaf, 0, 0x11, 0x90)
+	pub.send(t, rtmpVideo, 40, 0x27, 1, 0, 0, 0, 0xaa)
+	pub.send(t, rtmpVideo, 1000, 0x17, 1, 0, 0, 0, 0xbb)
+	require.NoError(t, s.WaitPublished(context.Background(), "cam"))
+
+	// And: A player joins
+	player := dialRTMP(t, s)
+	play := player.open(t, "play", "cam")
+	assert.Equal(t, "NetStream.Play.Reset", statusCode(t, play))
+	assert.Equal(t, "NetStream.Play.Start", statusCode(t, player.reply(t)))
+
+	// Then: It gets the metadata without @setDataFrame and both headers
+	m := player.next(t)
+	assert.Equal(t, byte(rtmpDataAMF0), m.typeID)
+	assert.Equal(t, meta[rtmpSetDataFrameLen:], m.payload)
+	assert.Equal(t, []byte{0x17, 0, 0, 0, 0, 1, 0x64}, player.next(t).payload)
+	assert.Equal(t, []byte{0xaf, 0, 0x11, 0x90}, player.next(t).payload)
+
+	// When: More media arrives, starting with an inter frame
+	pub.send(t, rtmpVideo, 1040, 0x27, 1, 0, 0, 0, 0xcc)
+	pub.send(t, rtmpVideo, 2000, 0x17, 1, 0, 0, 0, 0xdd)
+	pub.send(t, rtmpAudio, 2010, 0xaf, 1, 0xee)
+
+	// Then: The player starts at the key frame, shifted to zero
+	m = player.next(t)
+	assert.Equal(t, []byte{0x17, 1, 0, 0, 0, 0xdd}, m.payload)
+	assert.Equal(t, uint32(0), m.timestamp)
+	m = player.next(t)
+	assert.Equal(t, []byte{0xaf, 1, 0xee}, m.payload)
+	assert.Equal(t, uint32(10), m.timestamp)
+
+	// And: The server kept what was published
+	st, ok := s.Stream("cam")
+	require.True(t, ok)
+	assert.True(t, st.Live)
+	assert.Equal(t, flvCodecAVC, st.VideoCodec)
+	assert.Equal(t, flvCodecAAC, st.AudioCodec)
+	assert.Equal(t, 4, st.VideoFrames)
+	assert.Equal(t, 2, st.KeyFrames)
+	assert.Equal(t, 1, st.AudioFrames)
+	assert.Equal(t, 1970*time.Millisecond, st.Duration)
+	assert.Equal(t, 640.0, st.Metadata["width"])
+
+	// When: A second publisher claims the name
+	dup := dialRTMP(t, s)
+	assert.Equal(t, "NetStream.Publish.BadName", statusCode(t, dup.open(t, "publish", "cam")))
+
+	// And: The publisher leaves
+	pub.conn.Close()
+
+	// Then: The player reaches end of stream and the stream is not live
+	_, err := player.readMessage()
+	assert.Error(t, err)
+	require.NoError(t, poll(context.Background(), func() bool {
+		st, _ := s.Stream("cam")
+		return !st.Live
+	}))
+}
+
+func TestRTMPServer_ShortMediaMessages(t *testing.T) {
+	// Given: A publisher and a player waiting for a key frame
+	s := &RTMPServer{}
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	pub := dialRTMP(t, s)
+	assert.Equal(t, "NetStream.Publish.Start", statusCode(t, pub.open(t, "publish", "cam")))
+	pub.send(t, rtmpVideo, 0, 0x17, 0, 0, 0, 0, 1, 0x64)
+	pub.send(t, rtmpVideo, 0, 0x17, 1, 0, 0, 0, 0x99)
+	require.NoError(t, s.WaitPublished(context.Background(), "cam"))
+	player := dialRTMP(t, s)
+	player.open(t, "play", "cam")
+	assert.Equal(t, "NetStream.Play.Start", statusCode(t, player.reply(t)))
+	assert.Equal(t, []byte{0x17, 0, 0, 0, 0, 1, 0x64}, player.next(t).payload)
+
+	// When: The publisher sends empty and one byte media, then a key frame
+	pub.send(t, rtmpVideo, 40)
+	pub.send(t, rtmpAudio, 40, 0xaf)
+	pub.send(t, rtmpVideo, 80, 0x17, 1, 0, 0, 0, 0xaa)
+
+	// Then: The short messages are dropped and the key frame is relayed
+	assert.Equal(t, []byte{0x17, 1, 0, 0, 0, 0xaa}, player.next(t).payload)
+	st, ok := s.Stream("cam")
+	require.True(t, ok)
+	assert.Equal(t, 2, st.VideoFrames)
+	assert.Zero(t, st.AudioFrames)
+}
+
+func TestRTMPServer_PlayUnknownStream(t *testing.T) {
+	// Given: A running server with nothing published
+	s := &RTMPServer{}
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	// When: Playing a stream
+	player := dialRTMP(t, s)
+	reply := player.open(t, "play", "missing")
+
+	// Then: The player is told it does not exist
+	assert.Equal(t, "NetStream.Play.StreamNotFound", statusCode(t, reply))
+}
+
+func TestRTMPServer_WriteFLV(t *testing.T) {
+	// Given: A stream with metadata, headers and two frames
+	s := &RTMPServer{}
+	require.NoError(t, s.Start())
+	defer s.Close()
+	pub := dialRTMP(t, s)
+	pub.open(t, "publish", "cam")
+	pub.send(t, rtmpDataAMF0, 0, encodeAMF("@setDataFrame", "onMetaData", map[string]any{"duration": 0})...)
+	pub.send(t, rtmpVideo, 0, 0x17, 0, 0, 0, 0, 1)
+	pub.send(t, rtmpVideo, 0, 0x17, 1, 0, 0, 0, 0xaa)
+	pub.send(t, rtmpAudio, 0x1000020, 0xaf, 1, 0xbb)
+	require.NoError(t, poll(context.Background(), func() bool {
+		st, _ := s.Stream("cam")
+		return st.AudioFrames == 1
+	}))
+
+	// When: Writing it out
+	path := filepath.Join(t.TempDir(), "cam.flv")
+	require.NoError(t, s.WriteFLV("cam", path))
+
+	// Then: The file is an FLV header followed by one tag per message
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []byte{'F', 'L', 'V', 1, 5, 0, 0, 0, 9, 0, 0, 0, 0}, b[:13])
+	var types []byte
+	var last []byte
+	for b = b[13:]; len(b) > 0; {
+		size := int(uint24(b[1:]))
+		types = append(types, b[0])
+		last = b[:11]
+		require.Equal(t, uint32(11+size), binary.BigEndian.Uint32(b[11+size:]))
+		b = b[15+size:]
+	}
+	assert.Equal(t, []byte{rtmpDataAMF0, rtmpVideo, rtmpVideo, rtmpAudio}, types)
+
+	// And: Timestamps past 24 bits use the extension byte
+	assert.Equal(t, []byte{0, 0, 0x20, 0x01}, last[4:8])
+	assert.Error(t, s.WriteFLV("missing", path))
+}
@@ -0,0 +1,460 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RTSPStream is what a publisher has sent to an RTSPServer.
+type RTSPStream struct {
+	Name string
+	// SDP is the session description the publisher announced.
+	SDP string
+	// Packets counts the RTP packets received per media section of the
+	// SDP, in order.
+	Packets []int
+	// Live is true from RECORD until the publisher leaves.
+	Live bool
+}
+
+// RTSPServer is a local stand-in for an RTSP server. Publishers ANNOUNCE a
+// session description and RECORD; players DESCRIBE it and PLAY, and the
+// server relays RTP and RTCP between them. Only RTP interleaved on the RTSP
+// connection is supported: UDP setups get 461 Unsupported Transport, so
+// clients should use -rtsp_transport tcp. Streams are keyed by the last
+// path segment, like RTMPServer.
+type RTSPServer struct {
+	mu       sync.Mutex
+	streams  map[string]*rtspStream
+	conns    map[*rtspConn]struct{}
+	listener net.Listener
+}
+
+type rtspStream struct {
+	info      RTSPStream
+	publisher *rtspConn
+	players   map[*rtspConn]struct{}
+}
+
+// Start listens on a random local port and serves connections in the
+// background.
+func (s *RTSPServer) Start() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("rtsp server: listen: %w", err)
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.streams = map[string]*rtspStream{}
+	s.conns = map[*rtspConn]struct{}{}
+	s.mu.Unlock()
+	go s.serve(l)
+	return nil
+}
+
+// Port returns the port the server listens on.
+func (s *RTSPServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// URL returns the URL of a stream as seen from a container that was given
+// access to Port through HostAccessPorts.
+func (s *RTSPServer) URL(name string) string {
+	return "rtsp://" + HostAlias + ":" + strconv.Itoa(s.Port()) + "/live/" + name
+}
+
+// Close stops the server and drops every connection.
+func (s *RTSPServer) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	return err
+}
+
+// Stream returns what the publisher of name has sent so far.
+func (s *RTSPServer) Stream(name string) (RTSPStream, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.streams[name]
+	if !ok {
+		return RTSPStream{}, false
+	}
+	info := st.info
+	info.Packets = append([]int(nil), info.Packets...)
+	return info, true
+}
+
+// WaitPublished blocks until name is recording and RTP has arrived on
+// every track, so players can join.
+func (s *RTSPServer) WaitPublished(ctx context.Context, name string) error {
+	return poll(ctx, func() bool {
+		st, ok := s.Stream(name)
+		if !ok || !st.Live || len(st.Packets) == 0 {
+			return false
+		}
+		for _, n := range st.Packets {
+			if n == 0 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (s *RTSPServer) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := &rtspConn{
+			server:   s,
+			conn:     conn,
+			r:        bufio.NewReader(conn),
+			session:  sessionID(),
+			tracks:   map[int]rtspChannel{},
+			channels: map[int]int{},
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// rtspConn is one client connection, either publishing or playing.
+type rtspConn struct {
+	server  *RTSPServer
+	conn    net.Conn
+	r       *bufio.Reader
+	session string
+	wmu     sync.Mutex
+
+	// stream is set by ANNOUNCE for publishers and by PLAY for players.
+	stream    string
+	publisher bool
+
+	// tracks maps a publisher's interleaved channels to tracks; channels
+	// maps tracks to a player's RTP channel, with RTCP on the next one.
+	tracks   map[int]rtspChannel
+	channels map[int]int
+}
+
+type rtspChannel struct {
+	track int
+	rtcp  bool
+}
+
+type rtspRequest struct {
+	method string
+	url    string
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func (c *rtspConn) serve() {
+	defer c.close()
+	tp := textproto.NewReader(c.r)
+	for {
+		b, err := c.r.Peek(1)
+		if err != nil {
+			return
+		}
+		if b[0] == '$' {
+			if err := c.readInterleaved(); err != nil {
+				return
+			}
+			continue
+		}
+
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		if line == "" {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			return
+		}
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		req := &rtspRequest{method: parts[0], url: parts[1], header: header}
+		if n, _ := strconv.Atoi(header.Get("Content-Length")); n > 0 {
+			req.body = make([]byte, n)
+			if _, err := io.ReadFull(c.r, req.body); err != nil {
+				return
+			}
+		}
+		if !c.handle(req) {
+			return
+		}
+	}
+}
+
+func (c *rtspConn) close() {
+	c.conn.Close()
+	s := c.server
+	s.mu.Lock()
+	delete(s.conns, c)
+	var players []*rtspConn
+	if st, ok := s.streams[c.stream]; ok {
+		if st.publisher == c {
+			st.publisher = nil
+			st.info.Live = false
+			for p := range st.players {
+				players = append(players, p)
+			}
+		}
+		delete(st.players, c)
+	}
+	s.mu.Unlock()
+	// Players get end of stream when their publisher leaves.
+	for _, p := range players {
+		p.conn.Close()
+	}
+}
+
+// readInterleaved reads one '$'-framed RTP or RTCP packet and relays it if
+// it comes from a publisher. Players' receiver reports are dropped.
+func (c *rtspConn) readInterleaved() error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[2:]))
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+	if ch, ok := c.tracks[int(hdr[1])]; ok && c.publisher {
+		c.server.relay(c, ch, payload)
+	}
+	return nil
+}
+
+// handle answers one request and reports whether the connection stays
+// open.
+func (c *rtspConn) handle(req *rtspRequest) bool {
+	name, track := streamPath(req.url)
+	switch req.method {
+	case "OPTIONS":
+		c.reply(req, "200 OK", map[string]string{
+			"Public": "OPTIONS, DESCRIBE, ANNOUNCE, SETUP, PLAY, RECORD, GET_PARAMETER, TEARDOWN",
+		}, nil)
+	case "ANNOUNCE":
+		if !c.server.announce(c, name, string(req.body)) {
+			c.reply(req, "455 Method Not Valid in This State", nil, nil)
+			return false
+		}
+		c.reply(req, "200 OK", nil, nil)
+	case "DESCRIBE":
+		sdp, ok := c.server.describe(name)
+		if !ok {
+			c.reply(req, "404 Not Found", nil, nil)
+			return true
+		}
+		c.reply(req, "200 OK", map[string]string{
+			"Content-Base": strings.TrimSuffix(req.url, "/") + "/",
+			"Content-Type": "application/sdp",
+		}, []byte(sdp))
+	case "SETUP":
+		transport := req.header.Get("Transport")
+		rtp, rtcp, ok := interleaved(transport)
+		if !ok {
+			c.reply(req, "461 Unsupported Transport", nil, nil)
+			return true
+		}
+		if c.publisher {
+			c.tracks[rtp] = rtspChannel{track: track}
+			c.tracks[rtcp] = rtspChannel{track: track, rtcp: true}
+		} else {
+			c.channels[track] = rtp
+		}
+		c.reply(req, "200 OK", map[string]string{"Transport": transport}, nil)
+	case "RECORD":
+		c.server.record(c)
+		c.reply(req, "200 OK", nil, nil)
+	case "PLAY":
+		if !c.server.play(c, name) {
+			c.reply(req, "404 Not Found", nil, nil)
+			return true
+		}
+		c.reply(req, "200 OK", map[string]string{"Range": "npt=0.000-"}, nil)
+	case "GET_PARAMETER", "SET_PARAMETER":
+		c.reply(req, "200 OK", nil, nil)
+	case "TEARDOWN":
+		c.reply(req, "200 OK", nil, nil)
+		return false
+	default:
+		c.reply(req, "501 Not Implemented", nil, nil)
+	}
+	return true
+}
+
+func (c *rtspConn) reply(req *rtspRequest, status string, header map[string]string, body []byte) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "RTSP/1.0 %s\r\nCSeq: %s\r\nSession: %s;timeout=60\r\n", status, req.header.Get("CSeq"), c.session)
+	for k, v := range header {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	if len(body) > 0 {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(body))
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+func (s *RTSPServer) announce(c *rtspConn, name, sdp string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.streams[name]; ok && st.publisher != nil {
+		return false
+	}
+	// A new publisher replaces what an earlier one sent.
+	s.streams[name] = &rtspStream{
+		info:      RTSPStream{Name: name, SDP: sdp, Packets: make([]int, strings.Count(sdp, "\nm="))},
+		publisher: c,
+		players:   map[*rtspConn]struct{}{},
+	}
+	c.stream, c.publisher = name, true
+	return true
+}
+
+func (s *RTSPServer) record(c *rtspConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.streams[c.stream]; ok && st.publisher == c {
+		st.info.Live = true
+	}
+}
+
+func (s *RTSPServer) describe(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.streams[name]
+	if !ok || !st.info.Live {
+		return "", false
+	}
+	return st.info.SDP, true
+}
+
+func (s *RTSPServer) play(c *rtspConn, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.streams[name]
+	if !ok || !st.info.Live {
+		return false
+	}
+	c.stream = name
+	st.players[c] = struct{}{}
+	return true
+}
+
+// relay counts a publisher's packet and forwards it to every player that
+// set up its track.
+func (s *RTSPServer) relay(c *rtspConn, ch rtspChannel, payload []byte) {
+	s.mu.Lock()
+	st, ok := s.streams[c.stream]
+	if !ok || st.publisher != c {
+		s.mu.Unlock()
+		return
+	}
+	if !ch.rtcp && ch.track < len(st.info.Packets) {
+		st.info.Packets[ch.track]++
+	}
+	players := make([]*rtspConn, 0, len(st.players))
+	for p := range st.players {
+		players = append(players, p)
+	}
+	s.mu.Unlock()
+
+	for _, p := range players {
+		channel, ok := p.channels[ch.track]
+		if !ok {
+			continue
+		}
+		if ch.rtcp {
+			channel++
+		}
+		frame := make([]byte, 4, 4+len(payload))
+		frame[0], frame[1] = '$', byte(channel)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+		p.wmu.Lock()
+		_, err := p.conn.Write(append(frame, payload...))
+		p.wmu.Unlock()
+		if err != nil {
+			p.conn.Close()
+		}
+	}
+}
+
+// streamPath returns the stream name and track of a request URL. SETUP
+// URLs end in a control segment such as streamid=1 or trackID=1, which is
+// the track; other URLs address track 0.
+func streamPath(raw string) (string, int) {
+	path := raw
+	if u, err := url.Parse(raw); err == nil {
+		path = u.Path
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	track := 0
+	if last := segments[len(segments)-1]; len(segments) > 1 && strings.Contains(last, "=") {
+		_, n, _ := strings.Cut(last, "=")
+		track, _ = strconv.Atoi(n)
+		segments = segments[:len(segments)-1]
+	}
+	return segments[len(segments)-1], track
+}
+
+// interleaved returns the RTP and RTCP channels of a TCP transport, such as
+// RTP/AVP/TCP;unicast;interleaved=0-1.
+func interleaved(transport string) (int, int, bool) {
+	if !strings.Contains(transport, "/TCP") {
+		return 0, 0, false
+	}
+	for _, p := range strings.Split(transport, ";") {
+		if v, ok := strings.CutPrefix(p, "interleaved="); ok {
+			a, b, _ := strings.Cut(v, "-")
+			rtp, err := strconv.Atoi(a)
+			if err != nil {
+				return 0, 0, false
+			}
+			rtcp, err := strconv.Atoi(b)
+			if err != nil {
+				rtcp = rtp + 1
+			}
+			return rtp, rtcp, true
+		}
+	}
+	return 0, 0, false
+}
+
+func sessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
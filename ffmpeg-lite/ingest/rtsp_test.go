@@ -0,0 +1,175 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSDP = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=No Name\r\nt=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:streamid=0\r\n" +
+	"m=audio 0 RTP/AVP 97\r\na=rtpmap:97 MPEG4-GENERIC/48000/2\r\na=control:streamid=1\r\n"
+
+type rtspClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	seq  int
+}
+
+type rtspResponse struct {
+	status int
+	header textproto.MIMEHeader
+	body   string
+}
+
+func dialRTSP(t *testing.T, s *RTSPServer) *rtspClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &rtspClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *rtspClient) do(t *testing.T, method, url string, header map[string]string, body string) rtspResponse {
+	t.Helper()
+	c.seq++
+	req := fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\n", method, url, c.seq)
+	for k, v := range header {
+		req += k + ": " + v + "\r\n"
+	}
+	if body != "" {
+		req += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n"
+	}
+	_, err := io.WriteString(c.conn, req+"\r\n"+body)
+	require.NoError(t, err)
+
+	tp := textproto.NewReader(c.r)
+	line, err := tp.ReadLine()
+	require.NoError(t, err)
+	fields := strings.SplitN(line, " ", 3)
+	require.Len(t, fields, 3)
+	status, err := strconv.Atoi(fields[1])
+	require.NoError(t, err)
+	h, err := tp.ReadMIMEHeader()
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(c.seq), h.Get("CSeq"))
+	res := rtspResponse{status: status, header: h}
+	if n, _ := strconv.Atoi(h.Get("Content-Length")); n > 0 {
+		b := make([]byte, n)
+		_, err := io.ReadFull(c.r, b)
+		require.NoError(t, err)
+		res.body = string(b)
+	}
+	return res
+}
+
+func (c *rtspClient) send(t *testing.T, channel byte, payload ...byte) {
+	t.Helper()
+	_, err := c.conn.Write(append([]byte{'$', channel, 0, byte(len(payload))}, payload...))
+	require.NoError(t, err)
+}
+
+func (c *rtspClient) receive(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var hdr [4]byte
+	_, err := io.ReadFull(c.r, hdr[:])
+	require.NoError(t, err)
+	require.Equal(t, byte('$'), hdr[0])
+	payload := make([]byte, int(hdr[2])<<8|int(hdr[3]))
+	_, err = io.ReadFull(c.r, payload)
+	require.NoError(t, err)
+	return hdr[1], payload
+}
+
+func TestRTSPServer_RecordAndPlay(t *testing.T) {
+	// Given: A running server
+	s := &RTSPServer{}
+	require.NoError(t, s.Start())
+	defer s.Close()
+	url := "rtsp://127.0.0.1:" + strconv.Itoa(s.Port()) + "/live/cam"
+
+	// When: A publisher announces two tracks and records over TCP
+	pub := dialRTSP(t, s)
+	assert.Equal(t, 200, pub.do(t, "OPTIONS", url, nil, "").status)
+	assert.Equal(t, 200, pub.do(t, "ANNOUNCE", url, map[string]string{"Content-Type": "application/sdp"}, testSDP).status)
+	udp := pub.do(t, "SETUP", url+"/streamid=0", map[string]string{"Transport": "RTP/AVP/UDP;unicast;client_port=5000-5001;mode=record"}, "")
+	assert.Equal(t, 461, udp.status)
+	for i := range 2 {
+		transport := fmt.Sprintf("RTP/AVP/TCP;unicast;mode=record;interleaved=%d-%d", 2*i, 2*i+1)
+		res := pub.do(t, "SETUP", fmt.Sprintf("%s/streamid=%d", url, i), map[string]string{"Transport": transport}, "")
+		assert.Equal(t, 200, res.status)
+		assert.Equal(t, transport, res.header.Get("Transport"))
+	}
+	assert.Equal(t, 200, pub.do(t, "RECORD", url, nil, "").status)
+	pub.send(t, 0, 0x80, 1)
+	pub.send(t, 2, 0x80, 2)
+	require.NoError(t, s.WaitPublished(context.Background(), "cam"))
+
+	// And: A player describes the stream and sets up the audio track only
+	player := dialRTSP(t, s)
+	desc := player.do(t, "DESCRIBE", url, nil, "")
+	require.Equal(t, 200, desc.status)
+	assert.Equal(t, testSDP, desc.body)
+	assert.Equal(t, url+"/", desc.header.Get("Content-Base"))
+	res := player.do(t, "SETUP", url+"/streamid=1", map[string]string{"Transport": "RTP/AVP/TCP;unicast;interleaved=0-1"}, "")
+	require.Equal(t, 200, res.status)
+	require.Equal(t, 200, player.do(t, "PLAY", url+"/", nil, "").status)
+
+	// Then: Audio RTP and RTCP reach it on its own channels
+	pub.send(t, 0, 0x80, 3)
+	pub.send(t, 2, 0x80, 4)
+	pub.send(t, 3, 0x81, 5)
+	ch, payload := player.receive(t)
+	assert.Equal(t, byte(0), ch)
+	assert.Equal(t, []byte{0x80, 4}, payload)
+	ch, payload = player.receive(t)
+	assert.Equal(t, byte(1), ch)
+	assert.Equal(t, []byte{0x81, 5}, payload)
+
+	// And: RTP packets are counted per track, RTCP is not
+	st, ok := s.Stream("cam")
+	require.True(t, ok)
+	assert.Equal(t, []int{2, 2}, st.Packets)
+	assert.True(t, st.Live)
+
+	// When: The publisher tears down
+	assert.Equal(t, 200, pub.do(t, "TEARDOWN", url, nil, "").status)
+
+	// Then: The player reaches end of stream and the stream is gone
+	_, err := player.r.ReadByte()
+	assert.Error(t, err)
+	require.NoError(t, poll(context.Background(), func() bool {
+		st, _ := s.Stream("cam")
+		return !st.Live
+	}))
+	late := dialRTSP(t, s)
+	assert.Equal(t, 404, late.do(t, "DESCRIBE", url, nil, "").status)
+}
+
+func TestStreamPath(t *testing.T) {
+	tests := []struct {
+		url   string
+		name  string
+		track int
+	}{
+		{"rtsp://host:8554/live/cam", "cam", 0},
+		{"rtsp://host:8554/live/cam/", "cam", 0},
+		{"rtsp://host:8554/live/cam/streamid=1", "cam", 1},
+		{"rtsp://host:8554/cam/trackID=2", "cam", 2},
+	}
+	for _, tt := range tests {
+		name, track := streamPath(tt.url)
+		assert.Equal(t, tt.name, name, tt.url)
+		assert.Equal(t, tt.track, track, tt.url)
+	}
+}
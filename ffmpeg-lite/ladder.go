@@ -0,0 +1,423 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/runner"
+	shakapackager "github.com/veloxpack/tools/shaka-packager"
+)
+
+// Codec is a video codec.
+type Codec string
+
+const (
+	// CodecH264 encodes with x264.
+	CodecH264 Codec = "h264"
+	// CodecHEVC encodes with x265, tagged hvc1 for Apple players.
+	CodecHEVC Codec = "hevc"
+	// CodecVP9 encodes with libvpx-vp9. Ladders do not accept it.
+	CodecVP9 Codec = "vp9"
+	// CodecAV1 encodes with SVT-AV1. Ladders do not accept it.
+	CodecAV1 Codec = "av1"
+)
+
+// rungName matches rung names that are safe as file names and in an HLS
+// var_stream_map.
+var rungName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// reservedNames are the outputs that sit next to the renditions: the audio
+// rendition and the HLS and DASH manifests.
+var reservedNames = map[string]bool{"audio": true, "master": true, "manifest": true}
+
+// Rung is one rendition of an ABR ladder.
+type Rung struct {
+	// Name is the output file name without extension; defaults to the
+	// height, e.g. "720p". It is limited to letters, digits, "_", "-" and
+	// ".", cannot start with "." and cannot be one of the reserved names
+	// audio, master or manifest.
+	Name string `json:"name,omitempty"`
+	// Width and Height of the rendition. When one is zero it follows the
+	// display aspect ratio of the source.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Bitrate is the target video bitrate in kbit/s.
+	Bitrate int `json:"bitrate"`
+	// MaxRate and BufSize constrain the VBV in kbit/s; they default to 1.2
+	// times Bitrate and twice MaxRate.
+	MaxRate int `json:"max_rate,omitempty"`
+	BufSize int `json:"buf_size,omitempty"`
+	// Codec defaults to CodecH264.
+	Codec Codec `json:"codec,omitempty"`
+	// Profile defaults to "high" for H.264 and "main" for HEVC.
+	Profile string `json:"profile,omitempty"`
+}
+
+// Ladder is an ABR ladder definition. Zero values pick defaults.
+type Ladder struct {
+	Rungs []Rung `json:"rungs"`
+	// SegmentDuration is the target segment duration in seconds; key
+	// frames are placed on every segment boundary. Defaults to 4.
+	SegmentDuration float64 `json:"segment_duration,omitempty"`
+	// FrameRate converts every rendition to a constant frame rate; zero
+	// keeps the source rate.
+	FrameRate float64 `json:"frame_rate,omitempty"`
+	// Preset is the encoder preset; defaults to "veryfast".
+	Preset string `json:"preset,omitempty"`
+	// AudioBitrate of the shared AAC rendition in kbit/s; defaults to 128.
+	// Use a negative value to leave audio out.
+	AudioBitrate int `json:"audio_bitrate,omitempty"`
+	// AllowUpscale keeps rungs larger than the source. By default they are
+	// dropped; when every rung is larger, the smallest is kept at the
+	// source size.
+	AllowUpscale bool `json:"allow_upscale,omitempty"`
+	// Watermark is burnt into every rendition when set.
+	Watermark *Watermark `json:"watermark,omitempty"`
+}
+
+// LadderPlan is a ladder resolved against a source video.
+type LadderPlan struct {
+	// Rungs have their names, sizes, rates, codec and profile filled in.
+	Rungs           []Rung
+	FrameRate       float64
+	SegmentDuration float64
+	// KeyframeInterval is the fixed GOP length in frames.
+	KeyframeInterval int
+	Preset           string
+	// AudioBitrate is zero when no audio rendition is encoded.
+	AudioBitrate int
+	// Watermark has its defaults filled in; nil when there is none.
+	Watermark *Watermark
+}
+
+// PlanLadder resolves l against the source video stream.
+func PlanLadder(l Ladder, video *ffprobe.Stream) (*LadderPlan, error) {
+	if len(l.Rungs) == 0 {
+		return nil, errors.New("ladder: no rungs")
+	}
+	srcW, srcH := video.DisplaySize()
+	if srcW <= 0 || srcH <= 0 {
+		return nil, errors.New("ladder: source frame size is unknown")
+	}
+	dar := float64(srcW) / float64(srcH)
+
+	p := &LadderPlan{
+		FrameRate:       l.FrameRate,
+		SegmentDuration: l.SegmentDuration,
+		Preset:          l.Preset,
+		AudioBitrate:    l.AudioBitrate,
+	}
+	if p.FrameRate == 0 {
+		p.FrameRate = video.FrameRate()
+	}
+	if p.FrameRate <= 0 {
+		return nil, errors.New("ladder: source frame rate is unknown")
+	}
+	if p.SegmentDuration == 0 {
+		p.SegmentDuration = 4
+	}
+	if p.SegmentDuration < 0 {
+		return nil, fmt.Errorf("ladder: invalid segment duration %v", p.SegmentDuration)
+	}
+	p.KeyframeInterval = max(1, int(math.Round(p.FrameRate*p.SegmentDuration)))
+	if p.Preset == "" {
+		p.Preset = "veryfast"
+	}
+	if p.AudioBitrate == 0 {
+		p.AudioBitrate = 128
+	} else if p.AudioBitrate < 0 {
+		p.AudioBitrate = 0
+	}
+	if l.Watermark != nil {
+		w, err := l.Watermark.resolve()
+		if err != nil {
+			return nil, err
+		}
+		p.Watermark = w
+	}
+
+	var smallest *Rung
+	for _, r := range l.Rungs {
+		if r.Bitrate <= 0 {
+			return nil, fmt.Errorf("ladder: rung %s needs a bitrate", r.label())
+		}
+		if r.Width < 0 || r.Height < 0 || (r.Width == 0 && r.Height == 0) {
+			return nil, fmt.Errorf("ladder: rung %s needs a width or height", r.label())
+		}
+		switch r.Codec {
+		case "":
+			r.Codec = CodecH264
+		case CodecH264, CodecHEVC:
+		default:
+			return nil, fmt.Errorf("ladder: rung %s has unsupported codec %q", r.label(), r.Codec)
+		}
+		if r.Width == 0 {
+			r.Width = even(float64(r.Height) * dar)
+		}
+		if r.Height == 0 {
+			r.Height = even(float64(r.Width) / dar)
+		}
+		if smallest == nil || r.Height < smallest.Height {
+			smallest = &r
+		}
+		if !l.AllowUpscale && (r.Width > srcW || r.Height > srcH) {
+			continue
+		}
+		p.Rungs = append(p.Rungs, r)
+	}
+	if len(p.Rungs) == 0 {
+		r := *smallest
+		r.Height = even(float64(srcH))
+		r.Width = even(float64(r.Height) * dar)
+		p.Rungs = append(p.Rungs, r)
+	}
+
+	names := map[string]bool{}
+	for i := range p.Rungs {
+		r := &p.Rungs[i]
+		if r.Name == "" {
+			r.Name = strconv.Itoa(r.Height) + "p"
+		}
+		if !rungName.MatchString(r.Name) {
+			return nil, fmt.Errorf("ladder: invalid rendition name %q", r.Name)
+		}
+		if reservedNames[r.Name] {
+			return nil, fmt.Errorf("ladder: rendition name %q is reserved", r.Name)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("ladder: duplicate rendition name %q", r.Name)
+		}
+		names[r.Name] = true
+		if r.MaxRate == 0 {
+			r.MaxRate = r.Bitrate * 6 / 5
+		}
+		if r.BufSize == 0 {
+			r.BufSize = 2 * r.MaxRate
+		}
+		if r.Profile == "" {
+			r.Profile = "high"
+			if r.Codec == CodecHEVC {
+				r.Profile = "main"
+			}
+		}
+	}
+	return p, nil
+}
+
+func (r Rung) label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("%dx%d", r.Width, r.Height)
+}
+
+// even rounds to the nearest even size of at least 2, which chroma
+// subsampled encoders require.
+func even(v float64) int {
+	return max(2, int(math.Round(v/2))*2)
+}
+
+// Args returns the FFmpeg arguments that encode every rendition of the plan
+// from the container path input into /output in a single run. The source is
+// decoded once and split into one scaler per rung.
+func (p *LadderPlan) Args(input string) []string {
+	args := []string{"-i", input, "-filter_complex", p.filterGraph()}
+	for i, r := range p.Rungs {
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i), "-an")
+		args = append(args, p.videoArgs(r)...)
+		args = append(args, "-movflags", "+faststart", "/output/"+r.Name+".mp4")
+	}
+	if p.AudioBitrate > 0 {
+		args = append(args, "-map", "0:a:0", "-vn")
+		args = append(args, p.audioArgs()...)
+		args = append(args, "-movflags", "+faststart", "/output/audio.mp4")
+	}
+	return args
+}
+
+// filterGraph decodes the source once and splits it into one scaler per
+// rung, labelled [v0], [v1] and so on. A watermark is burnt in after each
+// scaler, sized for the rung.
+func (p *LadderPlan) filterGraph() string {
+	var graph strings.Builder
+	graph.WriteString("[0:v]")
+	if p.FrameRate > 0 {
+		fmt.Fprintf(&graph, "fps=%s,", formatFloat(p.FrameRate))
+	}
+	fmt.Fprintf(&graph, "split=%d", len(p.Rungs))
+	for i := range p.Rungs {
+		fmt.Fprintf(&graph, "[s%d]", i)
+	}
+	w := p.Watermark
+	if w != nil && w.Image != "" {
+		graph.WriteString(";" + w.logoGraph(p.FrameRate, len(p.Rungs)))
+	}
+	for i, r := range p.Rungs {
+		fmt.Fprintf(&graph, ";[s%d]scale=%d:%d,setsar=1", i, r.Width, r.Height)
+		switch {
+		case w == nil:
+			fmt.Fprintf(&graph, "[v%d]", i)
+		case w.Image != "":
+			fmt.Fprintf(&graph, "[b%d]", i)
+			graph.WriteString(w.overlay(i, r))
+		default:
+			fmt.Fprintf(&graph, ",%s[v%d]", w.drawtext(r), i)
+		}
+	}
+	return graph.String()
+}
+
+// files returns the files to copy into the container: the source at the
+// container path input plus any watermark files.
+func (p *LadderPlan) files(source, input string) []testcontainers.ContainerFile {
+	files := []testcontainers.ContainerFile{runner.File(source, input)}
+	if p.Watermark != nil {
+		files = append(files, p.Watermark.files()...)
+	}
+	return files
+}
+
+// audioArgs encodes the shared stereo AAC rendition.
+func (p *LadderPlan) audioArgs() []string {
+	return []string{"-c:a", "aac", "-b:a", strconv.Itoa(p.AudioBitrate) + "k", "-ac", "2"}
+}
+
+// videoArgs returns the encoder arguments of one rung: a fixed closed GOP
+// with scene cut detection off, plus forced key frames on every segment
+// boundary so renditions switch cleanly even at fractional frame rates.
+func (p *LadderPlan) videoArgs(r Rung) []string {
+	gop := strconv.Itoa(p.KeyframeInterval)
+	var args []string
+	switch r.Codec {
+	case CodecHEVC:
+		args = []string{"-c:v", "libx265", "-tag:v", "hvc1",
+			"-x265-params", fmt.Sprintf("keyint=%s:min-keyint=%s:scenecut=0:open-gop=0", gop, gop)}
+	default:
+		args = []string{"-c:v", "libx264", "-sc_threshold", "0", "-flags", "+cgop"}
+	}
+	return append(args,
+		"-profile:v", r.Profile,
+		"-preset", p.Preset,
+		"-b:v", strconv.Itoa(r.Bitrate)+"k",
+		"-maxrate", strconv.Itoa(r.MaxRate)+"k",
+		"-bufsize", strconv.Itoa(r.BufSize)+"k",
+		"-g", gop, "-keyint_min", gop,
+		"-force_key_frames", "expr:gte(t,n_forced*"+formatFloat(p.SegmentDuration)+")",
+		"-pix_fmt", "yuv420p",
+	)
+}
+
+// Rendition is an encoded rung.
+type Rendition struct {
+	Rung
+	// Path is the host path of the MP4 file.
+	Path string
+}
+
+// LadderResult describes an encoded ladder.
+type LadderResult struct {
+	Renditions []Rendition
+	// Audio is the host path of the audio rendition, empty without audio.
+	Audio            string
+	FrameRate        float64
+	SegmentDuration  float64
+	KeyframeInterval int
+}
+
+// EncodeLadder probes the video at input, drops the rungs it would have to
+// upscale and encodes the rest, plus one AAC audio rendition, into
+// outputDir in a single FFmpeg run.
+func EncodeLadder(ctx context.Context, input, outputDir string, l Ladder) (*LadderResult, error) {
+	if outputDir == "" {
+		return nil, errors.New("ladder: output directory is required")
+	}
+	plan, err := probeAndPlan(ctx, input, l)
+	if err != nil {
+		return nil, err
+	}
+
+	containerInput := "/input/" + filepath.Base(input)
+	_, err = runner.Run(ctx, runner.Request{
+		Image:  Image,
+		Cmd:    plan.Args(containerInput),
+		Files:  plan.files(input, containerInput),
+		Mounts: []mount.Mount{runner.Bind(outputDir, "/output")},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ladder: %w", err)
+	}
+
+	res := &LadderResult{
+		FrameRate:        plan.FrameRate,
+		SegmentDuration:  plan.SegmentDuration,
+		KeyframeInterval: plan.KeyframeInterval,
+	}
+	for _, r := range plan.Rungs {
+		res.Renditions = append(res.Renditions, Rendition{Rung: r, Path: filepath.Join(outputDir, r.Name+".mp4")})
+	}
+	if plan.AudioBitrate > 0 {
+		res.Audio = filepath.Join(outputDir, "audio.mp4")
+	}
+	return res, nil
+}
+
+// probeAndPlan probes the video at input and plans l against it, leaving
+// audio out when the source has none.
+func probeAndPlan(ctx context.Context, input string, l Ladder) (*LadderPlan, error) {
+	probe, err := ffprobe.Probe(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("ladder: %w", err)
+	}
+	video := probe.VideoStream()
+	if video == nil {
+		return nil, fmt.Errorf("ladder: %s has no video stream", filepath.Base(input))
+	}
+	if probe.AudioStream() == nil {
+		l.AudioBitrate = -1
+	}
+	return PlanLadder(l, video)
+}
+
+// PackagerStreams returns one packager stream per rendition, named after
+// the rung, plus the audio stream.
+func (r *LadderResult) PackagerStreams() []shakapackager.Stream {
+	var streams []shakapackager.Stream
+	for _, v := range r.Renditions {
+		streams = append(streams, shakapackager.Stream{
+			Input:        v.Path,
+			Selector:     "video",
+			Output:       v.Name + ".mp4",
+			PlaylistName: v.Name + ".m3u8",
+		})
+	}
+	if r.Audio != "" {
+		streams = append(streams, shakapackager.Stream{
+			Input:        r.Audio,
+			Selector:     "audio",
+			Output:       "audio.mp4",
+			PlaylistName: "audio.m3u8",
+		})
+	}
+	return streams
+}
+
+// PackagerJob returns a packager job writing manifest.mpd and master.m3u8
+// to outputDir, segmented at the ladder's segment duration.
+func (r *LadderResult) PackagerJob(outputDir string) shakapackager.Job {
+	return shakapackager.Job{
+		Streams:                 r.PackagerStreams(),
+		OutputDir:               outputDir,
+		MPDOutput:               "manifest.mpd",
+		HLSMasterPlaylistOutput: "master.m3u8",
+		SegmentDuration:         r.SegmentDuration,
+	}
+}
@@ -0,0 +1,215 @@
+package ffmpeg_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ffmpeg "github.com/veloxpack/tools/ffmpeg-lite"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+	"github.com/veloxpack/tools/internal/mp4"
+	"github.com/veloxpack/tools/manifest"
+	shakapackager "github.com/veloxpack/tools/shaka-packager"
+)
+
+func standardLadder() ffmpeg.Ladder {
+	return ffmpeg.Ladder{Rungs: []ffmpeg.Rung{
+		{Height: 1080, Bitrate: 5000},
+		{Height: 720, Bitrate: 2800},
+		{Height: 360, Bitrate: 800, Profile: "main"},
+	}}
+}
+
+func TestPlanLadder_DropsUpscaledRungs(t *testing.T) {
+	// Given: A 720p source at 25 fps
+	video := &ffprobe.Stream{Width: 1280, Height: 720, AvgFrameRate: "25/1"}
+
+	// When: Planning a ladder that tops out at 1080p
+	plan, err := ffmpeg.PlanLadder(standardLadder(), video)
+	require.NoError(t, err)
+
+	// Then: 1080p is dropped and the rest are filled in
+	require.Len(t, plan.Rungs, 2)
+	assert.Equal(t, ffmpeg.Rung{Name: "720p", Width: 1280, Height: 720, Bitrate: 2800, MaxRate: 3360, BufSize: 6720,
+		Codec: ffmpeg.CodecH264, Profile: "high"}, plan.Rungs[0])
+	assert.Equal(t, "360p", plan.Rungs[1].Name)
+	assert.Equal(t, 640, plan.Rungs[1].Width)
+	assert.Equal(t, "main", plan.Rungs[1].Profile)
+	assert.Equal(t, 100, plan.KeyframeInterval)
+	assert.Equal(t, 128, plan.AudioBitrate)
+}
+
+func TestPlanLadder_KeepsSmallestRungAtSourceSize(t *testing.T) {
+	// Given: A tiny anamorphic source, 352x288 with 16:11 samples
+	video := &ffprobe.Stream{Width: 352, Height: 288, SampleAspectRatio: "16:11", AvgFrameRate: "30000/1001"}
+
+	// When: Every rung is larger than the source
+	plan, err := ffmpeg.PlanLadder(standardLadder(), video)
+	require.NoError(t, err)
+
+	// Then: Only the smallest rung is kept, clamped to the display size
+	require.Len(t, plan.Rungs, 1)
+	assert.Equal(t, "288p", plan.Rungs[0].Name)
+	assert.Equal(t, 512, plan.Rungs[0].Width)
+	assert.Equal(t, 288, plan.Rungs[0].Height)
+	assert.Equal(t, 800, plan.Rungs[0].Bitrate)
+	assert.Equal(t, 120, plan.KeyframeInterval)
+}
+
+func TestPlanLadder_Errors(t *testing.T) {
+	video := &ffprobe.Stream{Width: 1920, Height: 1080, AvgFrameRate: "25/1"}
+	for name, l := range map[string]ffmpeg.Ladder{
+		"no rungs":       {},
+		"no bitrate":     {Rungs: []ffmpeg.Rung{{Height: 720}}},
+		"no size":        {Rungs: []ffmpeg.Rung{{Bitrate: 1000}}},
+		"unknown codec":  {Rungs: []ffmpeg.Rung{{Height: 720, Bitrate: 1000, Codec: "mpeg2"}}},
+		"duplicate name": {Rungs: []ffmpeg.Rung{{Height: 720, Bitrate: 3000}, {Height: 720, Bitrate: 2000}}},
+		"audio name":     {Rungs: []ffmpeg.Rung{{Name: "audio", Height: 720, Bitrate: 3000}}},
+		"master name":    {Rungs: []ffmpeg.Rung{{Name: "master", Height: 720, Bitrate: 3000}}},
+		"slash in name":  {Rungs: []ffmpeg.Rung{{Name: "hd/720p", Height: 720, Bitrate: 3000}}},
+		"parent name":    {Rungs: []ffmpeg.Rung{{Name: "..", Height: 720, Bitrate: 3000}}},
+		"escaping name":  {Rungs: []ffmpeg.Rung{{Name: "../720p", Height: 720, Bitrate: 3000}}},
+		"comma in name":  {Rungs: []ffmpeg.Rung{{Name: "720p,name:x", Height: 720, Bitrate: 3000}}},
+	} {
+		_, err := ffmpeg.PlanLadder(l, video)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLadderPlan_Args(t *testing.T) {
+	// Given: An H.264 and an HEVC rung on 2s segments
+	video := &ffprobe.Stream{Width: 1920, Height: 1080, AvgFrameRate: "50/1"}
+	plan, err := ffmpeg.PlanLadder(ffmpeg.Ladder{
+		Rungs: []ffmpeg.Rung{
+			{Name: "hd", Height: 1080, Bitrate: 6000, Codec: ffmpeg.CodecHEVC},
+			{Name: "sd", Width: 640, Bitrate: 900},
+		},
+		SegmentDuration: 2,
+		FrameRate:       25,
+		AudioBitrate:    96,
+	}, video)
+	require.NoError(t, err)
+
+	// When: Building the command line
+	args := plan.Args("/input/in.mp4")
+
+	// Then: One decode feeds a split with a scaler per rung
+	assert.Equal(t, []string{"-i", "/input/in.mp4", "-filter_complex",
+		"[0:v]fps=25,split=2[s0][s1];[s0]scale=1920:1080,setsar=1[v0];[s1]scale=640:360,setsar=1[v1]"}, args[:4])
+
+	// And: Both renditions get the same fixed, closed GOP
+	assert.Subset(t, args, []string{"libx265", "hvc1", "keyint=50:min-keyint=50:scenecut=0:open-gop=0", "/output/hd.mp4"})
+	assert.Subset(t, args, []string{"libx264", "+cgop", "/output/sd.mp4", "expr:gte(t,n_forced*2)"})
+	assert.Equal(t, 2, countArg(args, "-g", "50"))
+
+	// And: Audio is encoded once
+	assert.Equal(t, []string{"-map", "0:a:0", "-vn", "-c:a", "aac", "-b:a", "96k", "-ac", "2",
+		"-movflags", "+faststart", "/output/audio.mp4"}, args[len(args)-12:])
+}
+
+// countArg counts occurrences of flag followed by value.
+func countArg(args []string, flag, value string) int {
+	n := 0
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag && args[i+1] == value {
+			n++
+		}
+	}
+	return n
+}
+
+// videoSamples returns the sample table of the video track in a
+// progressive MP4.
+func videoSamples(t *testing.T, path string) *mp4.SampleTable {
+	boxes, err := mp4.ReadFile(path)
+	require.NoError(t, err)
+	for _, trak := range mp4.Tracks(boxes) {
+		if mp4.HandlerType(trak) == "vide" {
+			st, err := mp4.ParseSampleTable(trak)
+			require.NoError(t, err)
+			return st
+		}
+	}
+	t.Fatalf("%s has no video track", path)
+	return nil
+}
+
+// syncSamples returns the sample numbers of the key frames of the video
+// track in a progressive MP4.
+func syncSamples(t *testing.T, path string) []int {
+	var samples []int
+	for i, sync := range videoSamples(t, path).Sync {
+		if sync {
+			samples = append(samples, i)
+		}
+	}
+	return samples
+}
+
+func TestFFmpeg_EncodeLadder(t *testing.T) {
+	// Given: A 12s 720p clip with audio
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	input, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:     "source.mp4",
+		Duration: 12,
+		Width:    1280,
+		Height:   720,
+		Audio:    "sine=frequency=440",
+	})
+	require.NoError(t, err)
+
+	// When: Encoding a ladder that tops out at 1080p on 2s segments
+	ladder := standardLadder()
+	ladder.SegmentDuration = 2
+	renditions := createTempDir(t)
+	defer cleanupFiles(t, renditions)
+	result, err := ffmpeg.EncodeLadder(ctx, input, renditions, ladder)
+	require.NoError(t, err)
+
+	// Then: 1080p was not upscaled and the rest came out at their sizes
+	require.Len(t, result.Renditions, 2)
+	assert.Equal(t, 50, result.KeyframeInterval)
+	for _, r := range result.Renditions {
+		probe, err := ffprobe.Probe(ctx, r.Path)
+		require.NoError(t, err)
+		video := probe.VideoStream()
+		require.NotNil(t, video)
+		assert.Equal(t, r.Width, video.Width, r.Name)
+		assert.Equal(t, r.Height, video.Height, r.Name)
+		assert.Nil(t, probe.AudioStream(), r.Name)
+	}
+	verifyFileExists(t, result.Audio)
+
+	// And: Key frames fall on the same samples, one every segment
+	want := []int{0, 50, 100, 150, 200, 250}
+	for _, r := range result.Renditions {
+		assert.Equal(t, want, syncSamples(t, r.Path), r.Name)
+	}
+
+	// When: Packaging the renditions as they are
+	packaged := createTempDir(t)
+	defer cleanupFiles(t, packaged)
+	_, err = shakapackager.Run(ctx, result.PackagerJob(packaged))
+	require.NoError(t, err)
+
+	// Then: Both manifests list every rendition
+	mpd, err := manifest.ReadMPD(filepath.Join(packaged, "manifest.mpd"))
+	require.NoError(t, err)
+	heights := map[int]bool{}
+	for _, as := range mpd.AdaptationSetsByType("video") {
+		for _, rep := range as.Representations {
+			heights[rep.Height] = true
+		}
+	}
+	assert.Equal(t, map[int]bool{720: true, 360: true}, heights)
+
+	master, err := manifest.ReadMasterPlaylist(filepath.Join(packaged, "master.m3u8"))
+	require.NoError(t, err)
+	assert.Len(t, master.Variants, 2)
+}
@@ -0,0 +1,450 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/ffmpeg-lite/quality"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// Metric is the quality measure the convex hull is built on.
+type Metric string
+
+const (
+	// MetricPSNR is the average PSNR over all planes, in dB.
+	MetricPSNR Metric = "psnr"
+	// MetricSSIM is the SSIM over all planes, from 0 to 1.
+	MetricSSIM Metric = "ssim"
+)
+
+// PerTitleOptions configures PerTitle. Zero values pick defaults.
+type PerTitleOptions struct {
+	// WorkDir is the host directory that receives the reference sample,
+	// the probe encodes and pertitle.json.
+	WorkDir string
+	// Heights are the candidate resolutions; defaults to 1080, 720, 540,
+	// 360 and 240. Heights above the source are skipped.
+	Heights []int
+	// CRFs are the probe encode quality levels, from 0 to 51; defaults to
+	// 18, 23, 28, 33 and 38.
+	CRFs []int
+	// Samples is the number of excerpts measured; defaults to 3.
+	Samples int
+	// SampleDuration is the length of each excerpt in seconds; defaults to
+	// 4.
+	SampleDuration float64
+	// Codec of the probe encodes and the ladder; defaults to CodecH264.
+	Codec Codec
+	// Preset of the probe encodes; defaults to "veryfast".
+	Preset string
+	// Metric defaults to MetricPSNR.
+	Metric Metric
+	// Rungs is the maximum ladder size; defaults to 4.
+	Rungs int
+	// MinBitrate and MaxBitrate bound the ladder in kbit/s; zero leaves
+	// that end open.
+	MinBitrate int
+	MaxBitrate int
+	// SegmentDuration is copied into the ladder.
+	SegmentDuration float64
+}
+
+func (o PerTitleOptions) withDefaults() PerTitleOptions {
+	if len(o.Heights) == 0 {
+		o.Heights = []int{1080, 720, 540, 360, 240}
+	}
+	if len(o.CRFs) == 0 {
+		o.CRFs = []int{18, 23, 28, 33, 38}
+	}
+	if o.Samples == 0 {
+		o.Samples = 3
+	}
+	if o.SampleDuration == 0 {
+		o.SampleDuration = 4
+	}
+	if o.Codec == "" {
+		o.Codec = CodecH264
+	}
+	if o.Preset == "" {
+		o.Preset = "veryfast"
+	}
+	if o.Metric == "" {
+		o.Metric = MetricPSNR
+	}
+	if o.Rungs == 0 {
+		o.Rungs = 4
+	}
+	return o
+}
+
+// validate checks options that have had their defaults applied. Each
+// height and CRF names one probe file, so neither may repeat.
+func (o PerTitleOptions) validate() error {
+	if o.Codec != CodecH264 && o.Codec != CodecHEVC {
+		return fmt.Errorf("per-title: unsupported codec %q", o.Codec)
+	}
+	if o.Metric != MetricPSNR && o.Metric != MetricSSIM {
+		return fmt.Errorf("per-title: unsupported metric %q", o.Metric)
+	}
+	heights := map[int]bool{}
+	for _, h := range o.Heights {
+		if h <= 0 || heights[h] {
+			return fmt.Errorf("per-title: invalid or duplicate height %d", h)
+		}
+		heights[h] = true
+	}
+	crfs := map[int]bool{}
+	for _, crf := range o.CRFs {
+		if crf < 0 || crf > 51 || crfs[crf] {
+			return fmt.Errorf("per-title: invalid or duplicate crf %d", crf)
+		}
+		crfs[crf] = true
+	}
+	if o.Samples < 0 || o.SampleDuration < 0 || o.Rungs < 0 {
+		return errors.New("per-title: samples, sample duration and rungs cannot be negative")
+	}
+	if o.MinBitrate < 0 || o.MaxBitrate < 0 || (o.MaxBitrate > 0 && o.MinBitrate > o.MaxBitrate) {
+		return fmt.Errorf("per-title: invalid bitrate range %d to %d", o.MinBitrate, o.MaxBitrate)
+	}
+	return nil
+}
+
+// ProbePoint is one probe encode measured against the reference.
+type ProbePoint struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	CRF    int `json:"crf"`
+	// Bitrate is the measured video bitrate in kbit/s.
+	Bitrate float64 `json:"bitrate"`
+	PSNR    float64 `json:"psnr"`
+	SSIM    float64 `json:"ssim"`
+	// OnHull marks the points on the convex hull.
+	OnHull bool `json:"on_hull"`
+}
+
+// Quality returns the point's value of metric.
+func (p ProbePoint) Quality(metric Metric) float64 {
+	if metric == MetricSSIM {
+		return p.SSIM
+	}
+	return p.PSNR
+}
+
+// PerTitleReport holds the measurements and the chosen ladder.
+type PerTitleReport struct {
+	Source string `json:"source"`
+	// Samples are the excerpt start points in seconds.
+	Samples        []float64    `json:"samples"`
+	SampleDuration float64      `json:"sample_duration"`
+	Metric         Metric       `json:"metric"`
+	Points         []ProbePoint `json:"points"`
+	Ladder         Ladder       `json:"ladder"`
+}
+
+// PerTitle builds a ladder fitted to the content of the video at input. It
+// cuts short excerpts into a lossless reference at the display size, encodes
+// it at every candidate height and CRF in one run, scales each probe back up
+// and measures it against the reference, then picks rungs from the convex
+// hull of bitrate against quality. The report is also written to
+// WorkDir/pertitle.json.
+func PerTitle(ctx context.Context, input string, opts PerTitleOptions) (*PerTitleReport, error) {
+	if opts.WorkDir == "" {
+		return nil, errors.New("per-title: work directory is required")
+	}
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	probe, err := ffprobe.Probe(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("per-title: %w", err)
+	}
+	video := probe.VideoStream()
+	if video == nil {
+		return nil, fmt.Errorf("per-title: %s has no video stream", filepath.Base(input))
+	}
+	srcW, srcH := video.DisplaySize()
+	samples, sampleDuration := samplePoints(probe.Duration(), opts.Samples, opts.SampleDuration)
+	if len(samples) == 0 {
+		return nil, errors.New("per-title: video is too short to sample")
+	}
+
+	var sizes [][2]int
+	for _, h := range opts.Heights {
+		if h <= srcH {
+			sizes = append(sizes, [2]int{even(float64(h) * float64(srcW) / float64(srcH)), h})
+		}
+	}
+	if len(sizes) == 0 {
+		sizes = append(sizes, [2]int{even(float64(srcW)), even(float64(srcH))})
+	}
+
+	if err := encodeReference(ctx, input, opts.WorkDir, samples, sampleDuration, srcW, srcH); err != nil {
+		return nil, fmt.Errorf("per-title: %w", err)
+	}
+	if err := encodeProbes(ctx, opts, sizes); err != nil {
+		return nil, fmt.Errorf("per-title: %w", err)
+	}
+
+	total := sampleDuration * float64(len(samples))
+	var points []ProbePoint
+	for _, size := range sizes {
+		for _, crf := range opts.CRFs {
+			p := ProbePoint{Width: size[0], Height: size[1], CRF: crf}
+			name := probeName(p)
+			info, err := os.Stat(filepath.Join(opts.WorkDir, name))
+			if err != nil {
+				return nil, fmt.Errorf("per-title: %w", err)
+			}
+			p.Bitrate = float64(info.Size()) * 8 / total / 1000
+			if p.PSNR, p.SSIM, err = measureProbe(ctx, opts.WorkDir, name, srcW, srcH); err != nil {
+				return nil, fmt.Errorf("per-title: %s: %w", name, err)
+			}
+			points = append(points, p)
+		}
+	}
+
+	hull := ConvexHull(points, opts.Metric)
+	for i := range points {
+		for _, h := range hull {
+			if points[i] == h {
+				points[i].OnHull = true
+			}
+		}
+	}
+	report := &PerTitleReport{
+		Source:         filepath.Base(input),
+		Samples:        samples,
+		SampleDuration: sampleDuration,
+		Metric:         opts.Metric,
+		Points:         points,
+		Ladder: Ladder{
+			Rungs:           SelectRungs(hull, opts.Rungs, opts.MinBitrate, opts.MaxBitrate),
+			SegmentDuration: opts.SegmentDuration,
+		},
+	}
+	for i := range report.Ladder.Rungs {
+		report.Ladder.Rungs[i].Codec = opts.Codec
+	}
+	if err := report.WriteJSON(filepath.Join(opts.WorkDir, "pertitle.json")); err != nil {
+		return nil, fmt.Errorf("per-title: %w", err)
+	}
+	return report, nil
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *PerTitleReport) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// samplePoints spreads n excerpts of d seconds evenly over the middle 90% of
+// the video. Short videos are measured whole as a single excerpt.
+func samplePoints(duration float64, n int, d float64) ([]float64, float64) {
+	if duration <= 0 || n <= 0 {
+		return nil, 0
+	}
+	if duration <= float64(n)*d {
+		return []float64{0}, duration
+	}
+	start, end := duration*0.05, duration*0.95-d
+	if end < start {
+		start, end = 0, duration-d
+	}
+	points := make([]float64, n)
+	for i := range points {
+		if n == 1 {
+			points[i] = start + (end-start)/2
+		} else {
+			points[i] = start + float64(i)*(end-start)/float64(n-1)
+		}
+	}
+	return points, d
+}
+
+// encodeReference concatenates the excerpts into a lossless reference at
+// the display size of the source, so every probe is measured the way a
+// player would show it.
+func encodeReference(ctx context.Context, input, workDir string, samples []float64, d float64, w, h int) error {
+	containerInput := "/input/" + filepath.Base(input)
+	var cmd []string
+	var graph strings.Builder
+	for i, t := range samples {
+		cmd = append(cmd, "-ss", formatFloat(t), "-t", formatFloat(d), "-i", containerInput)
+		fmt.Fprintf(&graph, "[%d:v]scale=%d:%d,setsar=1,format=yuv420p[r%d];", i, w, h, i)
+	}
+	for i := range samples {
+		fmt.Fprintf(&graph, "[r%d]", i)
+	}
+	fmt.Fprintf(&graph, "concat=n=%d:v=1:a=0[ref]", len(samples))
+	cmd = append(cmd, "-filter_complex", graph.String(), "-map", "[ref]",
+		"-c:v", "libx264", "-preset", "ultrafast", "-qp", "0", "/work/reference.mkv")
+	_, err := runner.Run(ctx, runner.Request{
+		Image:  Image,
+		Cmd:    cmd,
+		Files:  []testcontainers.ContainerFile{runner.File(input, containerInput)},
+		Mounts: []mount.Mount{runner.Bind(workDir, "/work")},
+	})
+	if err != nil {
+		return fmt.Errorf("reference: %w", err)
+	}
+	return nil
+}
+
+// encodeProbes encodes the reference at every size and CRF in one run.
+func encodeProbes(ctx context.Context, opts PerTitleOptions, sizes [][2]int) error {
+	var graph strings.Builder
+	fmt.Fprintf(&graph, "[0:v]split=%d", len(sizes))
+	for i := range sizes {
+		fmt.Fprintf(&graph, "[h%d]", i)
+	}
+	var outputs []string
+	for i, size := range sizes {
+		fmt.Fprintf(&graph, ";[h%d]scale=%d:%d,setsar=1,split=%d", i, size[0], size[1], len(opts.CRFs))
+		for j := range opts.CRFs {
+			fmt.Fprintf(&graph, "[h%dc%d]", i, j)
+		}
+		for j, crf := range opts.CRFs {
+			outputs = append(outputs, "-map", fmt.Sprintf("[h%dc%d]", i, j))
+			if opts.Codec == CodecHEVC {
+				outputs = append(outputs, "-c:v", "libx265", "-x265-params", "log-level=error")
+			} else {
+				outputs = append(outputs, "-c:v", "libx264")
+			}
+			p := ProbePoint{Width: size[0], Height: size[1], CRF: crf}
+			outputs = append(outputs, "-preset", opts.Preset, "-crf", strconv.Itoa(crf), "-pix_fmt", "yuv420p",
+				"/work/"+probeName(p))
+		}
+	}
+	cmd := append([]string{"-i", "/work/reference.mkv", "-filter_complex", graph.String()}, outputs...)
+	_, err := runner.Run(ctx, runner.Request{
+		Image:  Image,
+		Cmd:    cmd,
+		Mounts: []mount.Mount{runner.Bind(opts.WorkDir, "/work")},
+	})
+	if err != nil {
+		return fmt.Errorf("probe encodes: %w", err)
+	}
+	return nil
+}
+
+func probeName(p ProbePoint) string {
+	return fmt.Sprintf("probe_%dp_crf%d.mp4", p.Height, p.CRF)
+}
+
+// measureProbe scales a probe back to the reference size and measures PSNR
+// and SSIM in one pass, leaving the filter logs of the last probe in
+// workDir.
+func measureProbe(ctx context.Context, workDir, name string, w, h int) (psnr, ssim float64, err error) {
+	opts := quality.Options{Width: w, Height: h}
+	_, err = runner.Run(ctx, runner.Request{
+		Image: quality.Image,
+		Cmd:   quality.Args("/work/reference.mkv", "/work/"+name, opts),
+		Mounts: []mount.Mount{
+			runner.Bind(workDir, "/work"),
+			runner.Bind(workDir, "/logs"),
+		},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	res, err := quality.ReadResult(workDir, w, h, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	return res.PSNR.Mean, res.SSIM.Mean, nil
+}
+
+// ConvexHull returns the upper convex hull of quality against bitrate,
+// ordered by bitrate. Points that do not raise quality over a cheaper point
+// are dominated and never on the hull.
+func ConvexHull(points []ProbePoint, metric Metric) []ProbePoint {
+	sorted := append([]ProbePoint(nil), points...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Bitrate < sorted[j].Bitrate })
+
+	var hull []ProbePoint
+	for _, p := range sorted {
+		if len(hull) > 0 && p.Quality(metric) <= hull[len(hull)-1].Quality(metric) {
+			continue
+		}
+		for len(hull) >= 2 {
+			o, a := hull[len(hull)-2], hull[len(hull)-1]
+			cross := (a.Bitrate-o.Bitrate)*(p.Quality(metric)-o.Quality(metric)) -
+				(a.Quality(metric)-o.Quality(metric))*(p.Bitrate-o.Bitrate)
+			if cross < 0 {
+				break
+			}
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull
+}
+
+// SelectRungs picks at most n hull points spread evenly over the log of the
+// bitrate range, one per height, and returns them as rungs from the highest
+// bitrate down. Heights never grow as the bitrate drops.
+func SelectRungs(hull []ProbePoint, n, minBitrate, maxBitrate int) []Rung {
+	var usable []ProbePoint
+	for _, p := range hull {
+		if (minBitrate > 0 && p.Bitrate < float64(minBitrate)) || (maxBitrate > 0 && p.Bitrate > float64(maxBitrate)) {
+			continue
+		}
+		usable = append(usable, p)
+	}
+	if len(usable) == 0 || n <= 0 {
+		return nil
+	}
+
+	lo, hi := math.Log(usable[0].Bitrate), math.Log(usable[len(usable)-1].Bitrate)
+	used := map[int]bool{}
+	var picked []ProbePoint
+	for i := 0; i < n; i++ {
+		target := hi
+		if n > 1 {
+			target = lo + float64(i)*(hi-lo)/float64(n-1)
+		}
+		best := -1
+		for j, p := range usable {
+			if used[p.Height] {
+				continue
+			}
+			if best < 0 || math.Abs(math.Log(p.Bitrate)-target) < math.Abs(math.Log(usable[best].Bitrate)-target) {
+				best = j
+			}
+		}
+		if best < 0 {
+			break
+		}
+		used[usable[best].Height] = true
+		picked = append(picked, usable[best])
+	}
+	sort.Slice(picked, func(i, j int) bool { return picked[i].Bitrate > picked[j].Bitrate })
+
+	var rungs []Rung
+	for _, p := range picked {
+		if len(rungs) > 0 && p.Height > rungs[len(rungs)-1].Height {
+			continue
+		}
+		// Tiny probes can measure under 0.5 kbit/s; PlanLadder needs a
+		// positive bitrate.
+		rungs = append(rungs, Rung{Width: p.Width, Height: p.Height, Bitrate: max(1, int(math.Round(p.Bitrate)))})
+	}
+	return rungs
+}
@@ -0,0 +1,141 @@
+package ffmpeg_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ffmpeg "github.com/veloxpack/tools/ffmpeg-lite"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+func TestConvexHull(t *testing.T) {
+	// Given: Two resolutions whose curves cross, plus a dominated point
+	points := []ffmpeg.ProbePoint{
+		{Height: 360, CRF: 33, Bitrate: 300, PSNR: 33},
+		{Height: 360, CRF: 28, Bitrate: 600, PSNR: 36},
+		{Height: 360, CRF: 23, Bitrate: 1200, PSNR: 37},
+		{Height: 720, CRF: 33, Bitrate: 700, PSNR: 35}, // dominated by 360p CRF 28
+		{Height: 720, CRF: 28, Bitrate: 1400, PSNR: 39},
+		{Height: 720, CRF: 23, Bitrate: 2800, PSNR: 42},
+	}
+
+	// When: Building the hull on PSNR
+	hull := ffmpeg.ConvexHull(points, ffmpeg.MetricPSNR)
+
+	// Then: The dominated point and the point below the hull are dropped
+	var crfs []int
+	for _, p := range hull {
+		crfs = append(crfs, p.Height*100+p.CRF)
+	}
+	assert.Equal(t, []int{36033, 36028, 72028, 72023}, crfs)
+}
+
+func TestSelectRungs(t *testing.T) {
+	hull := []ffmpeg.ProbePoint{
+		{Width: 426, Height: 240, Bitrate: 200},
+		{Width: 640, Height: 360, Bitrate: 450},
+		{Width: 640, Height: 360, Bitrate: 700},
+		{Width: 1280, Height: 720, Bitrate: 1500},
+		{Width: 1920, Height: 1080, Bitrate: 3600},
+		{Width: 1920, Height: 1080, Bitrate: 6000},
+	}
+
+	// Three rungs spread over the whole range, the middle one nearest the
+	// geometric mean of about 1100 kbit/s
+	assert.Equal(t, []ffmpeg.Rung{
+		{Width: 1920, Height: 1080, Bitrate: 6000},
+		{Width: 1280, Height: 720, Bitrate: 1500},
+		{Width: 426, Height: 240, Bitrate: 200},
+	}, ffmpeg.SelectRungs(hull, 3, 0, 0))
+
+	// A bitrate cap leaves out the top of the hull
+	rungs := ffmpeg.SelectRungs(hull, 4, 300, 4000)
+	require.NotEmpty(t, rungs)
+	assert.Equal(t, 3600, rungs[0].Bitrate)
+	for _, r := range rungs {
+		assert.GreaterOrEqual(t, r.Bitrate, 300)
+	}
+}
+
+func TestSelectRungs_MinimumBitrate(t *testing.T) {
+	// A nearly static probe rounds to zero kbit/s but still plans
+	rungs := ffmpeg.SelectRungs([]ffmpeg.ProbePoint{{Width: 426, Height: 240, Bitrate: 0.3}}, 1, 0, 0)
+	assert.Equal(t, []ffmpeg.Rung{{Width: 426, Height: 240, Bitrate: 1}}, rungs)
+	_, err := ffmpeg.PlanLadder(ffmpeg.Ladder{Rungs: rungs}, &ffprobe.Stream{Width: 640, Height: 360, AvgFrameRate: "25/1"})
+	assert.NoError(t, err)
+}
+
+func TestPerTitle_Errors(t *testing.T) {
+	for name, opts := range map[string]ffmpeg.PerTitleOptions{
+		"no work dir":       {},
+		"unknown codec":     {WorkDir: "out", Codec: ffmpeg.CodecAV1},
+		"unknown metric":    {WorkDir: "out", Metric: "vmaf"},
+		"duplicate crf":     {WorkDir: "out", CRFs: []int{23, 28, 23}},
+		"crf out of range":  {WorkDir: "out", CRFs: []int{60}},
+		"duplicate height":  {WorkDir: "out", Heights: []int{720, 720}},
+		"negative height":   {WorkDir: "out", Heights: []int{-360}},
+		"negative samples":  {WorkDir: "out", Samples: -1},
+		"negative duration": {WorkDir: "out", SampleDuration: -4},
+		"negative rungs":    {WorkDir: "out", Rungs: -2},
+		"min above max":     {WorkDir: "out", MinBitrate: 5000, MaxBitrate: 1000},
+	} {
+		_, err := ffmpeg.PerTitle(context.Background(), "video.mp4", opts)
+		assert.ErrorContains(t, err, "per-title: ", name)
+	}
+}
+
+func TestFFmpeg_PerTitle(t *testing.T) {
+	// Given: A 640x360 clip
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	input, err := fixture.Generate(ctx, outputPath, fixture.Video{Name: "source.mp4", Duration: 12})
+	require.NoError(t, err)
+
+	// When: Probing two resolutions at three CRFs on two excerpts
+	workDir := createTempDir(t)
+	defer cleanupFiles(t, workDir)
+	report, err := ffmpeg.PerTitle(ctx, input, ffmpeg.PerTitleOptions{
+		WorkDir:        workDir,
+		Heights:        []int{720, 360, 180},
+		CRFs:           []int{20, 30, 40},
+		Samples:        2,
+		SampleDuration: 2,
+		Rungs:          3,
+	})
+	require.NoError(t, err)
+
+	// Then: 720p was skipped and every probe was measured
+	require.Len(t, report.Points, 6)
+	for _, p := range report.Points {
+		assert.LessOrEqual(t, p.Height, 360)
+		assert.Greater(t, p.Bitrate, 0.0)
+		assert.Greater(t, p.PSNR, 20.0)
+		assert.Greater(t, p.SSIM, 0.5)
+	}
+	// And: Quality drops as the CRF rises at each resolution
+	assert.Greater(t, report.Points[0].PSNR, report.Points[2].PSNR)
+	assert.Greater(t, report.Points[0].Bitrate, report.Points[2].Bitrate)
+
+	// And: The ladder is accepted by the ABR API
+	require.NotEmpty(t, report.Ladder.Rungs)
+	probe, err := ffprobe.Probe(ctx, input)
+	require.NoError(t, err)
+	plan, err := ffmpeg.PlanLadder(report.Ladder, probe.VideoStream())
+	require.NoError(t, err)
+	assert.Len(t, plan.Rungs, len(report.Ladder.Rungs))
+
+	// And: The JSON report round trips
+	data, err := os.ReadFile(filepath.Join(workDir, "pertitle.json"))
+	require.NoError(t, err)
+	var decoded ffmpeg.PerTitleReport
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *report, decoded)
+}
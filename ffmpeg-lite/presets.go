@@ -0,0 +1,61 @@
+package ffmpeg
+
+import "fmt"
+
+// EncodePreset names a curated set of EncodeOptions for a delivery target.
+type EncodePreset string
+
+const (
+	// PresetHEVCMain is 8-bit HEVC Main in MP4, tagged hvc1 so Safari and
+	// QuickTime play it.
+	PresetHEVCMain EncodePreset = "hevc-main"
+	// PresetHEVCMain10 is 10-bit HEVC Main10 in MP4, tagged hvc1. It bands
+	// less than Main on gradients at the same bitrate.
+	PresetHEVCMain10 EncodePreset = "hevc-main10"
+	// PresetAV1MP4 is SVT-AV1 in MP4 with AAC audio.
+	PresetAV1MP4 EncodePreset = "av1-mp4"
+	// PresetAV1WebM is SVT-AV1 in WebM with Opus audio.
+	PresetAV1WebM EncodePreset = "av1-webm"
+)
+
+var encodePresets = map[EncodePreset]EncodeOptions{
+	PresetHEVCMain: {
+		Name:    "output.mp4",
+		Codec:   CodecHEVC,
+		Profile: "main",
+		CRF:     26,
+		Preset:  "medium",
+	},
+	PresetHEVCMain10: {
+		Name:     "output.mp4",
+		Codec:    CodecHEVC,
+		Profile:  "main10",
+		BitDepth: 10,
+		CRF:      26,
+		Preset:   "medium",
+	},
+	// Preset 8 is the fastest setting that keeps most of SVT-AV1's
+	// coding tools; CRF 35 is close to HEVC at CRF 26 in size.
+	PresetAV1MP4: {
+		Name:   "output.mp4",
+		Codec:  CodecAV1,
+		CRF:    35,
+		Preset: "8",
+	},
+	PresetAV1WebM: {
+		Name:   "output.webm",
+		Codec:  CodecAV1,
+		CRF:    35,
+		Preset: "8",
+	},
+}
+
+// Options returns the options of the preset. Callers set OutputDir and
+// may override any field, such as Height or MaxRate.
+func (p EncodePreset) Options() (EncodeOptions, error) {
+	opts, ok := encodePresets[p]
+	if !ok {
+		return EncodeOptions{}, fmt.Errorf("encode: unknown preset %q", p)
+	}
+	return opts, nil
+}
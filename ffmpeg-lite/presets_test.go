@@ -0,0 +1,100 @@
+package ffmpeg_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ffmpeg "github.com/veloxpack/tools/ffmpeg-lite"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+func TestEncodePreset_Options(t *testing.T) {
+	// Given: The HEVC Main10 preset
+	opts, err := ffmpeg.PresetHEVCMain10.Options()
+	require.NoError(t, err)
+
+	// When: Building its arguments
+	runs, err := ffmpeg.EncodeArgs("/input/in.mp4", opts)
+	require.NoError(t, err)
+
+	// Then: It encodes 10-bit Main10 tagged hvc1
+	require.Len(t, runs, 1)
+	assert.Subset(t, runs[0], []string{"libx265", "-profile:v", "main10", "-tag:v", "hvc1", "yuv420p10le"})
+
+	// And: The AV1 presets pick SVT-AV1 with the container's audio codec
+	opts, err = ffmpeg.PresetAV1WebM.Options()
+	require.NoError(t, err)
+	runs, err = ffmpeg.EncodeArgs("/input/in.mp4", opts)
+	require.NoError(t, err)
+	assert.Subset(t, runs[0], []string{"libsvtav1", "-preset", "8", "-crf", "35", "libopus", "/output/output.webm"})
+	assert.NotContains(t, runs[0], "-profile:v")
+
+	_, err = ffmpeg.EncodePreset("prores").Options()
+	assert.Error(t, err)
+}
+
+func TestFFmpeg_EncodePresets(t *testing.T) {
+	// Given: A short clip with audio
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	input, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:     "source.mp4",
+		Duration: 3,
+		Audio:    "sine=frequency=440",
+	})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		preset  ffmpeg.EncodePreset
+		codec   string
+		tag     string
+		profile string
+		pixFmt  string
+		format  string
+		audio   string
+	}{
+		{ffmpeg.PresetHEVCMain, "hevc", "hvc1", "Main", "yuv420p", "mov,mp4,m4a,3gp,3g2,mj2", "aac"},
+		{ffmpeg.PresetHEVCMain10, "hevc", "hvc1", "Main 10", "yuv420p10le", "mov,mp4,m4a,3gp,3g2,mj2", "aac"},
+		{ffmpeg.PresetAV1MP4, "av1", "av01", "Main", "yuv420p", "mov,mp4,m4a,3gp,3g2,mj2", "aac"},
+		{ffmpeg.PresetAV1WebM, "av1", "", "Main", "yuv420p", "matroska,webm", "opus"},
+	} {
+		t.Run(string(tc.preset), func(t *testing.T) {
+			// When: Encoding with the preset
+			opts, err := tc.preset.Options()
+			require.NoError(t, err)
+			opts.OutputDir = outputPath
+			opts.Name = string(tc.preset) + filepath.Ext(opts.Name)
+			path, err := ffmpeg.Encode(ctx, input, opts)
+			require.NoError(t, err)
+
+			// Then: The container and streams match the preset
+			probe, err := ffprobe.Probe(ctx, path)
+			require.NoError(t, err)
+			assert.Equal(t, tc.format, probe.Format.FormatName)
+
+			video := probe.VideoStream()
+			require.NotNil(t, video)
+			assert.Equal(t, tc.codec, video.CodecName)
+			assert.Equal(t, tc.profile, video.Profile)
+			assert.Equal(t, tc.pixFmt, video.PixFmt)
+			if tc.tag != "" {
+				assert.Equal(t, tc.tag, video.CodecTagString)
+			}
+			assert.Equal(t, 640, video.Width)
+			assert.Equal(t, 360, video.Height)
+
+			audio := probe.AudioStream()
+			require.NotNil(t, audio)
+			assert.Equal(t, tc.audio, audio.CodecName)
+
+			// And: Every frame decodes
+			require.NoError(t, ffmpeg.Decode(ctx, path))
+		})
+	}
+}
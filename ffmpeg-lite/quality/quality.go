@@ -0,0 +1,393 @@
+// Package quality scores an encode against its reference with the psnr,
+// ssim and libvmaf filters, reading per-frame scores back from the filter
+// log files.
+package quality
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+const (
+	// Image is the lite FFmpeg image, which scores PSNR and SSIM.
+	Image = "ghcr.io/veloxpack/ffmpeg:8.0-lite"
+	// VMAFImage is the variant built with libvmaf.
+	VMAFImage = "ghcr.io/veloxpack/ffmpeg:8.0-vmaf"
+)
+
+// MaxPSNR caps the PSNR of identical frames, which the filter reports as
+// inf, so means and JSON stay finite.
+const MaxPSNR = 100
+
+// DefaultVMAFModel is the libvmaf model for viewing on a 1080p display.
+const DefaultVMAFModel = "vmaf_v0.6.1"
+
+// Options configures Compare.
+type Options struct {
+	// LogDir is the host directory the filter logs psnr.log, ssim.log and
+	// vmaf.json are written to.
+	LogDir string
+	// Width and Height are the common resolution both videos are scaled to
+	// with bicubic filtering; they default to the display size of the
+	// reference.
+	Width  int
+	Height int
+	// VMAF also scores VMAF, which needs VMAFImage.
+	VMAF bool
+	// VMAFModel defaults to DefaultVMAFModel; use "vmaf_4k_v0.6.1" for 4K
+	// viewing.
+	VMAFModel string
+	// Image overrides the FFmpeg image; defaults to Image, or VMAFImage
+	// when VMAF is set.
+	Image string
+}
+
+// Frame holds the scores of one frame. Frames count from zero.
+type Frame struct {
+	N int `json:"n"`
+	// PSNR is over all planes, from the mean squared error of all samples.
+	PSNR  float64 `json:"psnr"`
+	PSNRY float64 `json:"psnr_y"`
+	PSNRU float64 `json:"psnr_u"`
+	PSNRV float64 `json:"psnr_v"`
+	// MSE is the mean squared error over all planes.
+	MSE  float64 `json:"mse"`
+	SSIM float64 `json:"ssim"`
+	// SSIMY is the SSIM of the luma plane alone.
+	SSIMY float64 `json:"ssim_y"`
+	// VMAF is zero unless Options.VMAF is set.
+	VMAF float64 `json:"vmaf,omitempty"`
+}
+
+// Stats aggregates a score over all frames.
+type Stats struct {
+	Mean float64 `json:"mean"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	// P5 is the 5th percentile, which tracks the worst moments better than
+	// Min, a single frame.
+	P5 float64 `json:"p5"`
+	// HarmonicMean weighs low scores more, as VMAF pooling often does.
+	HarmonicMean float64 `json:"harmonic_mean"`
+}
+
+// Result holds per-frame and aggregate scores.
+type Result struct {
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Frames []Frame `json:"frames"`
+	// PSNR.Mean is the PSNR of the mean MSE of all frames, as FFmpeg
+	// reports it, rather than the mean of the frame PSNRs.
+	PSNR Stats  `json:"psnr"`
+	SSIM Stats  `json:"ssim"`
+	VMAF *Stats `json:"vmaf,omitempty"`
+}
+
+// Compare scales distorted and reference to a common resolution, aligns
+// their first frames and scores every frame pair in a single FFmpeg run.
+// Both videos must have the same frame rate.
+func Compare(ctx context.Context, reference, distorted string, opts Options) (*Result, error) {
+	if opts.LogDir == "" {
+		return nil, errors.New("quality: log directory is required")
+	}
+	if opts.Width == 0 || opts.Height == 0 {
+		probe, err := ffprobe.Probe(ctx, reference)
+		if err != nil {
+			return nil, fmt.Errorf("quality: %w", err)
+		}
+		video := probe.VideoStream()
+		if video == nil {
+			return nil, fmt.Errorf("quality: %s has no video stream", filepath.Base(reference))
+		}
+		w, h := video.DisplaySize()
+		opts.Width, opts.Height = w/2*2, h/2*2
+	}
+	if opts.VMAFModel == "" {
+		opts.VMAFModel = DefaultVMAFModel
+	}
+	image := opts.Image
+	if image == "" {
+		image = Image
+		if opts.VMAF {
+			image = VMAFImage
+		}
+	}
+
+	// Inputs sharing a base name must not collide.
+	refInput := "/input/reference/" + filepath.Base(reference)
+	disInput := "/input/distorted/" + filepath.Base(distorted)
+	_, err := runner.Run(ctx, runner.Request{
+		Image: image,
+		Cmd:   Args(refInput, disInput, opts),
+		Files: []testcontainers.ContainerFile{
+			runner.File(reference, refInput),
+			runner.File(distorted, disInput),
+		},
+		Mounts: []mount.Mount{runner.Bind(opts.LogDir, "/logs")},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("quality: %w", err)
+	}
+
+	return ReadResult(opts.LogDir, opts.Width, opts.Height, opts.VMAF)
+}
+
+// Args returns the FFmpeg arguments comparing the container path distorted
+// against reference at opts.Width x opts.Height, writing the filter logs to
+// /logs. Each score needs its own copy of both streams, and every scorer
+// output goes to the null muxer.
+func Args(reference, distorted string, opts Options) []string {
+	n := 2
+	if opts.VMAF {
+		n = 3
+	}
+	prep := fmt.Sprintf("scale=%d:%d:flags=bicubic,setsar=1,format=yuv420p,settb=AVTB,setpts=PTS-STARTPTS,split=%d",
+		opts.Width, opts.Height, n)
+	var graph strings.Builder
+	fmt.Fprintf(&graph, "[0:v]%s", prep)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&graph, "[d%d]", i)
+	}
+	fmt.Fprintf(&graph, ";[1:v]%s", prep)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&graph, "[r%d]", i)
+	}
+	graph.WriteString(";[d0][r0]psnr=stats_file=/logs/psnr.log[psnr]")
+	graph.WriteString(";[d1][r1]ssim=stats_file=/logs/ssim.log[ssim]")
+	outputs := []string{"psnr", "ssim"}
+	if opts.VMAF {
+		// libvmaf takes the distorted stream first.
+		model := opts.VMAFModel
+		if model == "" {
+			model = DefaultVMAFModel
+		}
+		fmt.Fprintf(&graph, ";[d2][r2]libvmaf=model=version=%s:log_fmt=json:log_path=/logs/vmaf.json[vmaf]", model)
+		outputs = append(outputs, "vmaf")
+	}
+
+	args := []string{"-i", distorted, "-i", reference, "-filter_complex", graph.String()}
+	for _, o := range outputs {
+		args = append(args, "-map", "["+o+"]", "-f", "null", "-")
+	}
+	return args
+}
+
+// ReadResult reads the filter logs that Args writes into logDir, for
+// callers that run the comparison themselves.
+func ReadResult(logDir string, width, height int, vmaf bool) (*Result, error) {
+	res := &Result{Width: width, Height: height}
+	var err error
+	if res.Frames, err = readLog(filepath.Join(logDir, "psnr.log"), ParsePSNRLog); err != nil {
+		return nil, fmt.Errorf("quality: %w", err)
+	}
+	ssim, err := readLog(filepath.Join(logDir, "ssim.log"), ParseSSIMLog)
+	if err != nil {
+		return nil, fmt.Errorf("quality: %w", err)
+	}
+	if err := merge(res.Frames, ssim, func(dst *Frame, src Frame) { dst.SSIM, dst.SSIMY = src.SSIM, src.SSIMY }); err != nil {
+		return nil, fmt.Errorf("quality: ssim: %w", err)
+	}
+	if vmaf {
+		scores, err := readLog(filepath.Join(logDir, "vmaf.json"), ParseVMAFLog)
+		if err != nil {
+			return nil, fmt.Errorf("quality: %w", err)
+		}
+		if err := merge(res.Frames, scores, func(dst *Frame, src Frame) { dst.VMAF = src.VMAF }); err != nil {
+			return nil, fmt.Errorf("quality: vmaf: %w", err)
+		}
+	}
+	res.aggregate(vmaf)
+	return res, nil
+}
+
+func readLog(path string, parse func(io.Reader) ([]Frame, error)) ([]Frame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	frames, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%s: no frames scored", filepath.Base(path))
+	}
+	return frames, nil
+}
+
+// merge copies scores from src into dst frame by frame.
+func merge(dst, src []Frame, set func(*Frame, Frame)) error {
+	if len(src) != len(dst) {
+		return fmt.Errorf("%d frames scored, want %d", len(src), len(dst))
+	}
+	for i := range dst {
+		set(&dst[i], src[i])
+	}
+	return nil
+}
+
+// ParsePSNRLog reads a psnr filter stats file, one line per frame such as
+// "n:1 mse_avg:0.65 mse_y:0.80 mse_u:0.33 mse_v:0.36 psnr_avg:50.00
+// psnr_y:49.08 psnr_u:52.93 psnr_v:52.55". Frames in the log count from
+// one.
+func ParsePSNRLog(r io.Reader) ([]Frame, error) {
+	var frames []Frame
+	err := scanFields(r, func(fields map[string]string) error {
+		f := Frame{N: len(frames)}
+		for key, dst := range map[string]*float64{
+			"mse_avg":  &f.MSE,
+			"psnr_avg": &f.PSNR,
+			"psnr_y":   &f.PSNRY,
+			"psnr_u":   &f.PSNRU,
+			"psnr_v":   &f.PSNRV,
+		} {
+			v, err := parseScore(fields[key])
+			if err != nil {
+				return fmt.Errorf("frame %s: %s: %w", fields["n"], key, err)
+			}
+			*dst = v
+		}
+		f.PSNR = math.Min(f.PSNR, MaxPSNR)
+		f.PSNRY = math.Min(f.PSNRY, MaxPSNR)
+		f.PSNRU = math.Min(f.PSNRU, MaxPSNR)
+		f.PSNRV = math.Min(f.PSNRV, MaxPSNR)
+		frames = append(frames, f)
+		return nil
+	})
+	return frames, err
+}
+
+// ParseSSIMLog reads an ssim filter stats file, one line per frame such as
+// "n:1 Y:0.995 U:0.996 V:0.997 All:0.996 (23.98)".
+func ParseSSIMLog(r io.Reader) ([]Frame, error) {
+	var frames []Frame
+	err := scanFields(r, func(fields map[string]string) error {
+		f := Frame{N: len(frames)}
+		var err error
+		if f.SSIM, err = parseScore(fields["All"]); err != nil {
+			return fmt.Errorf("frame %s: All: %w", fields["n"], err)
+		}
+		if f.SSIMY, err = parseScore(fields["Y"]); err != nil {
+			return fmt.Errorf("frame %s: Y: %w", fields["n"], err)
+		}
+		frames = append(frames, f)
+		return nil
+	})
+	return frames, err
+}
+
+// scanFields calls fn with the key:value fields of every non-empty line.
+// Values without a key, such as the SSIM in dB, are ignored.
+func scanFields(r io.Reader, fn func(map[string]string) error) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		fields := map[string]string{}
+		for _, f := range strings.Fields(line) {
+			if key, value, ok := strings.Cut(f, ":"); ok {
+				fields[key] = value
+			}
+		}
+		if err := fn(fields); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func parseScore(s string) (float64, error) {
+	if s == "" {
+		return 0, errors.New("missing")
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// ParseVMAFLog reads the per-frame scores of a libvmaf JSON log.
+func ParseVMAFLog(r io.Reader) ([]Frame, error) {
+	var log struct {
+		Frames []struct {
+			FrameNum int                `json:"frameNum"`
+			Metrics  map[string]float64 `json:"metrics"`
+		} `json:"frames"`
+	}
+	if err := json.NewDecoder(r).Decode(&log); err != nil {
+		return nil, err
+	}
+	frames := make([]Frame, 0, len(log.Frames))
+	for _, f := range log.Frames {
+		v, ok := f.Metrics["vmaf"]
+		if !ok {
+			return nil, fmt.Errorf("frame %d: no vmaf score", f.FrameNum)
+		}
+		frames = append(frames, Frame{N: f.FrameNum, VMAF: v})
+	}
+	return frames, nil
+}
+
+func (r *Result) aggregate(vmaf bool) {
+	values := func(get func(Frame) float64) []float64 {
+		v := make([]float64, len(r.Frames))
+		for i, f := range r.Frames {
+			v[i] = get(f)
+		}
+		return v
+	}
+	r.PSNR = Aggregate(values(func(f Frame) float64 { return f.PSNR }))
+	// FFmpeg's overall PSNR comes from the mean error, not the mean PSNR.
+	mse := Aggregate(values(func(f Frame) float64 { return f.MSE })).Mean
+	r.PSNR.Mean = MaxPSNR
+	if mse > 0 {
+		r.PSNR.Mean = math.Min(10*math.Log10(255*255/mse), MaxPSNR)
+	}
+	r.SSIM = Aggregate(values(func(f Frame) float64 { return f.SSIM }))
+	if vmaf {
+		s := Aggregate(values(func(f Frame) float64 { return f.VMAF }))
+		r.VMAF = &s
+	}
+}
+
+// Aggregate computes the statistics of a series of scores. The harmonic
+// mean is zero when any score is zero.
+func Aggregate(values []float64) Stats {
+	if len(values) == 0 {
+		return Stats{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var sum, inv float64
+	for _, v := range sorted {
+		sum += v
+		if v > 0 {
+			inv += 1 / v
+		}
+	}
+	s := Stats{
+		Mean: sum / float64(len(sorted)),
+		Min:  sorted[0],
+		Max:  sorted[len(sorted)-1],
+		P5:   sorted[int(0.05*float64(len(sorted)-1))],
+	}
+	if sorted[0] > 0 {
+		s.HarmonicMean = float64(len(sorted)) / inv
+	}
+	return s
+}
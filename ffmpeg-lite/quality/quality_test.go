@@ -0,0 +1,149 @@
+package quality_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/ffmpeg-lite/quality"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+func TestParsePSNRLog(t *testing.T) {
+	log := "n:1 mse_avg:0.65 mse_y:0.80 mse_u:0.33 mse_v:0.36 psnr_avg:50.00 psnr_y:49.08 psnr_u:52.93 psnr_v:52.55 \n" +
+		"n:2 mse_avg:0.00 mse_y:0.00 mse_u:0.00 mse_v:0.00 psnr_avg:inf psnr_y:inf psnr_u:inf psnr_v:inf \n"
+
+	frames, err := quality.ParsePSNRLog(strings.NewReader(log))
+	require.NoError(t, err)
+
+	// Then: Frames count from zero and identical frames are capped
+	assert.Equal(t, []quality.Frame{
+		{N: 0, PSNR: 50, PSNRY: 49.08, PSNRU: 52.93, PSNRV: 52.55, MSE: 0.65},
+		{N: 1, PSNR: 100, PSNRY: 100, PSNRU: 100, PSNRV: 100},
+	}, frames)
+
+	_, err = quality.ParsePSNRLog(strings.NewReader("n:1 mse_avg:0.65\n"))
+	assert.Error(t, err)
+}
+
+func TestParseSSIMLog(t *testing.T) {
+	log := "n:1 Y:0.995133 U:0.996000 V:0.997000 All:0.995800 (23.767)\n" +
+		"n:2 Y:0.901000 U:0.950000 V:0.960000 All:0.925000 (11.249)\n"
+
+	frames, err := quality.ParseSSIMLog(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.Equal(t, []quality.Frame{
+		{N: 0, SSIM: 0.9958, SSIMY: 0.995133},
+		{N: 1, SSIM: 0.925, SSIMY: 0.901},
+	}, frames)
+}
+
+func TestParseVMAFLog(t *testing.T) {
+	log := `{"version": "3.0.0", "frames": [
+		{"frameNum": 0, "metrics": {"integer_adm2": 0.98, "vmaf": 95.5}},
+		{"frameNum": 1, "metrics": {"integer_adm2": 0.97, "vmaf": 91.25}}
+	], "pooled_metrics": {"vmaf": {"min": 91.25, "max": 95.5, "mean": 93.375}}}`
+
+	frames, err := quality.ParseVMAFLog(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.Equal(t, []quality.Frame{{N: 0, VMAF: 95.5}, {N: 1, VMAF: 91.25}}, frames)
+}
+
+func TestAggregate(t *testing.T) {
+	values := make([]float64, 0, 21)
+	for i := 0; i <= 20; i++ {
+		values = append(values, float64(80+i))
+	}
+
+	s := quality.Aggregate(values)
+	assert.Equal(t, 90.0, s.Mean)
+	assert.Equal(t, 80.0, s.Min)
+	assert.Equal(t, 100.0, s.Max)
+	assert.Equal(t, 81.0, s.P5)
+	assert.InDelta(t, 89.59, s.HarmonicMean, 0.01)
+
+	assert.Equal(t, quality.Stats{}, quality.Aggregate(nil))
+}
+
+func TestArgs(t *testing.T) {
+	// PSNR and SSIM each get a copy of both streams
+	args := quality.Args("/in/ref.mp4", "/in/dis.mp4", quality.Options{Width: 1280, Height: 720})
+	assert.Equal(t, []string{"-i", "/in/dis.mp4", "-i", "/in/ref.mp4"}, args[:4])
+	assert.Contains(t, args[5], "[0:v]scale=1280:720:flags=bicubic,setsar=1,format=yuv420p,settb=AVTB,setpts=PTS-STARTPTS,split=2[d0][d1]")
+	assert.Contains(t, args[5], "[d1][r1]ssim=stats_file=/logs/ssim.log[ssim]")
+	assert.NotContains(t, args[5], "libvmaf")
+	assert.Equal(t, []string{"-map", "[psnr]", "-f", "null", "-", "-map", "[ssim]", "-f", "null", "-"}, args[6:])
+
+	// VMAF adds a third copy and the model
+	args = quality.Args("/in/ref.mp4", "/in/dis.mp4", quality.Options{Width: 3840, Height: 2160, VMAF: true, VMAFModel: "vmaf_4k_v0.6.1"})
+	assert.Contains(t, args[5], "split=3[d0][d1][d2]")
+	assert.Contains(t, args[5], "[d2][r2]libvmaf=model=version=vmaf_4k_v0.6.1:log_fmt=json:log_path=/logs/vmaf.json[vmaf]")
+	assert.Equal(t, []string{"-map", "[vmaf]", "-f", "null", "-"}, args[len(args)-5:])
+}
+
+func TestQuality_Compare(t *testing.T) {
+	// Given: A 640x360 reference and a 320x180 heavily compressed copy of
+	// the same content
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	reference, err := fixture.Generate(ctx, outputPath, fixture.Video{Name: "reference.mp4", Duration: 4})
+	require.NoError(t, err)
+	distorted, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:        "distorted.mp4",
+		Duration:    4,
+		VideoFilter: "scale=320:180",
+		OutputArgs:  []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "40", "-pix_fmt", "yuv420p"},
+	})
+	require.NoError(t, err)
+
+	// When: Comparing the copy to the reference
+	logDir := createTempDir(t)
+	defer cleanupFiles(t, logDir)
+	res, err := quality.Compare(ctx, reference, distorted, quality.Options{LogDir: logDir})
+	require.NoError(t, err)
+
+	// Then: Every frame is scored at the reference size
+	assert.Equal(t, 640, res.Width)
+	assert.Equal(t, 360, res.Height)
+	require.Len(t, res.Frames, 100)
+	assert.Equal(t, 99, res.Frames[99].N)
+	assert.Nil(t, res.VMAF)
+
+	// And: The scores show visible loss
+	assert.Less(t, res.PSNR.Mean, 35.0)
+	assert.Greater(t, res.PSNR.Mean, 15.0)
+	assert.Less(t, res.SSIM.Mean, 0.98)
+	assert.LessOrEqual(t, res.SSIM.Min, res.SSIM.P5)
+
+	// When: Comparing the reference to itself
+	same, err := quality.Compare(ctx, reference, reference, quality.Options{LogDir: logDir})
+	require.NoError(t, err)
+
+	// Then: The scores are perfect
+	assert.Equal(t, float64(quality.MaxPSNR), same.PSNR.Mean)
+	assert.InDelta(t, 1.0, same.SSIM.Mean, 1e-6)
+}
+
+func createTempDir(t *testing.T) string {
+	outputPath, err := filepath.Abs(filepath.Join("..", "..", "testdata", strings.ReplaceAll(uuid.NewString(), "-", "")))
+	require.NoError(t, err)
+
+	err = os.MkdirAll(outputPath, 0755)
+	require.NoError(t, err)
+
+	return outputPath
+}
+
+func cleanupFiles(t *testing.T, path string) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		t.Logf("failed to remove output directory: %s", path)
+	}
+}
@@ -0,0 +1,208 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// StreamFormat is an ABR delivery format written by FFmpeg's own segmenting
+// muxers, without a separate packaging step.
+type StreamFormat string
+
+const (
+	// FormatHLSTS is HLS with MPEG-TS segments, playable by every HLS
+	// client. It cannot carry HEVC rungs.
+	FormatHLSTS StreamFormat = "hls-ts"
+	// FormatHLSFMP4 is HLS with fragmented MP4 segments and one init
+	// segment per rendition.
+	FormatHLSFMP4 StreamFormat = "hls-fmp4"
+	// FormatDASH is DASH with fragmented MP4 segments addressed by a
+	// SegmentTemplate with a SegmentTimeline.
+	FormatDASH StreamFormat = "dash"
+)
+
+// Manifest returns the file name of the top-level manifest of the format.
+func (f StreamFormat) Manifest() string {
+	if f == FormatDASH {
+		return "manifest.mpd"
+	}
+	return "master.m3u8"
+}
+
+// StreamArgs returns the FFmpeg arguments that encode every rendition of the
+// plan from the container path input and segment them into /output in a
+// single run. Each rung keeps the fixed GOP and forced key frames of Args,
+// so every segment starts on a key frame and lasts SegmentDuration.
+//
+// HLS writes master.m3u8 plus one media playlist per rung, named after the
+// rung, and audio.m3u8 in an "audio" group every variant refers to. DASH
+// writes manifest.mpd with one adaptation set per video codec and one for
+// audio.
+func (p *LadderPlan) StreamArgs(input string, format StreamFormat) ([]string, error) {
+	switch format {
+	case FormatHLSTS:
+		for _, r := range p.Rungs {
+			if r.Codec == CodecHEVC {
+				return nil, fmt.Errorf("stream: %s is HEVC, which HLS only carries in fMP4 segments", r.Name)
+			}
+		}
+	case FormatHLSFMP4, FormatDASH:
+	default:
+		return nil, fmt.Errorf("stream: unknown format %q", format)
+	}
+
+	args := []string{"-i", input, "-filter_complex", p.filterGraph()}
+	for i := range p.Rungs {
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i))
+	}
+	if p.AudioBitrate > 0 {
+		args = append(args, "-map", "0:a:0")
+	}
+	for i, r := range p.Rungs {
+		args = append(args, forStream(p.videoArgs(r), i)...)
+	}
+	if p.AudioBitrate > 0 {
+		args = append(args, p.audioArgs()...)
+	}
+
+	seg := formatFloat(p.SegmentDuration)
+	if format == FormatDASH {
+		return append(args,
+			"-f", "dash",
+			"-seg_duration", seg,
+			"-use_template", "1",
+			"-use_timeline", "1",
+			"-adaptation_sets", p.adaptationSets(),
+			"-init_seg_name", "init_$RepresentationID$.m4s",
+			"-media_seg_name", "chunk_$RepresentationID$_$Number%05d$.m4s",
+			"/output/"+format.Manifest(),
+		), nil
+	}
+
+	segType, ext := "mpegts", ".ts"
+	if format == FormatHLSFMP4 {
+		segType, ext = "fmp4", ".m4s"
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", seg,
+		"-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_type", segType,
+		"-hls_segment_filename", "/output/%v_%05d"+ext,
+	)
+	if format == FormatHLSFMP4 {
+		args = append(args, "-hls_fmp4_init_filename", "%v_init.mp4")
+	}
+	return append(args,
+		"-master_pl_name", format.Manifest(),
+		"-var_stream_map", p.varStreamMap(),
+		"/output/%v.m3u8",
+	), nil
+}
+
+// forStream qualifies the options of videoArgs with the output stream
+// index, so several renditions can share one output: -c:v becomes -c:v:1
+// and -preset becomes -preset:v:1.
+func forStream(args []string, i int) []string {
+	out := make([]string, 0, len(args))
+	for j := 0; j+1 < len(args); j += 2 {
+		key := strings.TrimSuffix(args[j], ":v")
+		out = append(out, fmt.Sprintf("%s:v:%d", key, i), args[j+1])
+	}
+	return out
+}
+
+// varStreamMap names one HLS variant per rung, all sharing the audio
+// rendition when there is one.
+func (p *LadderPlan) varStreamMap() string {
+	var vs []string
+	for i, r := range p.Rungs {
+		v := fmt.Sprintf("v:%d,name:%s", i, r.Name)
+		if p.AudioBitrate > 0 {
+			v += ",agroup:audio"
+		}
+		vs = append(vs, v)
+	}
+	if p.AudioBitrate > 0 {
+		vs = append(vs, "a:0,agroup:audio,name:audio,default:yes")
+	}
+	return strings.Join(vs, " ")
+}
+
+// adaptationSets groups the video streams by codec, since players only
+// switch between representations of the same codec, and puts audio in a
+// set of its own.
+func (p *LadderPlan) adaptationSets() string {
+	var codecs []Codec
+	streams := map[Codec][]string{}
+	for i, r := range p.Rungs {
+		if _, ok := streams[r.Codec]; !ok {
+			codecs = append(codecs, r.Codec)
+		}
+		streams[r.Codec] = append(streams[r.Codec], strconv.Itoa(i))
+	}
+	var sets []string
+	for id, c := range codecs {
+		sets = append(sets, fmt.Sprintf("id=%d,streams=%s", id, strings.Join(streams[c], ",")))
+	}
+	if p.AudioBitrate > 0 {
+		sets = append(sets, fmt.Sprintf("id=%d,streams=a", len(codecs)))
+	}
+	return strings.Join(sets, " ")
+}
+
+// StreamResult describes a ladder segmented for delivery.
+type StreamResult struct {
+	Format StreamFormat
+	// Manifest is the host path of master.m3u8 or manifest.mpd.
+	Manifest string
+	// Rungs are the encoded renditions, with upscaled rungs dropped.
+	Rungs            []Rung
+	Audio            bool
+	SegmentDuration  float64
+	KeyframeInterval int
+}
+
+// EncodeStream probes the video at input, plans l against it like
+// EncodeLadder and writes the renditions to outputDir as segmented HLS or
+// DASH in a single FFmpeg run, ready to serve.
+func EncodeStream(ctx context.Context, input, outputDir string, l Ladder, format StreamFormat) (*StreamResult, error) {
+	if outputDir == "" {
+		return nil, errors.New("stream: output directory is required")
+	}
+	plan, err := probeAndPlan(ctx, input, l)
+	if err != nil {
+		return nil, err
+	}
+	containerInput := "/input/" + filepath.Base(input)
+	args, err := plan.StreamArgs(containerInput, format)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = runner.Run(ctx, runner.Request{
+		Image:  Image,
+		Cmd:    args,
+		Files:  plan.files(input, containerInput),
+		Mounts: []mount.Mount{runner.Bind(outputDir, "/output")},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("stream: %w", err)
+	}
+	return &StreamResult{
+		Format:           format,
+		Manifest:         filepath.Join(outputDir, format.Manifest()),
+		Rungs:            plan.Rungs,
+		Audio:            plan.AudioBitrate > 0,
+		SegmentDuration:  plan.SegmentDuration,
+		KeyframeInterval: plan.KeyframeInterval,
+	}, nil
+}
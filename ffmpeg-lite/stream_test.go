@@ -0,0 +1,214 @@
+package ffmpeg_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ffmpeg "github.com/veloxpack/tools/ffmpeg-lite"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+	"github.com/veloxpack/tools/manifest"
+)
+
+func streamPlan(t *testing.T, rungs ...ffmpeg.Rung) *ffmpeg.LadderPlan {
+	video := &ffprobe.Stream{Width: 1280, Height: 720, AvgFrameRate: "25/1"}
+	plan, err := ffmpeg.PlanLadder(ffmpeg.Ladder{Rungs: rungs, SegmentDuration: 2, AudioBitrate: 96}, video)
+	require.NoError(t, err)
+	return plan
+}
+
+func TestLadderPlan_StreamArgs_HLS(t *testing.T) {
+	// Given: Two H.264 rungs with audio
+	plan := streamPlan(t, ffmpeg.Rung{Height: 720, Bitrate: 2800}, ffmpeg.Rung{Height: 360, Bitrate: 800})
+
+	// When: Building the HLS command lines
+	ts, err := plan.StreamArgs("/input/in.mp4", ffmpeg.FormatHLSTS)
+	require.NoError(t, err)
+	fmp4, err := plan.StreamArgs("/input/in.mp4", ffmpeg.FormatHLSFMP4)
+	require.NoError(t, err)
+
+	// Then: Both renditions and the audio go to one output
+	assert.Equal(t, []string{"-map", "[v0]", "-map", "[v1]", "-map", "0:a:0"}, ts[4:10])
+	assert.Equal(t, 1, countArg(ts, "-c:v:0", "libx264"))
+	assert.Equal(t, 1, countArg(ts, "-b:v:1", "800k"))
+	assert.Equal(t, 1, countArg(ts, "-preset:v:1", "veryfast"))
+	assert.Equal(t, 1, countArg(ts, "-g:v:0", "50"))
+	assert.Equal(t, 1, countArg(ts, "-force_key_frames:v:1", "expr:gte(t,n_forced*2)"))
+	assert.Equal(t, 1, countArg(ts, "-b:a", "96k"))
+
+	// And: Variants share the audio group and the master names them
+	assert.Equal(t, 1, countArg(ts, "-var_stream_map",
+		"v:0,name:720p,agroup:audio v:1,name:360p,agroup:audio a:0,agroup:audio,name:audio,default:yes"))
+	assert.Equal(t, 1, countArg(ts, "-master_pl_name", "master.m3u8"))
+	assert.Equal(t, 1, countArg(ts, "-hls_time", "2"))
+	assert.Equal(t, "/output/%v.m3u8", ts[len(ts)-1])
+
+	// And: The segment type sets the segment names and init segment
+	assert.Equal(t, 1, countArg(ts, "-hls_segment_type", "mpegts"))
+	assert.Equal(t, 1, countArg(ts, "-hls_segment_filename", "/output/%v_%05d.ts"))
+	assert.NotContains(t, ts, "-hls_fmp4_init_filename")
+	assert.Equal(t, 1, countArg(fmp4, "-hls_segment_type", "fmp4"))
+	assert.Equal(t, 1, countArg(fmp4, "-hls_segment_filename", "/output/%v_%05d.m4s"))
+	assert.Equal(t, 1, countArg(fmp4, "-hls_fmp4_init_filename", "%v_init.mp4"))
+}
+
+func TestLadderPlan_StreamArgs_DASH(t *testing.T) {
+	// Given: An HEVC rung between two H.264 rungs
+	plan := streamPlan(t,
+		ffmpeg.Rung{Height: 720, Bitrate: 2800},
+		ffmpeg.Rung{Name: "720p-hevc", Height: 720, Bitrate: 1800, Codec: ffmpeg.CodecHEVC},
+		ffmpeg.Rung{Height: 360, Bitrate: 800})
+
+	// When: Building the DASH command line
+	args, err := plan.StreamArgs("/input/in.mp4", ffmpeg.FormatDASH)
+	require.NoError(t, err)
+
+	// Then: Each codec gets its own adaptation set and audio another
+	assert.Equal(t, 1, countArg(args, "-adaptation_sets", "id=0,streams=0,2 id=1,streams=1 id=2,streams=a"))
+	assert.Equal(t, 1, countArg(args, "-c:v:1", "libx265"))
+	assert.Equal(t, 1, countArg(args, "-tag:v:1", "hvc1"))
+	assert.Equal(t, 1, countArg(args, "-seg_duration", "2"))
+	assert.Equal(t, 1, countArg(args, "-use_timeline", "1"))
+	assert.Equal(t, "/output/manifest.mpd", args[len(args)-1])
+}
+
+func TestLadderPlan_StreamArgs_Errors(t *testing.T) {
+	// Given: A ladder with an HEVC rung
+	plan := streamPlan(t, ffmpeg.Rung{Height: 720, Bitrate: 1800, Codec: ffmpeg.CodecHEVC})
+
+	// When: Segmenting it as MPEG-TS or an unknown format
+	_, tsErr := plan.StreamArgs("/input/in.mp4", ffmpeg.FormatHLSTS)
+	_, unknownErr := plan.StreamArgs("/input/in.mp4", "smooth")
+
+	// Then: Both are rejected
+	assert.ErrorContains(t, tsErr, "fMP4")
+	assert.ErrorContains(t, unknownErr, "unknown format")
+}
+
+func TestFFmpeg_EncodeStream(t *testing.T) {
+	// Given: A 10s 720p clip with audio
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	input, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:     "source.mp4",
+		Duration: 10,
+		Width:    1280,
+		Height:   720,
+		Audio:    "sine=frequency=440",
+	})
+	require.NoError(t, err)
+
+	ladder := standardLadder()
+	ladder.SegmentDuration = 2
+
+	for _, format := range []ffmpeg.StreamFormat{ffmpeg.FormatHLSTS, ffmpeg.FormatHLSFMP4} {
+		t.Run(string(format), func(t *testing.T) {
+			// When: Encoding the ladder straight to HLS
+			out := createTempDir(t)
+			defer cleanupFiles(t, out)
+			result, err := ffmpeg.EncodeStream(ctx, input, out, ladder, format)
+			require.NoError(t, err)
+
+			// Then: The master lists one variant per kept rung with the audio group
+			master, err := manifest.ReadMasterPlaylist(result.Manifest)
+			require.NoError(t, err)
+			require.Len(t, master.Variants, 2)
+			assert.Equal(t, "1280x720", master.Variants[0].Resolution)
+			assert.Equal(t, "640x360", master.Variants[1].Resolution)
+			uris := []string{master.Variants[0].URI, master.Variants[1].URI}
+			for _, v := range master.Variants {
+				assert.Equal(t, "audio", v.Audio)
+				assert.Contains(t, v.Codecs, "avc1")
+			}
+			require.Len(t, master.Media, 1)
+			assert.Equal(t, "AUDIO", master.Media[0].Type)
+			uris = append(uris, master.Media[0].URI)
+
+			// And: Every media playlist is cut on the segment duration
+			for _, uri := range uris {
+				playlist, err := manifest.ReadMediaPlaylist(filepath.Join(out, uri))
+				require.NoError(t, err, uri)
+				assert.True(t, playlist.EndList, uri)
+				assert.Equal(t, "VOD", playlist.PlaylistType, uri)
+				assert.Equal(t, 2, playlist.TargetDuration, uri)
+				verifySegments(t, uri, durations(playlist.Segments), 2, 10)
+				for _, s := range playlist.Segments {
+					verifyFileExists(t, filepath.Join(out, s.URI))
+				}
+				if format == ffmpeg.FormatHLSFMP4 {
+					require.NotNil(t, playlist.Map, uri)
+					verifyFileExists(t, filepath.Join(out, playlist.Map.URI))
+				} else {
+					assert.Nil(t, playlist.Map, uri)
+				}
+			}
+		})
+	}
+
+	t.Run(string(ffmpeg.FormatDASH), func(t *testing.T) {
+		// When: Encoding the ladder straight to DASH
+		out := createTempDir(t)
+		defer cleanupFiles(t, out)
+		result, err := ffmpeg.EncodeStream(ctx, input, out, ladder, ffmpeg.FormatDASH)
+		require.NoError(t, err)
+
+		// Then: One video set holds both rungs and one audio set the audio
+		mpd, err := manifest.ReadMPD(result.Manifest)
+		require.NoError(t, err)
+		videoSets := mpd.AdaptationSetsByType("video")
+		require.Len(t, videoSets, 1)
+		require.Len(t, videoSets[0].Representations, 2)
+		audioSets := mpd.AdaptationSetsByType("audio")
+		require.Len(t, audioSets, 1)
+		require.Len(t, audioSets[0].Representations, 1)
+
+		// And: Every timeline is cut on the segment duration
+		for _, as := range append(videoSets, audioSets...) {
+			for _, rep := range as.Representations {
+				tmpl := rep.SegmentTemplate
+				if tmpl == nil {
+					tmpl = as.SegmentTemplate
+				}
+				require.NotNil(t, tmpl, rep.ID)
+				require.NotNil(t, tmpl.SegmentTimeline, rep.ID)
+				var d []float64
+				for _, s := range tmpl.SegmentTimeline.S {
+					for range s.R + 1 {
+						d = append(d, float64(s.D)/float64(tmpl.Timescale))
+					}
+				}
+				verifySegments(t, rep.ID, d, 2, 10)
+				verifyFileExists(t, filepath.Join(out, "init_"+rep.ID+".m4s"))
+			}
+		}
+	})
+}
+
+func durations(segments []manifest.Segment) []float64 {
+	d := make([]float64, len(segments))
+	for i, s := range segments {
+		d[i] = s.Duration
+	}
+	return d
+}
+
+// verifySegments checks that every segment but the last lasts seg seconds,
+// that none is longer, and that together they cover total seconds.
+func verifySegments(t *testing.T, name string, d []float64, seg, total float64) {
+	t.Helper()
+	require.NotEmpty(t, d, name)
+	var sum float64
+	for i, v := range d {
+		sum += v
+		assert.LessOrEqual(t, v, seg+0.05, "%s segment %d", name, i)
+		if i < len(d)-1 {
+			assert.InDelta(t, seg, v, 0.05, "%s segment %d", name, i)
+		}
+	}
+	assert.InDelta(t, total, sum, 0.1, name)
+}
@@ -0,0 +1,261 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// Corner is the corner of the frame a watermark is placed in.
+type Corner string
+
+const (
+	CornerTopLeft     Corner = "top-left"
+	CornerTopRight    Corner = "top-right"
+	CornerBottomLeft  Corner = "bottom-left"
+	CornerBottomRight Corner = "bottom-right"
+)
+
+// WatermarkFont is the font the lite image ships for text watermarks.
+const WatermarkFont = "/fonts/DejaVuSans.ttf"
+
+// Container paths of the watermark files. Fixed names keep user file names
+// out of the filter graph, where they would need escaping.
+const (
+	watermarkDir  = "/input/watermark/"
+	watermarkText = watermarkDir + "text.txt"
+)
+
+// fontColor matches FFmpeg's colour syntax: a name or 0xRRGGBB[AA] or
+// #RRGGBB[AA], with an optional @alpha. Anything else could break out of the
+// drawtext options.
+var fontColor = regexp.MustCompile(`^([a-zA-Z]+|(0x|#)[0-9a-fA-F]{6}([0-9a-fA-F]{2})?)(@(0x[0-9a-fA-F]{2}|[0-9]*\.?[0-9]+))?$`)
+
+// Watermark is a logo or a line of text burnt into every rendition of a
+// ladder. Its size and margin are fractions of each rendition's height, so
+// it covers the same share of the picture on every rung. Zero values pick
+// defaults.
+type Watermark struct {
+	// Image is the host path of the logo, usually a PNG with alpha. Set
+	// either Image or Text.
+	Image string `json:"image,omitempty"`
+	// Text is drawn as is, without expanding % sequences.
+	Text string `json:"text,omitempty"`
+	// FontFile is the host path of a TrueType or OpenType font for Text;
+	// defaults to WatermarkFont in the image.
+	FontFile string `json:"font_file,omitempty"`
+	// FontColor is an FFmpeg color for Text, such as "yellow", "0xFF8800"
+	// or "white@0.5"; defaults to "white".
+	FontColor string `json:"font_color,omitempty"`
+	// Corner defaults to CornerBottomRight.
+	Corner Corner `json:"corner,omitempty"`
+	// Size is the height of the logo or the font size as a fraction of the
+	// output height; defaults to 0.1.
+	Size float64 `json:"size,omitempty"`
+	// Margin from both edges of the corner as a fraction of the output
+	// height; defaults to 0.03.
+	Margin float64 `json:"margin,omitempty"`
+	// Opacity from 0 to 1; defaults to 1.
+	Opacity float64 `json:"opacity,omitempty"`
+	// Start and End bound when the watermark is shown, in seconds of the
+	// source. A zero End shows it until the end.
+	Start float64 `json:"start,omitempty"`
+	End   float64 `json:"end,omitempty"`
+	// FadeIn and FadeOut are fade durations in seconds at either end of
+	// the window. FadeOut needs an End.
+	FadeIn  float64 `json:"fade_in,omitempty"`
+	FadeOut float64 `json:"fade_out,omitempty"`
+}
+
+// resolve validates w and fills in its defaults.
+func (w Watermark) resolve() (*Watermark, error) {
+	if (w.Image == "") == (w.Text == "") {
+		return nil, errors.New("watermark: set either an image or text")
+	}
+	if w.Image != "" && w.FontFile != "" {
+		return nil, errors.New("watermark: a font only applies to text")
+	}
+	switch w.Corner {
+	case "":
+		w.Corner = CornerBottomRight
+	case CornerTopLeft, CornerTopRight, CornerBottomLeft, CornerBottomRight:
+	default:
+		return nil, fmt.Errorf("watermark: unknown corner %q", w.Corner)
+	}
+	if w.FontColor == "" {
+		w.FontColor = "white"
+	} else if !fontColor.MatchString(w.FontColor) {
+		return nil, fmt.Errorf("watermark: invalid font color %q", w.FontColor)
+	}
+	if w.Size == 0 {
+		w.Size = 0.1
+	}
+	if w.Margin == 0 {
+		w.Margin = 0.03
+	}
+	if w.Opacity == 0 {
+		w.Opacity = 1
+	}
+	if w.Size < 0 || w.Size > 1 {
+		return nil, fmt.Errorf("watermark: size %v is outside 0 to 1", w.Size)
+	}
+	if w.Margin < 0 || w.Margin >= 0.5 {
+		return nil, fmt.Errorf("watermark: margin %v is outside 0 to 0.5", w.Margin)
+	}
+	if w.Opacity < 0 || w.Opacity > 1 {
+		return nil, fmt.Errorf("watermark: opacity %v is outside 0 to 1", w.Opacity)
+	}
+	if w.Start < 0 || w.End < 0 || (w.End > 0 && w.End <= w.Start) {
+		return nil, fmt.Errorf("watermark: invalid window %v to %v", w.Start, w.End)
+	}
+	if w.FadeIn < 0 || w.FadeOut < 0 {
+		return nil, errors.New("watermark: fades cannot be negative")
+	}
+	if w.FadeOut > 0 && w.End == 0 {
+		return nil, errors.New("watermark: a fade out needs an end")
+	}
+	if w.End > 0 && w.FadeIn+w.FadeOut > w.End-w.Start {
+		return nil, fmt.Errorf("watermark: fades are longer than the %v to %v window", w.Start, w.End)
+	}
+	return &w, nil
+}
+
+// imagePath returns the container path of the logo.
+func (w *Watermark) imagePath() string {
+	return watermarkDir + "logo" + strings.ToLower(filepath.Ext(w.Image))
+}
+
+// fontPath returns the container path of the font.
+func (w *Watermark) fontPath() string {
+	if w.FontFile == "" {
+		return WatermarkFont
+	}
+	return watermarkDir + "font" + strings.ToLower(filepath.Ext(w.FontFile))
+}
+
+// files returns the files to copy into the container for w.
+func (w *Watermark) files() []testcontainers.ContainerFile {
+	if w.Image != "" {
+		return []testcontainers.ContainerFile{runner.File(w.Image, w.imagePath())}
+	}
+	files := []testcontainers.ContainerFile{{
+		Reader:            strings.NewReader(w.Text),
+		ContainerFilePath: watermarkText,
+		FileMode:          0o644,
+	}}
+	if w.FontFile != "" {
+		files = append(files, runner.File(w.FontFile, w.fontPath()))
+	}
+	return files
+}
+
+// enable returns the timeline expression of the window, empty when the
+// watermark is always shown.
+func (w *Watermark) enable() string {
+	switch {
+	case w.End > 0:
+		return fmt.Sprintf("between(t,%s,%s)", formatFloat(w.Start), formatFloat(w.End))
+	case w.Start > 0:
+		return "gte(t," + formatFloat(w.Start) + ")"
+	}
+	return ""
+}
+
+// position returns the x and y expressions for the corner, given the names
+// of the frame and watermark dimensions in the filter's expressions.
+func (w *Watermark) position(frameW, frameH, markW, markH string, margin int) (x, y string) {
+	m := fmt.Sprint(margin)
+	x, y = m, m
+	if w.Corner == CornerTopRight || w.Corner == CornerBottomRight {
+		x = fmt.Sprintf("%s-%s-%s", frameW, markW, m)
+	}
+	if w.Corner == CornerBottomLeft || w.Corner == CornerBottomRight {
+		y = fmt.Sprintf("%s-%s-%s", frameH, markH, m)
+	}
+	return x, y
+}
+
+// scaled returns a fraction of height in whole pixels, at least one.
+func scaled(fraction float64, height int) int {
+	return max(1, int(math.Round(fraction*float64(height))))
+}
+
+// logoGraph loads the logo once, applies opacity and fades to its alpha
+// and splits it into one copy per rung, labelled [w0], [w1] and so on. The
+// still image loops at the ladder's frame rate so fades animate.
+func (w *Watermark) logoGraph(frameRate float64, rungs int) string {
+	var graph strings.Builder
+	fmt.Fprintf(&graph, "movie=%s:loop=0,setpts=N/(%s*TB),format=rgba", w.imagePath(), formatFloat(frameRate))
+	if w.Opacity < 1 {
+		fmt.Fprintf(&graph, ",colorchannelmixer=aa=%s", formatFloat(w.Opacity))
+	}
+	if w.FadeIn > 0 {
+		fmt.Fprintf(&graph, ",fade=t=in:st=%s:d=%s:alpha=1", formatFloat(w.Start), formatFloat(w.FadeIn))
+	}
+	if w.FadeOut > 0 {
+		fmt.Fprintf(&graph, ",fade=t=out:st=%s:d=%s:alpha=1", formatFloat(w.End-w.FadeOut), formatFloat(w.FadeOut))
+	}
+	fmt.Fprintf(&graph, ",split=%d", rungs)
+	for i := range rungs {
+		fmt.Fprintf(&graph, "[w%d]", i)
+	}
+	return graph.String()
+}
+
+// overlay scales logo copy [wI] to rung i and places it over the scaled
+// rung [bI], giving [vI]. The logo loops forever, so the main input decides
+// when the output ends.
+func (w *Watermark) overlay(i int, r Rung) string {
+	margin := scaled(w.Margin, r.Height)
+	x, y := w.position("W", "H", "w", "h", margin)
+	opts := fmt.Sprintf("x=%s:y=%s:shortest=1", x, y)
+	if e := w.enable(); e != "" {
+		opts += ":enable='" + e + "'"
+	}
+	return fmt.Sprintf(";[w%d]scale=-1:%d[l%d];[b%d][l%d]overlay=%s[v%d]",
+		i, scaled(w.Size, r.Height), i, i, i, opts, i)
+}
+
+// drawtext returns the filter that draws the text on a rung. The text is
+// read from a file so it needs no escaping, and opacity and fades go into
+// its alpha expression.
+func (w *Watermark) drawtext(r Rung) string {
+	x, y := w.position("w", "h", "tw", "th", scaled(w.Margin, r.Height))
+	opts := []string{
+		"fontfile=" + w.fontPath(),
+		"textfile=" + watermarkText,
+		"expansion=none",
+		fmt.Sprintf("fontsize=%d", scaled(w.Size, r.Height)),
+		"fontcolor=" + w.FontColor,
+		"x=" + x,
+		"y=" + y,
+	}
+	if a := w.alpha(); a != "1" {
+		opts = append(opts, "alpha='"+a+"'")
+	}
+	if e := w.enable(); e != "" {
+		opts = append(opts, "enable='"+e+"'")
+	}
+	return "drawtext=" + strings.Join(opts, ":")
+}
+
+// alpha returns the opacity of the text over time.
+func (w *Watermark) alpha() string {
+	terms := []string{formatFloat(w.Opacity)}
+	if w.FadeIn > 0 {
+		terms = append(terms, fmt.Sprintf("clip((t-%s)/%s,0,1)", formatFloat(w.Start), formatFloat(w.FadeIn)))
+	}
+	if w.FadeOut > 0 {
+		terms = append(terms, fmt.Sprintf("clip((%s-t)/%s,0,1)", formatFloat(w.End), formatFloat(w.FadeOut)))
+	}
+	if len(terms) > 1 && terms[0] == "1" {
+		terms = terms[1:]
+	}
+	return strings.Join(terms, "*")
+}
@@ -0,0 +1,269 @@
+package ffmpeg_test
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ffmpeg "github.com/veloxpack/tools/ffmpeg-lite"
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+func watermarkPlan(t *testing.T, w ffmpeg.Watermark) *ffmpeg.LadderPlan {
+	t.Helper()
+	video := &ffprobe.Stream{Width: 1920, Height: 1080, AvgFrameRate: "25/1"}
+	plan, err := ffmpeg.PlanLadder(ffmpeg.Ladder{
+		Rungs:     []ffmpeg.Rung{{Height: 1080, Bitrate: 5000}, {Height: 360, Bitrate: 800}},
+		Watermark: &w,
+	}, video)
+	require.NoError(t, err)
+	return plan
+}
+
+func TestLadderPlan_Args_ImageWatermark(t *testing.T) {
+	// Given: A half transparent logo in the bottom right corner from 2s to
+	// 10s, fading over a second at both ends
+	plan := watermarkPlan(t, ffmpeg.Watermark{
+		Image:   "/tmp/Partner Logo.PNG",
+		Opacity: 0.5,
+		Start:   2,
+		End:     10,
+		FadeIn:  1,
+		FadeOut: 1,
+	})
+
+	// When: Building the command line
+	graph := plan.Args("/input/in.mp4")[3]
+
+	// Then: The logo is loaded once, faded and split per rung
+	assert.Equal(t, []string{
+		"[0:v]fps=25,split=2[s0][s1]",
+		"movie=/input/watermark/logo.png:loop=0,setpts=N/(25*TB),format=rgba,colorchannelmixer=aa=0.5," +
+			"fade=t=in:st=2:d=1:alpha=1,fade=t=out:st=9:d=1:alpha=1,split=2[w0][w1]",
+		"[s0]scale=1920:1080,setsar=1[b0]",
+		"[w0]scale=-1:108[l0]",
+		"[b0][l0]overlay=x=W-w-32:y=H-h-32:shortest=1:enable='between(t,2,10)'[v0]",
+		"[s1]scale=640:360,setsar=1[b1]",
+		"[w1]scale=-1:36[l1]",
+		"[b1][l1]overlay=x=W-w-11:y=H-h-11:shortest=1:enable='between(t,2,10)'[v1]",
+	}, strings.Split(graph, ";"))
+
+	// And: The defaults were filled in
+	assert.Equal(t, ffmpeg.CornerBottomRight, plan.Watermark.Corner)
+	assert.Equal(t, 0.1, plan.Watermark.Size)
+}
+
+func TestLadderPlan_StreamArgs_TextWatermark(t *testing.T) {
+	// Given: Text in the top left corner from 3s on
+	plan := watermarkPlan(t, ffmpeg.Watermark{
+		Text:      "PREVIEW 100%",
+		Corner:    ffmpeg.CornerTopLeft,
+		FontColor: "yellow",
+		Size:      0.05,
+		Start:     3,
+		FadeIn:    0.5,
+	})
+
+	// When: Building the HLS command line
+	args, err := plan.StreamArgs("/input/in.mp4", ffmpeg.FormatHLSTS)
+	require.NoError(t, err)
+
+	// Then: Each rung draws the text from a file at its own size
+	parts := strings.Split(args[3], ";")
+	require.Len(t, parts, 3)
+	assert.Equal(t, "[s1]scale=640:360,setsar=1,drawtext=fontfile=/fonts/DejaVuSans.ttf:"+
+		"textfile=/input/watermark/text.txt:expansion=none:fontsize=18:fontcolor=yellow:x=11:y=11:"+
+		"alpha='clip((t-3)/0.5,0,1)':enable='gte(t,3)'[v1]", parts[2])
+	assert.Contains(t, parts[1], "fontsize=54:fontcolor=yellow:x=32:y=32:")
+	assert.NotContains(t, args[3], "PREVIEW")
+}
+
+func TestPlanLadder_WatermarkErrors(t *testing.T) {
+	video := &ffprobe.Stream{Width: 1920, Height: 1080, AvgFrameRate: "25/1"}
+	for name, w := range map[string]ffmpeg.Watermark{
+		"nothing":          {},
+		"image and text":   {Image: "logo.png", Text: "hi"},
+		"font for image":   {Image: "logo.png", FontFile: "font.ttf"},
+		"unknown corner":   {Text: "hi", Corner: "center"},
+		"too large":        {Text: "hi", Size: 1.5},
+		"margin":           {Text: "hi", Margin: 0.6},
+		"opacity":          {Text: "hi", Opacity: 2},
+		"empty window":     {Text: "hi", Start: 5, End: 5},
+		"fade out, no end": {Text: "hi", FadeOut: 1},
+		"fades too long":   {Text: "hi", Start: 1, End: 2, FadeIn: 1, FadeOut: 1},
+		"color with :":     {Text: "hi", FontColor: "white:x=0"},
+		"color with ,":     {Text: "hi", FontColor: "red,drawbox"},
+		"color with ;":     {Text: "hi", FontColor: "red;[v0]null"},
+		"color with '":     {Text: "hi", FontColor: "red'"},
+		"short hex color":  {Text: "hi", FontColor: "0xFFF"},
+	} {
+		_, err := ffmpeg.PlanLadder(ffmpeg.Ladder{
+			Rungs:     []ffmpeg.Rung{{Height: 720, Bitrate: 3000}},
+			Watermark: &w,
+		}, video)
+		assert.ErrorContains(t, err, "watermark: ", name)
+	}
+
+	// And: FFmpeg colour syntax is accepted for text
+	for _, c := range []string{"yellow", "0xFF8800", "0xFF880080", "#ff8800", "white@0.5", "black@0x80"} {
+		_, err := ffmpeg.PlanLadder(ffmpeg.Ladder{
+			Rungs:     []ffmpeg.Rung{{Height: 720, Bitrate: 3000}},
+			Watermark: &ffmpeg.Watermark{Text: "hi", FontColor: c},
+		}, video)
+		assert.NoError(t, err, c)
+	}
+}
+
+// writeLogo writes a 100x100 PNG logo: opaque white with a transparent
+// 10 pixel border.
+func writeLogo(t *testing.T, path string) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for y := 10; y < 90; y++ {
+		for x := 10; x < 90; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, png.Encode(f, img))
+}
+
+// frames returns one PNG frame a second of the video at path, from 0.5s.
+func frames(t *testing.T, path string, n int) []image.Image {
+	t.Helper()
+	var images []image.Image
+	err := thumbnail.Stream(context.Background(), path, thumbnail.StreamOptions{
+		Start:     0.5,
+		Interval:  1,
+		MaxFrames: n,
+		Format:    thumbnail.FormatPNG,
+	}, func(f thumbnail.StreamFrame) error {
+		img, err := f.Image()
+		images = append(images, img)
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, images, n)
+	return images
+}
+
+// difference returns the mean absolute RGB difference of a and b within r,
+// from 0 to 255.
+func difference(a, b image.Image, r image.Rectangle) float64 {
+	var sum float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			sum += math.Abs(float64(r1)-float64(r2)) + math.Abs(float64(g1)-float64(g2)) + math.Abs(float64(b1)-float64(b2))
+		}
+	}
+	return sum / float64(3*257*r.Dx()*r.Dy())
+}
+
+// watermarkClip renders a flat blue 6s clip, on which any watermark stands
+// out.
+func watermarkClip(t *testing.T, dir string) string {
+	t.Helper()
+	input, err := fixture.Generate(context.Background(), dir, fixture.Video{
+		Name:     "source.mp4",
+		Duration: 6,
+		Source:   "color=c=0x336699",
+	})
+	require.NoError(t, err)
+	return input
+}
+
+func encodeLadder(t *testing.T, input string, l ffmpeg.Ladder) *ffmpeg.LadderResult {
+	t.Helper()
+	dir := createTempDir(t)
+	t.Cleanup(func() { cleanupFiles(t, dir) })
+	result, err := ffmpeg.EncodeLadder(context.Background(), input, dir, l)
+	require.NoError(t, err)
+	return result
+}
+
+func TestFFmpeg_EncodeLadder_ImageWatermark(t *testing.T) {
+	// Given: A 360p clip and a logo with alpha
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	input := watermarkClip(t, outputPath)
+	logo := filepath.Join(outputPath, "logo.png")
+	writeLogo(t, logo)
+
+	// When: Encoding a two rung ladder with and without the logo, shown
+	// from 1s to 5s and fading over a second at both ends
+	ladder := ffmpeg.Ladder{Rungs: []ffmpeg.Rung{{Height: 360, Bitrate: 800}, {Height: 180, Bitrate: 300}}}
+	plain := encodeLadder(t, input, ladder)
+	ladder.Watermark = &ffmpeg.Watermark{Image: logo, Size: 0.2, Start: 1, End: 5, FadeIn: 1, FadeOut: 1}
+	marked := encodeLadder(t, input, ladder)
+
+	for i, r := range marked.Renditions {
+		// Then: The opaque middle of the logo sits in the bottom right
+		// corner, 20% of the height tall with a 3% margin
+		size := math.Round(0.2 * float64(r.Height))
+		margin := math.Round(0.03 * float64(r.Height))
+		inset := int(math.Ceil(size * 0.15))
+		right, bottom := r.Width-int(margin), r.Height-int(margin)
+		logoBox := image.Rect(right-int(size)+inset, bottom-int(size)+inset, right-inset, bottom-inset)
+		corner := image.Rect(0, 0, logoBox.Dx(), logoBox.Dy()).Add(image.Pt(int(margin), int(margin)))
+
+		want := frames(t, plain.Renditions[i].Path, 6)
+		got := frames(t, r.Path, 6)
+		diffs := make([]float64, len(got))
+		for j := range got {
+			diffs[j] = difference(want[j], got[j], logoBox)
+			// And: The rest of the picture is untouched
+			assert.Less(t, difference(want[j], got[j], corner), 2.0, "%s frame %d", r.Name, j)
+		}
+
+		// And: It is absent outside the window, half faded at 1.5s and 4.5s
+		// and fully visible in between
+		assert.Less(t, diffs[0], 2.0, r.Name)
+		assert.Less(t, diffs[5], 2.0, r.Name)
+		assert.Greater(t, diffs[2], 100.0, r.Name)
+		assert.Greater(t, diffs[3], 100.0, r.Name)
+		assert.InDelta(t, 0.5, diffs[1]/diffs[2], 0.2, r.Name)
+		assert.InDelta(t, 0.5, diffs[4]/diffs[3], 0.2, r.Name)
+	}
+}
+
+func TestFFmpeg_EncodeLadder_TextWatermark(t *testing.T) {
+	// Given: A 360p clip
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	input := watermarkClip(t, outputPath)
+
+	// When: Encoding with and without text in the top left corner from 2s
+	ladder := ffmpeg.Ladder{Rungs: []ffmpeg.Rung{{Height: 360, Bitrate: 800}}}
+	plain := encodeLadder(t, input, ladder)
+	ladder.Watermark = &ffmpeg.Watermark{Text: "PREVIEW", Corner: ffmpeg.CornerTopLeft, Size: 0.15, Start: 2}
+	marked := encodeLadder(t, input, ladder)
+
+	// Then: The text covers part of the top left corner from 2s on
+	textBox := image.Rect(11, 11, 160, 65)
+	opposite := image.Rect(480, 295, 629, 349)
+	want := frames(t, plain.Renditions[0].Path, 5)
+	got := frames(t, marked.Renditions[0].Path, 5)
+	for j := range got {
+		if j < 2 {
+			assert.Less(t, difference(want[j], got[j], textBox), 2.0, "frame %d", j)
+		} else {
+			assert.Greater(t, difference(want[j], got[j], textBox), 15.0, "frame %d", j)
+		}
+		// And: The opposite corner is untouched
+		assert.Less(t, difference(want[j], got[j], opposite), 2.0, "frame %d", j)
+	}
+}
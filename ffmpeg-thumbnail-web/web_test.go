@@ -0,0 +1,177 @@
+package thumbnailweb_test
+
+import (
+	"context"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+	"github.com/veloxpack/tools/internal/mp4"
+	"github.com/veloxpack/tools/internal/runner"
+	"golang.org/x/image/webp"
+)
+
+func TestThumbnailWeb_WebP(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Extracting a 320x180 WebP thumbnail
+	path, err := thumbnail.Extract(context.Background(), absPath, thumbnail.ExtractOptions{
+		OutputDir: outputPath,
+		Time:      5,
+		Width:     320,
+		Height:    180,
+		Format:    thumbnail.FormatWebP,
+	})
+	require.NoError(t, err)
+
+	// Then: The file is a WebP image that decodes at the requested size
+	assert.Equal(t, "thumbnail.webp", filepath.Base(path))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	img, err := webp.Decode(f)
+	require.NoError(t, err)
+	assert.Equal(t, 320, img.Bounds().Dx())
+	assert.Equal(t, 180, img.Bounds().Dy())
+}
+
+func TestThumbnailWeb_AVIF(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Extracting a 320 wide AVIF thumbnail
+	ctx := context.Background()
+	path, err := thumbnail.Extract(ctx, absPath, thumbnail.ExtractOptions{
+		OutputDir: outputPath,
+		Time:      5,
+		Width:     320,
+		Format:    thumbnail.FormatAVIF,
+	})
+	require.NoError(t, err)
+
+	// Then: The file is an AVIF still whose spatial extents match
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	boxes, err := mp4.Parse(data)
+	require.NoError(t, err)
+	ftyp := mp4.Find(boxes, "ftyp")
+	require.Len(t, ftyp, 1)
+	assert.Equal(t, "avif", string(ftyp[0].Payload[:4]))
+	ispe := mp4.Find(boxes, "meta/iprp/ipco/ispe")
+	require.NotEmpty(t, ispe)
+	w, h, err := mp4.ParseISPE(ispe[0])
+	require.NoError(t, err)
+	assert.Equal(t, 320, w)
+	assert.Equal(t, 180, h)
+
+	// Then: The image decodes back to a PNG of the same size
+	_, err = runner.Run(ctx, runner.Request{
+		Image:  thumbnail.WebImage,
+		Cmd:    []string{"-i", "/input/thumbnail.avif", "-frames:v", "1", "/output/decoded.png"},
+		Files:  []testcontainers.ContainerFile{runner.File(path, "/input/thumbnail.avif")},
+		Mounts: []mount.Mount{runner.Bind(outputPath, "/output")},
+	})
+	require.NoError(t, err)
+	f, err := os.Open(filepath.Join(outputPath, "decoded.png"))
+	require.NoError(t, err)
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	require.NoError(t, err)
+	assert.Equal(t, 320, cfg.Width)
+	assert.Equal(t, 180, cfg.Height)
+}
+
+func TestThumbnailWeb_WebPSmallerThanJPEG(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Extracting the same frame as JPEG, WebP and AVIF
+	ctx := context.Background()
+	sizes := map[thumbnail.Format]int64{}
+	for _, format := range []thumbnail.Format{thumbnail.FormatJPEG, thumbnail.FormatWebP, thumbnail.FormatAVIF} {
+		path, err := thumbnail.Extract(ctx, absPath, thumbnail.ExtractOptions{
+			OutputDir: outputPath,
+			Time:      5,
+			Width:     640,
+			Format:    format,
+		})
+		require.NoError(t, err, format)
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		sizes[format] = info.Size()
+	}
+	t.Logf("thumbnail sizes: %v", sizes)
+
+	// Then: The web formats are smaller than the JPEG at the default quality
+	assert.Less(t, sizes[thumbnail.FormatWebP], sizes[thumbnail.FormatJPEG])
+	assert.Less(t, sizes[thumbnail.FormatAVIF], sizes[thumbnail.FormatJPEG])
+}
+
+func TestThumbnailWeb_StoryboardWebP(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Generating a storyboard with WebP sprite sheets
+	sb, err := thumbnail.GenerateStoryboard(context.Background(), absPath, thumbnail.StoryboardOptions{
+		OutputDir:     outputPath,
+		MaxThumbnails: 150,
+		Format:        thumbnail.FormatWebP,
+	})
+	require.NoError(t, err)
+
+	// Then: Every sheet is a WebP image of the planned grid size
+	require.NotEmpty(t, sb.Sheets)
+	for _, sheet := range sb.Sheets {
+		assert.True(t, strings.HasSuffix(sheet, ".webp"), sheet)
+		f, err := os.Open(filepath.Join(outputPath, sheet))
+		require.NoError(t, err)
+		cfg, err := webp.DecodeConfig(f)
+		f.Close()
+		require.NoError(t, err, sheet)
+		assert.Equal(t, sb.Columns*sb.TileWidth, cfg.Width, sheet)
+		assert.Equal(t, sb.Rows*sb.TileHeight, cfg.Height, sheet)
+	}
+}
+
+// Helper functions
+func createTempDir(t *testing.T) string {
+	outputPath, err := filepath.Abs(filepath.Join("..", "testdata", strings.ReplaceAll(uuid.NewString(), "-", "")))
+	require.NoError(t, err)
+
+	err = os.MkdirAll(outputPath, 0755)
+	require.NoError(t, err)
+
+	return outputPath
+}
+
+func cleanupFiles(t *testing.T, path string) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		t.Logf("failed to remove output directory: %s", path)
+	}
+}
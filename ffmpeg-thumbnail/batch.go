@@ -0,0 +1,128 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// BatchOptions configures ExtractBatch.
+type BatchOptions struct {
+	// OutputDir is the host directory the thumbnails are written to.
+	OutputDir string
+	// Times are the timestamps in seconds. Duplicates, to the millisecond,
+	// produce one file.
+	Times []float64
+	// Prefix names the outputs, see TimestampName; defaults to "frame".
+	Prefix string
+	// Width, Height, Fit, CropBlackBars, Format and Quality apply to every
+	// frame as in ExtractOptions. Black bars are detected once, at the
+	// median timestamp.
+	Width         int
+	Height        int
+	Fit           Fit
+	CropBlackBars bool
+	Format        Format
+	Quality       int
+}
+
+// TimestampName names the thumbnail at t seconds by its millisecond
+// timestamp, e.g. frame_00065500.jpg for 65.5s, so names sort by time.
+func TimestampName(prefix string, t float64, format Format) string {
+	return fmt.Sprintf("%s_%08d%s", prefix, int64(math.Round(t*1000)), format.Extension())
+}
+
+// ExtractBatch writes the frames at opts.Times of the video at input in a
+// single FFmpeg run and returns the host path of each, keyed by timestamp.
+// Every timestamp is a separately seeked input, so only the frames around
+// the requested times are decoded.
+func ExtractBatch(ctx context.Context, input string, opts BatchOptions) (map[float64]string, error) {
+	if opts.OutputDir == "" {
+		return nil, errors.New("extract batch: output directory is required")
+	}
+	if len(opts.Times) == 0 {
+		return nil, errors.New("extract batch: no timestamps")
+	}
+	if err := opts.Format.Validate(); err != nil {
+		return nil, fmt.Errorf("extract batch: %w", err)
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "frame"
+	}
+
+	names := make(map[float64]string, len(opts.Times))
+	var times []float64
+	seen := make(map[string]bool, len(opts.Times))
+	for _, t := range opts.Times {
+		if t < 0 || math.IsNaN(t) || math.IsInf(t, 0) {
+			return nil, fmt.Errorf("extract batch: invalid timestamp %v", t)
+		}
+		name := TimestampName(opts.Prefix, t, opts.Format)
+		names[t] = name
+		if !seen[name] {
+			seen[name] = true
+			times = append(times, t)
+		}
+	}
+	sort.Float64s(times)
+
+	var filter string
+	if opts.Width != 0 || opts.Height != 0 || opts.CropBlackBars {
+		var err error
+		filter, err = extractFilter(ctx, input, ExtractOptions{
+			Time:          times[len(times)/2],
+			Width:         opts.Width,
+			Height:        opts.Height,
+			Fit:           opts.Fit,
+			CropBlackBars: opts.CropBlackBars,
+			Format:        opts.Format,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("extract batch: %w", err)
+		}
+	}
+
+	// One input per timestamp keeps each seek fast; each output maps the
+	// video of its own input.
+	containerInput := "/input/" + filepath.Base(input)
+	var cmd []string
+	for _, t := range times {
+		cmd = append(cmd, "-ss", formatFloat(t), "-i", containerInput)
+	}
+	for i, t := range times {
+		cmd = append(cmd, "-map", fmt.Sprintf("%d:v:0", i), "-frames:v", "1")
+		if filter != "" {
+			cmd = append(cmd, "-vf", filter)
+		}
+		cmd = append(cmd, opts.Format.EncoderArgs(opts.Quality)...)
+		cmd = append(cmd, "/output/"+TimestampName(opts.Prefix, t, opts.Format))
+	}
+
+	_, err := runner.Run(ctx, runner.Request{
+		Image:  opts.Format.Image(),
+		Cmd:    cmd,
+		Files:  []testcontainers.ContainerFile{runner.File(input, containerInput)},
+		Mounts: []mount.Mount{runner.Bind(opts.OutputDir, "/output")},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extract batch: %w", err)
+	}
+
+	paths := make(map[float64]string, len(names))
+	for t, name := range names {
+		path := filepath.Join(opts.OutputDir, name)
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("extract batch: no frame at %ss: %w", formatFloat(t), err)
+		}
+		paths[t] = path
+	}
+	return paths, nil
+}
@@ -0,0 +1,82 @@
+package thumbnail_test
+
+import (
+	"context"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+)
+
+func TestTimestampName(t *testing.T) {
+	assert.Equal(t, "frame_00065500.jpg", thumbnail.TimestampName("frame", 65.5, ""))
+	assert.Equal(t, "chapter_00000000.webp", thumbnail.TimestampName("chapter", 0, thumbnail.FormatWebP))
+	assert.Equal(t, "frame_03600001.png", thumbnail.TimestampName("frame", 3600.0005, thumbnail.FormatPNG))
+}
+
+func TestThumbnail_ExtractBatch(t *testing.T) {
+	// Given: A test video file and 30 chapter timestamps, out of order and
+	// with a duplicate
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	var times []float64
+	for i := 29; i >= 0; i-- {
+		times = append(times, 2.5+float64(i)*15)
+	}
+	times = append(times, 2.5)
+
+	// When: Extracting all frames in one run
+	frames, err := thumbnail.ExtractBatch(context.Background(), absPath, thumbnail.BatchOptions{
+		OutputDir: outputPath,
+		Times:     times,
+		Width:     320,
+	})
+	require.NoError(t, err)
+
+	// Then: Every timestamp maps to a JPEG named after it
+	require.Len(t, frames, 30)
+	for _, ts := range times {
+		path, ok := frames[ts]
+		require.True(t, ok, "missing %.1fs", ts)
+		assert.Equal(t, thumbnail.TimestampName("frame", ts, ""), filepath.Base(path))
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		cfg, err := jpeg.DecodeConfig(f)
+		f.Close()
+		require.NoError(t, err, path)
+		assert.Equal(t, 320, cfg.Width)
+	}
+
+	// Then: No other files were written
+	entries, err := os.ReadDir(outputPath)
+	require.NoError(t, err)
+	assert.Len(t, entries, 30)
+}
+
+func TestThumbnail_ExtractBatch_PastEnd(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: One timestamp lies beyond the end of the video
+	_, err = thumbnail.ExtractBatch(context.Background(), absPath, thumbnail.BatchOptions{
+		OutputDir: outputPath,
+		Times:     []float64{5, 100000},
+	})
+
+	// Then: The missing frame is reported
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "100000.000")
+}
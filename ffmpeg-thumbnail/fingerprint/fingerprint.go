@@ -0,0 +1,261 @@
+// Package fingerprint computes perceptual fingerprints of videos from frames
+// pulled through the thumbnail image, to find re-uploads and near duplicates
+// that survived re-encoding or resizing.
+package fingerprint
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"sort"
+
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+)
+
+// DefaultThreshold is the Similarity above which two videos are treated as
+// duplicates.
+const DefaultThreshold = 0.85
+
+// hashSize is the side of the square frames hashed; pHash runs its DCT over
+// it.
+const hashSize = 32
+
+// Options configures Compute.
+type Options struct {
+	// Interval between hashed frames in seconds; defaults to 2.
+	Interval float64
+	// MaxFrames bounds the fingerprint length; defaults to 300.
+	MaxFrames int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Interval == 0 {
+		o.Interval = 2
+	}
+	if o.MaxFrames == 0 {
+		o.MaxFrames = 300
+	}
+	return o
+}
+
+// Hash is the perceptual hash pair of one frame.
+type Hash struct {
+	// PHash thresholds the low DCT frequencies against their median.
+	PHash uint64
+	// DHash compares horizontally adjacent pixels.
+	DHash uint64
+}
+
+// Fingerprint is the sequence of frame hashes sampled every Interval
+// seconds. It marshals to a compact base64 text, 16 bytes per frame.
+type Fingerprint struct {
+	Interval float64
+	Hashes   []Hash
+}
+
+// Compute streams frames every opts.Interval seconds of the video at input
+// out of the thumbnail image, squashed to 32x32 so the aspect ratio and
+// resolution do not matter, and hashes them.
+func Compute(ctx context.Context, input string, opts Options) (*Fingerprint, error) {
+	opts = opts.withDefaults()
+	fp := &Fingerprint{Interval: opts.Interval}
+	err := thumbnail.Stream(ctx, input, thumbnail.StreamOptions{
+		Interval:  opts.Interval,
+		MaxFrames: opts.MaxFrames,
+		Width:     hashSize,
+		Height:    hashSize,
+		Fit:       thumbnail.FitExact,
+		Format:    thumbnail.FormatPNG,
+	}, func(f thumbnail.StreamFrame) error {
+		img, err := f.Image()
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", f.Index, err)
+		}
+		fp.Hashes = append(fp.Hashes, HashImage(img))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fingerprint: %w", err)
+	}
+	if len(fp.Hashes) == 0 {
+		return nil, errors.New("fingerprint: no frames were extracted")
+	}
+	return fp, nil
+}
+
+// HashImage computes both perceptual hashes of an image of any size.
+func HashImage(img image.Image) Hash {
+	return Hash{PHash: PHash(img), DHash: DHash(img)}
+}
+
+// DHash is the 64 bit difference hash: the image is reduced to 9x8 grey
+// levels and each bit is set when a pixel is brighter than its left
+// neighbour.
+func DHash(img image.Image) uint64 {
+	g := grayscale(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if g[y*9+x+1] > g[y*9+x] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// PHash is the 64 bit DCT hash: the image is reduced to 32x32 grey levels
+// and each bit of the 8x8 lowest frequencies is set when the coefficient is
+// above their median. The DC term takes part in the bits but not in the
+// median, as it only measures overall brightness.
+func PHash(img image.Image) uint64 {
+	g := grayscale(img, hashSize, hashSize)
+	var coeffs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < hashSize; y++ {
+				cy := math.Cos(float64(2*y+1) * float64(v) * math.Pi / (2 * hashSize))
+				for x := 0; x < hashSize; x++ {
+					sum += g[y*hashSize+x] * math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*hashSize)) * cy
+				}
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+
+	var h uint64
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
+
+// grayscale area-averages img down to w x h luma values in 0..255.
+func grayscale(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	out := make([]float64, w*h)
+	if sw == 0 || sh == 0 {
+		return out
+	}
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var sum float64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sum += float64(color.GrayModel.Convert(img.At(b.Min.X+sx, b.Min.Y+sy)).(color.Gray).Y)
+				}
+			}
+			out[y*w+x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return out
+}
+
+// Distance is the number of differing bits between two hashes.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// frameSimilarity averages both hashes, 1 for identical frames and about 0.5
+// for unrelated ones.
+func frameSimilarity(a, b Hash) float64 {
+	return 1 - float64(Distance(a.PHash, b.PHash)+Distance(a.DHash, b.DHash))/128
+}
+
+// Similarity compares two fingerprints and returns a score from 0 to 1.
+// Fingerprints taken at different intervals cannot be compared frame by
+// frame and score 0. Each frame of the shorter fingerprint is
+// matched to the best of the three nearest frames of the other, which
+// absorbs sampling jitter from different frame rates and keyframe
+// positions, and the mean is scaled by how much of the longer video the
+// match covers. Unrelated content scores around 0.5 before scaling.
+func Similarity(a, b *Fingerprint) float64 {
+	if a == nil || b == nil || len(a.Hashes) == 0 || len(b.Hashes) == 0 || a.Interval != b.Interval {
+		return 0
+	}
+	if len(a.Hashes) > len(b.Hashes) {
+		a, b = b, a
+	}
+	var sum float64
+	for i, h := range a.Hashes {
+		best := 0.0
+		for j := max(i-1, 0); j <= min(i+1, len(b.Hashes)-1); j++ {
+			best = math.Max(best, frameSimilarity(h, b.Hashes[j]))
+		}
+		sum += best
+	}
+	coverage := float64(len(a.Hashes)) / float64(len(b.Hashes))
+	return sum / float64(len(a.Hashes)) * coverage
+}
+
+// IsDuplicate reports whether Similarity reaches DefaultThreshold.
+func IsDuplicate(a, b *Fingerprint) bool {
+	return Similarity(a, b) >= DefaultThreshold
+}
+
+// MarshalBinary encodes the interval as a float64 followed by the pHash
+// and dHash of each frame, all big-endian.
+func (fp *Fingerprint) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 8, 8+16*len(fp.Hashes))
+	binary.BigEndian.PutUint64(out, math.Float64bits(fp.Interval))
+	for _, h := range fp.Hashes {
+		out = binary.BigEndian.AppendUint64(out, h.PHash)
+		out = binary.BigEndian.AppendUint64(out, h.DHash)
+	}
+	return out, nil
+}
+
+// UnmarshalBinary decodes the MarshalBinary encoding.
+func (fp *Fingerprint) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 || (len(data)-8)%16 != 0 {
+		return fmt.Errorf("fingerprint: invalid encoding of %d bytes", len(data))
+	}
+	fp.Interval = math.Float64frombits(binary.BigEndian.Uint64(data))
+	fp.Hashes = make([]Hash, 0, (len(data)-8)/16)
+	for p := data[8:]; len(p) > 0; p = p[16:] {
+		fp.Hashes = append(fp.Hashes, Hash{
+			PHash: binary.BigEndian.Uint64(p),
+			DHash: binary.BigEndian.Uint64(p[8:]),
+		})
+	}
+	return nil
+}
+
+// MarshalText encodes the binary form as base64, so fingerprints stay
+// compact in JSON.
+func (fp *Fingerprint) MarshalText() ([]byte, error) {
+	data, err := fp.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.RawURLEncoding.EncodedLen(len(data)))
+	base64.RawURLEncoding.Encode(out, data)
+	return out, nil
+}
+
+// UnmarshalText decodes the MarshalText encoding.
+func (fp *Fingerprint) UnmarshalText(text []byte) error {
+	data := make([]byte, base64.RawURLEncoding.DecodedLen(len(text)))
+	n, err := base64.RawURLEncoding.Decode(data, text)
+	if err != nil {
+		return fmt.Errorf("fingerprint: %w", err)
+	}
+	return fp.UnmarshalBinary(data[:n])
+}
@@ -0,0 +1,176 @@
+package fingerprint_test
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/ffmpeg-thumbnail/fingerprint"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+// scene draws overlapping rectangles whose layout depends on seed, in
+// relative coordinates so the same scene can be rendered at several
+// resolutions.
+func scene(w, h int, seed uint32) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	next := func() float64 {
+		seed = seed*1664525 + 1013904223
+		return float64(seed>>8) / (1 << 24)
+	}
+	for i := 0; i < 16; i++ {
+		x0, y0 := next(), next()
+		x1, y1 := x0+next()/2, y0+next()/2
+		l := color.Gray{Y: uint8(next() * 255)}
+		for y := int(y0 * float64(h)); y < min(int(y1*float64(h)), h); y++ {
+			for x := int(x0 * float64(w)); x < min(int(x1*float64(w)), w); x++ {
+				img.SetGray(x, y, l)
+			}
+		}
+	}
+	return img
+}
+
+func TestHashImage_ResizeTolerant(t *testing.T) {
+	// Given: The same scene at two resolutions and an unrelated scene
+	large := fingerprint.HashImage(scene(640, 360, 13))
+	small := fingerprint.HashImage(scene(96, 54, 13))
+	other := fingerprint.HashImage(scene(640, 360, 41))
+
+	// Then: Resizing flips few bits and other content flips many
+	assert.LessOrEqual(t, fingerprint.Distance(large.PHash, small.PHash), 6)
+	assert.LessOrEqual(t, fingerprint.Distance(large.DHash, small.DHash), 6)
+	assert.Greater(t, fingerprint.Distance(large.PHash, other.PHash), 16)
+	assert.Greater(t, fingerprint.Distance(large.DHash, other.DHash), 16)
+}
+
+func TestSimilarity(t *testing.T) {
+	a := &fingerprint.Fingerprint{Interval: 2}
+	b := &fingerprint.Fingerprint{Interval: 2}
+	for i := 0; i < 10; i++ {
+		a.Hashes = append(a.Hashes, fingerprint.HashImage(scene(64, 36, uint32(i))))
+		b.Hashes = append(b.Hashes, fingerprint.HashImage(scene(64, 36, uint32(i+100))))
+	}
+
+	assert.Equal(t, 1.0, fingerprint.Similarity(a, a))
+	assert.True(t, fingerprint.IsDuplicate(a, a))
+	assert.False(t, fingerprint.IsDuplicate(a, b))
+
+	// Given: A fingerprint offset by one frame, as when sampling starts on
+	// a different keyframe
+	shifted := &fingerprint.Fingerprint{Interval: 2, Hashes: a.Hashes[1:]}
+
+	// Then: The neighbouring frames still match and only coverage is lost
+	assert.InDelta(t, 0.9, fingerprint.Similarity(a, shifted), 0.001)
+	assert.Equal(t, 0.0, fingerprint.Similarity(a, &fingerprint.Fingerprint{}))
+
+	// And: The same frames sampled at another interval do not compare
+	other := &fingerprint.Fingerprint{Interval: 1, Hashes: a.Hashes}
+	assert.Equal(t, 0.0, fingerprint.Similarity(a, other))
+	assert.False(t, fingerprint.IsDuplicate(a, other))
+}
+
+func TestFingerprint_MarshalText(t *testing.T) {
+	fp := &fingerprint.Fingerprint{Interval: 2.5, Hashes: []fingerprint.Hash{
+		{PHash: 0xDEADBEEF00112233, DHash: 1},
+		{PHash: 42, DHash: math.MaxUint64},
+	}}
+
+	data, err := json.Marshal(fp)
+	require.NoError(t, err)
+	assert.Len(t, data, 2+int(math.Ceil(float64(8+16*2)*4/3)))
+
+	var decoded fingerprint.Fingerprint
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *fp, decoded)
+
+	assert.Error(t, decoded.UnmarshalBinary(make([]byte, 9)))
+}
+
+func TestFingerprint_ReencodedDuplicates(t *testing.T) {
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	ctx := context.Background()
+
+	// Given: An original clip, a low quality 480p re-encode of the same
+	// content at another frame rate, and an unrelated clip
+	original, err := fixture.Generate(ctx, outputPath, fixture.Video{Name: "original.mp4", Duration: 20, Width: 1280, Height: 720})
+	require.NoError(t, err)
+	reencoded, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name: "reencoded.mp4", Duration: 20, Width: 1280, Height: 720,
+		VideoFilter: "scale=854:480,fps=30",
+		OutputArgs:  []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "36", "-pix_fmt", "yuv420p"},
+	})
+	require.NoError(t, err)
+	other, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name: "other.mp4", Duration: 20, Width: 1280, Height: 720,
+		Source: "testsrc", VideoFilter: "hue=H=2*PI*t/5",
+	})
+	require.NoError(t, err)
+
+	// When: Fingerprinting all three
+	opts := fingerprint.Options{Interval: 1}
+	fpOriginal, err := fingerprint.Compute(ctx, original, opts)
+	require.NoError(t, err)
+	fpReencoded, err := fingerprint.Compute(ctx, reencoded, opts)
+	require.NoError(t, err)
+	fpOther, err := fingerprint.Compute(ctx, other, opts)
+	require.NoError(t, err)
+
+	// Then: Every sampled second is hashed
+	assert.InDelta(t, 20, len(fpOriginal.Hashes), 1)
+
+	// Then: The re-encode is a duplicate and the other clip is not
+	t.Logf("similarity: reencoded %.3f, other %.3f",
+		fingerprint.Similarity(fpOriginal, fpReencoded), fingerprint.Similarity(fpOriginal, fpOther))
+	assert.True(t, fingerprint.IsDuplicate(fpOriginal, fpReencoded))
+	assert.False(t, fingerprint.IsDuplicate(fpOriginal, fpOther))
+}
+
+func TestCompute_ReadsFramesFromPipe(t *testing.T) {
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	ctx := context.Background()
+
+	// Given: A moving test pattern
+	input, err := fixture.Generate(ctx, outputPath, fixture.Video{Name: "pattern.mp4", Duration: 10})
+	require.NoError(t, err)
+
+	// When: Fingerprinting five frames, which the thumbnail image writes to
+	// its standard output through the pipe protocol
+	fp, err := fingerprint.Compute(ctx, input, fingerprint.Options{Interval: 2, MaxFrames: 5})
+	require.NoError(t, err)
+
+	// Then: Exactly five decoded frames were hashed
+	require.Len(t, fp.Hashes, 5)
+	for i, h := range fp.Hashes {
+		assert.NotZero(t, h, "frame %d", i)
+	}
+}
+
+// Helper functions
+func createTempDir(t *testing.T) string {
+	outputPath, err := filepath.Abs(filepath.Join("..", "..", "testdata", strings.ReplaceAll(uuid.NewString(), "-", "")))
+	require.NoError(t, err)
+
+	err = os.MkdirAll(outputPath, 0755)
+	require.NoError(t, err)
+
+	return outputPath
+}
+
+func cleanupFiles(t *testing.T, path string) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		t.Logf("failed to remove output directory: %s", path)
+	}
+}
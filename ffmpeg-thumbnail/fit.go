@@ -0,0 +1,184 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// Fit is how a frame is sized into a bounding box.
+type Fit string
+
+const (
+	// FitContain scales the frame to fit inside the box, keeping its
+	// display aspect ratio; one side may come out smaller than the box.
+	FitContain Fit = "contain"
+	// FitCover scales the frame to cover the box and crops the overflow
+	// around the centre.
+	FitCover Fit = "cover"
+	// FitPad scales like FitContain and pads to the box with black bars.
+	FitPad Fit = "pad"
+	// FitExact scales to the box, distorting the frame if the aspect ratios
+	// differ.
+	FitExact Fit = "exact"
+)
+
+// Geometry is a video frame as FFmpeg's filters see it: after automatic
+// rotation, with Width and Height in stored pixels of SAR aspect.
+type Geometry struct {
+	Width  int
+	Height int
+	SAR    float64
+}
+
+// StreamGeometry derives the filter geometry of a probed video stream.
+// FFmpeg rotates frames by the display matrix before user filters run, and
+// a quarter turn swaps the sides and inverts the sample aspect ratio.
+func StreamGeometry(s *ffprobe.Stream) Geometry {
+	g := Geometry{Width: s.Width, Height: s.Height, SAR: s.SAR()}
+	if s.Rotation()%180 != 0 {
+		g.Width, g.Height = g.Height, g.Width
+		g.SAR = 1 / g.SAR
+	}
+	return g
+}
+
+// Crop is a crop rectangle in filter geometry pixels.
+type Crop struct {
+	W, H, X, Y int
+}
+
+func (c Crop) filter() string {
+	return fmt.Sprintf("crop=%d:%d:%d:%d", c.W, c.H, c.X, c.Y)
+}
+
+// FitFilter returns the filter chain that crops the frame to crop, when not
+// nil, and sizes it into the boxW x boxH box with square pixels, along with
+// the output size. A zero side follows the display aspect ratio; when both
+// are zero the frame keeps its display size. The zero Fit is FitContain.
+func FitFilter(g Geometry, crop *Crop, boxW, boxH int, fit Fit) (filter string, width, height int, err error) {
+	if g.Width <= 0 || g.Height <= 0 {
+		return "", 0, 0, errors.New("fit: frame size is required")
+	}
+	if boxW < 0 || boxH < 0 {
+		return "", 0, 0, fmt.Errorf("fit: invalid box %dx%d", boxW, boxH)
+	}
+	if g.SAR <= 0 {
+		g.SAR = 1
+	}
+	srcW, srcH := g.Width, g.Height
+	var chain string
+	if crop != nil {
+		srcW, srcH = crop.W, crop.H
+		chain = crop.filter() + ","
+	}
+	dar := float64(srcW) * g.SAR / float64(srcH)
+
+	switch {
+	case boxW == 0 && boxH == 0:
+		fit = FitExact
+		boxW, boxH = even(float64(srcH)*dar), srcH
+	case boxW == 0:
+		fit = FitExact
+		boxW = even(float64(boxH) * dar)
+	case boxH == 0:
+		fit = FitExact
+		boxH = even(float64(boxW) / dar)
+	}
+
+	switch fit {
+	case FitExact:
+		return fmt.Sprintf("%sscale=%d:%d,setsar=1", chain, boxW, boxH), boxW, boxH, nil
+	case "", FitContain, FitPad:
+		w, h := boxW, boxH
+		if dar > float64(boxW)/float64(boxH) {
+			h = min(even(float64(boxW)/dar), boxH)
+		} else {
+			w = min(even(float64(boxH)*dar), boxW)
+		}
+		chain += fmt.Sprintf("scale=%d:%d,setsar=1", w, h)
+		if fit != FitPad {
+			return chain, w, h, nil
+		}
+		return fmt.Sprintf("%s,pad=%d:%d:%d:%d:black", chain, boxW, boxH, (boxW-w)/2, (boxH-h)/2), boxW, boxH, nil
+	case FitCover:
+		w, h := boxW, boxH
+		if dar > float64(boxW)/float64(boxH) {
+			w = max(even(float64(boxH)*dar), boxW)
+		} else {
+			h = max(even(float64(boxW)/dar), boxH)
+		}
+		return fmt.Sprintf("%sscale=%d:%d,setsar=1,crop=%d:%d:%d:%d", chain, w, h, boxW, boxH, (w-boxW)/2, (h-boxH)/2),
+			boxW, boxH, nil
+	}
+	return "", 0, 0, fmt.Errorf("fit: unsupported mode %q", fit)
+}
+
+// even rounds to the nearest even size of at least 2, which chroma
+// subsampled encoders require.
+func even(v float64) int {
+	return max(2, int(math.Round(v/2))*2)
+}
+
+var cropDetectLine = regexp.MustCompile(`crop=(\d+):(\d+):(\d+):(\d+)`)
+
+// ParseCropDetect returns the last crop reported by the cropdetect filter in
+// an FFmpeg log.
+func ParseCropDetect(log string) (Crop, bool) {
+	matches := cropDetectLine.FindAllStringSubmatch(log, -1)
+	if len(matches) == 0 {
+		return Crop{}, false
+	}
+	m := matches[len(matches)-1]
+	var v [4]int
+	for i := range v {
+		v[i], _ = strconv.Atoi(m[i+1])
+	}
+	return Crop{W: v[0], H: v[1], X: v[2], Y: v[3]}, true
+}
+
+// cropDetectFrames is the number of frames cropdetect inspects; the
+// detected area only grows, so a few frames of content are enough.
+const cropDetectFrames = 10
+
+// DetectCrop runs cropdetect over the frames from at seconds of the video at
+// input with image, and returns the area inside any black bars. It returns
+// nil when the frame of geometry g has no bars.
+func DetectCrop(ctx context.Context, input, image string, at float64, g Geometry) (*Crop, error) {
+	containerInput := "/input/" + filepath.Base(input)
+	// The frames themselves are not needed; shrink them and drop the
+	// stream so only the log matters.
+	res, err := runner.Run(ctx, runner.Request{
+		Image: image,
+		Cmd: []string{
+			"-ss", formatFloat(at),
+			"-i", containerInput,
+			"-vf", "cropdetect=limit=24:round=2:reset=0,scale=16:16",
+			"-frames:v", strconv.Itoa(cropDetectFrames),
+			"-c:v", "mjpeg",
+			"-f", "image2pipe", "pipe:1",
+		},
+		Files:  []testcontainers.ContainerFile{runner.File(input, containerInput)},
+		Stdout: io.Discard,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cropdetect: %w", err)
+	}
+	crop, ok := ParseCropDetect(res.Stderr)
+	if !ok {
+		return nil, errors.New("cropdetect: no crop reported")
+	}
+	if crop.W <= 0 || crop.H <= 0 || (crop.W >= g.Width && crop.H >= g.Height) {
+		return nil, nil
+	}
+	return &crop, nil
+}
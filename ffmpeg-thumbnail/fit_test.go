@@ -0,0 +1,185 @@
+package thumbnail_test
+
+import (
+	"context"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+func TestFitFilter(t *testing.T) {
+	hd := thumbnail.Geometry{Width: 1920, Height: 1080, SAR: 1}
+	tests := []struct {
+		name       string
+		g          thumbnail.Geometry
+		crop       *thumbnail.Crop
+		boxW, boxH int
+		fit        thumbnail.Fit
+		filter     string
+		w, h       int
+	}{
+		{"contain", hd, nil, 320, 320, thumbnail.FitContain, "scale=320:180,setsar=1", 320, 180},
+		{"default is contain", hd, nil, 320, 320, "", "scale=320:180,setsar=1", 320, 180},
+		{"pad", hd, nil, 320, 320, thumbnail.FitPad, "scale=320:180,setsar=1,pad=320:320:0:70:black", 320, 320},
+		{"cover", hd, nil, 320, 320, thumbnail.FitCover, "scale=568:320,setsar=1,crop=320:320:124:0", 320, 320},
+		{"exact", hd, nil, 320, 320, thumbnail.FitExact, "scale=320:320,setsar=1", 320, 320},
+		{"4:3 into 16:9", thumbnail.Geometry{Width: 640, Height: 480, SAR: 1}, nil, 320, 180, thumbnail.FitContain,
+			"scale=240:180,setsar=1", 240, 180},
+		{"anamorphic NTSC", thumbnail.Geometry{Width: 720, Height: 480, SAR: 32.0 / 27}, nil, 320, 0, thumbnail.FitContain,
+			"scale=320:180,setsar=1", 320, 180},
+		{"portrait", thumbnail.Geometry{Width: 1080, Height: 1920, SAR: 1}, nil, 320, 320, thumbnail.FitContain,
+			"scale=180:320,setsar=1", 180, 320},
+		{"letterbox cropped", thumbnail.Geometry{Width: 640, Height: 360, SAR: 1}, &thumbnail.Crop{W: 640, H: 270, X: 0, Y: 45},
+			320, 0, thumbnail.FitContain, "crop=640:270:0:45,scale=320:136,setsar=1", 320, 136},
+		{"display size", thumbnail.Geometry{Width: 720, Height: 576, SAR: 16.0 / 15}, nil, 0, 0, thumbnail.FitContain,
+			"scale=768:576,setsar=1", 768, 576},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, w, h, err := thumbnail.FitFilter(tt.g, tt.crop, tt.boxW, tt.boxH, tt.fit)
+			require.NoError(t, err)
+			assert.Equal(t, tt.filter, filter)
+			assert.Equal(t, tt.w, w)
+			assert.Equal(t, tt.h, h)
+		})
+	}
+
+	_, _, _, err := thumbnail.FitFilter(hd, nil, 320, 180, "stretch")
+	assert.Error(t, err)
+}
+
+func TestStreamGeometry(t *testing.T) {
+	// Given: A phone recording stored landscape with a quarter turn
+	s := &ffprobe.Stream{
+		Width: 1920, Height: 1080, SampleAspectRatio: "1:1",
+		SideDataList: []ffprobe.SideData{{SideDataType: "Display Matrix", Rotation: -90}},
+	}
+
+	// Then: Filters see it portrait
+	assert.Equal(t, thumbnail.Geometry{Width: 1080, Height: 1920, SAR: 1}, thumbnail.StreamGeometry(s))
+}
+
+func TestParseCropDetect(t *testing.T) {
+	log := "[Parsed_cropdetect_0 @ 0x1] x1:0 x2:639 y1:46 y2:313 w:640 h:266 x:0 y:48 pts:0 t:0.000000 limit:0.094118 crop=640:266:0:48\n" +
+		"[Parsed_cropdetect_0 @ 0x1] x1:0 x2:639 y1:45 y2:314 w:640 h:270 x:0 y:45 pts:512 t:0.040000 limit:0.094118 crop=640:270:0:45\n"
+
+	crop, ok := thumbnail.ParseCropDetect(log)
+	require.True(t, ok)
+	assert.Equal(t, thumbnail.Crop{W: 640, H: 270, X: 0, Y: 45}, crop)
+
+	_, ok = thumbnail.ParseCropDetect("no crop here")
+	assert.False(t, ok)
+}
+
+func TestThumbnail_Fit_AspectRatios(t *testing.T) {
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	ctx := context.Background()
+
+	// Given: A 4:3 clip, an anamorphic 16:9 NTSC clip and a phone clip
+	// stored landscape with a quarter turn display matrix
+	standard, err := fixture.Generate(ctx, outputPath, fixture.Video{Name: "standard.mp4", Duration: 3, Width: 640, Height: 480})
+	require.NoError(t, err)
+	anamorphic, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name: "anamorphic.mp4", Duration: 3, Width: 720, Height: 480, VideoFilter: "setsar=32/27",
+	})
+	require.NoError(t, err)
+	_, err = fixture.Generate(ctx, outputPath, fixture.Video{Name: "landscape.mp4", Duration: 3})
+	require.NoError(t, err)
+	_, err = runner.Run(ctx, runner.Request{
+		Image:  fixture.Image,
+		Cmd:    []string{"-display_rotation", "90", "-i", "/output/landscape.mp4", "-c", "copy", "/output/rotated.mp4"},
+		Mounts: []mount.Mount{runner.Bind(outputPath, "/output")},
+	})
+	require.NoError(t, err)
+	rotated := filepath.Join(outputPath, "rotated.mp4")
+
+	tests := []struct {
+		name  string
+		input string
+		opts  thumbnail.ExtractOptions
+		w, h  int
+	}{
+		{"standard_contain", standard, thumbnail.ExtractOptions{Width: 320, Height: 180}, 240, 180},
+		{"standard_pad", standard, thumbnail.ExtractOptions{Width: 320, Height: 180, Fit: thumbnail.FitPad}, 320, 180},
+		{"standard_cover", standard, thumbnail.ExtractOptions{Width: 160, Height: 160, Fit: thumbnail.FitCover}, 160, 160},
+		{"anamorphic", anamorphic, thumbnail.ExtractOptions{Width: 320}, 320, 180},
+		{"rotated", rotated, thumbnail.ExtractOptions{Width: 320, Height: 320}, 180, 320},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// When: Extracting a PNG thumbnail into the box
+			opts := tt.opts
+			opts.OutputDir = outputPath
+			opts.Name = tt.name
+			opts.Time = 1
+			opts.Format = thumbnail.FormatPNG
+			path, err := thumbnail.Extract(ctx, tt.input, opts)
+			require.NoError(t, err)
+
+			// Then: The image has the display aspect ratio of the source
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			defer f.Close()
+			img, err := png.Decode(f)
+			require.NoError(t, err)
+			assert.Equal(t, tt.w, img.Bounds().Dx())
+			assert.Equal(t, tt.h, img.Bounds().Dy())
+
+			// Then: Padding is black
+			if opts.Fit == thumbnail.FitPad {
+				r, g, b, _ := img.At(0, img.Bounds().Dy()/2).RGBA()
+				assert.Less(t, r+g+b, uint32(3*0x1000))
+			}
+		})
+	}
+}
+
+func TestThumbnail_Fit_CropBlackBars(t *testing.T) {
+	// Given: A 16:9 frame carrying a 2.37:1 picture between black bars
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	clip, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:        "letterbox.mp4",
+		Duration:    3,
+		VideoFilter: "scale=640:270,pad=640:360:0:45:black",
+	})
+	require.NoError(t, err)
+
+	// When: Extracting a 320 wide thumbnail with and without bar removal
+	plain, err := thumbnail.Extract(ctx, clip, thumbnail.ExtractOptions{
+		OutputDir: outputPath, Name: "plain", Time: 1, Width: 320, Format: thumbnail.FormatPNG,
+	})
+	require.NoError(t, err)
+	cropped, err := thumbnail.Extract(ctx, clip, thumbnail.ExtractOptions{
+		OutputDir: outputPath, Name: "cropped", Time: 1, Width: 320, Format: thumbnail.FormatPNG, CropBlackBars: true,
+	})
+	require.NoError(t, err)
+
+	// Then: Only the cropped thumbnail drops the bars
+	f, err := os.Open(plain)
+	require.NoError(t, err)
+	cfg, err := png.DecodeConfig(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, 180, cfg.Height)
+
+	f, err = os.Open(cropped)
+	require.NoError(t, err)
+	cfg, err = png.DecodeConfig(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, 320, cfg.Width)
+	assert.InDelta(t, 136, cfg.Height, 4)
+}
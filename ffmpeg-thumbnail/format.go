@@ -0,0 +1,172 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// WebImage is the thumbnail variant that adds the WebP and AVIF encoders.
+const WebImage = "ghcr.io/veloxpack/ffmpeg:8.0-thumbnail-web"
+
+// Format is a thumbnail output format.
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+	FormatWebP Format = "webp"
+	FormatAVIF Format = "avif"
+)
+
+// Extension returns the file extension of the format, including the dot.
+// The zero Format is JPEG.
+func (f Format) Extension() string {
+	switch f {
+	case "", FormatJPEG:
+		return ".jpg"
+	default:
+		return "." + string(f)
+	}
+}
+
+// MimeType returns the media type of the format.
+func (f Format) MimeType() string {
+	if f == "" {
+		f = FormatJPEG
+	}
+	return "image/" + string(f)
+}
+
+// Image returns the FFmpeg image able to encode the format: the thumbnail
+// image for JPEG and PNG, WebImage for WebP and AVIF.
+func (f Format) Image() string {
+	if f == FormatWebP || f == FormatAVIF {
+		return WebImage
+	}
+	return Image
+}
+
+// Validate reports unknown formats.
+func (f Format) Validate() error {
+	switch f {
+	case "", FormatJPEG, FormatPNG, FormatWebP, FormatAVIF:
+		return nil
+	}
+	return fmt.Errorf("unsupported thumbnail format %q", f)
+}
+
+// EncoderArgs returns the FFmpeg output arguments for the format at a
+// quality from 1 to 100, where 0 means the default of 80. PNG is lossless
+// and ignores the quality.
+func (f Format) EncoderArgs(quality int) []string {
+	if quality <= 0 {
+		quality = 80
+	}
+	quality = min(quality, 100)
+	switch f {
+	case FormatPNG:
+		return []string{"-c:v", "png"}
+	case FormatWebP:
+		return []string{"-c:v", "libwebp", "-quality", strconv.Itoa(quality), "-compression_level", "4"}
+	case FormatAVIF:
+		// SVT-AV1 CRF runs from 0 (best) to 63; quality 80 maps to CRF 23.
+		crf := 63 - quality/2
+		return []string{"-c:v", "libsvtav1", "-crf", strconv.Itoa(crf), "-preset", "8", "-pix_fmt", "yuv420p", "-f", "avif"}
+	default:
+		// The JPEG qscale runs from 2 (best) to 31.
+		q := 2 + (100-quality)*29/100
+		return []string{"-c:v", "mjpeg", "-q:v", strconv.Itoa(q)}
+	}
+}
+
+// ExtractOptions configures a single thumbnail.
+type ExtractOptions struct {
+	// OutputDir is the host directory the thumbnail is written to.
+	OutputDir string
+	// Name is the file name without extension; defaults to "thumbnail".
+	Name string
+	// Time is the timestamp of the frame in seconds.
+	Time float64
+	// Width and Height bound the thumbnail. When one is zero it follows the
+	// display aspect ratio; when both are zero the frame keeps its size.
+	Width  int
+	Height int
+	// Fit sizes the frame into the Width x Height box; defaults to
+	// FitContain. Sample aspect ratio and rotation are taken into account.
+	Fit Fit
+	// CropBlackBars removes letterbox and pillarbox bars found by
+	// cropdetect before sizing.
+	CropBlackBars bool
+	// Format defaults to JPEG.
+	Format Format
+	// Quality from 1 to 100; defaults to 80.
+	Quality int
+}
+
+// Extract writes the frame at opts.Time of the video at input as a single
+// image and returns its host path.
+func Extract(ctx context.Context, input string, opts ExtractOptions) (string, error) {
+	if opts.OutputDir == "" {
+		return "", errors.New("extract: output directory is required")
+	}
+	if err := opts.Format.Validate(); err != nil {
+		return "", fmt.Errorf("extract: %w", err)
+	}
+	if opts.Name == "" {
+		opts.Name = "thumbnail"
+	}
+	name := opts.Name + opts.Format.Extension()
+
+	containerInput := "/input/" + filepath.Base(input)
+	cmd := []string{"-ss", formatFloat(opts.Time), "-i", containerInput, "-frames:v", "1"}
+	if opts.Width != 0 || opts.Height != 0 || opts.CropBlackBars {
+		filter, err := extractFilter(ctx, input, opts)
+		if err != nil {
+			return "", fmt.Errorf("extract: %w", err)
+		}
+		cmd = append(cmd, "-vf", filter)
+	}
+	cmd = append(cmd, opts.Format.EncoderArgs(opts.Quality)...)
+	cmd = append(cmd, "/output/"+name)
+
+	_, err := runner.Run(ctx, runner.Request{
+		Image:  opts.Format.Image(),
+		Cmd:    cmd,
+		Files:  []testcontainers.ContainerFile{runner.File(input, containerInput)},
+		Mounts: []mount.Mount{runner.Bind(opts.OutputDir, "/output")},
+	})
+	if err != nil {
+		return "", fmt.Errorf("extract: %w", err)
+	}
+	return filepath.Join(opts.OutputDir, name), nil
+}
+
+// extractFilter probes the video so the frame is sized by its display
+// geometry rather than its stored pixels.
+func extractFilter(ctx context.Context, input string, opts ExtractOptions) (string, error) {
+	probe, err := ffprobe.Probe(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	video := probe.VideoStream()
+	if video == nil {
+		return "", fmt.Errorf("%s has no video stream", filepath.Base(input))
+	}
+	g := StreamGeometry(video)
+	var crop *Crop
+	if opts.CropBlackBars {
+		if crop, err = DetectCrop(ctx, input, opts.Format.Image(), opts.Time, g); err != nil {
+			return "", err
+		}
+	}
+	filter, _, _, err := FitFilter(g, crop, opts.Width, opts.Height, opts.Fit)
+	return filter, err
+}
@@ -0,0 +1,89 @@
+package thumbnail_test
+
+import (
+	"context"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+)
+
+func TestFormat_EncoderArgs(t *testing.T) {
+	tests := []struct {
+		format  thumbnail.Format
+		quality int
+		ext     string
+		image   string
+		args    []string
+	}{
+		{"", 0, ".jpg", thumbnail.Image, []string{"-c:v", "mjpeg", "-q:v", "7"}},
+		{thumbnail.FormatJPEG, 100, ".jpg", thumbnail.Image, []string{"-c:v", "mjpeg", "-q:v", "2"}},
+		{thumbnail.FormatPNG, 50, ".png", thumbnail.Image, []string{"-c:v", "png"}},
+		{thumbnail.FormatWebP, 75, ".webp", thumbnail.WebImage,
+			[]string{"-c:v", "libwebp", "-quality", "75", "-compression_level", "4"}},
+		{thumbnail.FormatAVIF, 0, ".avif", thumbnail.WebImage,
+			[]string{"-c:v", "libsvtav1", "-crf", "23", "-preset", "8", "-pix_fmt", "yuv420p", "-f", "avif"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			assert.Equal(t, tt.ext, tt.format.Extension())
+			assert.Equal(t, tt.image, tt.format.Image())
+			assert.Equal(t, tt.args, tt.format.EncoderArgs(tt.quality))
+		})
+	}
+	assert.Error(t, thumbnail.Format("gif").Validate())
+}
+
+func TestPlanStoryboard_Format(t *testing.T) {
+	// Given: WebP sprite sheets
+	sb, err := thumbnail.PlanStoryboard(30, 1280, 720, thumbnail.StoryboardOptions{Interval: 10, Format: thumbnail.FormatWebP})
+	require.NoError(t, err)
+
+	// Then: Sheets carry the format's extension
+	assert.Equal(t, []string{"sprite_001.webp"}, sb.Sheets)
+
+	// Then: AVIF sheets are refused since the muxer writes a single image
+	_, err = thumbnail.PlanStoryboard(30, 1280, 720, thumbnail.StoryboardOptions{Format: thumbnail.FormatAVIF})
+	assert.Error(t, err)
+}
+
+func TestThumbnail_Extract_JPEGAndPNG(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Extracting a 320 wide JPEG and a 240x135 PNG
+	ctx := context.Background()
+	jpg, err := thumbnail.Extract(ctx, absPath, thumbnail.ExtractOptions{OutputDir: outputPath, Time: 5, Width: 320})
+	require.NoError(t, err)
+	pngPath, err := thumbnail.Extract(ctx, absPath, thumbnail.ExtractOptions{
+		OutputDir: outputPath, Name: "small", Time: 5, Width: 240, Height: 135, Format: thumbnail.FormatPNG,
+	})
+	require.NoError(t, err)
+
+	// Then: Both files decode at the requested size
+	assert.Equal(t, "thumbnail.jpg", filepath.Base(jpg))
+	f, err := os.Open(jpg)
+	require.NoError(t, err)
+	cfg, err := jpeg.DecodeConfig(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, 320, cfg.Width)
+
+	assert.Equal(t, "small.png", filepath.Base(pngPath))
+	f, err = os.Open(pngPath)
+	require.NoError(t, err)
+	cfg, err = png.DecodeConfig(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, 240, cfg.Width)
+	assert.Equal(t, 135, cfg.Height)
+}
@@ -0,0 +1,300 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// PosterOptions configures poster frame selection. Zero values pick
+// defaults.
+type PosterOptions struct {
+	// OutputDir receives the candidate frames, candidate_001.png and on.
+	// Candidates left there by an earlier run are removed.
+	OutputDir string
+	// Candidates is the number of frames sampled evenly across the video;
+	// defaults to 40.
+	Candidates int
+	// TopK is the number of frames returned; defaults to 3.
+	TopK int
+	// Skip is the fraction of the duration, below 0.5, ignored at both the
+	// start and the end, where logos and credits live; defaults to 0.05.
+	// Use a negative value to sample the whole video.
+	Skip float64
+	// Width of the analysed frames; defaults to 320.
+	Width int
+	// BlackLevel is the mean luma, 0 to 1, below which a frame counts as
+	// black; defaults to 0.08. Frames above 1-BlackLevel count as white.
+	BlackLevel float64
+	// MinContrast is the luma standard deviation, 0 to 1, below which a
+	// frame counts as flat, as in the middle of a fade; defaults to 0.04.
+	MinContrast float64
+}
+
+func (o PosterOptions) withDefaults() PosterOptions {
+	if o.Candidates == 0 {
+		o.Candidates = 40
+	}
+	if o.TopK == 0 {
+		o.TopK = 3
+	}
+	if o.Skip == 0 {
+		o.Skip = 0.05
+	} else if o.Skip < 0 {
+		o.Skip = 0
+	}
+	if o.Width == 0 {
+		o.Width = 320
+	}
+	if o.BlackLevel == 0 {
+		o.BlackLevel = 0.08
+	}
+	if o.MinContrast == 0 {
+		o.MinContrast = 0.04
+	}
+	return o
+}
+
+// validate checks options that have had their defaults applied.
+func (o PosterOptions) validate() error {
+	if o.Candidates < 0 || o.TopK < 0 {
+		return errors.New("poster: candidates and top k cannot be negative")
+	}
+	if o.Skip >= 0.5 {
+		return fmt.Errorf("poster: skip %v leaves nothing to sample", o.Skip)
+	}
+	if o.Width < 0 {
+		return errors.New("poster: width cannot be negative")
+	}
+	return nil
+}
+
+// Score rates a frame as a poster candidate. All values are in 0..1.
+type Score struct {
+	// Brightness is the mean luma.
+	Brightness float64 `json:"brightness"`
+	// Contrast is the luma standard deviation, doubled so a half black,
+	// half white frame scores 1.
+	Contrast float64 `json:"contrast"`
+	// Entropy is the luma histogram entropy divided by its 8 bit maximum.
+	Entropy float64 `json:"entropy"`
+	// Sharpness maps the variance of the Laplacian v to v/(v+100), so
+	// blurred frames score low.
+	Sharpness float64 `json:"sharpness"`
+	// Rejected is set for black, white and flat frames, which never win.
+	Rejected bool `json:"rejected"`
+	// Total is the weighted score used for ranking.
+	Total float64 `json:"total"`
+}
+
+// Frame is a scored candidate frame.
+type Frame struct {
+	// Time is the timestamp of the frame in seconds.
+	Time float64 `json:"time"`
+	// Path is the host path of the analysed candidate image.
+	Path  string `json:"path"`
+	Score Score  `json:"score"`
+}
+
+// SelectPosters samples candidate frames evenly across the video at input,
+// scores them and returns the best TopK frames, highest score first. Black,
+// white and flat frames are never returned, so fewer than TopK frames come
+// back when the video has too few usable frames.
+func SelectPosters(ctx context.Context, input string, opts PosterOptions) ([]Frame, error) {
+	candidates, err := SampleFrames(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	return TopFrames(candidates, opts.withDefaults().TopK), nil
+}
+
+// SampleFrames extracts and scores the candidate frames of SelectPosters
+// in one FFmpeg run.
+func SampleFrames(ctx context.Context, input string, opts PosterOptions) ([]Frame, error) {
+	if opts.OutputDir == "" {
+		return nil, errors.New("poster: output directory is required")
+	}
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	probe, err := ffprobe.Probe(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("poster: %w", err)
+	}
+	duration := probe.Duration()
+	if duration <= 0 {
+		return nil, fmt.Errorf("poster: %s has no duration", filepath.Base(input))
+	}
+	start := duration * opts.Skip
+	span := duration - 2*start
+	step := span / float64(opts.Candidates)
+
+	// Candidates from an earlier run would be read back as this run's.
+	stale, err := filepath.Glob(filepath.Join(opts.OutputDir, "candidate_*.png"))
+	if err != nil {
+		return nil, fmt.Errorf("poster: %w", err)
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("poster: %w", err)
+		}
+	}
+
+	// Sample the middle of each step so the first candidate is not the
+	// frame right at the cut.
+	containerInput := "/input/" + filepath.Base(input)
+	_, err = runner.Run(ctx, runner.Request{
+		Image: Image,
+		Cmd: []string{
+			"-ss", formatFloat(start + step/2),
+			"-i", containerInput,
+			"-vf", fmt.Sprintf("fps=1/%s,scale=%d:-2", formatFloat(step), opts.Width),
+			"-frames:v", strconv.Itoa(opts.Candidates),
+			"/output/candidate_%03d.png",
+		},
+		Files:  []testcontainers.ContainerFile{runner.File(input, containerInput)},
+		Mounts: []mount.Mount{runner.Bind(opts.OutputDir, "/output")},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("poster: %w", err)
+	}
+
+	var frames []Frame
+	for i := 0; i < opts.Candidates; i++ {
+		path := filepath.Join(opts.OutputDir, fmt.Sprintf("candidate_%03d.png", i+1))
+		img, err := readPNG(path)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("poster: %w", err)
+		}
+		frames = append(frames, Frame{
+			Time:  start + step/2 + float64(i)*step,
+			Path:  path,
+			Score: ScoreImage(img, opts),
+		})
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("poster: no frames were extracted")
+	}
+	return frames, nil
+}
+
+// TopFrames returns up to k frames that were not rejected, best first. A
+// negative k returns none.
+func TopFrames(frames []Frame, k int) []Frame {
+	k = max(k, 0)
+	var usable []Frame
+	for _, f := range frames {
+		if !f.Score.Rejected {
+			usable = append(usable, f)
+		}
+	}
+	sort.SliceStable(usable, func(i, j int) bool {
+		return usable[i].Score.Total > usable[j].Score.Total
+	})
+	if len(usable) > k {
+		usable = usable[:k]
+	}
+	return usable
+}
+
+// ScoreImage scores one frame. Only opts.BlackLevel and opts.MinContrast are
+// used; zero values take the defaults.
+func ScoreImage(img image.Image, opts PosterOptions) Score {
+	opts = opts.withDefaults()
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return Score{Rejected: true}
+	}
+
+	luma := make([]float64, w*h)
+	var hist [256]int
+	var sum float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			l := color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			luma[y*w+x] = float64(l)
+			hist[l]++
+			sum += float64(l)
+		}
+	}
+	n := float64(w * h)
+	mean := sum / n
+
+	var variance float64
+	for _, l := range luma {
+		variance += (l - mean) * (l - mean)
+	}
+	stddev := math.Sqrt(variance/n) / 255
+
+	var entropy float64
+	for _, c := range hist {
+		if c > 0 {
+			p := float64(c) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+
+	// Variance of the 4-neighbour Laplacian over the interior pixels.
+	var lapSum, lapSq float64
+	var lapN int
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			v := luma[i-w] + luma[i+w] + luma[i-1] + luma[i+1] - 4*luma[i]
+			lapSum += v
+			lapSq += v * v
+			lapN++
+		}
+	}
+	var lapVar float64
+	if lapN > 0 {
+		m := lapSum / float64(lapN)
+		lapVar = lapSq/float64(lapN) - m*m
+	}
+
+	s := Score{
+		Brightness: mean / 255,
+		Contrast:   math.Min(2*stddev, 1),
+		Entropy:    entropy / 8,
+		Sharpness:  lapVar / (lapVar + 100),
+	}
+	s.Rejected = s.Brightness < opts.BlackLevel || s.Brightness > 1-opts.BlackLevel || stddev < opts.MinContrast
+
+	// Exposure peaks at mid grey and falls off linearly towards black and
+	// white.
+	exposure := 1 - math.Abs(s.Brightness-0.5)*2
+	s.Total = 0.2*exposure + 0.25*s.Contrast + 0.25*s.Entropy + 0.3*s.Sharpness
+	return s
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
@@ -0,0 +1,179 @@
+package thumbnail_test
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+func grayImage(w, h int, at func(x, y int) uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{Y: at(x, y)})
+		}
+	}
+	return img
+}
+
+func TestScoreImage(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	noise := make([]uint8, 64*64)
+	for i := range noise {
+		noise[i] = uint8(40 + rng.Intn(176))
+	}
+
+	// Given: Black, fading, detailed and blurred frames
+	black := grayImage(64, 64, func(x, y int) uint8 { return 4 })
+	fade := grayImage(64, 64, func(x, y int) uint8 { return uint8(60 + x%3) })
+	detailed := grayImage(64, 64, func(x, y int) uint8 { return noise[y*64+x] })
+	blurred := grayImage(64, 64, func(x, y int) uint8 {
+		var sum int
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				sum += int(noise[min(max(y+dy, 0), 63)*64+min(max(x+dx, 0), 63)])
+			}
+		}
+		return uint8(sum / 9)
+	})
+
+	// When: Scoring them
+	opts := thumbnail.PosterOptions{}
+	blackScore := thumbnail.ScoreImage(black, opts)
+	fadeScore := thumbnail.ScoreImage(fade, opts)
+	detailedScore := thumbnail.ScoreImage(detailed, opts)
+	blurredScore := thumbnail.ScoreImage(blurred, opts)
+
+	// Then: Black and flat frames are rejected
+	assert.True(t, blackScore.Rejected)
+	assert.Less(t, blackScore.Brightness, 0.05)
+	assert.True(t, fadeScore.Rejected)
+
+	// Then: Detail beats blur on sharpness, entropy and total
+	assert.False(t, detailedScore.Rejected)
+	assert.False(t, blurredScore.Rejected)
+	assert.Greater(t, detailedScore.Sharpness, blurredScore.Sharpness)
+	assert.Greater(t, detailedScore.Entropy, blurredScore.Entropy)
+	assert.Greater(t, detailedScore.Total, blurredScore.Total)
+}
+
+func TestTopFrames(t *testing.T) {
+	frames := []thumbnail.Frame{
+		{Time: 1, Score: thumbnail.Score{Total: 0.9, Rejected: true}},
+		{Time: 2, Score: thumbnail.Score{Total: 0.4}},
+		{Time: 3, Score: thumbnail.Score{Total: 0.7}},
+		{Time: 4, Score: thumbnail.Score{Total: 0.5}},
+	}
+
+	top := thumbnail.TopFrames(frames, 2)
+	require.Len(t, top, 2)
+	assert.Equal(t, 3.0, top[0].Time)
+	assert.Equal(t, 4.0, top[1].Time)
+	assert.Len(t, thumbnail.TopFrames(frames, 10), 3, "rejected frames are never returned")
+}
+
+func TestSampleFrames_Errors(t *testing.T) {
+	for name, opts := range map[string]thumbnail.PosterOptions{
+		"no output dir":       {},
+		"negative candidates": {OutputDir: "out", Candidates: -1},
+		"negative top k":      {OutputDir: "out", TopK: -3},
+		"skip half":           {OutputDir: "out", Skip: 0.5},
+		"negative width":      {OutputDir: "out", Width: -320},
+	} {
+		_, err := thumbnail.SampleFrames(context.Background(), "video.mp4", opts)
+		assert.ErrorContains(t, err, "poster: ", name)
+		_, err = thumbnail.SelectPosters(context.Background(), "video.mp4", opts)
+		assert.ErrorContains(t, err, "poster: ", name)
+	}
+	assert.Empty(t, thumbnail.TopFrames([]thumbnail.Frame{{Time: 1}}, -1))
+}
+
+func TestThumbnail_Posters_SkipBlackAndFades(t *testing.T) {
+	// Given: A 12 second clip that is black until 3s and after 9s
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	clip, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:        "fades.mp4",
+		Duration:    12,
+		VideoFilter: "fade=t=in:st=3:d=1,fade=t=out:st=8:d=1",
+	})
+	require.NoError(t, err)
+
+	// When: Selecting posters from one candidate per second of the whole clip
+	candidates := filepath.Join(outputPath, "candidates")
+	require.NoError(t, os.MkdirAll(candidates, 0o755))
+	posters, err := thumbnail.SelectPosters(ctx, clip, thumbnail.PosterOptions{
+		OutputDir:  candidates,
+		Candidates: 12,
+		TopK:       3,
+		Skip:       -1,
+	})
+	require.NoError(t, err)
+
+	// Then: Only frames from the fully visible section are chosen
+	require.Len(t, posters, 3)
+	for _, p := range posters {
+		assert.GreaterOrEqual(t, p.Time, 4.0, "poster at %.1fs", p.Time)
+		assert.LessOrEqual(t, p.Time, 8.0, "poster at %.1fs", p.Time)
+		assert.False(t, p.Score.Rejected)
+		verifyFileExists(t, p.Path)
+	}
+	assert.GreaterOrEqual(t, posters[0].Score.Total, posters[2].Score.Total)
+}
+
+func TestThumbnail_Posters_RerunInSameDir(t *testing.T) {
+	// Given: A directory holding 12 candidates from an earlier run
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	_, err = thumbnail.SampleFrames(ctx, absPath, thumbnail.PosterOptions{OutputDir: outputPath, Candidates: 12})
+	require.NoError(t, err)
+
+	// When: Sampling 4 candidates into the same directory
+	frames, err := thumbnail.SampleFrames(ctx, absPath, thumbnail.PosterOptions{OutputDir: outputPath, Candidates: 4})
+	require.NoError(t, err)
+
+	// Then: Only this run's candidates are scored and left behind
+	assert.Len(t, frames, 4)
+	left, err := filepath.Glob(filepath.Join(outputPath, "candidate_*.png"))
+	require.NoError(t, err)
+	assert.Len(t, left, 4)
+}
+
+func TestThumbnail_Posters_Sample(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Selecting the top 5 posters across the video
+	posters, err := thumbnail.SelectPosters(context.Background(), absPath, thumbnail.PosterOptions{
+		OutputDir: outputPath,
+		TopK:      5,
+	})
+	require.NoError(t, err)
+
+	// Then: Five usable frames come back from outside the skipped edges
+	require.Len(t, posters, 5)
+	for _, p := range posters {
+		assert.Greater(t, p.Time, 0.0)
+		assert.Greater(t, p.Score.Brightness, 0.08)
+		assert.Greater(t, p.Score.Sharpness, 0.0)
+	}
+}
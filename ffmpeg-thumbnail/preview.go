@@ -0,0 +1,354 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// LiteImage is the general purpose FFmpeg image, used for MP4 previews and
+// scene scoring.
+const LiteImage = "ghcr.io/veloxpack/ffmpeg:8.0-lite"
+
+// PreviewFormat is the container of an animated preview.
+type PreviewFormat string
+
+const (
+	// PreviewWebP is an animated WebP, rendered by WebImage.
+	PreviewWebP PreviewFormat = "webp"
+	// PreviewGIF is a GIF with a palette generated for the excerpts,
+	// rendered by WebImage.
+	PreviewGIF PreviewFormat = "gif"
+	// PreviewMP4 is a muted H.264 MP4, rendered by LiteImage.
+	PreviewMP4 PreviewFormat = "mp4"
+)
+
+// PreviewOptions configures an animated hover preview. Zero values pick
+// defaults.
+type PreviewOptions struct {
+	// OutputDir is the host directory the preview is written to.
+	OutputDir string
+	// Name is the file name without extension; defaults to "preview".
+	Name string
+	// Format defaults to PreviewWebP.
+	Format PreviewFormat
+	// Clips is the number of excerpts; defaults to 5.
+	Clips int
+	// ClipDuration is the length of each excerpt in seconds; defaults to 1.
+	ClipDuration float64
+	// FPS of the preview; defaults to 10.
+	FPS int
+	// Width, Height and Fit size the preview as in ExtractOptions. Width
+	// defaults to 320 when both are zero.
+	Width  int
+	Height int
+	Fit    Fit
+	// Skip is the fraction of the duration ignored at both ends; defaults
+	// to 0.05. Use a negative value to use the whole video.
+	Skip float64
+	// Scenes starts excerpts at the strongest scene changes instead of
+	// evenly spaced points, falling back to even points when the video has
+	// too few cuts.
+	Scenes bool
+	// SceneThreshold is the minimum scene score of a cut; defaults to 0.3.
+	SceneThreshold float64
+	// Times overrides the excerpt start points.
+	Times []float64
+	// Quality from 1 to 100 for WebP and MP4; defaults to 80.
+	Quality int
+}
+
+func (o PreviewOptions) withDefaults() PreviewOptions {
+	if o.Name == "" {
+		o.Name = "preview"
+	}
+	if o.Format == "" {
+		o.Format = PreviewWebP
+	}
+	if o.Clips == 0 {
+		o.Clips = 5
+	}
+	if o.ClipDuration == 0 {
+		o.ClipDuration = 1
+	}
+	if o.FPS == 0 {
+		o.FPS = 10
+	}
+	if o.Width == 0 && o.Height == 0 {
+		o.Width = 320
+	}
+	if o.Skip == 0 {
+		o.Skip = 0.05
+	} else if o.Skip < 0 {
+		o.Skip = 0
+	}
+	if o.SceneThreshold == 0 {
+		o.SceneThreshold = 0.3
+	}
+	return o
+}
+
+// validate checks options that have had their defaults applied.
+func (o PreviewOptions) validate() error {
+	if o.Clips < 0 || o.ClipDuration < 0 || o.FPS < 0 || o.Quality < 0 {
+		return errors.New("preview: clips, clip duration, fps and quality cannot be negative")
+	}
+	return nil
+}
+
+// Preview describes a generated preview.
+type Preview struct {
+	Path   string
+	Format PreviewFormat
+	// Times are the excerpt start points in seconds.
+	Times []float64
+	// Frames is the planned frame count, FramesPerClip for every excerpt.
+	Frames int
+	Width  int
+	Height int
+}
+
+// GeneratePreview stitches short excerpts of the video at input into one
+// looping animation in a single FFmpeg run: each excerpt is a separately
+// seeked input, trimmed to the same number of frames and concatenated.
+func GeneratePreview(ctx context.Context, input string, opts PreviewOptions) (*Preview, error) {
+	if opts.OutputDir == "" {
+		return nil, errors.New("preview: output directory is required")
+	}
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	probe, err := ffprobe.Probe(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("preview: %w", err)
+	}
+	video := probe.VideoStream()
+	if video == nil {
+		return nil, fmt.Errorf("preview: %s has no video stream", filepath.Base(input))
+	}
+	sizing, width, height, err := FitFilter(StreamGeometry(video), nil, opts.Width, opts.Height, opts.Fit)
+	if err != nil {
+		return nil, fmt.Errorf("preview: %w", err)
+	}
+
+	times := opts.Times
+	if len(times) == 0 {
+		duration := probe.Duration()
+		times = EvenPoints(duration, opts.Clips, opts.ClipDuration, opts.Skip)
+		if opts.Scenes {
+			scenes, err := SceneChanges(ctx, input, opts.SceneThreshold)
+			if err != nil {
+				return nil, fmt.Errorf("preview: %w", err)
+			}
+			times = PickScenePoints(scenes, times, duration, opts.ClipDuration, opts.Skip)
+		}
+	}
+	if len(times) == 0 {
+		return nil, errors.New("preview: video is too short for an excerpt")
+	}
+
+	args, image, err := previewArgs(opts, times, sizing)
+	if err != nil {
+		return nil, err
+	}
+	name := opts.Name + "." + string(opts.Format)
+	containerInput := "/input/" + filepath.Base(input)
+	var cmd []string
+	for _, t := range times {
+		cmd = append(cmd, "-ss", formatFloat(t), "-t", formatFloat(opts.ClipDuration), "-i", containerInput)
+	}
+	cmd = append(cmd, args...)
+	cmd = append(cmd, "/output/"+name)
+
+	_, err = runner.Run(ctx, runner.Request{
+		Image:  image,
+		Cmd:    cmd,
+		Files:  []testcontainers.ContainerFile{runner.File(input, containerInput)},
+		Mounts: []mount.Mount{runner.Bind(opts.OutputDir, "/output")},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("preview: %w", err)
+	}
+	return &Preview{
+		Path:   filepath.Join(opts.OutputDir, name),
+		Format: opts.Format,
+		Times:  times,
+		Frames: len(times) * FramesPerClip(opts.ClipDuration, opts.FPS),
+		Width:  width,
+		Height: height,
+	}, nil
+}
+
+// FramesPerClip is the number of frames each excerpt contributes.
+func FramesPerClip(clipDuration float64, fps int) int {
+	return max(1, int(math.Round(clipDuration*float64(fps))))
+}
+
+// previewArgs builds the filter graph and encoder arguments following the
+// inputs, and picks the image that can encode the format.
+func previewArgs(opts PreviewOptions, times []float64, sizing string) ([]string, string, error) {
+	frames := FramesPerClip(opts.ClipDuration, opts.FPS)
+	var graph strings.Builder
+	for i := range times {
+		fmt.Fprintf(&graph, "[%d:v]fps=%d,trim=end_frame=%d,%s,setpts=PTS-STARTPTS[v%d];", i, opts.FPS, frames, sizing, i)
+	}
+	for i := range times {
+		fmt.Fprintf(&graph, "[v%d]", i)
+	}
+	fmt.Fprintf(&graph, "concat=n=%d:v=1:a=0", len(times))
+
+	quality := opts.Quality
+	if quality <= 0 {
+		quality = 80
+	}
+	quality = min(quality, 100)
+	switch opts.Format {
+	case PreviewWebP:
+		graph.WriteString("[out]")
+		return []string{"-filter_complex", graph.String(), "-map", "[out]",
+			"-c:v", "libwebp_anim", "-quality", strconv.Itoa(quality), "-compression_level", "4",
+			"-loop", "0", "-f", "webp"}, WebImage, nil
+	case PreviewGIF:
+		// A palette generated from the excerpts themselves keeps GIF
+		// banding down; only changed regions feed the statistics.
+		graph.WriteString(",split[a][b];[a]palettegen=stats_mode=diff[p];[b][p]paletteuse=dither=bayer:bayer_scale=3[out]")
+		return []string{"-filter_complex", graph.String(), "-map", "[out]",
+			"-loop", "0", "-f", "gif"}, WebImage, nil
+	case PreviewMP4:
+		graph.WriteString(",format=yuv420p[out]")
+		crf := 51 - quality*33/100
+		return []string{"-filter_complex", graph.String(), "-map", "[out]", "-an",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", strconv.Itoa(crf),
+			"-movflags", "+faststart", "-f", "mp4"}, LiteImage, nil
+	}
+	return nil, "", fmt.Errorf("preview: unsupported format %q", opts.Format)
+}
+
+// EvenPoints spreads clips excerpt start points of clipDuration seconds
+// evenly over the video, ignoring skip of the duration at both ends. Fewer
+// points come back when the excerpts do not fit.
+func EvenPoints(duration float64, clips int, clipDuration, skip float64) []float64 {
+	start := duration * skip
+	end := duration*(1-skip) - clipDuration
+	if end < start {
+		start, end = 0, duration-clipDuration
+	}
+	if end < 0 || clips <= 0 {
+		return nil
+	}
+	clips = min(clips, int((end-start+clipDuration)/clipDuration))
+	if clips <= 1 {
+		return []float64{start + (end-start)/2}
+	}
+	points := make([]float64, clips)
+	step := (end - start) / float64(clips-1)
+	for i := range points {
+		points[i] = start + float64(i)*step
+	}
+	return points
+}
+
+// SceneChange is a cut found by the select filter's scene detection.
+type SceneChange struct {
+	Time  float64
+	Score float64
+}
+
+// SceneChanges scores the video at input for scene changes with the lite
+// image and returns the cuts scoring above threshold, in time order. Frames
+// are scored at 160 pixels wide, which is enough to see cuts and keeps the
+// pass fast.
+func SceneChanges(ctx context.Context, input string, threshold float64) ([]SceneChange, error) {
+	containerInput := "/input/" + filepath.Base(input)
+	res, err := runner.Run(ctx, runner.Request{
+		Image: LiteImage,
+		Cmd: []string{
+			"-i", containerInput,
+			"-an",
+			"-vf", fmt.Sprintf("scale=160:-2,select='gt(scene,%s)',metadata=print", strconv.FormatFloat(threshold, 'f', -1, 64)),
+			"-fps_mode", "vfr",
+			"-f", "null", "-",
+		},
+		Files: []testcontainers.ContainerFile{runner.File(input, containerInput)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scene changes: %w", err)
+	}
+	return ParseSceneChanges(res.Stderr), nil
+}
+
+var (
+	metadataTime  = regexp.MustCompile(`pts_time:([0-9.]+)`)
+	metadataScene = regexp.MustCompile(`lavfi\.scene_score=([0-9.]+)`)
+)
+
+// ParseSceneChanges reads the frame and scene score lines the metadata
+// filter prints in print mode.
+func ParseSceneChanges(log string) []SceneChange {
+	var scenes []SceneChange
+	t := -1.0
+	for _, line := range strings.Split(log, "\n") {
+		if m := metadataTime.FindStringSubmatch(line); m != nil {
+			t, _ = strconv.ParseFloat(m[1], 64)
+			continue
+		}
+		if m := metadataScene.FindStringSubmatch(line); m != nil && t >= 0 {
+			score, _ := strconv.ParseFloat(m[1], 64)
+			scenes = append(scenes, SceneChange{Time: t, Score: score})
+			t = -1
+		}
+	}
+	return scenes
+}
+
+// PickScenePoints chooses excerpt start points at the strongest cuts, at
+// most len(even) of them, keeping excerpts from overlapping and out of the
+// skipped ends. Points missing for lack of cuts are taken from even where
+// they do not overlap the chosen cuts. The result is in time order.
+func PickScenePoints(scenes []SceneChange, even []float64, duration, clipDuration, skip float64) []float64 {
+	want := len(even)
+	lo, hi := duration*skip, duration*(1-skip)-clipDuration
+	free := func(points []float64, t float64) bool {
+		for _, p := range points {
+			if math.Abs(p-t) < clipDuration {
+				return false
+			}
+		}
+		return true
+	}
+
+	ranked := append([]SceneChange(nil), scenes...)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	var points []float64
+	for _, s := range ranked {
+		if len(points) == want {
+			break
+		}
+		if s.Time >= lo && s.Time <= hi && free(points, s.Time) {
+			points = append(points, s.Time)
+		}
+	}
+	for _, t := range even {
+		if len(points) == want {
+			break
+		}
+		if free(points, t) {
+			points = append(points, t)
+		}
+	}
+	sort.Float64s(points)
+	return points
+}
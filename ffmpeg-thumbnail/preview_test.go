@@ -0,0 +1,180 @@
+package thumbnail_test
+
+import (
+	"context"
+	"encoding/binary"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+func TestEvenPoints(t *testing.T) {
+	// Five 1s excerpts over the middle 90% of 100s
+	assert.Equal(t, []float64{5, 27.25, 49.5, 71.75, 94}, thumbnail.EvenPoints(100, 5, 1, 0.05))
+
+	// Only three 1s excerpts fit into 3.5s
+	assert.Equal(t, []float64{0, 1.25, 2.5}, thumbnail.EvenPoints(3.5, 5, 1, 0))
+
+	// Nothing fits into a video shorter than one excerpt
+	assert.Empty(t, thumbnail.EvenPoints(0.5, 5, 1, 0))
+}
+
+func TestGeneratePreview_Errors(t *testing.T) {
+	for name, opts := range map[string]thumbnail.PreviewOptions{
+		"no output dir":          {},
+		"negative clips":         {OutputDir: "out", Clips: -1},
+		"negative clip duration": {OutputDir: "out", ClipDuration: -1},
+		"negative fps":           {OutputDir: "out", FPS: -5},
+		"negative quality":       {OutputDir: "out", Quality: -10},
+	} {
+		_, err := thumbnail.GeneratePreview(context.Background(), "video.mp4", opts)
+		assert.ErrorContains(t, err, "preview: ", name)
+	}
+}
+
+func TestParseSceneChanges(t *testing.T) {
+	log := "[Parsed_metadata_2 @ 0x1] frame:0    pts:3072  pts_time:3\n" +
+		"[Parsed_metadata_2 @ 0x1] lavfi.scene_score=0.812345\n" +
+		"frame=    2 fps=0.0 q=-0.0 size=       0KiB time=00:00:06.00\n" +
+		"[Parsed_metadata_2 @ 0x1] frame:1    pts:6144  pts_time:6.04\n" +
+		"[Parsed_metadata_2 @ 0x1] lavfi.scene_score=0.456\n"
+
+	assert.Equal(t, []thumbnail.SceneChange{{Time: 3, Score: 0.812345}, {Time: 6.04, Score: 0.456}},
+		thumbnail.ParseSceneChanges(log))
+}
+
+func TestPickScenePoints(t *testing.T) {
+	scenes := []thumbnail.SceneChange{
+		{Time: 0.5, Score: 0.99}, // inside the skipped start
+		{Time: 20, Score: 0.4},
+		{Time: 20.5, Score: 0.6}, // overlaps the stronger cut at 20.5
+		{Time: 50, Score: 0.9},
+	}
+	even := thumbnail.EvenPoints(100, 4, 1, 0.05)
+
+	// Then: The two usable cuts come first and even points fill the rest
+	// without overlapping them
+	assert.Equal(t, []float64{5, 20.5, 34.66666666666667, 50},
+		thumbnail.PickScenePoints(scenes, even, 100, 1, 0.05))
+}
+
+// animatedWebP returns the canvas size and frame count of an animated WebP.
+func animatedWebP(t *testing.T, path string) (width, height, frames int) {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Greater(t, len(data), 12)
+	require.Equal(t, "RIFF", string(data[:4]))
+	require.Equal(t, "WEBP", string(data[8:12]))
+	for p := data[12:]; len(p) >= 8; {
+		size := int(binary.LittleEndian.Uint32(p[4:8]))
+		payload := p[8 : 8+size]
+		switch string(p[:4]) {
+		case "VP8X":
+			width = int(payload[4]) | int(payload[5])<<8 | int(payload[6])<<16 + 1
+			height = int(payload[7]) | int(payload[8])<<8 | int(payload[9])<<16 + 1
+		case "ANMF":
+			frames++
+		}
+		p = p[min(8+size+size%2, len(p)):]
+	}
+	return width, height, frames
+}
+
+func TestThumbnail_Preview_WebP(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Generating the default 5 x 1s animated WebP at 10 fps
+	preview, err := thumbnail.GeneratePreview(context.Background(), absPath, thumbnail.PreviewOptions{OutputDir: outputPath})
+	require.NoError(t, err)
+
+	// Then: The animation has 50 frames at 320x180
+	assert.Len(t, preview.Times, 5)
+	assert.Equal(t, 50, preview.Frames)
+	w, h, frames := animatedWebP(t, preview.Path)
+	assert.Equal(t, 320, w)
+	assert.Equal(t, 180, h)
+	assert.Equal(t, preview.Frames, frames)
+}
+
+func TestThumbnail_Preview_GIF(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Generating a 3 x 1.5s GIF at 8 fps, 240 wide
+	preview, err := thumbnail.GeneratePreview(context.Background(), absPath, thumbnail.PreviewOptions{
+		OutputDir:    outputPath,
+		Format:       thumbnail.PreviewGIF,
+		Clips:        3,
+		ClipDuration: 1.5,
+		FPS:          8,
+		Width:        240,
+	})
+	require.NoError(t, err)
+
+	// Then: The GIF loops forever with 36 frames at 240x136, the even height closest to 16:9
+	f, err := os.Open(preview.Path)
+	require.NoError(t, err)
+	defer f.Close()
+	g, err := gif.DecodeAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, 36, preview.Frames)
+	assert.Len(t, g.Image, preview.Frames)
+	assert.Equal(t, 0, g.LoopCount)
+	assert.Equal(t, 240, g.Config.Width)
+	assert.Equal(t, 136, g.Config.Height)
+}
+
+func TestThumbnail_Preview_MP4_SceneCuts(t *testing.T) {
+	// Given: A 12 second clip with hard cuts at 3s and 7s
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	clip, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:        "cuts.mp4",
+		Duration:    12,
+		VideoFilter: "negate=enable='between(t,3,6.96)'",
+	})
+	require.NoError(t, err)
+
+	// When: Generating a muted MP4 preview from the scene changes
+	preview, err := thumbnail.GeneratePreview(ctx, clip, thumbnail.PreviewOptions{
+		OutputDir: outputPath,
+		Format:    thumbnail.PreviewMP4,
+		Clips:     2,
+		Scenes:    true,
+	})
+	require.NoError(t, err)
+
+	// Then: The excerpts start at the cuts
+	require.Len(t, preview.Times, 2)
+	assert.InDelta(t, 3, preview.Times[0], 0.1)
+	assert.InDelta(t, 7, preview.Times[1], 0.1)
+
+	// Then: The MP4 has no audio and the planned frames and size
+	probe, err := ffprobe.Probe(ctx, preview.Path)
+	require.NoError(t, err)
+	assert.Nil(t, probe.AudioStream())
+	video := probe.VideoStream()
+	require.NotNil(t, video)
+	assert.Equal(t, "h264", video.CodecName)
+	assert.Equal(t, 320, video.Width)
+	assert.Equal(t, 180, video.Height)
+	assert.Equal(t, "20", video.NbFrames)
+}
@@ -0,0 +1,260 @@
+// Package thumbnail generates still images from video with the thumbnail
+// image.
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/ffprobe"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// Image is the FFmpeg thumbnail image.
+const Image = "ghcr.io/veloxpack/ffmpeg:8.0-thumbnail"
+
+// StoryboardOptions configures a storyboard. Zero values pick defaults
+// derived from the probed video.
+type StoryboardOptions struct {
+	// OutputDir is the host directory the sprite sheets, WebVTT track and
+	// JSON manifest are written to.
+	OutputDir string
+	// Interval between thumbnails in seconds. When zero it is chosen so the
+	// video yields at most MaxThumbnails thumbnails, and never below one
+	// second.
+	Interval float64
+	// MaxThumbnails bounds the thumbnail count when Interval is zero;
+	// defaults to 100.
+	MaxThumbnails int
+	// TileWidth defaults to 160. TileHeight follows the display aspect
+	// ratio when zero.
+	TileWidth  int
+	TileHeight int
+	// Columns and Rows of each sprite sheet. When zero the grid is as
+	// square as possible with at most 10 tiles per side.
+	Columns int
+	Rows    int
+	// Prefix names the outputs: <prefix>_001.jpg, <prefix>.vtt and
+	// <prefix>.json. Defaults to "sprite".
+	Prefix string
+	// Format of the sprite sheets; defaults to JPEG. AVIF is not supported
+	// because the avif muxer writes a single image.
+	Format Format
+	// BaseURL is prepended to sheet names in the WebVTT cues.
+	BaseURL string
+}
+
+// Storyboard is the layout of a generated storyboard. It is also the JSON
+// manifest written next to the sprite sheets.
+type Storyboard struct {
+	Duration   float64 `json:"duration"`
+	Interval   float64 `json:"interval"`
+	TileWidth  int     `json:"tile_width"`
+	TileHeight int     `json:"tile_height"`
+	Columns    int     `json:"columns"`
+	Rows       int     `json:"rows"`
+	// Sheets are the sprite sheet file names, relative to the output
+	// directory.
+	Sheets     []string    `json:"sheets"`
+	Thumbnails []Thumbnail `json:"thumbnails"`
+
+	// WebVTT and Manifest are the names of the written track and manifest.
+	WebVTT   string `json:"-"`
+	Manifest string `json:"-"`
+}
+
+// Thumbnail locates one tile of a sprite sheet.
+type Thumbnail struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Sheet string  `json:"sheet"`
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+	W     int     `json:"w"`
+	H     int     `json:"h"`
+}
+
+const maxGridSide = 10
+
+// PlanStoryboard computes the storyboard layout for a video of the given
+// duration and display size without running FFmpeg.
+func PlanStoryboard(duration float64, displayWidth, displayHeight int, opts StoryboardOptions) (*Storyboard, error) {
+	if duration <= 0 {
+		return nil, errors.New("storyboard: duration must be positive")
+	}
+	if opts.Interval < 0 || opts.MaxThumbnails < 0 {
+		return nil, errors.New("storyboard: interval and thumbnail count cannot be negative")
+	}
+	if opts.TileWidth < 0 || opts.TileHeight < 0 || opts.Columns < 0 || opts.Rows < 0 {
+		return nil, errors.New("storyboard: tile size and grid cannot be negative")
+	}
+	if err := opts.Format.Validate(); err != nil {
+		return nil, fmt.Errorf("storyboard: %w", err)
+	}
+	if opts.Format == FormatAVIF {
+		return nil, errors.New("storyboard: AVIF sprite sheets are not supported")
+	}
+	opts = opts.withDefaults()
+
+	sb := &Storyboard{
+		Duration:   duration,
+		Interval:   opts.Interval,
+		TileWidth:  opts.TileWidth,
+		TileHeight: opts.TileHeight,
+		Columns:    opts.Columns,
+		Rows:       opts.Rows,
+		WebVTT:     opts.Prefix + ".vtt",
+		Manifest:   opts.Prefix + ".json",
+	}
+	if sb.Interval == 0 {
+		sb.Interval = math.Max(1, math.Ceil(duration/float64(opts.MaxThumbnails)))
+	}
+	if sb.TileHeight == 0 {
+		if displayWidth <= 0 || displayHeight <= 0 {
+			return nil, errors.New("storyboard: display size is required to derive the tile height")
+		}
+		// Even heights keep chroma subsampled encoders happy.
+		sb.TileHeight = int(math.Round(float64(sb.TileWidth)*float64(displayHeight)/float64(displayWidth)/2)) * 2
+	}
+
+	count := int(math.Ceil(duration / sb.Interval))
+	if sb.Columns == 0 && sb.Rows == 0 {
+		sb.Columns = min(int(math.Ceil(math.Sqrt(float64(count)))), maxGridSide)
+		sb.Rows = min(int(math.Ceil(float64(count)/float64(sb.Columns))), maxGridSide)
+	} else if sb.Columns == 0 {
+		sb.Columns = min(int(math.Ceil(float64(count)/float64(sb.Rows))), maxGridSide)
+	} else if sb.Rows == 0 {
+		sb.Rows = min(int(math.Ceil(float64(count)/float64(sb.Columns))), maxGridSide)
+	}
+
+	perSheet := sb.Columns * sb.Rows
+	for i := 0; i < count; i++ {
+		sheet, tile := i/perSheet, i%perSheet
+		if tile == 0 {
+			sb.Sheets = append(sb.Sheets, sheetName(opts.Prefix, sheet+1, opts.Format))
+		}
+		sb.Thumbnails = append(sb.Thumbnails, Thumbnail{
+			Start: float64(i) * sb.Interval,
+			End:   math.Min(float64(i+1)*sb.Interval, duration),
+			Sheet: sb.Sheets[sheet],
+			X:     (tile % sb.Columns) * sb.TileWidth,
+			Y:     (tile / sb.Columns) * sb.TileHeight,
+			W:     sb.TileWidth,
+			H:     sb.TileHeight,
+		})
+	}
+	return sb, nil
+}
+
+func (o StoryboardOptions) withDefaults() StoryboardOptions {
+	if o.MaxThumbnails == 0 {
+		o.MaxThumbnails = 100
+	}
+	if o.TileWidth == 0 {
+		o.TileWidth = 160
+	}
+	if o.Prefix == "" {
+		o.Prefix = "sprite"
+	}
+	return o
+}
+
+func sheetName(prefix string, n int, format Format) string {
+	return fmt.Sprintf("%s_%03d%s", prefix, n, format.Extension())
+}
+
+// GenerateStoryboard probes the video at input, renders its sprite sheets in
+// one FFmpeg run and writes the WebVTT thumbnail track and JSON manifest to
+// opts.OutputDir.
+func GenerateStoryboard(ctx context.Context, input string, opts StoryboardOptions) (*Storyboard, error) {
+	if opts.OutputDir == "" {
+		return nil, errors.New("storyboard: output directory is required")
+	}
+	probe, err := ffprobe.Probe(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("storyboard: %w", err)
+	}
+	video := probe.VideoStream()
+	if video == nil {
+		return nil, fmt.Errorf("storyboard: %s has no video stream", filepath.Base(input))
+	}
+	w, h := video.DisplaySize()
+	sb, err := PlanStoryboard(probe.Duration(), w, h, opts)
+	if err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+
+	containerInput := "/input/" + filepath.Base(input)
+	filter := fmt.Sprintf("fps=1/%s,scale=%d:%d,tile=%dx%d",
+		strconv.FormatFloat(sb.Interval, 'f', -1, 64), sb.TileWidth, sb.TileHeight, sb.Columns, sb.Rows)
+	cmd := []string{
+		"-i", containerInput,
+		"-vf", filter,
+		"-frames:v", strconv.Itoa(len(sb.Sheets)),
+	}
+	if opts.Format == "" || opts.Format == FormatJPEG {
+		cmd = append(cmd, "-q:v", "4")
+	} else {
+		// The image2 muxer writes each sheet as a file of its own.
+		cmd = append(cmd, opts.Format.EncoderArgs(0)...)
+		cmd = append(cmd, "-f", "image2")
+	}
+	cmd = append(cmd, "/output/"+opts.Prefix+"_%03d"+opts.Format.Extension())
+	_, err = runner.Run(ctx, runner.Request{
+		Image:  opts.Format.Image(),
+		Cmd:    cmd,
+		Files:  []testcontainers.ContainerFile{runner.File(input, containerInput)},
+		Mounts: []mount.Mount{runner.Bind(opts.OutputDir, "/output")},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("storyboard: %w", err)
+	}
+
+	if err := sb.WriteFiles(opts.OutputDir, opts.BaseURL); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// WriteFiles writes the WebVTT track and JSON manifest into dir.
+func (sb *Storyboard) WriteFiles(dir, baseURL string) error {
+	if err := os.WriteFile(filepath.Join(dir, sb.WebVTT), sb.WebVTTTrack(baseURL), 0o644); err != nil {
+		return fmt.Errorf("storyboard: %w", err)
+	}
+	manifest, err := json.MarshalIndent(sb, "", "  ")
+	if err != nil {
+		return fmt.Errorf("storyboard: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, sb.Manifest), manifest, 0o644); err != nil {
+		return fmt.Errorf("storyboard: %w", err)
+	}
+	return nil
+}
+
+// WebVTTTrack renders the thumbnails track: one cue per thumbnail whose
+// payload is "<sheet>#xywh=x,y,w,h".
+func (sb *Storyboard) WebVTTTrack(baseURL string) []byte {
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n")
+	for _, th := range sb.Thumbnails {
+		fmt.Fprintf(&b, "\n%s --> %s\n%s%s#xywh=%d,%d,%d,%d\n",
+			FormatTimestamp(th.Start), FormatTimestamp(th.End), baseURL, th.Sheet, th.X, th.Y, th.W, th.H)
+	}
+	return b.Bytes()
+}
+
+// FormatTimestamp formats seconds as a WebVTT timestamp, hh:mm:ss.ttt.
+func FormatTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
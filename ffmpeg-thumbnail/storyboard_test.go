@@ -0,0 +1,125 @@
+package thumbnail_test
+
+import (
+	"context"
+	"encoding/json"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+)
+
+func TestPlanStoryboard(t *testing.T) {
+	// Given: A 10 minute 16:9 video and at most 150 thumbnails
+	sb, err := thumbnail.PlanStoryboard(600, 1280, 720, thumbnail.StoryboardOptions{MaxThumbnails: 150})
+	require.NoError(t, err)
+
+	// Then: The interval, tile size and grid are derived from the video
+	assert.Equal(t, 4.0, sb.Interval)
+	assert.Equal(t, 160, sb.TileWidth)
+	assert.Equal(t, 90, sb.TileHeight)
+	assert.Equal(t, 10, sb.Columns)
+	assert.Equal(t, 10, sb.Rows)
+	assert.Equal(t, []string{"sprite_001.jpg", "sprite_002.jpg"}, sb.Sheets)
+	require.Len(t, sb.Thumbnails, 150)
+
+	// Then: Tiles fill each sheet row by row
+	assert.Equal(t, thumbnail.Thumbnail{Start: 44, End: 48, Sheet: "sprite_001.jpg", X: 160, Y: 90, W: 160, H: 90}, sb.Thumbnails[11])
+	assert.Equal(t, "sprite_002.jpg", sb.Thumbnails[100].Sheet)
+	assert.Equal(t, 0, sb.Thumbnails[100].X)
+	assert.Equal(t, 0, sb.Thumbnails[100].Y)
+}
+
+func TestPlanStoryboard_ShortVideo(t *testing.T) {
+	// Given: A 9.5 second portrait video
+	sb, err := thumbnail.PlanStoryboard(9.5, 1080, 1920, thumbnail.StoryboardOptions{TileWidth: 90})
+	require.NoError(t, err)
+
+	// Then: Thumbnails are one second apart on a near-square grid and the
+	// last one ends with the video
+	assert.Equal(t, 1.0, sb.Interval)
+	assert.Equal(t, 160, sb.TileHeight)
+	assert.Equal(t, 4, sb.Columns)
+	assert.Equal(t, 3, sb.Rows)
+	require.Len(t, sb.Thumbnails, 10)
+	assert.Equal(t, 9.5, sb.Thumbnails[9].End)
+}
+
+func TestPlanStoryboard_Errors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		duration float64
+		opts     thumbnail.StoryboardOptions
+	}{
+		"zero duration":           {0, thumbnail.StoryboardOptions{}},
+		"negative interval":       {60, thumbnail.StoryboardOptions{Interval: -1}},
+		"negative max thumbnails": {60, thumbnail.StoryboardOptions{MaxThumbnails: -5}},
+		"negative columns":        {60, thumbnail.StoryboardOptions{Columns: -2}},
+		"negative rows":           {60, thumbnail.StoryboardOptions{Rows: -2}},
+		"negative tile width":     {60, thumbnail.StoryboardOptions{TileWidth: -160}},
+		"negative tile height":    {60, thumbnail.StoryboardOptions{TileHeight: -90}},
+		"avif sheets":             {60, thumbnail.StoryboardOptions{Format: thumbnail.FormatAVIF}},
+	} {
+		sb, err := thumbnail.PlanStoryboard(tc.duration, 1280, 720, tc.opts)
+		assert.Error(t, err, name)
+		assert.Nil(t, sb, name)
+	}
+}
+
+func TestStoryboard_WebVTTTrack(t *testing.T) {
+	sb, err := thumbnail.PlanStoryboard(3725, 1280, 720, thumbnail.StoryboardOptions{Interval: 3600})
+	require.NoError(t, err)
+
+	track := string(sb.WebVTTTrack("https://cdn.example.com/title/"))
+	assert.Equal(t, "WEBVTT\n"+
+		"\n00:00:00.000 --> 01:00:00.000\nhttps://cdn.example.com/title/sprite_001.jpg#xywh=0,0,160,90\n"+
+		"\n01:00:00.000 --> 01:02:05.000\nhttps://cdn.example.com/title/sprite_001.jpg#xywh=160,0,160,90\n", track)
+}
+
+func TestThumbnail_Storyboard_WebVTT(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	// When: Generating a storyboard with at most 150 thumbnails
+	sb, err := thumbnail.GenerateStoryboard(context.Background(), absPath, thumbnail.StoryboardOptions{
+		OutputDir:     outputPath,
+		MaxThumbnails: 150,
+	})
+	require.NoError(t, err)
+
+	// Then: Every sprite sheet is a JPEG of the planned grid size
+	require.Len(t, sb.Sheets, 2)
+	for _, sheet := range sb.Sheets {
+		f, err := os.Open(filepath.Join(outputPath, sheet))
+		require.NoError(t, err)
+		cfg, err := jpeg.DecodeConfig(f)
+		f.Close()
+		require.NoError(t, err, sheet)
+		assert.Equal(t, sb.Columns*sb.TileWidth, cfg.Width, sheet)
+		assert.Equal(t, sb.Rows*sb.TileHeight, cfg.Height, sheet)
+	}
+
+	// Then: The WebVTT track has a cue per thumbnail pointing into a sheet
+	vtt, err := os.ReadFile(filepath.Join(outputPath, sb.WebVTT))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(vtt), "WEBVTT\n"))
+	assert.Equal(t, len(sb.Thumbnails), strings.Count(string(vtt), " --> "))
+	assert.Contains(t, string(vtt), "sprite_002.jpg#xywh=")
+
+	// Then: The JSON manifest round-trips to the same layout
+	data, err := os.ReadFile(filepath.Join(outputPath, sb.Manifest))
+	require.NoError(t, err)
+	var manifest thumbnail.Storyboard
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	assert.Equal(t, sb.Thumbnails, manifest.Thumbnails)
+	assert.Equal(t, sb.Sheets, manifest.Sheets)
+	assert.InDelta(t, 600, manifest.Duration, 60)
+}
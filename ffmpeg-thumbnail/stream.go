@@ -0,0 +1,327 @@
+package thumbnail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strconv"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/internal/runner"
+	"golang.org/x/image/webp"
+)
+
+// StreamOptions configures Stream.
+type StreamOptions struct {
+	// Start is the timestamp of the first frame in seconds.
+	Start float64
+	// Interval between frames in seconds; required.
+	Interval float64
+	// MaxFrames stops the stream after that many frames; zero runs to the
+	// end of the video.
+	MaxFrames int
+	// Width, Height and Fit size the frames as in ExtractOptions.
+	Width  int
+	Height int
+	Fit    Fit
+	// Format is JPEG, PNG or WebP; defaults to JPEG.
+	Format Format
+	// Quality from 1 to 100; defaults to 80.
+	Quality int
+}
+
+// StreamFrame is one encoded frame read from the container.
+type StreamFrame struct {
+	// Index counts frames from zero.
+	Index int
+	// Time is the timestamp of the frame in seconds.
+	Time   float64
+	Format Format
+	// Data is the complete encoded image, ready to upload as is.
+	Data []byte
+}
+
+// Image decodes the frame.
+func (f StreamFrame) Image() (image.Image, error) {
+	r := bytes.NewReader(f.Data)
+	switch f.Format {
+	case FormatPNG:
+		return png.Decode(r)
+	case FormatWebP:
+		return webp.Decode(r)
+	default:
+		return jpeg.Decode(r)
+	}
+}
+
+// Stream samples a frame every opts.Interval seconds of the video at input
+// and passes each to fn as soon as FFmpeg writes it to standard output, with
+// no shared filesystem. The input is copied into the container, so bind
+// mounts are not needed at all. Returning an error from fn kills the
+// container and Stream returns that error.
+func Stream(ctx context.Context, input string, opts StreamOptions, fn func(StreamFrame) error) error {
+	if opts.Interval <= 0 {
+		return errors.New("stream: interval must be positive")
+	}
+	if err := opts.Format.Validate(); err != nil {
+		return fmt.Errorf("stream: %w", err)
+	}
+	if opts.Format == FormatAVIF {
+		return errors.New("stream: AVIF cannot be streamed through image2pipe")
+	}
+
+	filter := "fps=1/" + strconv.FormatFloat(opts.Interval, 'f', -1, 64)
+	if opts.Width != 0 || opts.Height != 0 {
+		sizing, err := extractFilter(ctx, input, ExtractOptions{
+			Time:   opts.Start,
+			Width:  opts.Width,
+			Height: opts.Height,
+			Fit:    opts.Fit,
+			Format: opts.Format,
+		})
+		if err != nil {
+			return fmt.Errorf("stream: %w", err)
+		}
+		filter += "," + sizing
+	}
+
+	containerInput := "/input/" + filepath.Base(input)
+	cmd := []string{"-ss", formatFloat(opts.Start), "-i", containerInput, "-vf", filter}
+	if opts.MaxFrames > 0 {
+		cmd = append(cmd, "-frames:v", strconv.Itoa(opts.MaxFrames))
+	}
+	cmd = append(cmd, opts.Format.EncoderArgs(opts.Quality)...)
+	cmd = append(cmd, "-f", "image2pipe", "pipe:1")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := runner.Run(ctx, runner.Request{
+			Image:  opts.Format.Image(),
+			Cmd:    cmd,
+			Files:  []testcontainers.ContainerFile{runner.File(input, containerInput)},
+			Stdout: pw,
+		})
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	frames := NewFrameReader(pr, opts.Format)
+	var readErr, fnErr error
+	for i := 0; ; i++ {
+		data, err := frames.Next()
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+		frame := StreamFrame{Index: i, Time: opts.Start + float64(i)*opts.Interval, Format: opts.Format, Data: data}
+		if fnErr = fn(frame); fnErr != nil {
+			break
+		}
+	}
+	// When reading stopped early, stop waiting for the container and fail
+	// its output, which kills it.
+	if fnErr != nil || readErr != nil {
+		cancel()
+	}
+	pr.Close()
+	runErr := <-done
+	switch {
+	case fnErr != nil:
+		return fnErr
+	case readErr != nil:
+		// A failed container closes the pipe with its error, so this also
+		// covers FFmpeg exiting mid-image.
+		return fmt.Errorf("stream: %w", readErr)
+	case runErr != nil:
+		return fmt.Errorf("stream: %w", runErr)
+	}
+	return nil
+}
+
+// FrameReader splits a concatenated image2pipe stream into complete encoded
+// images.
+type FrameReader struct {
+	r      *bufio.Reader
+	format Format
+}
+
+// NewFrameReader reads images of format from r; the zero Format is JPEG.
+func NewFrameReader(r io.Reader, format Format) *FrameReader {
+	return &FrameReader{r: bufio.NewReaderSize(r, 64<<10), format: format}
+}
+
+// Next returns the next image. It returns io.EOF at a clean end of stream
+// and io.ErrUnexpectedEOF when the stream ends inside an image.
+func (fr *FrameReader) Next() ([]byte, error) {
+	if _, err := fr.r.Peek(1); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	var err error
+	switch fr.format {
+	case FormatPNG:
+		err = fr.readPNG(&buf)
+	case FormatWebP:
+		err = fr.readWebP(&buf)
+	case "", FormatJPEG:
+		err = fr.readJPEG(&buf)
+	default:
+		return nil, fmt.Errorf("frame reader: unsupported format %q", fr.format)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// readPNG copies the signature and chunks up to and including IEND.
+func (fr *FrameReader) readPNG(buf *bytes.Buffer) error {
+	if err := fr.copyN(buf, 8); err != nil {
+		return err
+	}
+	if !bytes.Equal(buf.Bytes(), pngSignature) {
+		return errors.New("frame reader: missing PNG signature")
+	}
+	for {
+		start := buf.Len()
+		if err := fr.copyN(buf, 8); err != nil {
+			return err
+		}
+		header := buf.Bytes()[start:]
+		length := int64(binary.BigEndian.Uint32(header))
+		chunk := string(header[4:8])
+		// Chunk data and CRC.
+		if err := fr.copyN(buf, length+4); err != nil {
+			return err
+		}
+		if chunk == "IEND" {
+			return nil
+		}
+	}
+}
+
+// readWebP copies one RIFF container.
+func (fr *FrameReader) readWebP(buf *bytes.Buffer) error {
+	if err := fr.copyN(buf, 12); err != nil {
+		return err
+	}
+	header := buf.Bytes()
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return errors.New("frame reader: missing WebP RIFF header")
+	}
+	size := int64(binary.LittleEndian.Uint32(header[4:8]))
+	// The RIFF size counts from the WEBP tag and chunks are padded to even
+	// sizes.
+	return fr.copyN(buf, size-4+size%2)
+}
+
+// readJPEG copies marker segments up to the start of scan, then entropy
+// coded data up to the EOI marker. Inside scan data 0xFF is always followed
+// by a stuffed zero or a restart marker, so the first other marker after
+// the scan data ends it; progressive JPEGs carry several scans.
+func (fr *FrameReader) readJPEG(buf *bytes.Buffer) error {
+	if err := fr.copyN(buf, 2); err != nil {
+		return err
+	}
+	if buf.Bytes()[0] != 0xFF || buf.Bytes()[1] != 0xD8 {
+		return errors.New("frame reader: missing JPEG start of image")
+	}
+	for {
+		marker, err := fr.nextMarker(buf)
+		if err != nil {
+			return err
+		}
+		switch {
+		case marker == 0xD9:
+			return nil
+		case marker >= 0xD0 && marker <= 0xD7, marker == 0x01:
+			// Standalone markers carry no length.
+			continue
+		}
+		start := buf.Len()
+		if err := fr.copyN(buf, 2); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint16(buf.Bytes()[start:]))
+		if length < 2 {
+			return fmt.Errorf("frame reader: bad JPEG segment length %d", length)
+		}
+		if err := fr.copyN(buf, length-2); err != nil {
+			return err
+		}
+		if marker == 0xDA {
+			if err := fr.copyScan(buf); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// nextMarker copies fill bytes and a marker, returning the marker code.
+func (fr *FrameReader) nextMarker(buf *bytes.Buffer) (byte, error) {
+	b, err := fr.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("frame reader: expected JPEG marker, got 0x%02X", b)
+	}
+	buf.WriteByte(b)
+	for {
+		b, err = fr.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		buf.WriteByte(b)
+		if b != 0xFF {
+			return b, nil
+		}
+	}
+}
+
+// copyScan copies entropy coded data and stops before the marker that ends
+// it.
+func (fr *FrameReader) copyScan(buf *bytes.Buffer) error {
+	for {
+		p, err := fr.r.Peek(2)
+		if err != nil {
+			return err
+		}
+		n := int64(1)
+		if p[0] == 0xFF {
+			if p[1] != 0x00 && (p[1] < 0xD0 || p[1] > 0xD7) {
+				return nil
+			}
+			n = 2
+		}
+		if err := fr.copyN(buf, n); err != nil {
+			return err
+		}
+	}
+}
+
+func (fr *FrameReader) copyN(buf *bytes.Buffer, n int64) error {
+	if n < 0 {
+		return errors.New("frame reader: negative length")
+	}
+	_, err := io.CopyN(buf, fr.r, n)
+	return err
+}
@@ -0,0 +1,210 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+)
+
+// noiseImage returns an image whose JPEG encoding contains plenty of 0xFF
+// bytes in the scan data.
+func noiseImage(w, h int, seed uint32) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+	}
+	return img
+}
+
+func TestFrameReader_JPEG(t *testing.T) {
+	// Given: Three JPEGs written back to back, as image2pipe does
+	var stream bytes.Buffer
+	var want [][]byte
+	for i := 0; i < 3; i++ {
+		var b bytes.Buffer
+		require.NoError(t, jpeg.Encode(&b, noiseImage(64+16*i, 48, uint32(i)), &jpeg.Options{Quality: 90}))
+		want = append(want, b.Bytes())
+		stream.Write(b.Bytes())
+	}
+
+	// When: Splitting the stream
+	r := thumbnail.NewFrameReader(&stream, thumbnail.FormatJPEG)
+	for i := range want {
+		got, err := r.Next()
+		require.NoError(t, err)
+
+		// Then: Each frame is exactly one encoded image
+		assert.Equal(t, want[i], got, "frame %d", i)
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(got))
+		require.NoError(t, err)
+		assert.Equal(t, 64+16*i, cfg.Width)
+	}
+	_, err := r.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFrameReader_PNG(t *testing.T) {
+	var stream bytes.Buffer
+	for i := 0; i < 2; i++ {
+		require.NoError(t, png.Encode(&stream, noiseImage(32, 32+i, uint32(i))))
+	}
+
+	r := thumbnail.NewFrameReader(&stream, thumbnail.FormatPNG)
+	for i := 0; i < 2; i++ {
+		got, err := r.Next()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(got))
+		require.NoError(t, err)
+		assert.Equal(t, 32+i, img.Bounds().Dy())
+	}
+	_, err := r.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFrameReader_WebP(t *testing.T) {
+	// Given: Two RIFF containers, the first with an odd sized chunk
+	riff := func(payload []byte) []byte {
+		chunk := append([]byte("VP8L"), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+		chunk = append(chunk, payload...)
+		if len(payload)%2 == 1 {
+			chunk = append(chunk, 0)
+		}
+		out := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(chunk)))...)
+		return append(append(out, "WEBP"...), chunk...)
+	}
+	first, second := riff([]byte{1, 2, 3}), riff([]byte{4, 5, 6, 7})
+
+	r := thumbnail.NewFrameReader(bytes.NewReader(append(append([]byte{}, first...), second...)), thumbnail.FormatWebP)
+	got, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, first, got)
+	got, err = r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, second, got)
+}
+
+func TestFrameReader_Truncated(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, png.Encode(&b, image.NewGray(image.Rect(0, 0, 8, 8))))
+
+	r := thumbnail.NewFrameReader(bytes.NewReader(b.Bytes()[:b.Len()-6]), thumbnail.FormatPNG)
+	_, err := r.Next()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestThumbnail_Stream(t *testing.T) {
+	// Given: A test video file and no output directory at all
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	// When: Streaming five 320 wide JPEG frames a minute apart
+	var frames []thumbnail.StreamFrame
+	err = thumbnail.Stream(context.Background(), absPath, thumbnail.StreamOptions{
+		Start:     10,
+		Interval:  60,
+		MaxFrames: 5,
+		Width:     320,
+	}, func(f thumbnail.StreamFrame) error {
+		frames = append(frames, f)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Then: Every frame decodes at the requested size with its timestamp
+	require.Len(t, frames, 5)
+	for i, f := range frames {
+		assert.Equal(t, i, f.Index)
+		assert.Equal(t, 10+60*float64(i), f.Time)
+		img, err := f.Image()
+		require.NoError(t, err)
+		assert.Equal(t, 320, img.Bounds().Dx())
+		assert.Equal(t, 180, img.Bounds().Dy())
+	}
+	// Then: Frames differ, so they were not read from one repeated image
+	assert.NotEqual(t, frames[0].Data, frames[1].Data)
+}
+
+func TestThumbnail_Stream_StopEarly(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	// When: The consumer stops after two PNG frames of an open ended stream
+	errEnough := errors.New("enough")
+	var count int
+	returned := make(chan error, 1)
+	go func() {
+		returned <- thumbnail.Stream(context.Background(), absPath, thumbnail.StreamOptions{
+			Interval: 5,
+			Width:    160,
+			Format:   thumbnail.FormatPNG,
+		}, func(f thumbnail.StreamFrame) error {
+			img, err := f.Image()
+			if err != nil {
+				return err
+			}
+			if img.Bounds().Dx() != 160 {
+				return errors.New("unexpected frame width")
+			}
+			count++
+			if count == 2 {
+				return errEnough
+			}
+			return nil
+		})
+	}()
+
+	// Then: Stream kills the container and returns the consumer's error
+	// rather than hanging on the container's unread output
+	select {
+	case err = <-returned:
+	case <-time.After(2 * time.Minute):
+		t.Fatal("Stream did not return after the consumer stopped")
+	}
+	assert.ErrorIs(t, err, errEnough)
+	assert.Equal(t, 2, count)
+}
+
+func TestThumbnail_Stream_Images(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	// When: Streaming through the thumbnail image (JPEG, PNG) and the web
+	// image (WebP), which both need the pipe protocol
+	for _, format := range []thumbnail.Format{thumbnail.FormatJPEG, thumbnail.FormatPNG, thumbnail.FormatWebP} {
+		var frames []thumbnail.StreamFrame
+		err := thumbnail.Stream(context.Background(), absPath, thumbnail.StreamOptions{
+			Start:     5,
+			Interval:  10,
+			MaxFrames: 2,
+			Width:     160,
+			Format:    format,
+		}, func(f thumbnail.StreamFrame) error {
+			frames = append(frames, f)
+			return nil
+		})
+
+		// Then: Both frames arrive and decode
+		require.NoError(t, err, format.Image())
+		require.Len(t, frames, 2, format.Image())
+		for _, f := range frames {
+			img, err := f.Image()
+			require.NoError(t, err, format)
+			assert.Equal(t, 160, img.Bounds().Dx(), format)
+		}
+	}
+}
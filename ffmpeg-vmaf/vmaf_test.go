@@ -0,0 +1,121 @@
+package vmaf_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/ffmpeg-lite/quality"
+	"github.com/veloxpack/tools/internal/fixture"
+)
+
+func TestVMAF_Compare(t *testing.T) {
+	// Given: A 640x360 reference and a 320x180 heavily compressed copy of
+	// the same content
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	reference, err := fixture.Generate(ctx, outputPath, fixture.Video{Name: "reference.mp4", Duration: 4})
+	require.NoError(t, err)
+	distorted, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:        "distorted.mp4",
+		Duration:    4,
+		VideoFilter: "scale=320:180",
+		OutputArgs:  []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "40", "-pix_fmt", "yuv420p"},
+	})
+	require.NoError(t, err)
+
+	// When: Scoring the copy with VMAF
+	logDir := createTempDir(t)
+	defer cleanupFiles(t, logDir)
+	res, err := quality.Compare(ctx, reference, distorted, quality.Options{LogDir: logDir, VMAF: true})
+	require.NoError(t, err)
+
+	// Then: Every frame has a VMAF score next to PSNR and SSIM
+	require.Len(t, res.Frames, 100)
+	require.NotNil(t, res.VMAF)
+	for _, f := range res.Frames {
+		assert.GreaterOrEqual(t, f.VMAF, 0.0)
+		assert.LessOrEqual(t, f.VMAF, 100.0)
+		assert.Greater(t, f.PSNR, 0.0)
+		assert.Greater(t, f.SSIM, 0.0)
+	}
+	assert.Less(t, res.VMAF.Mean, 90.0)
+	assert.LessOrEqual(t, res.VMAF.Min, res.VMAF.P5)
+	assert.LessOrEqual(t, res.VMAF.HarmonicMean, res.VMAF.Mean)
+
+	// And: The JSON log pools the same mean
+	data, err := os.ReadFile(filepath.Join(logDir, "vmaf.json"))
+	require.NoError(t, err)
+	var log struct {
+		PooledMetrics map[string]struct {
+			Mean float64 `json:"mean"`
+		} `json:"pooled_metrics"`
+	}
+	require.NoError(t, json.Unmarshal(data, &log))
+	assert.InDelta(t, log.PooledMetrics["vmaf"].Mean, res.VMAF.Mean, 0.01)
+
+	// When: Scoring the reference against itself
+	same, err := quality.Compare(ctx, reference, reference, quality.Options{LogDir: logDir, VMAF: true})
+	require.NoError(t, err)
+
+	// Then: It scores far above the compressed copy
+	require.NotNil(t, same.VMAF)
+	assert.Greater(t, same.VMAF.Mean, 95.0)
+	assert.Greater(t, same.VMAF.Mean, res.VMAF.Mean+10)
+}
+
+func TestVMAF_4KModel(t *testing.T) {
+	// Given: A reference and a lightly compressed copy
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	reference, err := fixture.Generate(ctx, outputPath, fixture.Video{Name: "reference.mp4", Duration: 2})
+	require.NoError(t, err)
+	distorted, err := fixture.Generate(ctx, outputPath, fixture.Video{
+		Name:       "distorted.mp4",
+		Duration:   2,
+		OutputArgs: []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "28", "-pix_fmt", "yuv420p"},
+	})
+	require.NoError(t, err)
+
+	// When: Scoring with the built-in 4K model
+	logDir := createTempDir(t)
+	defer cleanupFiles(t, logDir)
+	res, err := quality.Compare(ctx, reference, distorted, quality.Options{
+		LogDir:    logDir,
+		VMAF:      true,
+		VMAFModel: "vmaf_4k_v0.6.1",
+	})
+	require.NoError(t, err)
+
+	// Then: The model loads without a model file and scores every frame
+	require.NotNil(t, res.VMAF)
+	assert.Len(t, res.Frames, 50)
+	assert.Greater(t, res.VMAF.Mean, 50.0)
+}
+
+func createTempDir(t *testing.T) string {
+	outputPath, err := filepath.Abs(filepath.Join("..", "testdata", strings.ReplaceAll(uuid.NewString(), "-", "")))
+	require.NoError(t, err)
+
+	err = os.MkdirAll(outputPath, 0755)
+	require.NoError(t, err)
+
+	return outputPath
+}
+
+func cleanupFiles(t *testing.T, path string) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		t.Logf("failed to remove output directory: %s", path)
+	}
+}
@@ -0,0 +1,200 @@
+// Package ffprobe inspects media files with the ffprobe image.
+package ffprobe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// Image is the ffprobe image used by Probe.
+const Image = "ghcr.io/veloxpack/ffmpeg:8.0-probe"
+
+// Output is the JSON document printed by ffprobe -show_format -show_streams.
+type Output struct {
+	Format  Format   `json:"format"`
+	Streams []Stream `json:"streams"`
+}
+
+// Format describes the container.
+type Format struct {
+	Filename   string            `json:"filename"`
+	FormatName string            `json:"format_name"`
+	Duration   string            `json:"duration"`
+	Size       string            `json:"size"`
+	BitRate    string            `json:"bit_rate"`
+	Tags       map[string]string `json:"tags"`
+}
+
+// Stream describes one elementary stream.
+type Stream struct {
+	Index              int               `json:"index"`
+	CodecName          string            `json:"codec_name"`
+	CodecType          string            `json:"codec_type"`
+	CodecTagString     string            `json:"codec_tag_string,omitempty"`
+	Profile            string            `json:"profile,omitempty"`
+	Width              int               `json:"width,omitempty"`
+	Height             int               `json:"height,omitempty"`
+	SampleAspectRatio  string            `json:"sample_aspect_ratio,omitempty"`
+	DisplayAspectRatio string            `json:"display_aspect_ratio,omitempty"`
+	PixFmt             string            `json:"pix_fmt,omitempty"`
+	ColorRange         string            `json:"color_range,omitempty"`
+	ColorSpace         string            `json:"color_space,omitempty"`
+	ColorTransfer      string            `json:"color_transfer,omitempty"`
+	ColorPrimaries     string            `json:"color_primaries,omitempty"`
+	RFrameRate         string            `json:"r_frame_rate,omitempty"`
+	AvgFrameRate       string            `json:"avg_frame_rate,omitempty"`
+	SampleRate         string            `json:"sample_rate,omitempty"`
+	Channels           int               `json:"channels,omitempty"`
+	ChannelLayout      string            `json:"channel_layout,omitempty"`
+	Duration           string            `json:"duration"`
+	BitRate            string            `json:"bit_rate"`
+	NbFrames           string            `json:"nb_frames,omitempty"`
+	Tags               map[string]string `json:"tags"`
+	Disposition        map[string]int    `json:"disposition,omitempty"`
+	SideDataList       []SideData        `json:"side_data_list,omitempty"`
+}
+
+// SideData is an entry of a stream's side_data_list, such as a display
+// matrix or HDR mastering display metadata.
+type SideData struct {
+	SideDataType string `json:"side_data_type"`
+	Rotation     int    `json:"rotation,omitempty"`
+
+	// Mastering display metadata: CIE 1931 chromaticities and luminance
+	// in cd/m², as rationals.
+	RedX         string `json:"red_x,omitempty"`
+	RedY         string `json:"red_y,omitempty"`
+	GreenX       string `json:"green_x,omitempty"`
+	GreenY       string `json:"green_y,omitempty"`
+	BlueX        string `json:"blue_x,omitempty"`
+	BlueY        string `json:"blue_y,omitempty"`
+	WhitePointX  string `json:"white_point_x,omitempty"`
+	WhitePointY  string `json:"white_point_y,omitempty"`
+	MinLuminance string `json:"min_luminance,omitempty"`
+	MaxLuminance string `json:"max_luminance,omitempty"`
+
+	// Content light level metadata in cd/m².
+	MaxContent int `json:"max_content,omitempty"`
+	MaxAverage int `json:"max_average,omitempty"`
+}
+
+// Probe runs ffprobe on the host file at path and decodes its format and
+// stream information. Extra arguments are inserted before the input.
+func Probe(ctx context.Context, path string, extra ...string) (*Output, error) {
+	input := "/input/" + filepath.Base(path)
+	cmd := []string{"-v", "error", "-print_format", "json", "-show_format", "-show_streams"}
+	cmd = append(cmd, extra...)
+	cmd = append(cmd, input)
+
+	res, err := runner.Run(ctx, runner.Request{
+		Image: Image,
+		Cmd:   cmd,
+		Files: []testcontainers.ContainerFile{runner.File(path, input)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe %s: %w", filepath.Base(path), err)
+	}
+	var out Output
+	if err := json.Unmarshal(res.Stdout, &out); err != nil {
+		return nil, fmt.Errorf("ffprobe %s: decode output: %w", filepath.Base(path), err)
+	}
+	return &out, nil
+}
+
+// Duration returns the container duration in seconds.
+func (o *Output) Duration() float64 {
+	d, _ := strconv.ParseFloat(o.Format.Duration, 64)
+	return d
+}
+
+// VideoStream returns the first video stream, or nil.
+func (o *Output) VideoStream() *Stream {
+	return o.firstStream("video")
+}
+
+// AudioStream returns the first audio stream, or nil.
+func (o *Output) AudioStream() *Stream {
+	return o.firstStream("audio")
+}
+
+func (o *Output) firstStream(codecType string) *Stream {
+	for i := range o.Streams {
+		if o.Streams[i].CodecType == codecType {
+			return &o.Streams[i]
+		}
+	}
+	return nil
+}
+
+// FrameRate returns the average frame rate, falling back to r_frame_rate.
+func (s *Stream) FrameRate() float64 {
+	if r := ParseRational(s.AvgFrameRate); r > 0 {
+		return r
+	}
+	return ParseRational(s.RFrameRate)
+}
+
+// SAR returns the sample aspect ratio, 1 when unknown.
+func (s *Stream) SAR() float64 {
+	if r := ParseRational(strings.Replace(s.SampleAspectRatio, ":", "/", 1)); r > 0 {
+		return r
+	}
+	return 1
+}
+
+// DisplaySize returns the frame size after applying the sample aspect ratio
+// and any rotation, i.e. the size a player shows.
+func (s *Stream) DisplaySize() (int, int) {
+	w := int(float64(s.Width)*s.SAR() + 0.5)
+	h := s.Height
+	if r := s.Rotation(); r == 90 || r == -90 || r == 270 || r == -270 {
+		w, h = h, w
+	}
+	return w, h
+}
+
+// Rotation returns the display matrix rotation in degrees, or the legacy
+// rotate tag.
+func (s *Stream) Rotation() int {
+	if sd := s.SideData("Display Matrix"); sd != nil {
+		return sd.Rotation
+	}
+	r, _ := strconv.Atoi(s.Tags["rotate"])
+	return r
+}
+
+// SideData returns the first side data entry of the given type, such as
+// "Mastering display metadata", or nil.
+func (s *Stream) SideData(kind string) *SideData {
+	for i := range s.SideDataList {
+		if s.SideDataList[i].SideDataType == kind {
+			return &s.SideDataList[i]
+		}
+	}
+	return nil
+}
+
+// ParseRational parses "num/den" or a plain number; it returns 0 for
+// invalid values and zero denominators.
+func ParseRational(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !ok {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -0,0 +1,54 @@
+package ffprobe_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/ffprobe"
+)
+
+func TestParseRational(t *testing.T) {
+	assert.InDelta(t, 29.97, ffprobe.ParseRational("30000/1001"), 0.001)
+	assert.Equal(t, 25.0, ffprobe.ParseRational("25"))
+	assert.Equal(t, 0.0, ffprobe.ParseRational("0/0"))
+	assert.Equal(t, 0.0, ffprobe.ParseRational(""))
+}
+
+func TestStream_DisplaySize(t *testing.T) {
+	// Given: Anamorphic PAL and a rotated phone recording
+	anamorphic := ffprobe.Stream{Width: 720, Height: 576, SampleAspectRatio: "64:45"}
+	rotated := ffprobe.Stream{Width: 1920, Height: 1080, SampleAspectRatio: "1:1",
+		SideDataList: []ffprobe.SideData{{SideDataType: "Display Matrix", Rotation: -90}}}
+
+	// Then: The display size applies the SAR and swaps rotated dimensions
+	w, h := anamorphic.DisplaySize()
+	assert.Equal(t, 1024, w)
+	assert.Equal(t, 576, h)
+
+	w, h = rotated.DisplaySize()
+	assert.Equal(t, 1080, w)
+	assert.Equal(t, 1920, h)
+}
+
+func TestFFProbe_Probe(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	// When: Probing it through the library
+	out, err := ffprobe.Probe(context.Background(), absPath)
+	require.NoError(t, err)
+
+	// Then: Duration and the first video and audio streams are available
+	assert.Greater(t, out.Duration(), 0.0)
+	video := out.VideoStream()
+	require.NotNil(t, video)
+	assert.Equal(t, "h264", video.CodecName)
+	assert.Equal(t, 1280, video.Width)
+	assert.Equal(t, 720, video.Height)
+	assert.Greater(t, video.FrameRate(), 0.0)
+	require.NotNil(t, out.AudioStream())
+}
@@ -0,0 +1,105 @@
+// Package fixture renders small synthetic media files with the lite FFmpeg
+// image, for tests that need content with known properties such as black
+// intros, fades or a specific aspect ratio.
+package fixture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// Image renders fixtures.
+const Image = "ghcr.io/veloxpack/ffmpeg:8.0-lite"
+
+// Video describes a synthetic clip built from lavfi sources.
+type Video struct {
+	// Name is the output file name, e.g. "fades.mp4".
+	Name string
+	// Duration in seconds; defaults to 10.
+	Duration float64
+	// Width, Height and Rate default to 640x360 at 25 fps.
+	Width  int
+	Height int
+	Rate   int
+	// Source is the lavfi video source; defaults to "testsrc2". Size, rate
+	// and duration are appended.
+	Source string
+	// VideoFilter is applied to the source, e.g. "fade=t=in:st=2:d=1".
+	VideoFilter string
+	// Audio is a lavfi audio source such as "sine=frequency=440"; empty
+	// leaves the clip silent.
+	Audio string
+	// AudioFilter is applied to the audio source.
+	AudioFilter string
+	// OutputArgs replace the default libx264/aac encoding arguments.
+	OutputArgs []string
+}
+
+func (v Video) withDefaults() Video {
+	if v.Duration == 0 {
+		v.Duration = 10
+	}
+	if v.Width == 0 {
+		v.Width = 640
+	}
+	if v.Height == 0 {
+		v.Height = 360
+	}
+	if v.Rate == 0 {
+		v.Rate = 25
+	}
+	if v.Source == "" {
+		v.Source = "testsrc2"
+	}
+	return v
+}
+
+// Args returns the FFmpeg arguments that render the clip into /output.
+func (v Video) Args() []string {
+	v = v.withDefaults()
+	duration := strconv.FormatFloat(v.Duration, 'f', -1, 64)
+	args := []string{
+		"-hide_banner", "-y",
+		"-f", "lavfi", "-i", fmt.Sprintf("%s=size=%dx%d:rate=%d:duration=%s", v.Source, v.Width, v.Height, v.Rate, duration),
+	}
+	if v.Audio != "" {
+		args = append(args, "-f", "lavfi", "-i", v.Audio)
+	}
+	if v.VideoFilter != "" {
+		args = append(args, "-vf", v.VideoFilter)
+	}
+	if v.AudioFilter != "" {
+		args = append(args, "-af", v.AudioFilter)
+	}
+	if v.OutputArgs != nil {
+		args = append(args, v.OutputArgs...)
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p")
+		if v.Audio != "" {
+			args = append(args, "-c:a", "aac", "-b:a", "128k")
+		}
+	}
+	return append(args, "-t", duration, "/output/"+v.Name)
+}
+
+// Generate renders the clip into dir and returns its host path.
+func Generate(ctx context.Context, dir string, v Video) (string, error) {
+	if v.Name == "" {
+		return "", errors.New("fixture: name is required")
+	}
+	_, err := runner.Run(ctx, runner.Request{
+		Image:  Image,
+		Cmd:    v.Args(),
+		Mounts: []mount.Mount{runner.Bind(dir, "/output")},
+	})
+	if err != nil {
+		return "", fmt.Errorf("fixture %s: %w", v.Name, err)
+	}
+	return filepath.Join(dir, v.Name), nil
+}
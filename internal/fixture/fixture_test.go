@@ -0,0 +1,23 @@
+package fixture
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVideo_Args(t *testing.T) {
+	// Given: A clip with a fade and a tone
+	v := Video{Name: "tone.mp4", Duration: 4, VideoFilter: "fade=t=in:d=1", Audio: "sine=frequency=440"}
+
+	// Then: Defaults fill the source and encoders
+	assert.Equal(t, []string{
+		"-hide_banner", "-y",
+		"-f", "lavfi", "-i", "testsrc2=size=640x360:rate=25:duration=4",
+		"-f", "lavfi", "-i", "sine=frequency=440",
+		"-vf", "fade=t=in:d=1",
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "128k",
+		"-t", "4", "/output/tone.mp4",
+	}, v.Args())
+}
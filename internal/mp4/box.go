@@ -0,0 +1,285 @@
+// Package mp4 reads the ISO BMFF box structure of packaged media so tests can
+// inspect encryption, fragment and sample metadata without a decoder.
+package mp4
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Box is a single ISO BMFF box.
+type Box struct {
+	Type string
+	// Offset is the position of the box header within the parsed buffer.
+	Offset int64
+	// Payload is the box content following the header.
+	Payload  []byte
+	Children []*Box
+
+	headerSize int
+}
+
+// Size returns the total size of the box including its header.
+func (b *Box) Size() int64 {
+	return int64(len(b.Payload) + b.headerSize)
+}
+
+// containers lists boxes whose payload is a plain sequence of child boxes.
+var containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"mvex": true, "moof": true, "traf": true, "sinf": true, "schi": true,
+	"dinf": true, "edts": true, "udta": true, "mfra": true,
+	"iprp": true, "ipco": true,
+}
+
+// sampleEntries maps sample entry types to the size of the fixed fields that
+// precede their child boxes.
+var sampleEntries = map[string]int{
+	"avc1": 78, "avc3": 78, "hev1": 78, "hvc1": 78, "av01": 78, "vp09": 78, "encv": 78,
+	"mp4a": 28, "ac-3": 28, "ec-3": 28, "Opus": 28, "enca": 28,
+	"wvtt": 8, "stpp": 8, "enct": 8,
+}
+
+// ReadFile parses every top-level box of the file at path.
+func ReadFile(path string) ([]*Box, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse parses a buffer holding a sequence of boxes.
+func Parse(data []byte) ([]*Box, error) {
+	return parse(data, 0)
+}
+
+func parse(data []byte, base int64) ([]*Box, error) {
+	var boxes []*Box
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < 8 {
+			return boxes, fmt.Errorf("truncated box header at offset %d", base+int64(pos))
+		}
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		header := 8
+		switch size {
+		case 0:
+			size = len(data) - pos
+		case 1:
+			if len(data)-pos < 16 {
+				return boxes, fmt.Errorf("truncated large box %q at offset %d", typ, base+int64(pos))
+			}
+			// Compare the 64-bit size before converting, so a huge largesize
+			// cannot wrap around int.
+			large := binary.BigEndian.Uint64(data[pos+8:])
+			if large > uint64(len(data)-pos) {
+				return boxes, fmt.Errorf("box %q at offset %d overruns buffer", typ, base+int64(pos))
+			}
+			size = int(large)
+			header = 16
+		}
+		if size < header || size > len(data)-pos {
+			return boxes, fmt.Errorf("box %q at offset %d overruns buffer", typ, base+int64(pos))
+		}
+
+		box := &Box{
+			Type:       typ,
+			Offset:     base + int64(pos),
+			Payload:    data[pos+header : pos+size],
+			headerSize: header,
+		}
+		if err := box.parseChildren(); err != nil {
+			return boxes, err
+		}
+		boxes = append(boxes, box)
+		pos += size
+	}
+	return boxes, nil
+}
+
+func (b *Box) parseChildren() error {
+	if b.Type == "meta" {
+		// ISO BMFF meta is a full box, QuickTime meta is not; only keep the
+		// children when the full box reading parses cleanly.
+		if len(b.Payload) >= 4 {
+			if children, err := parse(b.Payload[4:], b.Offset+int64(b.headerSize+4)); err == nil {
+				b.Children = children
+			}
+		}
+		return nil
+	}
+	skip := -1
+	switch {
+	case containers[b.Type]:
+		skip = 0
+	case b.Type == "stsd":
+		// Full box header plus entry count.
+		skip = 8
+	default:
+		if n, ok := sampleEntries[b.Type]; ok {
+			skip = n
+		}
+	}
+	if skip < 0 || skip > len(b.Payload) {
+		return nil
+	}
+	children, err := parse(b.Payload[skip:], b.Offset+int64(b.headerSize+skip))
+	if err != nil {
+		return fmt.Errorf("%s: %w", b.Type, err)
+	}
+	b.Children = children
+	return nil
+}
+
+// Find returns every box reachable from boxes whose path matches the
+// slash-separated box types, e.g. "moov/trak/mdia/minf/stbl/stsd". A "*"
+// element matches any box type.
+func Find(boxes []*Box, path string) []*Box {
+	parts := strings.Split(path, "/")
+	matches := boxes
+	for i, part := range parts {
+		var next []*Box
+		for _, b := range matches {
+			if part == "*" || b.Type == part {
+				if i == len(parts)-1 {
+					next = append(next, b)
+				} else {
+					next = append(next, b.Children...)
+				}
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+// FindAll returns every box of the given type at any depth.
+func FindAll(boxes []*Box, typ string) []*Box {
+	var found []*Box
+	for _, b := range boxes {
+		if b.Type == typ {
+			found = append(found, b)
+		}
+		found = append(found, FindAll(b.Children, typ)...)
+	}
+	return found
+}
+
+// Well-known DRM system IDs as they appear in pssh boxes.
+const (
+	SystemCommon    = "1077efecc0b24d02ace33c1e52e2fb4b"
+	SystemWidevine  = "edef8ba979d64acea3c827dcd51d21ed"
+	SystemPlayReady = "9a04f07998404286ab92e65be0885f95"
+	SystemFairPlay  = "94ce86fb07ff4f43adb893d2fa968ca2"
+	SystemMarlin    = "5e629af538da4063897797ffbd9902d4"
+)
+
+// PSSH is a parsed Protection System Specific Header box.
+type PSSH struct {
+	Version  uint8
+	SystemID string
+	KeyIDs   []string
+	Data     []byte
+}
+
+// ParsePSSH decodes a pssh box.
+func ParsePSSH(b *Box) (*PSSH, error) {
+	if b.Type != "pssh" {
+		return nil, fmt.Errorf("expected pssh box, got %q", b.Type)
+	}
+	p := b.Payload
+	if len(p) < 4+16+4 {
+		return nil, errors.New("pssh box too short")
+	}
+	pssh := &PSSH{
+		Version:  p[0],
+		SystemID: hex.EncodeToString(p[4:20]),
+	}
+	pos := 20
+	if pssh.Version > 0 {
+		count := int(binary.BigEndian.Uint32(p[pos:]))
+		pos += 4
+		if len(p) < pos+count*16+4 {
+			return nil, errors.New("pssh key id list truncated")
+		}
+		for i := 0; i < count; i++ {
+			pssh.KeyIDs = append(pssh.KeyIDs, hex.EncodeToString(p[pos:pos+16]))
+			pos += 16
+		}
+	}
+	size := int(binary.BigEndian.Uint32(p[pos:]))
+	pos += 4
+	if len(p) < pos+size {
+		return nil, errors.New("pssh data truncated")
+	}
+	pssh.Data = p[pos : pos+size]
+	return pssh, nil
+}
+
+// TrackEncryption is a parsed tenc box.
+type TrackEncryption struct {
+	Version           uint8
+	CryptByteBlock    uint8
+	SkipByteBlock     uint8
+	IsProtected       bool
+	PerSampleIVSize   uint8
+	DefaultKeyID      string
+	DefaultConstantIV []byte
+}
+
+// ParseTenc decodes a tenc box.
+func ParseTenc(b *Box) (*TrackEncryption, error) {
+	if b.Type != "tenc" {
+		return nil, fmt.Errorf("expected tenc box, got %q", b.Type)
+	}
+	p := b.Payload
+	if len(p) < 4+4+16 {
+		return nil, errors.New("tenc box too short")
+	}
+	tenc := &TrackEncryption{
+		Version:         p[0],
+		IsProtected:     p[6] == 1,
+		PerSampleIVSize: p[7],
+		DefaultKeyID:    hex.EncodeToString(p[8:24]),
+	}
+	if tenc.Version > 0 {
+		tenc.CryptByteBlock = p[5] >> 4
+		tenc.SkipByteBlock = p[5] & 0x0f
+	}
+	if tenc.IsProtected && tenc.PerSampleIVSize == 0 && len(p) > 24 {
+		n := int(p[24])
+		if len(p) >= 25+n {
+			tenc.DefaultConstantIV = p[25 : 25+n]
+		}
+	}
+	return tenc, nil
+}
+
+// SchemeType returns the protection scheme (cenc, cbc1, cens or cbcs) from a
+// schm box.
+func SchemeType(b *Box) (string, error) {
+	if b.Type != "schm" {
+		return "", fmt.Errorf("expected schm box, got %q", b.Type)
+	}
+	if len(b.Payload) < 8 {
+		return "", errors.New("schm box too short")
+	}
+	return string(b.Payload[4:8]), nil
+}
+
+// ParseISPE returns the image size from an ispe (image spatial extents)
+// property, as found in HEIF and AVIF files under meta/iprp/ipco.
+func ParseISPE(b *Box) (width, height int, err error) {
+	if b.Type != "ispe" {
+		return 0, 0, fmt.Errorf("expected ispe box, got %q", b.Type)
+	}
+	if len(b.Payload) < 12 {
+		return 0, 0, errors.New("ispe box too short")
+	}
+	return int(binary.BigEndian.Uint32(b.Payload[4:])), int(binary.BigEndian.Uint32(b.Payload[8:])), nil
+}
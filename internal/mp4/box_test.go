@@ -0,0 +1,172 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func box(typ string, payload ...[]byte) []byte {
+	var body []byte
+	for _, p := range payload {
+		body = append(body, p...)
+	}
+	out := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(out, uint32(8+len(body)))
+	copy(out[4:], typ)
+	return append(out, body...)
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestParse_NestedEncryptionBoxes(t *testing.T) {
+	// Given: A moov with a v1 pssh and an encv sample entry carrying tenc
+	kid := "00112233445566778899aabbccddeeff"
+	pssh := box("pssh",
+		[]byte{1, 0, 0, 0},
+		mustHex(SystemCommon),
+		[]byte{0, 0, 0, 1},
+		mustHex(kid),
+		[]byte{0, 0, 0, 0},
+	)
+	tenc := box("tenc", []byte{1, 0, 0, 0, 0, 0x19, 1, 0}, mustHex(kid), []byte{16}, make([]byte, 16))
+	schm := box("schm", []byte{0, 0, 0, 0}, []byte("cbcs"), []byte{0, 1, 0, 0})
+	encv := box("encv", make([]byte, 78), box("sinf", schm, box("schi", tenc)))
+	stsd := box("stsd", []byte{0, 0, 0, 0, 0, 0, 0, 1}, encv)
+	moov := box("moov", pssh, box("trak", box("mdia", box("minf", box("stbl", stsd)))))
+
+	// When: Parsing the buffer
+	boxes, err := Parse(append(box("ftyp", []byte("isom")), moov...))
+	require.NoError(t, err)
+
+	// Then: pssh, schm and tenc are reachable and decoded
+	psshBoxes := Find(boxes, "moov/pssh")
+	require.Len(t, psshBoxes, 1)
+	parsed, err := ParsePSSH(psshBoxes[0])
+	require.NoError(t, err)
+	assert.Equal(t, SystemCommon, parsed.SystemID)
+	assert.Equal(t, []string{kid}, parsed.KeyIDs)
+
+	scheme, err := SchemeType(FindAll(boxes, "schm")[0])
+	require.NoError(t, err)
+	assert.Equal(t, "cbcs", scheme)
+
+	te, err := ParseTenc(Find(boxes, "moov/trak/mdia/minf/stbl/stsd/encv/sinf/schi/tenc")[0])
+	require.NoError(t, err)
+	assert.True(t, te.IsProtected)
+	assert.Equal(t, uint8(1), te.CryptByteBlock)
+	assert.Equal(t, uint8(9), te.SkipByteBlock)
+	assert.Equal(t, kid, te.DefaultKeyID)
+	assert.Len(t, te.DefaultConstantIV, 16)
+	assert.Equal(t, int64(len(moov)), Find(boxes, "moov")[0].Size())
+}
+
+func TestParse_TruncatedBox(t *testing.T) {
+	data := box("moov", box("trak"))
+	_, err := Parse(data[:len(data)-2])
+	assert.Error(t, err)
+}
+
+func TestParse_OversizedLargeBox(t *testing.T) {
+	for name, size := range map[string]uint64{
+		"wraps to negative": 1 << 63,
+		"wraps past zero":   ^uint64(0),
+		"past the end":      64,
+		"inside header":     12,
+	} {
+		// Given: A large box whose 64-bit size does not fit the buffer
+		data := make([]byte, 32)
+		binary.BigEndian.PutUint32(data, 1)
+		copy(data[4:], "mdat")
+		binary.BigEndian.PutUint64(data[8:], size)
+
+		// Then: Parsing fails instead of panicking
+		assert.NotPanics(t, func() {
+			_, err := Parse(data)
+			assert.Error(t, err, name)
+		}, name)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(box("moov", box("trak")))
+	f.Add(mustHex("00000001" + "6d646174" + "8000000000000000"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Parse(data)
+	})
+}
+
+func TestParse_AVIFImageSize(t *testing.T) {
+	// Given: An AVIF header with the image size in meta/iprp/ipco/ispe
+	ispe := box("ispe", []byte{0, 0, 0, 0}, []byte{0, 0, 1, 64}, []byte{0, 0, 0, 180})
+	meta := box("meta", []byte{0, 0, 0, 0}, box("hdlr", make([]byte, 24)), box("iprp", box("ipco", ispe)))
+	boxes, err := Parse(append(box("ftyp", []byte("avif"), []byte{0, 0, 0, 0}), meta...))
+	require.NoError(t, err)
+
+	// Then: The spatial extents are reachable and decoded
+	found := Find(boxes, "meta/iprp/ipco/ispe")
+	require.Len(t, found, 1)
+	w, h, err := ParseISPE(found[0])
+	require.NoError(t, err)
+	assert.Equal(t, 320, w)
+	assert.Equal(t, 180, h)
+}
+
+func TestParseSampleTable(t *testing.T) {
+	// Given: A video track of five samples at 25 fps with key frames 1 and 4
+	mdhd := box("mdhd", make([]byte, 12), u32(12800), u32(0), make([]byte, 4))
+	hdlr := box("hdlr", make([]byte, 8), []byte("vide"), make([]byte, 12))
+	stts := box("stts", make([]byte, 4), u32(1), u32(5), u32(512))
+	stsz := box("stsz", make([]byte, 4), u32(0), u32(5), u32(900), u32(100), u32(120), u32(800), u32(90))
+	stss := box("stss", make([]byte, 4), u32(2), u32(1), u32(4))
+	stbl := box("stbl", stts, stsz, stss)
+	trak := box("trak", box("mdia", mdhd, hdlr, box("minf", stbl)))
+	boxes, err := Parse(box("moov", trak))
+	require.NoError(t, err)
+
+	// When: Reading the sample table of the only track
+	tracks := Tracks(boxes)
+	require.Len(t, tracks, 1)
+	assert.Equal(t, "vide", HandlerType(tracks[0]))
+	st, err := ParseSampleTable(tracks[0])
+	require.NoError(t, err)
+
+	// Then: Timing, sizes and key frames line up
+	assert.Equal(t, uint32(12800), st.Timescale)
+	assert.Equal(t, []uint64{0, 512, 1024, 1536, 2048}, st.DecodeTimes)
+	assert.Equal(t, []uint32{900, 100, 120, 800, 90}, st.Sizes)
+	assert.Equal(t, []float64{0, 0.12}, st.KeyframeTimes())
+	assert.InDelta(t, 0.2, st.Duration(), 1e-9)
+}
+
+func TestSampleTable_PeakBitrate(t *testing.T) {
+	// Given: Two seconds of samples at 10 fps, 100 bytes each except for a
+	// 1000 byte burst at 1s
+	st := &SampleTable{Timescale: 1000}
+	for i := 0; i < 20; i++ {
+		size := uint32(100)
+		if i == 10 {
+			size = 1000
+		}
+		st.DecodeTimes = append(st.DecodeTimes, uint64(i*100))
+		st.Durations = append(st.Durations, 100)
+		st.Sizes = append(st.Sizes, size)
+		st.Sync = append(st.Sync, i%10 == 0)
+	}
+
+	// Then: The burst dominates short windows and averages out over long
+	// ones
+	assert.InDelta(t, 80000.0, st.PeakBitrate(0.1), 1e-9)
+	assert.InDelta(t, 15200.0, st.PeakBitrate(1), 1e-9)
+	assert.InDelta(t, 11600.0, st.PeakBitrate(2), 1e-9)
+	assert.InDelta(t, 11600.0, st.Bitrate(), 1e-9)
+}
@@ -0,0 +1,260 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// TrackFragmentHeader is a parsed tfhd box.
+type TrackFragmentHeader struct {
+	TrackID               uint32
+	BaseDataOffset        int64
+	HasBaseDataOffset     bool
+	DefaultSampleSize     uint32
+	DefaultSampleFlags    uint32
+	HasDefaultFlags       bool
+	DefaultBaseIsMoof     bool
+	DefaultSampleDuration uint32
+}
+
+// ParseTfhd decodes a tfhd box.
+func ParseTfhd(b *Box) (*TrackFragmentHeader, error) {
+	if b.Type != "tfhd" {
+		return nil, fmt.Errorf("expected tfhd box, got %q", b.Type)
+	}
+	p := b.Payload
+	if len(p) < 8 {
+		return nil, errors.New("tfhd box too short")
+	}
+	flags := binary.BigEndian.Uint32(p) & 0xffffff
+	h := &TrackFragmentHeader{
+		TrackID:           binary.BigEndian.Uint32(p[4:]),
+		DefaultBaseIsMoof: flags&0x20000 != 0,
+	}
+	r := reader{buf: p, pos: 8}
+	if flags&0x1 != 0 {
+		h.BaseDataOffset = int64(r.uint64())
+		h.HasBaseDataOffset = true
+	}
+	if flags&0x2 != 0 {
+		r.uint32() // sample_description_index
+	}
+	if flags&0x8 != 0 {
+		h.DefaultSampleDuration = r.uint32()
+	}
+	if flags&0x10 != 0 {
+		h.DefaultSampleSize = r.uint32()
+	}
+	if flags&0x20 != 0 {
+		h.DefaultSampleFlags = r.uint32()
+		h.HasDefaultFlags = true
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("tfhd: %w", r.err)
+	}
+	return h, nil
+}
+
+// TrackRun is a parsed trun box.
+type TrackRun struct {
+	DataOffset    int32
+	HasDataOffset bool
+	Samples       []RunSample
+}
+
+// RunSample is one sample of a trun box. Fields absent from the box are
+// zero; HasSize and HasFlags tell whether the tfhd defaults apply.
+type RunSample struct {
+	Duration          uint32
+	Size              uint32
+	Flags             uint32
+	CompositionOffset int32
+	HasSize           bool
+	HasFlags          bool
+}
+
+// ParseTrun decodes a trun box.
+func ParseTrun(b *Box) (*TrackRun, error) {
+	if b.Type != "trun" {
+		return nil, fmt.Errorf("expected trun box, got %q", b.Type)
+	}
+	p := b.Payload
+	if len(p) < 8 {
+		return nil, errors.New("trun box too short")
+	}
+	flags := binary.BigEndian.Uint32(p) & 0xffffff
+	count := int(binary.BigEndian.Uint32(p[4:]))
+	run := &TrackRun{}
+	r := reader{buf: p, pos: 8}
+	if flags&0x1 != 0 {
+		run.DataOffset = int32(r.uint32())
+		run.HasDataOffset = true
+	}
+	var firstFlags uint32
+	hasFirstFlags := flags&0x4 != 0
+	if hasFirstFlags {
+		firstFlags = r.uint32()
+	}
+	for i := 0; i < count && r.err == nil; i++ {
+		var s RunSample
+		if flags&0x100 != 0 {
+			s.Duration = r.uint32()
+		}
+		if flags&0x200 != 0 {
+			s.Size = r.uint32()
+			s.HasSize = true
+		}
+		if flags&0x400 != 0 {
+			s.Flags = r.uint32()
+			s.HasFlags = true
+		} else if i == 0 && hasFirstFlags {
+			s.Flags = firstFlags
+			s.HasFlags = true
+		}
+		if flags&0x800 != 0 {
+			s.CompositionOffset = int32(r.uint32())
+		}
+		run.Samples = append(run.Samples, s)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("trun: %w", r.err)
+	}
+	return run, nil
+}
+
+// Sample locates one media sample of a fragmented file.
+type Sample struct {
+	// Offset is the position of the sample data within the parsed buffer.
+	Offset int64
+	Size   int64
+	// Sync reports a sync sample (sample_is_non_sync_sample is clear).
+	Sync bool
+}
+
+// FragmentSamples returns the samples of every moof in boxes, in file
+// order. Data offsets are resolved against the tfhd base data offset or, as
+// the packager writes them, the start of the enclosing moof.
+func FragmentSamples(boxes []*Box) ([]Sample, error) {
+	var samples []Sample
+	for _, moof := range Find(boxes, "moof") {
+		for _, traf := range Find(moof.Children, "traf") {
+			tfhdBoxes := Find(traf.Children, "tfhd")
+			if len(tfhdBoxes) == 0 {
+				return nil, fmt.Errorf("traf at offset %d has no tfhd", traf.Offset)
+			}
+			tfhd, err := ParseTfhd(tfhdBoxes[0])
+			if err != nil {
+				return nil, err
+			}
+			base := moof.Offset
+			if tfhd.HasBaseDataOffset {
+				base = tfhd.BaseDataOffset
+			}
+
+			for _, trunBox := range Find(traf.Children, "trun") {
+				run, err := ParseTrun(trunBox)
+				if err != nil {
+					return nil, err
+				}
+				pos := base + int64(run.DataOffset)
+				for _, rs := range run.Samples {
+					size, flags := rs.Size, rs.Flags
+					if !rs.HasSize {
+						size = tfhd.DefaultSampleSize
+					}
+					if !rs.HasFlags {
+						flags = tfhd.DefaultSampleFlags
+					}
+					samples = append(samples, Sample{
+						Offset: pos,
+						Size:   int64(size),
+						Sync:   flags&0x10000 == 0,
+					})
+					pos += int64(size)
+				}
+			}
+		}
+	}
+	return samples, nil
+}
+
+// AVCConfig is the part of an avcC box needed to split samples into NAL
+// units.
+type AVCConfig struct {
+	Profile       uint8
+	Level         uint8
+	NALLengthSize int
+}
+
+// ParseAVCC decodes an avcC box.
+func ParseAVCC(b *Box) (*AVCConfig, error) {
+	if b.Type != "avcC" {
+		return nil, fmt.Errorf("expected avcC box, got %q", b.Type)
+	}
+	if len(b.Payload) < 5 {
+		return nil, errors.New("avcC box too short")
+	}
+	return &AVCConfig{
+		Profile:       b.Payload[1],
+		Level:         b.Payload[3],
+		NALLengthSize: int(b.Payload[4]&0x03) + 1,
+	}, nil
+}
+
+// H.264 NAL unit types.
+const (
+	NALSlice = 1
+	NALIDR   = 5
+	NALSEI   = 6
+	NALSPS   = 7
+	NALPPS   = 8
+	NALAUD   = 9
+)
+
+// AVCNALTypes returns the H.264 NAL unit types of a length-prefixed sample.
+func AVCNALTypes(sample []byte, lengthSize int) ([]int, error) {
+	var types []int
+	for pos := 0; pos < len(sample); {
+		if len(sample)-pos < lengthSize {
+			return types, fmt.Errorf("truncated NAL length at offset %d", pos)
+		}
+		var n int
+		for i := 0; i < lengthSize; i++ {
+			n = n<<8 | int(sample[pos+i])
+		}
+		pos += lengthSize
+		if n == 0 || len(sample)-pos < n {
+			return types, fmt.Errorf("NAL unit of %d bytes at offset %d overruns sample", n, pos)
+		}
+		types = append(types, int(sample[pos]&0x1f))
+		pos += n
+	}
+	return types, nil
+}
+
+type reader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *reader) uint32() uint32 {
+	if r.err != nil || len(r.buf)-r.pos < 4 {
+		r.err = errors.New("unexpected end of box")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf[r.pos:])
+	r.pos += 4
+	return v
+}
+
+func (r *reader) uint64() uint64 {
+	if r.err != nil || len(r.buf)-r.pos < 8 {
+		r.err = errors.New("unexpected end of box")
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.buf[r.pos:])
+	r.pos += 8
+	return v
+}
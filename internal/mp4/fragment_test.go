@@ -0,0 +1,66 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func u32(vs ...uint32) []byte {
+	out := make([]byte, 4*len(vs))
+	for i, v := range vs {
+		binary.BigEndian.PutUint32(out[4*i:], v)
+	}
+	return out
+}
+
+func nal(typ byte, size int) []byte {
+	unit := make([]byte, size)
+	unit[0] = 0x60 | typ
+	return append(u32(uint32(size)), unit...)
+}
+
+func TestFragmentSamples(t *testing.T) {
+	// Given: A moof whose samples default to non-sync, with a sync first
+	// sample, followed by an mdat holding an IDR and a non-IDR sample
+	idr := append(append(nal(NALSPS, 4), nal(NALPPS, 3)...), nal(NALIDR, 20)...)
+	slice := nal(NALSlice, 10)
+	tfhd := box("tfhd", u32(0x020020, 1, 0x00010000))
+
+	build := func(dataOffset uint32) []byte {
+		trun := box("trun", u32(0x000205, 2, dataOffset, 0x02000000, uint32(len(idr)), uint32(len(slice))))
+		return box("moof", box("traf", tfhd, trun))
+	}
+	moof := build(0)
+	moof = build(uint32(len(moof) + 8))
+	data := append(box("ftyp", []byte("iso6")), moof...)
+	data = append(data, box("mdat", idr, slice)...)
+
+	// When: Resolving the samples
+	boxes, err := Parse(data)
+	require.NoError(t, err)
+	samples, err := FragmentSamples(boxes)
+	require.NoError(t, err)
+
+	// Then: Offsets are relative to the moof and flags fall back to tfhd
+	require.Len(t, samples, 2)
+	assert.True(t, samples[0].Sync)
+	assert.False(t, samples[1].Sync)
+	assert.Equal(t, int64(len(idr)), samples[0].Size)
+	assert.Equal(t, samples[0].Offset+samples[0].Size, samples[1].Offset)
+
+	types, err := AVCNALTypes(data[samples[0].Offset:samples[0].Offset+samples[0].Size], 4)
+	require.NoError(t, err)
+	assert.Equal(t, []int{NALSPS, NALPPS, NALIDR}, types)
+
+	types, err = AVCNALTypes(data[samples[1].Offset:samples[1].Offset+samples[1].Size], 4)
+	require.NoError(t, err)
+	assert.Equal(t, []int{NALSlice}, types)
+}
+
+func TestAVCNALTypes_Overrun(t *testing.T) {
+	_, err := AVCNALTypes(nal(NALIDR, 8)[:10], 4)
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SampleTable is the per-sample timing, size and sync information of a
+// track in a progressive, non-fragmented file.
+type SampleTable struct {
+	Timescale uint32
+	// DecodeTimes and Durations are in Timescale units.
+	DecodeTimes []uint64
+	Durations   []uint32
+	Sizes       []uint32
+	// Sync marks key frames; every sample is a sync sample when the track
+	// has no stss box.
+	Sync []bool
+}
+
+// Tracks returns the trak boxes of a progressive file.
+func Tracks(boxes []*Box) []*Box {
+	return Find(boxes, "moov/trak")
+}
+
+// HandlerType returns the handler of a trak box, e.g. "vide" or "soun".
+func HandlerType(trak *Box) string {
+	hdlr := Find([]*Box{trak}, "trak/mdia/hdlr")
+	if len(hdlr) == 0 || len(hdlr[0].Payload) < 12 {
+		return ""
+	}
+	return string(hdlr[0].Payload[8:12])
+}
+
+// ParseSampleTable reads the mdhd, stts, stsz and stss boxes of a trak box.
+func ParseSampleTable(trak *Box) (*SampleTable, error) {
+	find := func(path string) (*Box, error) {
+		found := Find([]*Box{trak}, "trak/"+path)
+		if len(found) == 0 {
+			return nil, fmt.Errorf("sample table: no %s box", path)
+		}
+		return found[0], nil
+	}
+
+	mdhd, err := find("mdia/mdhd")
+	if err != nil {
+		return nil, err
+	}
+	st := &SampleTable{}
+	r := &reader{buf: mdhd.Payload}
+	if version := r.uint32() >> 24; version == 1 {
+		r.uint64()
+		r.uint64()
+	} else {
+		r.uint32()
+		r.uint32()
+	}
+	st.Timescale = r.uint32()
+	if r.err != nil {
+		return nil, fmt.Errorf("mdhd: %w", r.err)
+	}
+	if st.Timescale == 0 {
+		return nil, errors.New("mdhd: zero timescale")
+	}
+
+	stts, err := find("mdia/minf/stbl/stts")
+	if err != nil {
+		return nil, err
+	}
+	r = &reader{buf: stts.Payload}
+	r.uint32()
+	var t uint64
+	for n := r.uint32(); n > 0 && r.err == nil; n-- {
+		count, delta := r.uint32(), r.uint32()
+		for ; count > 0 && r.err == nil; count-- {
+			st.DecodeTimes = append(st.DecodeTimes, t)
+			st.Durations = append(st.Durations, delta)
+			t += uint64(delta)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("stts: %w", r.err)
+	}
+
+	stsz, err := find("mdia/minf/stbl/stsz")
+	if err != nil {
+		return nil, err
+	}
+	r = &reader{buf: stsz.Payload}
+	r.uint32()
+	size, count := r.uint32(), r.uint32()
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		if size != 0 {
+			st.Sizes = append(st.Sizes, size)
+		} else {
+			st.Sizes = append(st.Sizes, r.uint32())
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("stsz: %w", r.err)
+	}
+	if len(st.Sizes) != len(st.Durations) {
+		return nil, fmt.Errorf("sample table: %d sizes for %d samples", len(st.Sizes), len(st.Durations))
+	}
+
+	st.Sync = make([]bool, len(st.Sizes))
+	stss := Find([]*Box{trak}, "trak/mdia/minf/stbl/stss")
+	if len(stss) == 0 {
+		for i := range st.Sync {
+			st.Sync[i] = true
+		}
+		return st, nil
+	}
+	r = &reader{buf: stss[0].Payload}
+	r.uint32()
+	for n := r.uint32(); n > 0 && r.err == nil; n-- {
+		i := int(r.uint32()) - 1
+		if r.err == nil && i >= 0 && i < len(st.Sync) {
+			st.Sync[i] = true
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("stss: %w", r.err)
+	}
+	return st, nil
+}
+
+// Time converts a decode time in Timescale units to seconds.
+func (st *SampleTable) Time(t uint64) float64 {
+	return float64(t) / float64(st.Timescale)
+}
+
+// Duration returns the track duration in seconds.
+func (st *SampleTable) Duration() float64 {
+	if len(st.DecodeTimes) == 0 {
+		return 0
+	}
+	last := len(st.DecodeTimes) - 1
+	return st.Time(st.DecodeTimes[last] + uint64(st.Durations[last]))
+}
+
+// KeyframeTimes returns the decode time in seconds of every sync sample.
+func (st *SampleTable) KeyframeTimes() []float64 {
+	var times []float64
+	for i, sync := range st.Sync {
+		if sync {
+			times = append(times, st.Time(st.DecodeTimes[i]))
+		}
+	}
+	return times
+}
+
+// PeakBitrate returns the highest bitrate in bit/s over any window of
+// window seconds, with windows starting at every sample. A VBV-compliant
+// stream stays under maxrate*window + bufsize bits in every window.
+func (st *SampleTable) PeakBitrate(window float64) float64 {
+	if window <= 0 || len(st.Sizes) == 0 {
+		return 0
+	}
+	span := uint64(window * float64(st.Timescale))
+	var peak, bits float64
+	end := 0
+	for start := range st.Sizes {
+		for end < len(st.Sizes) && st.DecodeTimes[end] < st.DecodeTimes[start]+span {
+			bits += float64(st.Sizes[end]) * 8
+			end++
+		}
+		peak = max(peak, bits)
+		bits -= float64(st.Sizes[start]) * 8
+	}
+	return peak / window
+}
+
+// Bitrate returns the mean bitrate of the track in bit/s.
+func (st *SampleTable) Bitrate() float64 {
+	d := st.Duration()
+	if d == 0 {
+		return 0
+	}
+	var bits float64
+	for _, size := range st.Sizes {
+		bits += float64(size) * 8
+	}
+	return bits / d
+}
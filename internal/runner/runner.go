@@ -0,0 +1,187 @@
+// Package runner executes one-shot tool containers and collects their output.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Request describes a single container invocation.
+type Request struct {
+	Image string
+	Cmd   []string
+
+	// Files are copied into the container before it starts.
+	Files []testcontainers.ContainerFile
+
+	// Mounts are attached to the container, typically a bind mount of the
+	// host output directory.
+	Mounts []mount.Mount
+
+	// Networks and NetworkAliases attach the container to user-defined
+	// Docker networks so it can reach sibling containers by name.
+	Networks       []string
+	NetworkAliases map[string][]string
+
+	// HostAccessPorts exposes host ports to the container as
+	// host.testcontainers.internal:<port>.
+	HostAccessPorts []int
+
+	// Stdout receives the container's standard output as it is produced.
+	// When nil, standard output is buffered into Result.Stdout.
+	Stdout io.Writer
+}
+
+// Result holds the outcome of a finished container.
+type Result struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   string
+}
+
+// ExitError is returned when a container exits with a non-zero status.
+type ExitError struct {
+	Image    string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%s exited with code %d: %s", e.Image, e.ExitCode, tail(e.Stderr, 20))
+}
+
+// Bind returns a bind mount of a host directory.
+func Bind(source, target string) mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeBind,
+		Source: source,
+		Target: target,
+	}
+}
+
+// File returns a file to be copied into the container before it starts.
+func File(hostPath, containerPath string) testcontainers.ContainerFile {
+	return testcontainers.ContainerFile{
+		HostFilePath:      hostPath,
+		ContainerFilePath: containerPath,
+		FileMode:          0o644,
+	}
+}
+
+// Run starts the container described by req, waits for it to exit and
+// returns its output. Standard output and standard error are attached before
+// the container starts, so binary output such as image2pipe streams arrives
+// intact. A non-zero exit status is reported as an *ExitError alongside the
+// Result.
+func Run(ctx context.Context, req Request) (*Result, error) {
+	c, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: containerRequest(req, wait.ForExit()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create %s container: %w", req.Image, err)
+	}
+	defer c.Terminate(context.Background())
+
+	cli, err := testcontainers.NewDockerClientWithOpts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("docker client: %w", err)
+	}
+	defer cli.Close()
+
+	attached, err := cli.ContainerAttach(ctx, c.GetContainerID(), container.AttachOptions{
+		Stream: true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("attach to %s container: %w", req.Image, err)
+	}
+	defer attached.Close()
+
+	var stdout, stderr bytes.Buffer
+	out := req.Stdout
+	if out == nil {
+		out = &stdout
+	}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(out, &stderr, attached.Reader)
+		if err != nil {
+			// Nothing takes the output any more, typically because a
+			// consumer of Stdout stopped reading. Kill the container so it
+			// does not block on a full pipe and the wait for its exit ends.
+			attached.Close()
+			cli.ContainerKill(context.Background(), c.GetContainerID(), "KILL")
+		}
+		copied <- err
+	}()
+
+	if err := c.Start(ctx); err != nil {
+		attached.Close()
+		<-copied
+		return nil, fmt.Errorf("start %s container: %w", req.Image, err)
+	}
+	if err := <-copied; err != nil {
+		return nil, fmt.Errorf("read %s output: %w", req.Image, err)
+	}
+
+	state, err := c.State(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("inspect %s container: %w", req.Image, err)
+	}
+
+	res := &Result{
+		ExitCode: state.ExitCode,
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.String(),
+	}
+	if res.ExitCode != 0 {
+		return res, &ExitError{Image: req.Image, ExitCode: res.ExitCode, Stderr: res.Stderr}
+	}
+	return res, nil
+}
+
+// Start launches a long-running container and returns once it is running.
+// The caller owns the container and must terminate it.
+func Start(ctx context.Context, req Request) (testcontainers.Container, error) {
+	c, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: containerRequest(req, nil),
+		Started:          true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("start %s container: %w", req.Image, err)
+	}
+	return c, nil
+}
+
+func containerRequest(req Request, strategy wait.Strategy) testcontainers.ContainerRequest {
+	return testcontainers.ContainerRequest{
+		Image:           req.Image,
+		Cmd:             req.Cmd,
+		Files:           req.Files,
+		Networks:        req.Networks,
+		NetworkAliases:  req.NetworkAliases,
+		HostAccessPorts: req.HostAccessPorts,
+		HostConfigModifier: func(hc *container.HostConfig) {
+			hc.Mounts = append(hc.Mounts, req.Mounts...)
+		},
+		WaitingFor: strategy,
+	}
+}
+
+func tail(s string, lines int) string {
+	parts := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(parts) > lines {
+		parts = parts[len(parts)-lines:]
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,57 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// Scratch is a named Docker volume shared by consecutive containers, for
+// intermediate files such as encoder pass logs that the host never needs.
+// The images are built from scratch with no shell, so a job that needs two
+// runs cannot keep its state inside one container.
+type Scratch struct {
+	Name string
+}
+
+// NewScratch creates an empty volume. It carries the testcontainers labels,
+// so the reaper removes it if the process dies before Remove is called.
+func NewScratch(ctx context.Context) (*Scratch, error) {
+	cli, err := testcontainers.NewDockerClientWithOpts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("docker client: %w", err)
+	}
+	defer cli.Close()
+
+	v, err := cli.VolumeCreate(ctx, volume.CreateOptions{Labels: testcontainers.GenericLabels()})
+	if err != nil {
+		return nil, fmt.Errorf("create scratch volume: %w", err)
+	}
+	return &Scratch{Name: v.Name}, nil
+}
+
+// Mount returns a mount of the volume at target.
+func (s *Scratch) Mount(target string) mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: s.Name,
+		Target: target,
+	}
+}
+
+// Remove deletes the volume and its contents.
+func (s *Scratch) Remove(ctx context.Context) error {
+	cli, err := testcontainers.NewDockerClientWithOpts(ctx)
+	if err != nil {
+		return fmt.Errorf("docker client: %w", err)
+	}
+	defer cli.Close()
+
+	if err := cli.VolumeRemove(ctx, s.Name, true); err != nil {
+		return fmt.Errorf("remove scratch volume %s: %w", s.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MasterPlaylist is an HLS multivariant playlist.
+type MasterPlaylist struct {
+	Version       int
+	Variants      []Variant
+	Media         []Media
+	IFrameStreams []IFrameStream
+}
+
+// Variant is an EXT-X-STREAM-INF entry.
+type Variant struct {
+	Bandwidth        int
+	AverageBandwidth int
+	Codecs           string
+	Resolution       string
+	FrameRate        float64
+	Audio            string
+	Subtitles        string
+	ClosedCaptions   string
+	URI              string
+}
+
+// Media is an EXT-X-MEDIA rendition.
+type Media struct {
+	Type            string
+	GroupID         string
+	Name            string
+	Language        string
+	Default         bool
+	Autoselect      bool
+	Forced          bool
+	Characteristics string
+	Channels        string
+	URI             string
+}
+
+// IFrameStream is an EXT-X-I-FRAME-STREAM-INF entry.
+type IFrameStream struct {
+	Bandwidth  int
+	Codecs     string
+	Resolution string
+	URI        string
+}
+
+// MediaPlaylist is an HLS media playlist.
+type MediaPlaylist struct {
+	Version               int
+	TargetDuration        int
+	MediaSequence         int
+	DiscontinuitySequence int
+	PlaylistType          string
+	IFramesOnly           bool
+	EndList               bool
+	Map                   *Map
+	Keys                  []Key
+	Segments              []Segment
+}
+
+// Segment is a media segment of a media playlist.
+type Segment struct {
+	Duration      float64
+	Title         string
+	URI           string
+	ByteRange     *ByteRange
+	Discontinuity bool
+	// ProgramDateTime is the raw EXT-X-PROGRAM-DATE-TIME value, if any.
+	ProgramDateTime string
+}
+
+// Map is an EXT-X-MAP initialization section.
+type Map struct {
+	URI       string
+	ByteRange *ByteRange
+}
+
+// Key is an EXT-X-KEY tag.
+type Key struct {
+	Method            string
+	URI               string
+	IV                string
+	KeyFormat         string
+	KeyFormatVersions string
+}
+
+// ByteRange is an EXT-X-BYTERANGE sub-range of a resource.
+type ByteRange struct {
+	Length int64
+	Offset int64
+}
+
+// Duration returns the total duration of the playlist's segments in seconds.
+func (p *MediaPlaylist) Duration() float64 {
+	var d float64
+	for _, s := range p.Segments {
+		d += s.Duration
+	}
+	return d
+}
+
+// ReadMasterPlaylist parses the master playlist at path.
+func ReadMasterPlaylist(path string) (*MasterPlaylist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMasterPlaylist(data)
+}
+
+// ParseMasterPlaylist parses an HLS multivariant playlist.
+func ParseMasterPlaylist(data []byte) (*MasterPlaylist, error) {
+	lines, err := playlistLines(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MasterPlaylist{}
+	var pending *Variant
+	for _, line := range lines {
+		tag, value := splitTag(line)
+		switch tag {
+		case "#EXT-X-VERSION":
+			p.Version, _ = strconv.Atoi(value)
+		case "#EXT-X-STREAM-INF":
+			attrs := ParseAttributes(value)
+			pending = &Variant{
+				Bandwidth:        attrs.Int("BANDWIDTH"),
+				AverageBandwidth: attrs.Int("AVERAGE-BANDWIDTH"),
+				Codecs:           attrs["CODECS"],
+				Resolution:       attrs["RESOLUTION"],
+				FrameRate:        attrs.Float("FRAME-RATE"),
+				Audio:            attrs["AUDIO"],
+				Subtitles:        attrs["SUBTITLES"],
+				ClosedCaptions:   attrs["CLOSED-CAPTIONS"],
+			}
+		case "#EXT-X-MEDIA":
+			attrs := ParseAttributes(value)
+			p.Media = append(p.Media, Media{
+				Type:            attrs["TYPE"],
+				GroupID:         attrs["GROUP-ID"],
+				Name:            attrs["NAME"],
+				Language:        attrs["LANGUAGE"],
+				Default:         attrs["DEFAULT"] == "YES",
+				Autoselect:      attrs["AUTOSELECT"] == "YES",
+				Forced:          attrs["FORCED"] == "YES",
+				Characteristics: attrs["CHARACTERISTICS"],
+				Channels:        attrs["CHANNELS"],
+				URI:             attrs["URI"],
+			})
+		case "#EXT-X-I-FRAME-STREAM-INF":
+			attrs := ParseAttributes(value)
+			p.IFrameStreams = append(p.IFrameStreams, IFrameStream{
+				Bandwidth:  attrs.Int("BANDWIDTH"),
+				Codecs:     attrs["CODECS"],
+				Resolution: attrs["RESOLUTION"],
+				URI:        attrs["URI"],
+			})
+		default:
+			if !strings.HasPrefix(line, "#") && pending != nil {
+				pending.URI = line
+				p.Variants = append(p.Variants, *pending)
+				pending = nil
+			}
+		}
+	}
+	return p, nil
+}
+
+// ReadMediaPlaylist parses the media playlist at path.
+func ReadMediaPlaylist(path string) (*MediaPlaylist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMediaPlaylist(data)
+}
+
+// ParseMediaPlaylist parses an HLS media playlist.
+func ParseMediaPlaylist(data []byte) (*MediaPlaylist, error) {
+	lines, err := playlistLines(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MediaPlaylist{}
+	var seg Segment
+	var lastRangeEnd int64
+	for _, line := range lines {
+		tag, value := splitTag(line)
+		switch tag {
+		case "#EXT-X-VERSION":
+			p.Version, _ = strconv.Atoi(value)
+		case "#EXT-X-TARGETDURATION":
+			p.TargetDuration, _ = strconv.Atoi(value)
+		case "#EXT-X-MEDIA-SEQUENCE":
+			p.MediaSequence, _ = strconv.Atoi(value)
+		case "#EXT-X-DISCONTINUITY-SEQUENCE":
+			p.DiscontinuitySequence, _ = strconv.Atoi(value)
+		case "#EXT-X-PLAYLIST-TYPE":
+			p.PlaylistType = value
+		case "#EXT-X-I-FRAMES-ONLY":
+			p.IFramesOnly = true
+		case "#EXT-X-ENDLIST":
+			p.EndList = true
+		case "#EXT-X-DISCONTINUITY":
+			seg.Discontinuity = true
+		case "#EXT-X-PROGRAM-DATE-TIME":
+			seg.ProgramDateTime = value
+		case "#EXT-X-MAP":
+			attrs := ParseAttributes(value)
+			m := &Map{URI: attrs["URI"]}
+			if br, ok := attrs["BYTERANGE"]; ok {
+				r, err := parseByteRange(br, 0)
+				if err != nil {
+					return nil, err
+				}
+				m.ByteRange = r
+			}
+			p.Map = m
+		case "#EXT-X-KEY":
+			attrs := ParseAttributes(value)
+			p.Keys = append(p.Keys, Key{
+				Method:            attrs["METHOD"],
+				URI:               attrs["URI"],
+				IV:                attrs["IV"],
+				KeyFormat:         attrs["KEYFORMAT"],
+				KeyFormatVersions: attrs["KEYFORMATVERSIONS"],
+			})
+		case "#EXTINF":
+			durStr, title, _ := strings.Cut(value, ",")
+			d, err := strconv.ParseFloat(durStr, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EXTINF duration %q: %w", durStr, err)
+			}
+			seg.Duration = d
+			seg.Title = title
+		case "#EXT-X-BYTERANGE":
+			r, err := parseByteRange(value, lastRangeEnd)
+			if err != nil {
+				return nil, err
+			}
+			seg.ByteRange = r
+			lastRangeEnd = r.Offset + r.Length
+		default:
+			if !strings.HasPrefix(line, "#") {
+				seg.URI = line
+				p.Segments = append(p.Segments, seg)
+				seg = Segment{}
+			}
+		}
+	}
+	return p, nil
+}
+
+// Attributes is a parsed HLS attribute list with quotes removed.
+type Attributes map[string]string
+
+// Int returns the integer value of key, or zero.
+func (a Attributes) Int(key string) int {
+	v, _ := strconv.Atoi(a[key])
+	return v
+}
+
+// Float returns the decimal value of key, or zero.
+func (a Attributes) Float(key string) float64 {
+	v, _ := strconv.ParseFloat(a[key], 64)
+	return v
+}
+
+// ParseAttributes parses an attribute list such as
+// `TYPE=AUDIO,GROUP-ID="audio",CODECS="mp4a.40.2,avc1.64001f"`.
+func ParseAttributes(s string) Attributes {
+	attrs := Attributes{}
+	for len(s) > 0 {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(key)
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[key] = value
+		s = rest
+	}
+	return attrs
+}
+
+func playlistLines(data []byte) ([]string, error) {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || lines[0] != "#EXTM3U" {
+		return nil, errors.New("playlist does not start with #EXTM3U")
+	}
+	return lines, nil
+}
+
+func splitTag(line string) (string, string) {
+	if !strings.HasPrefix(line, "#") {
+		return "", line
+	}
+	tag, value, _ := strings.Cut(line, ":")
+	return tag, value
+}
+
+// parseByteRange parses "<length>[@<offset>]". A missing offset continues
+// from the end of the previous range.
+func parseByteRange(s string, next int64) (*ByteRange, error) {
+	lenStr, offStr, hasOffset := strings.Cut(s, "@")
+	length, err := strconv.ParseInt(lenStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid byte range %q: %w", s, err)
+	}
+	offset := next
+	if hasOffset {
+		offset, err = strconv.ParseInt(offStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte range %q: %w", s, err)
+		}
+	}
+	return &ByteRange{Length: length, Offset: offset}, nil
+}
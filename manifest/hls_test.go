@@ -0,0 +1,92 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const masterPlaylist = `#EXTM3U
+## Generated with https://github.com/shaka-project/shaka-packager version v3.4.2
+#EXT-X-VERSION:6
+#EXT-X-INDEPENDENT-SEGMENTS
+
+#EXT-X-MEDIA:TYPE=AUDIO,URI="audio_en.m3u8",GROUP-ID="audio",LANGUAGE="en",NAME="English",DEFAULT=YES,AUTOSELECT=YES,CHANNELS="2"
+#EXT-X-MEDIA:TYPE=SUBTITLES,URI="text_fr.m3u8",GROUP-ID="subs",LANGUAGE="fr",NAME="Français",AUTOSELECT=YES
+
+#EXT-X-STREAM-INF:BANDWIDTH=2811060,AVERAGE-BANDWIDTH=2500000,CODECS="avc1.64001f,mp4a.40.2",RESOLUTION=1280x720,FRAME-RATE=24.000,AUDIO="audio",SUBTITLES="subs"
+video.m3u8
+
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=400000,CODECS="avc1.64001f",RESOLUTION=1280x720,URI="video_iframe.m3u8"
+`
+
+const liveMediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-MAP:URI="init.mp4",BYTERANGE="800@0"
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="skd://key",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"
+#EXTINF:2.000,
+#EXT-X-BYTERANGE:1000@800
+video.mp4
+#EXTINF:2.000,
+#EXT-X-BYTERANGE:1200
+video.mp4
+#EXT-X-DISCONTINUITY
+#EXTINF:1.500,
+segment_9.m4s
+`
+
+func TestParseMasterPlaylist(t *testing.T) {
+	// When: Parsing a multivariant playlist
+	p, err := ParseMasterPlaylist([]byte(masterPlaylist))
+	require.NoError(t, err)
+
+	// Then: Variants, renditions and I-frame streams are decoded
+	assert.Equal(t, 6, p.Version)
+	require.Len(t, p.Variants, 1)
+	v := p.Variants[0]
+	assert.Equal(t, 2811060, v.Bandwidth)
+	assert.Equal(t, "avc1.64001f,mp4a.40.2", v.Codecs)
+	assert.Equal(t, "1280x720", v.Resolution)
+	assert.InDelta(t, 24.0, v.FrameRate, 0.001)
+	assert.Equal(t, "audio", v.Audio)
+	assert.Equal(t, "video.m3u8", v.URI)
+
+	require.Len(t, p.Media, 2)
+	assert.Equal(t, "AUDIO", p.Media[0].Type)
+	assert.True(t, p.Media[0].Default)
+	assert.True(t, p.Media[0].Autoselect)
+	assert.Equal(t, "Français", p.Media[1].Name)
+	assert.False(t, p.Media[1].Default)
+
+	require.Len(t, p.IFrameStreams, 1)
+	assert.Equal(t, "video_iframe.m3u8", p.IFrameStreams[0].URI)
+}
+
+func TestParseMediaPlaylist(t *testing.T) {
+	// When: Parsing a live fMP4 media playlist
+	p, err := ParseMediaPlaylist([]byte(liveMediaPlaylist))
+	require.NoError(t, err)
+
+	// Then: Sequence, init section, keys and byte ranges are decoded
+	assert.Equal(t, 2, p.TargetDuration)
+	assert.Equal(t, 7, p.MediaSequence)
+	assert.False(t, p.EndList)
+	require.NotNil(t, p.Map)
+	assert.Equal(t, &ByteRange{Length: 800, Offset: 0}, p.Map.ByteRange)
+	require.Len(t, p.Keys, 1)
+	assert.Equal(t, "SAMPLE-AES", p.Keys[0].Method)
+
+	require.Len(t, p.Segments, 3)
+	assert.Equal(t, &ByteRange{Length: 1000, Offset: 800}, p.Segments[0].ByteRange)
+	assert.Equal(t, &ByteRange{Length: 1200, Offset: 1800}, p.Segments[1].ByteRange, "offset continues from previous range")
+	assert.True(t, p.Segments[2].Discontinuity)
+	assert.InDelta(t, 5.5, p.Duration(), 0.001)
+}
+
+func TestParseMediaPlaylist_RequiresHeader(t *testing.T) {
+	_, err := ParseMediaPlaylist([]byte("#EXTINF:2,\nseg.ts\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,274 @@
+// Package manifest parses the DASH and HLS manifests written by Shaka Packager
+// and FFmpeg so their structure can be validated programmatically.
+package manifest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// XML namespaces used by DASH content protection descriptors.
+const (
+	NamespaceCENC      = "urn:mpeg:cenc:2013"
+	NamespacePlayReady = "urn:microsoft:playready"
+)
+
+// Well-known ContentProtection scheme identifiers.
+const (
+	SchemeMP4Protection = "urn:mpeg:dash:mp4protection:2011"
+	SchemeWidevine      = "urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed"
+	SchemePlayReady     = "urn:uuid:9a04f079-9840-4286-ab92-e65be0885f95"
+	SchemeCommon        = "urn:uuid:1077efec-c0b2-4d02-ace3-3c1e52e2fb4b"
+)
+
+// SchemeThumbnailTile marks a DASH-IF thumbnail tile Representation; the
+// descriptor value is the grid, e.g. "10x10".
+const SchemeThumbnailTile = "http://dashif.org/thumbnail_tile"
+
+// MPD is the root of a DASH Media Presentation Description.
+type MPD struct {
+	XMLName                    xml.Name `xml:"MPD"`
+	Type                       string   `xml:"type,attr"`
+	Profiles                   string   `xml:"profiles,attr"`
+	MediaPresentationDuration  string   `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime              string   `xml:"minBufferTime,attr"`
+	AvailabilityStartTime      string   `xml:"availabilityStartTime,attr"`
+	PublishTime                string   `xml:"publishTime,attr"`
+	MinimumUpdatePeriod        string   `xml:"minimumUpdatePeriod,attr"`
+	TimeShiftBufferDepth       string   `xml:"timeShiftBufferDepth,attr"`
+	SuggestedPresentationDelay string   `xml:"suggestedPresentationDelay,attr"`
+	Periods                    []Period `xml:"Period"`
+}
+
+// Period is a DASH Period.
+type Period struct {
+	ID             string          `xml:"id,attr"`
+	Start          string          `xml:"start,attr"`
+	Duration       string          `xml:"duration,attr"`
+	AdaptationSets []AdaptationSet `xml:"AdaptationSet"`
+}
+
+// AdaptationSet groups interchangeable Representations.
+type AdaptationSet struct {
+	ID                     string              `xml:"id,attr"`
+	ContentType            string              `xml:"contentType,attr"`
+	MimeType               string              `xml:"mimeType,attr"`
+	Codecs                 string              `xml:"codecs,attr"`
+	Lang                   string              `xml:"lang,attr"`
+	Width                  int                 `xml:"width,attr"`
+	Height                 int                 `xml:"height,attr"`
+	MaxWidth               int                 `xml:"maxWidth,attr"`
+	MaxHeight              int                 `xml:"maxHeight,attr"`
+	FrameRate              string              `xml:"frameRate,attr"`
+	Par                    string              `xml:"par,attr"`
+	SegmentAlignment       string              `xml:"segmentAlignment,attr"`
+	ContentProtections     []ContentProtection `xml:"ContentProtection"`
+	Roles                  []Descriptor        `xml:"Role"`
+	Accessibilities        []Descriptor        `xml:"Accessibility"`
+	EssentialProperties    []Descriptor        `xml:"EssentialProperty"`
+	SupplementalProperties []Descriptor        `xml:"SupplementalProperty"`
+	SegmentTemplate        *SegmentTemplate    `xml:"SegmentTemplate"`
+	Representations        []Representation    `xml:"Representation"`
+}
+
+// Representation is a single encoded alternative of the content.
+type Representation struct {
+	ID                  string              `xml:"id,attr"`
+	Bandwidth           int                 `xml:"bandwidth,attr"`
+	Codecs              string              `xml:"codecs,attr"`
+	MimeType            string              `xml:"mimeType,attr"`
+	Width               int                 `xml:"width,attr"`
+	Height              int                 `xml:"height,attr"`
+	FrameRate           string              `xml:"frameRate,attr"`
+	MaxPlayoutRate      string              `xml:"maxPlayoutRate,attr"`
+	AudioSamplingRate   string              `xml:"audioSamplingRate,attr"`
+	BaseURL             string              `xml:"BaseURL"`
+	ContentProtections  []ContentProtection `xml:"ContentProtection"`
+	EssentialProperties []Descriptor        `xml:"EssentialProperty"`
+	SegmentBase         *SegmentBase        `xml:"SegmentBase"`
+	SegmentTemplate     *SegmentTemplate    `xml:"SegmentTemplate"`
+}
+
+// Descriptor is a generic scheme/value pair such as Role or EssentialProperty.
+type Descriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+// ContentProtection signals that a stream is encrypted and how to obtain keys.
+type ContentProtection struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+	DefaultKID  string `xml:"urn:mpeg:cenc:2013 default_KID,attr"`
+	PSSH        string `xml:"urn:mpeg:cenc:2013 pssh"`
+	PRO         string `xml:"urn:microsoft:playready pro"`
+}
+
+// SegmentBase addresses a single-file Representation by byte ranges.
+type SegmentBase struct {
+	IndexRange     string         `xml:"indexRange,attr"`
+	Timescale      int            `xml:"timescale,attr"`
+	Initialization Initialization `xml:"Initialization"`
+}
+
+// Initialization locates the initialization segment.
+type Initialization struct {
+	Range     string `xml:"range,attr"`
+	SourceURL string `xml:"sourceURL,attr"`
+}
+
+// SegmentTemplate addresses segments through a URL template.
+type SegmentTemplate struct {
+	Timescale              int              `xml:"timescale,attr"`
+	Duration               int              `xml:"duration,attr"`
+	StartNumber            int              `xml:"startNumber,attr"`
+	PresentationTimeOffset int64            `xml:"presentationTimeOffset,attr"`
+	Media                  string           `xml:"media,attr"`
+	Initialization         string           `xml:"initialization,attr"`
+	SegmentTimeline        *SegmentTimeline `xml:"SegmentTimeline"`
+}
+
+// SegmentTimeline lists segment start times and durations explicitly.
+type SegmentTimeline struct {
+	S []TimelineEntry `xml:"S"`
+}
+
+// TimelineEntry is one S element of a SegmentTimeline.
+type TimelineEntry struct {
+	T int64 `xml:"t,attr"`
+	D int64 `xml:"d,attr"`
+	R int   `xml:"r,attr"`
+}
+
+// Segments returns the number of segments described by the timeline,
+// expanding repeat counts.
+func (tl *SegmentTimeline) Segments() int {
+	if tl == nil {
+		return 0
+	}
+	n := 0
+	for _, s := range tl.S {
+		n += 1 + s.R
+	}
+	return n
+}
+
+// ReadMPD parses the MPD at path.
+func ReadMPD(path string) (*MPD, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMPD(data)
+}
+
+// ParseMPD parses an MPD document.
+func ParseMPD(data []byte) (*MPD, error) {
+	var mpd MPD
+	if err := xml.Unmarshal(data, &mpd); err != nil {
+		return nil, fmt.Errorf("parse MPD: %w", err)
+	}
+	return &mpd, nil
+}
+
+// AdaptationSets returns the adaptation sets of every period in order.
+func (m *MPD) AdaptationSets() []AdaptationSet {
+	var sets []AdaptationSet
+	for _, p := range m.Periods {
+		sets = append(sets, p.AdaptationSets...)
+	}
+	return sets
+}
+
+// AdaptationSetsByType returns the adaptation sets whose content type, or
+// failing that mime type prefix, matches contentType ("video", "audio",
+// "text" or "image").
+func (m *MPD) AdaptationSetsByType(contentType string) []AdaptationSet {
+	var sets []AdaptationSet
+	for _, as := range m.AdaptationSets() {
+		if as.Type() == contentType {
+			sets = append(sets, as)
+		}
+	}
+	return sets
+}
+
+// Type returns the content type of the adaptation set, derived from the mime
+// type when contentType is absent.
+func (as AdaptationSet) Type() string {
+	if as.ContentType != "" {
+		return as.ContentType
+	}
+	mime := as.MimeType
+	if mime == "" && len(as.Representations) > 0 {
+		mime = as.Representations[0].MimeType
+	}
+	for i, r := range mime {
+		if r == '/' {
+			mime = mime[:i]
+			break
+		}
+	}
+	if mime == "application" {
+		return "text"
+	}
+	return mime
+}
+
+// ThumbnailTile returns the grid of a DASH-IF thumbnail tile Representation.
+func (r Representation) ThumbnailTile() (columns, rows int, ok bool) {
+	for _, p := range r.EssentialProperties {
+		if p.SchemeIDURI != SchemeThumbnailTile {
+			continue
+		}
+		if _, err := fmt.Sscanf(p.Value, "%dx%d", &columns, &rows); err != nil {
+			return 0, 0, false
+		}
+		return columns, rows, true
+	}
+	return 0, 0, false
+}
+
+// HasRole reports whether the adaptation set carries the given DASH role.
+func (as AdaptationSet) HasRole(value string) bool {
+	for _, r := range as.Roles {
+		if r.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// ParseDuration parses an ISO 8601 duration such as "PT1M34.5S" as used by
+// MPD attributes. Years and months are not supported.
+func ParseDuration(s string) (time.Duration, error) {
+	m := isoDuration.FindStringSubmatch(s)
+	if m == nil || s == "P" || s == "PT" {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(m[i+1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q: %w", s, err)
+		}
+		d += time.Duration(v * float64(unit))
+	}
+	return d, nil
+}
+
+// FormatDuration renders d as an ISO 8601 duration suitable for MPD
+// attributes.
+func FormatDuration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S"
+}
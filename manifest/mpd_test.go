@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const protectedMPD = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" xmlns:cenc="urn:mpeg:cenc:2013" xmlns:mspr="urn:microsoft:playready" type="static" mediaPresentationDuration="PT634.566S">
+  <Period id="0">
+    <AdaptationSet id="0" contentType="video" width="1280" height="720">
+      <ContentProtection value="cenc" schemeIdUri="urn:mpeg:dash:mp4protection:2011" cenc:default_KID="00112233-4455-6677-8899-aabbccddeeff"/>
+      <ContentProtection schemeIdUri="urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed">
+        <cenc:pssh>AAAAOHBzc2g=</cenc:pssh>
+      </ContentProtection>
+      <ContentProtection value="MSPR 2.0" schemeIdUri="urn:uuid:9a04f079-9840-4286-ab92-e65be0885f95">
+        <mspr:pro>PRO=</mspr:pro>
+      </ContentProtection>
+      <Representation id="0" bandwidth="2500000" codecs="avc1.64001f" mimeType="video/mp4">
+        <BaseURL>video.mp4</BaseURL>
+        <SegmentBase indexRange="1000-2000" timescale="12800">
+          <Initialization range="0-999"/>
+        </SegmentBase>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet id="1" lang="en">
+      <Representation id="1" bandwidth="128000" mimeType="audio/mp4"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+func TestParseMPD_ContentProtection(t *testing.T) {
+	// When: Parsing a protected MPD
+	mpd, err := ParseMPD([]byte(protectedMPD))
+	require.NoError(t, err)
+
+	// Then: Namespaced protection attributes and elements are decoded
+	require.Len(t, mpd.AdaptationSets(), 2)
+	video := mpd.AdaptationSetsByType("video")
+	require.Len(t, video, 1)
+	cps := video[0].ContentProtections
+	require.Len(t, cps, 3)
+	assert.Equal(t, SchemeMP4Protection, cps[0].SchemeIDURI)
+	assert.Equal(t, "00112233-4455-6677-8899-aabbccddeeff", cps[0].DefaultKID)
+	assert.Equal(t, "AAAAOHBzc2g=", cps[1].PSSH)
+	assert.Equal(t, "PRO=", cps[2].PRO)
+	assert.Equal(t, "video.mp4", video[0].Representations[0].BaseURL)
+	assert.Equal(t, "0-999", video[0].Representations[0].SegmentBase.Initialization.Range)
+
+	// Then: Content type falls back to the representation mime type
+	assert.Len(t, mpd.AdaptationSetsByType("audio"), 1)
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT634.566S": 634566 * time.Millisecond,
+		"PT1M30S":    90 * time.Second,
+		"PT2H":       2 * time.Hour,
+		"P1DT1S":     24*time.Hour + time.Second,
+	}
+	for in, want := range tests {
+		got, err := ParseDuration(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"", "P", "PT", "1S", "PT1X"} {
+		_, err := ParseDuration(in)
+		assert.Error(t, err, in)
+	}
+
+	assert.Equal(t, "PT2.5S", FormatDuration(2500*time.Millisecond))
+}
+
+func TestRepresentation_ThumbnailTile(t *testing.T) {
+	rep := Representation{EssentialProperties: []Descriptor{{SchemeIDURI: SchemeThumbnailTile, Value: "10x4"}}}
+	cols, rows, ok := rep.ThumbnailTile()
+	require.True(t, ok)
+	assert.Equal(t, 10, cols)
+	assert.Equal(t, 4, rows)
+
+	_, _, ok = Representation{}.ThumbnailTile()
+	assert.False(t, ok)
+}
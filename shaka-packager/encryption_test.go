@@ -0,0 +1,312 @@
+package shakapackager
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/internal/mp4"
+	"github.com/veloxpack/tools/manifest"
+)
+
+func TestRawKeyEncryption_Args(t *testing.T) {
+	// Given: Two supplied keys with FairPlay-compatible settings
+	enc := RawKeyEncryption{
+		Keys: []Key{
+			{Label: "AUDIO", KeyID: strings.Repeat("a", 32), Key: strings.Repeat("b", 32)},
+			{Label: "SD", KeyID: strings.Repeat("c", 32), Key: strings.Repeat("d", 32), IV: strings.Repeat("e", 32)},
+		},
+		Scheme:               SchemeCBCS,
+		Systems:              []ProtectionSystem{SystemWidevine, SystemFairPlay},
+		ClearLead:            2,
+		CryptoPeriodDuration: 30,
+	}
+
+	// When: Building the packager flags
+	require.NoError(t, enc.Validate())
+	args := strings.Join(enc.Args(), " ")
+
+	// Then: Every option is rendered in packager syntax
+	assert.Contains(t, args, "--enable_raw_key_encryption")
+	assert.Contains(t, args, "--keys label=AUDIO:key_id="+strings.Repeat("a", 32)+":key="+strings.Repeat("b", 32)+",label=SD:")
+	assert.Contains(t, args, ":iv="+strings.Repeat("e", 32))
+	assert.Contains(t, args, "--protection_scheme cbcs")
+	assert.Contains(t, args, "--protection_systems Widevine,FairPlay")
+	assert.Contains(t, args, "--clear_lead 2")
+	assert.Contains(t, args, "--crypto_period_duration 30")
+}
+
+func TestRawKeyEncryption_Validate(t *testing.T) {
+	key, err := GenerateKey("SD")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		enc  RawKeyEncryption
+	}{
+		{"no keys", RawKeyEncryption{}},
+		{"short key id", RawKeyEncryption{Keys: []Key{{KeyID: "abcd", Key: key.Key}}}},
+		{"duplicate label", RawKeyEncryption{Keys: []Key{key, key}}},
+		{"unknown scheme", RawKeyEncryption{Keys: []Key{key}, Scheme: "aes"}},
+		{"fairplay without cbcs", RawKeyEncryption{Keys: []Key{key}, Systems: []ProtectionSystem{SystemFairPlay}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.enc.Validate())
+		})
+	}
+}
+
+// Test 9: Raw key CENC encryption with PSSH for multiple DRM systems
+func TestShakaPackager_RawKeyEncryption_MultiDRM(t *testing.T) {
+	// Given: A test video file and locally generated keys
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	audioKey, err := GenerateKey("AUDIO")
+	require.NoError(t, err)
+	videoKey, err := GenerateKey("SD")
+	require.NoError(t, err)
+
+	// When: Package with raw key encryption for Widevine, PlayReady and the common system
+	res, err := Run(context.Background(), Job{
+		Streams: []Stream{
+			{Input: absPath, Selector: "audio", Output: "audio.mp4", DRMLabel: "AUDIO"},
+			{Input: absPath, Selector: "video", Output: "video.mp4", DRMLabel: "SD"},
+		},
+		OutputDir: outputPath,
+		MPDOutput: "manifest.mpd",
+		Encryption: &RawKeyEncryption{
+			Keys:    []Key{audioKey, videoKey},
+			Scheme:  SchemeCENC,
+			Systems: []ProtectionSystem{SystemWidevine, SystemPlayReady, SystemCommon},
+		},
+	})
+	if res != nil {
+		t.Log("Shaka Packager output:", res.Stderr)
+	}
+	require.NoError(t, err)
+
+	// Then: Init segments carry a PSSH per system and a tenc with the track key
+	for file, key := range map[string]Key{"audio.mp4": audioKey, "video.mp4": videoKey} {
+		boxes, err := mp4.ReadFile(filepath.Join(outputPath, file))
+		require.NoError(t, err)
+
+		systems := psshSystems(t, mp4.Find(boxes, "moov/pssh"))
+		assert.Contains(t, systems, mp4.SystemWidevine, file)
+		assert.Contains(t, systems, mp4.SystemPlayReady, file)
+		assert.Contains(t, systems, mp4.SystemCommon, file)
+
+		assert.Equal(t, "cenc", schemeType(t, boxes), file)
+		tenc := trackEncryption(t, boxes)
+		assert.True(t, tenc.IsProtected, file)
+		assert.Equal(t, key.KeyID, tenc.DefaultKeyID, file)
+	}
+
+	// Then: The MPD advertises every system with the matching default KID
+	mpd, err := manifest.ReadMPD(filepath.Join(outputPath, "manifest.mpd"))
+	require.NoError(t, err)
+	sets := mpd.AdaptationSets()
+	require.Len(t, sets, 2)
+	for _, as := range sets {
+		schemes := map[string]manifest.ContentProtection{}
+		for _, cp := range as.ContentProtections {
+			schemes[cp.SchemeIDURI] = cp
+		}
+		require.Contains(t, schemes, manifest.SchemeMP4Protection)
+		assert.Equal(t, "cenc", schemes[manifest.SchemeMP4Protection].Value)
+
+		want := videoKey.KeyID
+		if as.Type() == "audio" {
+			want = audioKey.KeyID
+		}
+		assert.Equal(t, want, strings.ReplaceAll(schemes[manifest.SchemeMP4Protection].DefaultKID, "-", ""))
+
+		require.Contains(t, schemes, manifest.SchemeWidevine)
+		assert.NotEmpty(t, schemes[manifest.SchemeWidevine].PSSH)
+		require.Contains(t, schemes, manifest.SchemePlayReady)
+		assert.NotEmpty(t, schemes[manifest.SchemePlayReady].PSSH+schemes[manifest.SchemePlayReady].PRO)
+	}
+}
+
+// Test 10: Every Common Encryption protection scheme
+func TestShakaPackager_RawKeyEncryption_ProtectionSchemes(t *testing.T) {
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	for _, scheme := range []ProtectionScheme{SchemeCENC, SchemeCBC1, SchemeCENS, SchemeCBCS} {
+		t.Run(string(scheme), func(t *testing.T) {
+			// Given: A fresh key and output directory
+			outputPath := createTempDir(t)
+			defer cleanupFiles(t, outputPath)
+
+			key, err := GenerateKey("")
+			require.NoError(t, err)
+
+			// When: Package the video stream with the scheme under test
+			res, err := Run(context.Background(), Job{
+				Streams: []Stream{
+					{Input: absPath, Selector: "video", Output: "video.mp4"},
+				},
+				OutputDir: outputPath,
+				MPDOutput: "manifest.mpd",
+				Encryption: &RawKeyEncryption{
+					Keys:   []Key{key},
+					Scheme: scheme,
+				},
+			})
+			if res != nil {
+				t.Log("Shaka Packager output:", res.Stderr)
+			}
+			require.NoError(t, err)
+
+			// Then: schm and tenc reflect the scheme and its pattern
+			boxes, err := mp4.ReadFile(filepath.Join(outputPath, "video.mp4"))
+			require.NoError(t, err)
+			assert.Equal(t, string(scheme), schemeType(t, boxes))
+
+			tenc := trackEncryption(t, boxes)
+			assert.Equal(t, key.KeyID, tenc.DefaultKeyID)
+			switch scheme {
+			case SchemeCENS, SchemeCBCS:
+				// Pattern encryption: 1 encrypted block followed by 9 clear blocks for video
+				assert.Equal(t, uint8(1), tenc.CryptByteBlock)
+				assert.Equal(t, uint8(9), tenc.SkipByteBlock)
+			}
+			if scheme == SchemeCBCS {
+				assert.Zero(t, tenc.PerSampleIVSize, "cbcs uses a constant IV")
+				assert.NotEmpty(t, tenc.DefaultConstantIV)
+			}
+
+			mpd, err := manifest.ReadMPD(filepath.Join(outputPath, "manifest.mpd"))
+			require.NoError(t, err)
+			var values []string
+			for _, cp := range mpd.AdaptationSets()[0].ContentProtections {
+				if cp.SchemeIDURI == manifest.SchemeMP4Protection {
+					values = append(values, cp.Value)
+				}
+			}
+			assert.Equal(t, []string{string(scheme)}, values)
+		})
+	}
+}
+
+// Test 11: Clear lead leaves the first fragments unencrypted
+func TestShakaPackager_RawKeyEncryption_ClearLead(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	key, err := GenerateKey("")
+	require.NoError(t, err)
+
+	// When: Package with a 10 second clear lead and 2 second fragments
+	res, err := Run(context.Background(), Job{
+		Streams: []Stream{
+			{Input: absPath, Selector: "video", Output: "video.mp4"},
+		},
+		OutputDir:        outputPath,
+		FragmentDuration: 2,
+		SegmentDuration:  2,
+		Encryption: &RawKeyEncryption{
+			Keys:      []Key{key},
+			ClearLead: 10,
+		},
+	})
+	if res != nil {
+		t.Log("Shaka Packager output:", res.Stderr)
+	}
+	require.NoError(t, err)
+
+	// Then: The first fragment has no sample encryption data but later ones do
+	boxes, err := mp4.ReadFile(filepath.Join(outputPath, "video.mp4"))
+	require.NoError(t, err)
+	fragments := mp4.Find(boxes, "moof/traf")
+	require.Greater(t, len(fragments), 5)
+	assert.Empty(t, mp4.FindAll(fragments[0].Children, "senc"), "first fragment should be clear")
+	assert.NotEmpty(t, mp4.FindAll(fragments[len(fragments)-1].Children, "senc"), "last fragment should be encrypted")
+}
+
+// Test 12: Key rotation puts per-period PSSH boxes in the fragments
+func TestShakaPackager_RawKeyEncryption_KeyRotation(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	key, err := GenerateKey("")
+	require.NoError(t, err)
+
+	// When: Package with a 60 second crypto period
+	res, err := Run(context.Background(), Job{
+		Streams: []Stream{
+			{Input: absPath, Selector: "video", Output: "video.mp4"},
+		},
+		OutputDir: outputPath,
+		MPDOutput: "manifest.mpd",
+		Encryption: &RawKeyEncryption{
+			Keys:                 []Key{key},
+			Systems:              []ProtectionSystem{SystemCommon},
+			CryptoPeriodDuration: 60,
+		},
+	})
+	if res != nil {
+		t.Log("Shaka Packager output:", res.Stderr)
+	}
+	require.NoError(t, err)
+
+	// Then: Fragments carry common system PSSH boxes announcing rotating key IDs
+	boxes, err := mp4.ReadFile(filepath.Join(outputPath, "video.mp4"))
+	require.NoError(t, err)
+	fragmentPSSH := mp4.Find(boxes, "moof/pssh")
+	require.NotEmpty(t, fragmentPSSH, "key rotation should place PSSH boxes in moof")
+
+	keyIDs := map[string]bool{}
+	for _, b := range fragmentPSSH {
+		pssh, err := mp4.ParsePSSH(b)
+		require.NoError(t, err)
+		for _, kid := range pssh.KeyIDs {
+			keyIDs[kid] = true
+		}
+	}
+	assert.Greater(t, len(keyIDs), 1, "key IDs should change between crypto periods")
+
+	verifyFileExists(t, filepath.Join(outputPath, "manifest.mpd"))
+}
+
+func psshSystems(t *testing.T, boxes []*mp4.Box) []string {
+	var systems []string
+	for _, b := range boxes {
+		pssh, err := mp4.ParsePSSH(b)
+		require.NoError(t, err)
+		systems = append(systems, pssh.SystemID)
+	}
+	return systems
+}
+
+func schemeType(t *testing.T, boxes []*mp4.Box) string {
+	schm := mp4.FindAll(boxes, "schm")
+	require.NotEmpty(t, schm, "encrypted track should have a schm box")
+	scheme, err := mp4.SchemeType(schm[0])
+	require.NoError(t, err)
+	return scheme
+}
+
+func trackEncryption(t *testing.T, boxes []*mp4.Box) *mp4.TrackEncryption {
+	tenc := mp4.FindAll(boxes, "tenc")
+	require.NotEmpty(t, tenc, "encrypted track should have a tenc box")
+	parsed, err := mp4.ParseTenc(tenc[0])
+	require.NoError(t, err)
+	return parsed
+}
@@ -0,0 +1,339 @@
+package shakapackager
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// WidevineSystemID is the Widevine DRM system ID.
+const WidevineSystemID = "edef8ba979d64acea3c827dcd51d21ed"
+
+// KeyRequest is the common encryption request the packager sends to a
+// Widevine key server, after the signed envelope has been verified.
+type KeyRequest struct {
+	ContentID []byte `json:"content_id"`
+	Policy    string `json:"policy"`
+	Tracks    []struct {
+		Type string `json:"type"`
+	} `json:"tracks"`
+	DRMTypes               []string `json:"drm_types"`
+	FirstCryptoPeriodIndex uint32   `json:"first_crypto_period_index"`
+	CryptoPeriodCount      uint32   `json:"crypto_period_count"`
+	ProtectionScheme       any      `json:"protection_scheme"`
+
+	// Signer is taken from the signed envelope.
+	Signer string `json:"-"`
+}
+
+// TrackKey is a content key issued by the KeyServer.
+type TrackKey struct {
+	Type              string
+	KeyID             []byte
+	Key               []byte
+	CryptoPeriodIndex uint32
+}
+
+// KeyServer is a local stand-in for a Widevine common encryption key server.
+// It speaks the signed JSON protocol used by --enable_widevine_encryption:
+// it verifies the request signature, issues a random content key per track
+// type (and per crypto period when key rotation is requested) and returns
+// Widevine PSSH data for each key. Keys are stable for a content ID, track
+// type and crypto period, so repeated requests get the same keys.
+type KeyServer struct {
+	// Signer is the expected signer name; empty accepts any signer.
+	Signer string
+	// AESSigningKey and AESSigningIV verify AES request signatures. When
+	// the key is empty, signatures are not checked.
+	AESSigningKey []byte
+	AESSigningIV  []byte
+
+	mu       sync.Mutex
+	requests []KeyRequest
+	keys     map[string]TrackKey
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewKeyServer returns a key server that verifies AES signatures with the
+// given hex-encoded key and IV, the same values passed to the packager as
+// --aes_signing_key and --aes_signing_iv.
+func NewKeyServer(signer, aesSigningKey, aesSigningIV string) (*KeyServer, error) {
+	key, err := hex.DecodeString(aesSigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("key server: invalid AES signing key: %w", err)
+	}
+	iv, err := hex.DecodeString(aesSigningIV)
+	if err != nil {
+		return nil, fmt.Errorf("key server: invalid AES signing IV: %w", err)
+	}
+	return &KeyServer{Signer: signer, AESSigningKey: key, AESSigningIV: iv}, nil
+}
+
+// Start listens on a random local port and serves requests in the
+// background.
+func (s *KeyServer) Start() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("key server: listen: %w", err)
+	}
+	s.listener = l
+	s.server = &http.Server{Handler: s}
+	go s.server.Serve(l)
+	return nil
+}
+
+// Port returns the port the server listens on.
+func (s *KeyServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// URL returns the key server URL as seen from a container that was given
+// access to Port through HostAccessPorts.
+func (s *KeyServer) URL() string {
+	return "http://host.testcontainers.internal:" + strconv.Itoa(s.Port()) + "/cenc/getcontentkey"
+}
+
+// Close stops the server.
+func (s *KeyServer) Close() error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+// Requests returns the verified requests received so far.
+func (s *KeyServer) Requests() []KeyRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]KeyRequest(nil), s.requests...)
+}
+
+// Keys returns every key issued so far.
+func (s *KeyServer) Keys() []TrackKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]TrackKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+type signedRequest struct {
+	Request   string `json:"request"`
+	Signature string `json:"signature"`
+	Signer    string `json:"signer"`
+}
+
+type keyResponse struct {
+	Status string             `json:"status"`
+	DRM    []drmSystem        `json:"drm"`
+	Tracks []keyResponseTrack `json:"tracks"`
+}
+
+type drmSystem struct {
+	Type     string `json:"type"`
+	SystemID string `json:"system_id"`
+}
+
+type keyResponseTrack struct {
+	Type              string     `json:"type"`
+	KeyID             []byte     `json:"key_id"`
+	Key               []byte     `json:"key"`
+	PSSH              []psshData `json:"pssh"`
+	CryptoPeriodIndex *uint32    `json:"crypto_period_index,omitempty"`
+}
+
+type psshData struct {
+	DRMType string `json:"drm_type"`
+	Data    []byte `json:"data"`
+}
+
+// ServeHTTP implements the key server endpoint.
+func (s *KeyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var signed signedRequest
+	if err := json.NewDecoder(r.Body).Decode(&signed); err != nil {
+		http.Error(w, "malformed signed request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req, err := s.verify(signed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	resp := s.issue(req)
+	inner, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"response": base64.StdEncoding.EncodeToString(inner),
+	})
+}
+
+func (s *KeyServer) verify(signed signedRequest) (*KeyRequest, error) {
+	if s.Signer != "" && signed.Signer != s.Signer {
+		return nil, fmt.Errorf("unknown signer %q", signed.Signer)
+	}
+
+	// The request is JSON, either embedded as a string or base64 encoded
+	// depending on the packager version.
+	message := []byte(signed.Request)
+	if decoded, err := base64.StdEncoding.DecodeString(signed.Request); err == nil && json.Valid(decoded) {
+		message = decoded
+	}
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+	if err := s.verifySignature(message, signature); err != nil {
+		return nil, err
+	}
+
+	var req KeyRequest
+	if err := json.Unmarshal(message, &req); err != nil {
+		return nil, fmt.Errorf("malformed request: %w", err)
+	}
+	req.Signer = signed.Signer
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+	return &req, nil
+}
+
+func (s *KeyServer) verifySignature(message, signature []byte) error {
+	if len(s.AESSigningKey) == 0 {
+		return nil
+	}
+	want, err := AESSignature(message, s.AESSigningKey, s.AESSigningIV)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(want, signature) {
+		return errors.New("request signature does not verify")
+	}
+	return nil
+}
+
+func (s *KeyServer) issue(req *KeyRequest) keyResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = map[string]TrackKey{}
+	}
+
+	resp := keyResponse{
+		Status: "OK",
+		DRM: []drmSystem{{
+			Type:     "WIDEVINE",
+			SystemID: WidevineSystemID,
+		}},
+	}
+
+	periods := []uint32{0}
+	rotating := req.CryptoPeriodCount > 0
+	if rotating {
+		periods = periods[:0]
+		for i := uint32(0); i < req.CryptoPeriodCount; i++ {
+			periods = append(periods, req.FirstCryptoPeriodIndex+i)
+		}
+	}
+
+	for _, track := range req.Tracks {
+		for _, period := range periods {
+			id := fmt.Sprintf("%x/%s/%d", req.ContentID, track.Type, period)
+			key, ok := s.keys[id]
+			if !ok {
+				key = TrackKey{
+					Type:              track.Type,
+					KeyID:             randomBytes(16),
+					Key:               randomBytes(16),
+					CryptoPeriodIndex: period,
+				}
+				s.keys[id] = key
+			}
+
+			rt := keyResponseTrack{
+				Type:  track.Type,
+				KeyID: key.KeyID,
+				Key:   key.Key,
+				PSSH: []psshData{{
+					DRMType: "WIDEVINE",
+					Data:    widevinePSSHData(key.KeyID, req.ContentID),
+				}},
+			}
+			if rotating {
+				period := period
+				rt.CryptoPeriodIndex = &period
+			}
+			resp.Tracks = append(resp.Tracks, rt)
+		}
+	}
+	return resp
+}
+
+// AESSignature signs a key request the way the packager does: the SHA-1
+// digest of the message encrypted with AES-CBC and PKCS#5 padding.
+func AESSignature(message, key, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("AES signing key: %w", err)
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("AES signing IV must be %d bytes", block.BlockSize())
+	}
+	digest := sha1.Sum(message)
+	pad := block.BlockSize() - len(digest)%block.BlockSize()
+	plain := append(digest[:], bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, plain)
+	return out, nil
+}
+
+// widevinePSSHData encodes a minimal WidevinePsshData protobuf carrying the
+// AES-CTR algorithm, the key ID and the content ID.
+func widevinePSSHData(keyID, contentID []byte) []byte {
+	var b []byte
+	b = append(b, 0x08, 0x01) // algorithm = AESCTR
+	b = appendProtoBytes(b, 2, keyID)
+	if len(contentID) > 0 {
+		b = appendProtoBytes(b, 4, contentID)
+	}
+	return b
+}
+
+func appendProtoBytes(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,225 @@
+package shakapackager
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/internal/mp4"
+	"github.com/veloxpack/tools/manifest"
+)
+
+// Test signing credentials, the same values published for the widevine_test signer
+const (
+	testSigner        = "widevine_test"
+	testAESSigningKey = "1ae8ccd0e7985cc0b6203a55855a1034afc252980e970ca90e5202689f947ab9"
+	testAESSigningIV  = "d58ce954203b7c9a9a9d467f59839249"
+)
+
+func postKeyRequest(t *testing.T, url string, request map[string]any, key, iv string) *http.Response {
+	message, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	keyBytes, _ := hex.DecodeString(key)
+	ivBytes, _ := hex.DecodeString(iv)
+	signature, err := AESSignature(message, keyBytes, ivBytes)
+	require.NoError(t, err)
+
+	body, err := json.Marshal(map[string]string{
+		"request":   base64.StdEncoding.EncodeToString(message),
+		"signature": base64.StdEncoding.EncodeToString(signature),
+		"signer":    testSigner,
+	})
+	require.NoError(t, err)
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	return resp
+}
+
+func TestKeyServer_SignedRequest(t *testing.T) {
+	// Given: A key server expecting the test signer
+	ks, err := NewKeyServer(testSigner, testAESSigningKey, testAESSigningIV)
+	require.NoError(t, err)
+	srv := httptest.NewServer(ks)
+	defer srv.Close()
+
+	request := map[string]any{
+		"content_id": base64.StdEncoding.EncodeToString([]byte("title-1")),
+		"tracks":     []map[string]string{{"type": "SD"}, {"type": "AUDIO"}},
+		"drm_types":  []string{"WIDEVINE"},
+	}
+
+	// When: Posting a correctly signed request
+	resp := postKeyRequest(t, srv.URL, request, testAESSigningKey, testAESSigningIV)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Then: The response wraps a base64 JSON document with a key per track
+	decoded := decodeKeyResponse(t, resp)
+	assert.Equal(t, "OK", decoded.Status)
+	require.Len(t, decoded.Tracks, 2)
+	for _, track := range decoded.Tracks {
+		assert.Len(t, track.KeyID, 16)
+		assert.Len(t, track.Key, 16)
+		require.Len(t, track.PSSH, 1)
+		assert.Contains(t, string(track.PSSH[0].Data), string(track.KeyID))
+	}
+
+	reqs := ks.Requests()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, []byte("title-1"), reqs[0].ContentID)
+	assert.Equal(t, testSigner, reqs[0].Signer)
+
+	// Then: Repeating the request returns the same keys
+	again := postKeyRequest(t, srv.URL, request, testAESSigningKey, testAESSigningIV)
+	defer again.Body.Close()
+	require.Equal(t, http.StatusOK, again.StatusCode)
+	repeated := decodeKeyResponse(t, again)
+	require.Len(t, repeated.Tracks, len(decoded.Tracks))
+	for i, track := range repeated.Tracks {
+		assert.Equal(t, decoded.Tracks[i].Type, track.Type)
+		assert.Equal(t, decoded.Tracks[i].KeyID, track.KeyID)
+		assert.Equal(t, decoded.Tracks[i].Key, track.Key)
+	}
+	assert.Len(t, ks.Keys(), 2)
+}
+
+// decodeKeyResponse unwraps the base64 JSON document in a key server
+// response.
+func decodeKeyResponse(t *testing.T, resp *http.Response) keyResponse {
+	t.Helper()
+	var envelope map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&envelope))
+	inner, err := base64.StdEncoding.DecodeString(envelope["response"])
+	require.NoError(t, err)
+	var decoded keyResponse
+	require.NoError(t, json.Unmarshal(inner, &decoded))
+	return decoded
+}
+
+func TestKeyServer_RejectsBadSignature(t *testing.T) {
+	// Given: A key server expecting the test signing key
+	ks, err := NewKeyServer(testSigner, testAESSigningKey, testAESSigningIV)
+	require.NoError(t, err)
+	srv := httptest.NewServer(ks)
+	defer srv.Close()
+
+	// When: Posting a request signed with a different key
+	resp := postKeyRequest(t, srv.URL, map[string]any{"content_id": "dGl0bGU="},
+		"00000000000000000000000000000000", testAESSigningIV)
+	defer resp.Body.Close()
+
+	// Then: The request is refused and not recorded
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Empty(t, ks.Requests())
+}
+
+func TestKeyServer_KeyRotation(t *testing.T) {
+	// Given: A key server without signature checks
+	ks := &KeyServer{}
+	srv := httptest.NewServer(ks)
+	defer srv.Close()
+
+	// When: Requesting three crypto periods for one track
+	resp := postKeyRequest(t, srv.URL, map[string]any{
+		"content_id":                base64.StdEncoding.EncodeToString([]byte("live")),
+		"tracks":                    []map[string]string{{"type": "HD"}},
+		"first_crypto_period_index": 4,
+		"crypto_period_count":       3,
+	}, testAESSigningKey, testAESSigningIV)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Then: One distinct key is issued per period
+	periods := map[uint32]string{}
+	for _, k := range ks.Keys() {
+		periods[k.CryptoPeriodIndex] = hex.EncodeToString(k.KeyID)
+	}
+	assert.Len(t, periods, 3)
+	assert.Contains(t, periods, uint32(4))
+	assert.Contains(t, periods, uint32(6))
+}
+
+// Test 13: Widevine key server encryption against the local stand-in
+func TestShakaPackager_WidevineKeyServer(t *testing.T) {
+	// Given: A test video file and a running local key server
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ks, err := NewKeyServer(testSigner, testAESSigningKey, testAESSigningIV)
+	require.NoError(t, err)
+	require.NoError(t, ks.Start())
+	defer ks.Close()
+
+	// When: Package with --enable_widevine_encryption pointed at the stand-in
+	res, err := Run(context.Background(), Job{
+		Streams: []Stream{
+			{Input: absPath, Selector: "audio", Output: "audio.mp4"},
+			{Input: absPath, Selector: "video", Output: "video.mp4"},
+		},
+		OutputDir:       outputPath,
+		MPDOutput:       "manifest.mpd",
+		HostAccessPorts: []int{ks.Port()},
+		Widevine: &WidevineEncryption{
+			KeyServerURL:  ks.URL(),
+			ContentID:     []byte("veloxpack-test-content"),
+			Signer:        testSigner,
+			AESSigningKey: testAESSigningKey,
+			AESSigningIV:  testAESSigningIV,
+		},
+	})
+	if res != nil {
+		t.Log("Shaka Packager output:", res.Stderr)
+	}
+	require.NoError(t, err)
+
+	// Then: The server saw a verified request for our content ID
+	reqs := ks.Requests()
+	require.NotEmpty(t, reqs)
+	assert.Equal(t, []byte("veloxpack-test-content"), reqs[0].ContentID)
+	assert.NotEmpty(t, reqs[0].Tracks)
+
+	issued := map[string]string{}
+	for _, k := range ks.Keys() {
+		issued[hex.EncodeToString(k.KeyID)] = k.Type
+	}
+
+	// Then: Each track is encrypted with a key issued by the server
+	for _, file := range []string{"audio.mp4", "video.mp4"} {
+		boxes, err := mp4.ReadFile(filepath.Join(outputPath, file))
+		require.NoError(t, err)
+
+		tenc := trackEncryption(t, boxes)
+		assert.Contains(t, issued, tenc.DefaultKeyID, file)
+		assert.Contains(t, psshSystems(t, mp4.Find(boxes, "moov/pssh")), mp4.SystemWidevine, file)
+	}
+	audioBoxes, err := mp4.ReadFile(filepath.Join(outputPath, "audio.mp4"))
+	require.NoError(t, err)
+	assert.Equal(t, "AUDIO", issued[trackEncryption(t, audioBoxes).DefaultKeyID])
+
+	// Then: The MPD carries Widevine ContentProtection with the server's PSSH
+	mpd, err := manifest.ReadMPD(filepath.Join(outputPath, "manifest.mpd"))
+	require.NoError(t, err)
+	for _, as := range mpd.AdaptationSets() {
+		found := false
+		for _, cp := range as.ContentProtections {
+			if cp.SchemeIDURI == manifest.SchemeWidevine {
+				found = true
+				assert.NotEmpty(t, cp.PSSH)
+			}
+		}
+		assert.True(t, found, "adaptation set %s should have Widevine ContentProtection", as.ID)
+	}
+}
@@ -0,0 +1,307 @@
+package shakapackager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/network"
+	"github.com/veloxpack/tools/internal/runner"
+	"github.com/veloxpack/tools/manifest"
+)
+
+// LiveSourceImage is the FFmpeg image that produces the synthetic live feed.
+const LiveSourceImage = "ghcr.io/veloxpack/ffmpeg:8.0-lite"
+
+const (
+	liveAlias = "packager"
+	livePort  = 1234
+)
+
+// LiveOptions configures a live packaging harness.
+type LiveOptions struct {
+	// OutputDir is the host directory the packager writes manifests and
+	// segments into.
+	OutputDir string
+	// SegmentDuration in seconds; defaults to 2.
+	SegmentDuration float64
+	// TimeShiftBufferDepth in seconds; defaults to 20.
+	TimeShiftBufferDepth float64
+	// SourceDuration bounds how long the synthetic source runs; defaults to
+	// five minutes.
+	SourceDuration time.Duration
+}
+
+func (o LiveOptions) withDefaults() LiveOptions {
+	if o.SegmentDuration == 0 {
+		o.SegmentDuration = 2
+	}
+	if o.TimeShiftBufferDepth == 0 {
+		o.TimeShiftBufferDepth = 20
+	}
+	if o.SourceDuration == 0 {
+		o.SourceDuration = 5 * time.Minute
+	}
+	return o
+}
+
+// Live is a running live packaging harness: the lite image pushes a
+// synthetic MPEG-TS stream over UDP to a packager container that writes a
+// dynamic MPD and live HLS playlists.
+type Live struct {
+	Options LiveOptions
+
+	// Manifest names relative to Options.OutputDir.
+	MPD            string
+	MasterPlaylist string
+	VideoPlaylist  string
+	AudioPlaylist  string
+
+	network  *testcontainers.DockerNetwork
+	packager testcontainers.Container
+	source   testcontainers.Container
+}
+
+// StartLive starts the packager and then the synthetic source on a private
+// Docker network. The caller must Close the harness.
+func StartLive(ctx context.Context, opts LiveOptions) (*Live, error) {
+	if opts.OutputDir == "" {
+		return nil, errors.New("live harness: output directory is required")
+	}
+	opts = opts.withDefaults()
+
+	l := &Live{
+		Options:        opts,
+		MPD:            "live.mpd",
+		MasterPlaylist: "live.m3u8",
+		VideoPlaylist:  "video.m3u8",
+		AudioPlaylist:  "audio.m3u8",
+	}
+
+	nw, err := network.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("live harness: create network: %w", err)
+	}
+	l.network = nw
+
+	input := "udp://0.0.0.0:" + strconv.Itoa(livePort)
+	job := Job{
+		Streams: []Stream{
+			{
+				Input:           input,
+				Selector:        "video",
+				InitSegment:     "video_init.mp4",
+				SegmentTemplate: "video_$Number$.m4s",
+				PlaylistName:    l.VideoPlaylist,
+			},
+			{
+				Input:           input,
+				Selector:        "audio",
+				InitSegment:     "audio_init.mp4",
+				SegmentTemplate: "audio_$Number$.m4s",
+				PlaylistName:    l.AudioPlaylist,
+			},
+		},
+		OutputDir:               opts.OutputDir,
+		MPDOutput:               l.MPD,
+		HLSMasterPlaylistOutput: l.MasterPlaylist,
+		SegmentDuration:         opts.SegmentDuration,
+		Flags: []string{
+			"--hls_playlist_type", "LIVE",
+			"--time_shift_buffer_depth", formatSeconds(opts.TimeShiftBufferDepth),
+			"--io_block_size", "65536",
+		},
+	}
+	req := job.request()
+	req.Networks = []string{nw.Name}
+	req.NetworkAliases = map[string][]string{nw.Name: {liveAlias}}
+
+	l.packager, err = runner.Start(ctx, req)
+	if err != nil {
+		l.Close(ctx)
+		return nil, fmt.Errorf("live harness: %w", err)
+	}
+
+	gop := strconv.Itoa(int(opts.SegmentDuration * 25))
+	l.source, err = runner.Start(ctx, runner.Request{
+		Image: LiveSourceImage,
+		Cmd: []string{
+			"-hide_banner",
+			"-re",
+			"-f", "lavfi", "-i", "testsrc2=size=640x360:rate=25",
+			"-f", "lavfi", "-i", "sine=frequency=1000:sample_rate=48000",
+			"-t", strconv.Itoa(int(opts.SourceDuration.Seconds())),
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-tune", "zerolatency",
+			"-pix_fmt", "yuv420p",
+			"-b:v", "800k",
+			"-g", gop,
+			"-keyint_min", gop,
+			"-sc_threshold", "0",
+			"-c:a", "aac",
+			"-b:a", "96k",
+			"-f", "mpegts",
+			fmt.Sprintf("udp://%s:%d?pkt_size=1316", liveAlias, livePort),
+		},
+		Networks: []string{nw.Name},
+	})
+	if err != nil {
+		l.Close(ctx)
+		return nil, fmt.Errorf("live harness: %w", err)
+	}
+	return l, nil
+}
+
+// Observer returns a LiveObserver for the harness's MPD and video playlist.
+func (l *Live) Observer() LiveObserver {
+	return LiveObserver{
+		MPDPath:      filepath.Join(l.Options.OutputDir, l.MPD),
+		PlaylistPath: filepath.Join(l.Options.OutputDir, l.VideoPlaylist),
+	}
+}
+
+// Close stops both containers and removes the network.
+func (l *Live) Close(ctx context.Context) error {
+	var errs []error
+	for _, c := range []testcontainers.Container{l.source, l.packager} {
+		if c != nil {
+			errs = append(errs, c.Terminate(ctx))
+		}
+	}
+	if l.network != nil {
+		errs = append(errs, l.network.Remove(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// LiveSnapshot is the state of the live manifests at one point in time.
+type LiveSnapshot struct {
+	Time     time.Time
+	MPD      *manifest.MPD
+	Playlist *manifest.MediaPlaylist
+}
+
+// LiveObserver reads live manifests as the packager rewrites them.
+type LiveObserver struct {
+	MPDPath      string
+	PlaylistPath string
+}
+
+// Snapshot reads both manifests once.
+func (o LiveObserver) Snapshot() (LiveSnapshot, error) {
+	snap := LiveSnapshot{Time: time.Now()}
+	mpd, err := manifest.ReadMPD(o.MPDPath)
+	if err != nil {
+		return snap, err
+	}
+	playlist, err := manifest.ReadMediaPlaylist(o.PlaylistPath)
+	if err != nil {
+		return snap, err
+	}
+	snap.MPD = mpd
+	snap.Playlist = playlist
+	return snap, nil
+}
+
+// Watch collects count snapshots interval apart. Polls that find a manifest
+// missing or mid-write are retried on the next tick, so the first snapshot
+// is taken once the packager has produced output.
+func (o LiveObserver) Watch(ctx context.Context, interval time.Duration, count int) ([]LiveSnapshot, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var snaps []LiveSnapshot
+	var lastErr error
+	for len(snaps) < count {
+		if snap, err := o.Snapshot(); err == nil {
+			snaps = append(snaps, snap)
+			if len(snaps) == count {
+				break
+			}
+		} else {
+			lastErr = err
+		}
+		select {
+		case <-ctx.Done():
+			return snaps, fmt.Errorf("live observer: %d of %d snapshots before %w (last error: %v)", len(snaps), count, ctx.Err(), lastErr)
+		case <-ticker.C:
+		}
+	}
+	return snaps, nil
+}
+
+// CheckLiveness verifies that snapshots taken over time describe a
+// progressing live presentation: a dynamic MPD with a fixed
+// availabilityStartTime, the expected timeShiftBufferDepth and an advancing
+// publishTime, and an open HLS playlist whose media sequence moves forward
+// while its window stays within the time-shift buffer.
+func CheckLiveness(snaps []LiveSnapshot, timeShiftBufferDepth time.Duration) error {
+	if len(snaps) < 2 {
+		return errors.New("liveness: at least two snapshots are required")
+	}
+
+	first, last := snaps[0], snaps[len(snaps)-1]
+	for i, s := range snaps {
+		if s.MPD.Type != "dynamic" {
+			return fmt.Errorf("liveness: snapshot %d: MPD type is %q, want dynamic", i, s.MPD.Type)
+		}
+		if s.MPD.AvailabilityStartTime == "" {
+			return fmt.Errorf("liveness: snapshot %d: MPD has no availabilityStartTime", i)
+		}
+		if s.MPD.AvailabilityStartTime != first.MPD.AvailabilityStartTime {
+			return fmt.Errorf("liveness: snapshot %d: availabilityStartTime changed from %s to %s",
+				i, first.MPD.AvailabilityStartTime, s.MPD.AvailabilityStartTime)
+		}
+		if timeShiftBufferDepth > 0 {
+			tsb, err := manifest.ParseDuration(s.MPD.TimeShiftBufferDepth)
+			if err != nil {
+				return fmt.Errorf("liveness: snapshot %d: %w", i, err)
+			}
+			if tsb != timeShiftBufferDepth {
+				return fmt.Errorf("liveness: snapshot %d: timeShiftBufferDepth is %s, want %s", i, tsb, timeShiftBufferDepth)
+			}
+		}
+
+		p := s.Playlist
+		if p.EndList || p.PlaylistType == "VOD" {
+			return fmt.Errorf("liveness: snapshot %d: playlist is closed", i)
+		}
+		if timeShiftBufferDepth > 0 && p.TargetDuration > 0 {
+			window := time.Duration(p.Duration() * float64(time.Second))
+			if limit := timeShiftBufferDepth + 2*time.Duration(p.TargetDuration)*time.Second; window > limit {
+				return fmt.Errorf("liveness: snapshot %d: playlist window %s exceeds time-shift buffer %s", i, window, timeShiftBufferDepth)
+			}
+		}
+		if i > 0 {
+			prev := snaps[i-1]
+			if p.MediaSequence < prev.Playlist.MediaSequence {
+				return fmt.Errorf("liveness: snapshot %d: media sequence went back from %d to %d",
+					i, prev.Playlist.MediaSequence, p.MediaSequence)
+			}
+			if publishTime(s).Before(publishTime(prev)) {
+				return fmt.Errorf("liveness: snapshot %d: publishTime went back", i)
+			}
+		}
+	}
+
+	if !publishTime(last).After(publishTime(first)) {
+		return errors.New("liveness: MPD publishTime did not advance")
+	}
+	firstEnd := first.Playlist.MediaSequence + len(first.Playlist.Segments)
+	lastEnd := last.Playlist.MediaSequence + len(last.Playlist.Segments)
+	if lastEnd <= firstEnd {
+		return fmt.Errorf("liveness: no new segments between %s and %s",
+			first.Time.Format(time.RFC3339), last.Time.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func publishTime(s LiveSnapshot) time.Time {
+	t, _ := time.Parse(time.RFC3339, s.MPD.PublishTime)
+	return t
+}
@@ -0,0 +1,143 @@
+package shakapackager
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/manifest"
+)
+
+func liveSnapshot(publish string, sequence, segments int) LiveSnapshot {
+	p := &manifest.MediaPlaylist{TargetDuration: 2, MediaSequence: sequence}
+	for i := 0; i < segments; i++ {
+		p.Segments = append(p.Segments, manifest.Segment{Duration: 2})
+	}
+	return LiveSnapshot{
+		MPD: &manifest.MPD{
+			Type:                  "dynamic",
+			AvailabilityStartTime: "2025-01-01T00:00:00Z",
+			PublishTime:           publish,
+			TimeShiftBufferDepth:  "PT20S",
+		},
+		Playlist: p,
+	}
+}
+
+func TestCheckLiveness(t *testing.T) {
+	// Given: Snapshots of a sliding window that advances by two segments
+	snaps := []LiveSnapshot{
+		liveSnapshot("2025-01-01T00:00:10Z", 0, 5),
+		liveSnapshot("2025-01-01T00:00:14Z", 0, 7),
+		liveSnapshot("2025-01-01T00:00:30Z", 5, 10),
+	}
+
+	// Then: The presentation is considered live
+	assert.NoError(t, CheckLiveness(snaps, 20*time.Second))
+
+	// Then: A wrong time-shift buffer depth is reported
+	assert.ErrorContains(t, CheckLiveness(snaps, 30*time.Second), "timeShiftBufferDepth")
+}
+
+func TestCheckLiveness_Failures(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(snaps []LiveSnapshot)
+		want   string
+	}{
+		{
+			name:   "static MPD",
+			mutate: func(s []LiveSnapshot) { s[1].MPD.Type = "static" },
+			want:   "want dynamic",
+		},
+		{
+			name:   "moving availabilityStartTime",
+			mutate: func(s []LiveSnapshot) { s[1].MPD.AvailabilityStartTime = "2025-01-01T00:00:05Z" },
+			want:   "availabilityStartTime changed",
+		},
+		{
+			name:   "ended playlist",
+			mutate: func(s []LiveSnapshot) { s[1].Playlist.EndList = true },
+			want:   "playlist is closed",
+		},
+		{
+			name:   "window beyond the time-shift buffer",
+			mutate: func(s []LiveSnapshot) { s[1] = liveSnapshot("2025-01-01T00:00:14Z", 0, 20) },
+			want:   "exceeds time-shift buffer",
+		},
+		{
+			name:   "stalled publishTime",
+			mutate: func(s []LiveSnapshot) { s[1].MPD.PublishTime = s[0].MPD.PublishTime },
+			want:   "did not advance",
+		},
+		{
+			name:   "no new segments",
+			mutate: func(s []LiveSnapshot) { s[1].Playlist = s[0].Playlist },
+			want:   "no new segments",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given: Two snapshots of a live presentation with one defect
+			snaps := []LiveSnapshot{
+				liveSnapshot("2025-01-01T00:00:10Z", 0, 5),
+				liveSnapshot("2025-01-01T00:00:14Z", 1, 6),
+			}
+			tt.mutate(snaps)
+
+			// Then: CheckLiveness names the defect
+			assert.ErrorContains(t, CheckLiveness(snaps, 20*time.Second), tt.want)
+		})
+	}
+}
+
+// Test 14: Live packaging of a UDP MPEG-TS feed from the lite image
+func TestShakaPackager_LiveUDP(t *testing.T) {
+	// Given: A packager listening on UDP and a synthetic source pushing to it
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	live, err := StartLive(ctx, LiveOptions{OutputDir: outputPath})
+	require.NoError(t, err)
+	defer live.Close(context.Background())
+
+	// When: Observing the manifests while the stream runs
+	snaps, err := live.Observer().Watch(ctx, 4*time.Second, 8)
+	require.NoError(t, err)
+
+	// Then: The MPD and playlist describe a progressing live presentation
+	require.NoError(t, CheckLiveness(snaps, 20*time.Second))
+
+	// Then: The MPD uses segment templates for both media types
+	last := snaps[len(snaps)-1]
+	for _, typ := range []string{"video", "audio"} {
+		sets := last.MPD.AdaptationSetsByType(typ)
+		require.Len(t, sets, 1, typ)
+		tmpl := sets[0].SegmentTemplate
+		if tmpl == nil && len(sets[0].Representations) > 0 {
+			tmpl = sets[0].Representations[0].SegmentTemplate
+		}
+		require.NotNil(t, tmpl, "%s should use a SegmentTemplate", typ)
+		assert.Contains(t, tmpl.Media, "$Number$")
+	}
+
+	// Then: The master playlist references the video and audio renditions
+	master, err := manifest.ReadMasterPlaylist(filepath.Join(outputPath, live.MasterPlaylist))
+	require.NoError(t, err)
+	require.NotEmpty(t, master.Variants)
+	assert.Equal(t, live.VideoPlaylist, master.Variants[0].URI)
+	require.NotEmpty(t, master.Media)
+	assert.Equal(t, "AUDIO", master.Media[0].Type)
+
+	audio, err := manifest.ReadMediaPlaylist(filepath.Join(outputPath, live.AudioPlaylist))
+	require.NoError(t, err)
+	assert.False(t, audio.EndList)
+	assert.NotEmpty(t, audio.Segments)
+}
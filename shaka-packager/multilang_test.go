@@ -0,0 +1,285 @@
+package shakapackager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/manifest"
+)
+
+// writeWebVTT writes a subtitle file with one cue every five seconds.
+func writeWebVTT(t *testing.T, dir, name, text string, cues int) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for i := 0; i < cues; i++ {
+		fmt.Fprintf(&b, "00:%02d:%02d.000 --> 00:%02d:%02d.000\n%s %d\n\n",
+			i*5/60, i*5%60, (i*5+4)/60, (i*5+4)%60, text, i+1)
+	}
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o644))
+	return path
+}
+
+func findMedia(media []manifest.Media, typ, language string) *manifest.Media {
+	for i := range media {
+		if media[i].Type == typ && media[i].Language == language {
+			return &media[i]
+		}
+	}
+	return nil
+}
+
+func findAdaptationSet(sets []manifest.AdaptationSet, language string) *manifest.AdaptationSet {
+	for i := range sets {
+		if sets[i].Lang == language {
+			return &sets[i]
+		}
+	}
+	return nil
+}
+
+func TestStream_String_Languages(t *testing.T) {
+	// Given: A French dub and forced English subtitles
+	dub := Stream{
+		Input:              "/media/title/main.mp4",
+		Selector:           "audio",
+		Output:             "audio_fr.mp4",
+		Language:           "fr",
+		DASHRoles:          []string{"dub"},
+		HLSName:            "Français",
+		HLSGroupID:         "audio",
+		HLSCharacteristics: []string{"public.accessibility.describes-video"},
+	}
+	subs := Stream{
+		Input:           "/media/title/en.vtt",
+		Selector:        "text",
+		SegmentTemplate: "text_en_$Number$.vtt",
+		Format:          "webvtt",
+		Language:        "en",
+		Forced:          true,
+		HLSOnly:         true,
+	}
+
+	// Then: Each option is rendered as a stream descriptor field
+	assert.Equal(t, "in=/input/3dae89ce/main.mp4,stream=audio,output=/output/audio_fr.mp4,language=fr,dash_roles=dub,"+
+		"hls_name=Français,hls_group_id=audio,hls_characteristics=public.accessibility.describes-video", dub.String())
+	assert.Equal(t, "in=/input/3c041e4f/en.vtt,stream=text,segment_template=/output/text_en_$Number$.vtt,format=webvtt,"+
+		"language=en,forced_subtitle=1,hls_only=1", subs.String())
+
+	// Then: Default languages become packager flags
+	args := Job{Streams: []Stream{dub}, DefaultLanguage: "en", DefaultTextLanguage: "en"}.Args()
+	assert.Equal(t, []string{"--default_language", "en", "--default_text_language", "en"}, args[1:])
+}
+
+func TestJob_Request_SameNamedInputs(t *testing.T) {
+	// Given: Two inputs with the same base and parent directory names
+	job := Job{OutputDir: "out", Streams: []Stream{
+		{Input: "/a/en/audio.mp4", Selector: "audio", Output: "audio_a.mp4"},
+		{Input: "/b/en/audio.mp4", Selector: "audio", Output: "audio_b.mp4"},
+		{Input: "/a/en/audio.mp4", Selector: "audio", Output: "audio_a2.mp4"},
+	}}
+
+	// When: Building the container request
+	req := job.request()
+
+	// Then: Each input is copied once, to its own path, and each descriptor
+	// reads its own copy
+	require.Len(t, req.Files, 2)
+	a, b := req.Files[0].ContainerFilePath, req.Files[1].ContainerFilePath
+	assert.NotEqual(t, a, b)
+	assert.Contains(t, job.Streams[0].String(), "in="+a+",")
+	assert.Contains(t, job.Streams[1].String(), "in="+b+",")
+	assert.Contains(t, job.Streams[2].String(), "in="+a+",")
+}
+
+func TestRun_RejectsConflictingStreamOptions(t *testing.T) {
+	_, err := Run(context.Background(), Job{
+		OutputDir: t.TempDir(),
+		Streams:   []Stream{{Input: "a.mp4", Selector: "audio", DASHOnly: true, HLSOnly: true}},
+	})
+	assert.ErrorContains(t, err, "DASH-only and HLS-only")
+
+	_, err = Run(context.Background(), Job{
+		OutputDir: t.TempDir(),
+		Streams:   []Stream{{Input: "a.mp4", Selector: "audio", Forced: true}},
+	})
+	assert.ErrorContains(t, err, "forced subtitles")
+}
+
+func TestRun_RejectsUnescapableDescriptorFields(t *testing.T) {
+	for name, tc := range map[string]struct {
+		stream Stream
+		want   string
+	}{
+		"comma in hls name":      {Stream{Input: "a.mp4", Selector: "audio", HLSName: "English, Stereo"}, "hls_name"},
+		"= in group id":          {Stream{Input: "a.mp4", Selector: "audio", HLSGroupID: "audio=aac"}, "hls_group_id"},
+		"comma in playlist name": {Stream{Input: "a.mp4", Selector: "video", PlaylistName: "a,b.m3u8"}, "playlist_name"},
+		"= in language":          {Stream{Input: "a.mp4", Selector: "audio", Language: "en,output=x"}, "language"},
+		"comma in output":        {Stream{Input: "a.mp4", Selector: "video", Output: "v,1.mp4"}, "output"},
+		"comma in input":         {Stream{Input: "a,b.mp4", Selector: "video"}, "input"},
+		"semicolon in a role":    {Stream{Input: "a.mp4", Selector: "audio", DASHRoles: []string{"main;dub"}}, "dash_roles"},
+	} {
+		_, err := Run(context.Background(), Job{OutputDir: t.TempDir(), Streams: []Stream{tc.stream}})
+		assert.ErrorContains(t, err, tc.want, name)
+	}
+
+	// Then: An = inside an accessibility URN is kept
+	s := Stream{Input: "a.mp4", Selector: "audio", DASHAccessibilities: []string{"urn:tva:metadata:cs:AudioPurposeCS:2007=1"}}
+	assert.NoError(t, s.validateFields())
+}
+
+// Test 15: Multiple audio languages and fMP4 WebVTT subtitles in DASH and HLS
+func TestShakaPackager_MultiLanguage(t *testing.T) {
+	// Given: A test video file and subtitle files in two languages
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	subsDir := createTempDir(t)
+	defer cleanupFiles(t, subsDir)
+	enSubs := writeWebVTT(t, subsDir, "en.vtt", "Hello", 24)
+	esSubs := writeWebVTT(t, subsDir, "es.vtt", "Hola", 24)
+
+	// When: Packaging English and French audio with English and Spanish subtitles
+	res, err := Run(context.Background(), Job{
+		Streams: []Stream{
+			{Input: absPath, Selector: "video", Output: "video.mp4", PlaylistName: "video.m3u8"},
+			{Input: absPath, Selector: "audio", Output: "audio_en.mp4", PlaylistName: "audio_en.m3u8",
+				Language: "en", HLSName: "English", HLSGroupID: "audio"},
+			{Input: absPath, Selector: "audio", Output: "audio_fr.mp4", PlaylistName: "audio_fr.m3u8",
+				Language: "fr", HLSName: "Français", HLSGroupID: "audio", DASHRoles: []string{"dub"}},
+			{Input: enSubs, Selector: "text", Output: "text_en.mp4", PlaylistName: "text_en.m3u8",
+				Language: "en", HLSName: "English", HLSGroupID: "subs"},
+			{Input: esSubs, Selector: "text", Output: "text_es.mp4", PlaylistName: "text_es.m3u8",
+				Language: "es", HLSName: "Español", HLSGroupID: "subs"},
+		},
+		OutputDir:               outputPath,
+		MPDOutput:               "manifest.mpd",
+		HLSMasterPlaylistOutput: "master.m3u8",
+		DefaultLanguage:         "en",
+		DefaultTextLanguage:     "en",
+	})
+	if res != nil {
+		t.Log("Shaka Packager output:", res.Stderr)
+	}
+	require.NoError(t, err)
+
+	// Then: The MPD has one audio AdaptationSet per language with its role
+	mpd, err := manifest.ReadMPD(filepath.Join(outputPath, "manifest.mpd"))
+	require.NoError(t, err)
+
+	audio := mpd.AdaptationSetsByType("audio")
+	require.Len(t, audio, 2)
+	en := findAdaptationSet(audio, "en")
+	require.NotNil(t, en, "English audio AdaptationSet")
+	assert.True(t, en.HasRole("main"), "default language should have the main role")
+	fr := findAdaptationSet(audio, "fr")
+	require.NotNil(t, fr, "French audio AdaptationSet")
+	assert.True(t, fr.HasRole("dub"))
+	assert.False(t, fr.HasRole("main"))
+
+	// Then: Subtitles are wvtt in MP4 with the main role on the default language
+	text := mpd.AdaptationSetsByType("text")
+	require.Len(t, text, 2)
+	for _, as := range text {
+		require.NotEmpty(t, as.Representations)
+		codecs := as.Codecs
+		if codecs == "" {
+			codecs = as.Representations[0].Codecs
+		}
+		assert.Equal(t, "wvtt", codecs, "text AdaptationSet %s", as.Lang)
+	}
+	enText := findAdaptationSet(text, "en")
+	require.NotNil(t, enText)
+	assert.True(t, enText.HasRole("main"))
+
+	// Then: The master playlist groups the renditions with DEFAULT/AUTOSELECT
+	master, err := manifest.ReadMasterPlaylist(filepath.Join(outputPath, "master.m3u8"))
+	require.NoError(t, err)
+
+	enAudio := findMedia(master.Media, "AUDIO", "en")
+	require.NotNil(t, enAudio)
+	assert.Equal(t, "audio", enAudio.GroupID)
+	assert.Equal(t, "English", enAudio.Name)
+	assert.True(t, enAudio.Default)
+	assert.True(t, enAudio.Autoselect)
+
+	frAudio := findMedia(master.Media, "AUDIO", "fr")
+	require.NotNil(t, frAudio)
+	assert.Equal(t, "Français", frAudio.Name)
+	assert.False(t, frAudio.Default)
+
+	enSub := findMedia(master.Media, "SUBTITLES", "en")
+	require.NotNil(t, enSub)
+	assert.Equal(t, "subs", enSub.GroupID)
+	assert.True(t, enSub.Default)
+	esSub := findMedia(master.Media, "SUBTITLES", "es")
+	require.NotNil(t, esSub)
+	assert.False(t, esSub.Default)
+
+	require.NotEmpty(t, master.Variants)
+	for _, v := range master.Variants {
+		assert.Equal(t, "audio", v.Audio)
+		assert.Equal(t, "subs", v.Subtitles)
+	}
+}
+
+// Test 16: HLS WebVTT subtitle segments alongside segmented media
+func TestShakaPackager_HLSWebVTTSegments(t *testing.T) {
+	// Given: A test video file and an English subtitle file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+	subsDir := createTempDir(t)
+	defer cleanupFiles(t, subsDir)
+	enSubs := writeWebVTT(t, subsDir, "en.vtt", "Hello", 24)
+
+	// When: Packaging HLS with WebVTT text segments
+	res, err := Run(context.Background(), Job{
+		Streams: []Stream{
+			{Input: absPath, Selector: "video", InitSegment: "video_init.mp4",
+				SegmentTemplate: "video_$Number$.m4s", PlaylistName: "video.m3u8"},
+			{Input: absPath, Selector: "audio", InitSegment: "audio_init.mp4",
+				SegmentTemplate: "audio_$Number$.m4s", PlaylistName: "audio.m3u8", Language: "en"},
+			{Input: enSubs, Selector: "text", SegmentTemplate: "text_en_$Number$.vtt", Format: "webvtt",
+				PlaylistName: "text_en.m3u8", Language: "en", HLSName: "English"},
+		},
+		OutputDir:               outputPath,
+		HLSMasterPlaylistOutput: "master.m3u8",
+		SegmentDuration:         6,
+		DefaultTextLanguage:     "en",
+	})
+	if res != nil {
+		t.Log("Shaka Packager output:", res.Stderr)
+	}
+	require.NoError(t, err)
+
+	// Then: The master playlist lists the subtitles rendition
+	master, err := manifest.ReadMasterPlaylist(filepath.Join(outputPath, "master.m3u8"))
+	require.NoError(t, err)
+	sub := findMedia(master.Media, "SUBTITLES", "en")
+	require.NotNil(t, sub)
+	assert.Equal(t, "text_en.m3u8", sub.URI)
+	assert.True(t, sub.Default)
+
+	// Then: The text playlist references plain WebVTT segments
+	playlist, err := manifest.ReadMediaPlaylist(filepath.Join(outputPath, "text_en.m3u8"))
+	require.NoError(t, err)
+	assert.True(t, playlist.EndList)
+	assert.Nil(t, playlist.Map, "WebVTT segments need no init section")
+	require.NotEmpty(t, playlist.Segments)
+
+	first, err := os.ReadFile(filepath.Join(outputPath, playlist.Segments[0].URI))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(first), "WEBVTT"), "segment should be a WebVTT document")
+	assert.Contains(t, string(first), "Hello 1")
+}
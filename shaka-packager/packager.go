@@ -0,0 +1,316 @@
+package shakapackager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/veloxpack/tools/internal/runner"
+)
+
+// Image is the Shaka Packager image used by Run.
+const Image = "ghcr.io/veloxpack/shaka-packager:latest"
+
+// Stream describes one packager stream descriptor, the
+// "in=...,stream=...,output=..." arguments on the command line.
+type Stream struct {
+	// Input is a host path, copied into the container, or a URL such as
+	// udp://0.0.0.0:1234 that the packager reads directly.
+	Input string
+	// Selector picks the stream from the input: "audio", "video", "text" or
+	// a zero-based stream index.
+	Selector string
+	// Output is the output file name relative to Job.OutputDir.
+	Output string
+	// InitSegment is the initialization segment name for multi-segment
+	// output.
+	InitSegment string
+	// SegmentTemplate enables multi-segment output, e.g. "video_$Number$.m4s".
+	SegmentTemplate string
+	// PlaylistName is the HLS media playlist name for this stream.
+	PlaylistName string
+	// IFramePlaylistName adds an HLS I-frame playlist for this video stream.
+	IFramePlaylistName string
+	// TrickPlayFactor makes this a trick play stream holding every Nth key
+	// frame of the input; see Stream.TrickPlay.
+	TrickPlayFactor int
+	// Format overrides the output format inferred from the output name, e.g.
+	// "webvtt" for HLS WebVTT text segments or "ttml".
+	Format string
+	// Language is an ISO-639 code that overrides the language in the input.
+	Language string
+	// DASHRoles and DASHAccessibilities are emitted as Role and
+	// Accessibility descriptors, e.g. "main", "dub", "commentary" or
+	// "urn:tva:metadata:cs:AudioPurposeCS:2007=1".
+	DASHRoles           []string
+	DASHAccessibilities []string
+	// HLSName, HLSGroupID and HLSCharacteristics set the NAME, GROUP-ID and
+	// CHARACTERISTICS attributes of the EXT-X-MEDIA tag.
+	HLSName            string
+	HLSGroupID         string
+	HLSCharacteristics []string
+	// Forced marks a text stream as forced subtitles.
+	Forced bool
+	// DASHOnly and HLSOnly leave the stream out of the other manifest.
+	DASHOnly bool
+	HLSOnly  bool
+	// DRMLabel selects the key for this stream when encrypting.
+	DRMLabel string
+	// SkipEncryption leaves this stream in the clear.
+	SkipEncryption bool
+}
+
+// String renders the stream descriptor as a packager argument.
+func (s Stream) String() string {
+	fields := []string{
+		"in=" + containerInput(s.Input),
+		"stream=" + s.Selector,
+	}
+	if s.Output != "" {
+		fields = append(fields, "output="+containerOutput(s.Output))
+	}
+	if s.InitSegment != "" {
+		fields = append(fields, "init_segment="+containerOutput(s.InitSegment))
+	}
+	if s.SegmentTemplate != "" {
+		fields = append(fields, "segment_template="+containerOutput(s.SegmentTemplate))
+	}
+	if s.PlaylistName != "" {
+		fields = append(fields, "playlist_name="+s.PlaylistName)
+	}
+	if s.IFramePlaylistName != "" {
+		fields = append(fields, "iframe_playlist_name="+s.IFramePlaylistName)
+	}
+	if s.TrickPlayFactor > 0 {
+		fields = append(fields, "trick_play_factor="+strconv.Itoa(s.TrickPlayFactor))
+	}
+	if s.Format != "" {
+		fields = append(fields, "format="+s.Format)
+	}
+	if s.Language != "" {
+		fields = append(fields, "language="+s.Language)
+	}
+	if len(s.DASHRoles) > 0 {
+		fields = append(fields, "dash_roles="+strings.Join(s.DASHRoles, ";"))
+	}
+	if len(s.DASHAccessibilities) > 0 {
+		fields = append(fields, "dash_accessibilities="+strings.Join(s.DASHAccessibilities, ";"))
+	}
+	if s.HLSName != "" {
+		fields = append(fields, "hls_name="+s.HLSName)
+	}
+	if s.HLSGroupID != "" {
+		fields = append(fields, "hls_group_id="+s.HLSGroupID)
+	}
+	if len(s.HLSCharacteristics) > 0 {
+		fields = append(fields, "hls_characteristics="+strings.Join(s.HLSCharacteristics, ";"))
+	}
+	if s.Forced {
+		fields = append(fields, "forced_subtitle=1")
+	}
+	if s.DASHOnly {
+		fields = append(fields, "dash_only=1")
+	}
+	if s.HLSOnly {
+		fields = append(fields, "hls_only=1")
+	}
+	if s.DRMLabel != "" {
+		fields = append(fields, "drm_label="+s.DRMLabel)
+	}
+	if s.SkipEncryption {
+		fields = append(fields, "skip_encryption=1")
+	}
+	return strings.Join(fields, ",")
+}
+
+// validateFields rejects values the descriptor cannot carry. The packager
+// splits a descriptor on commas and each field on "=", without escaping,
+// and splits list fields on semicolons. Input may be a URL with a query
+// string and list items may be URNs such as "...AudioPurposeCS:2007=1", so
+// only commas and semicolons are rejected there.
+func (s Stream) validateFields() error {
+	fields := []struct{ name, value string }{
+		{"stream", s.Selector},
+		{"output", s.Output},
+		{"init_segment", s.InitSegment},
+		{"segment_template", s.SegmentTemplate},
+		{"playlist_name", s.PlaylistName},
+		{"iframe_playlist_name", s.IFramePlaylistName},
+		{"format", s.Format},
+		{"language", s.Language},
+		{"hls_name", s.HLSName},
+		{"hls_group_id", s.HLSGroupID},
+		{"drm_label", s.DRMLabel},
+	}
+	for _, f := range fields {
+		if strings.ContainsAny(f.value, ",=") {
+			return fmt.Errorf("shaka packager: %s %q cannot contain a comma or =", f.name, f.value)
+		}
+	}
+	if strings.Contains(s.Input, ",") {
+		return fmt.Errorf("shaka packager: input %q cannot contain a comma", s.Input)
+	}
+	lists := []struct {
+		name   string
+		values []string
+	}{
+		{"dash_roles", s.DASHRoles},
+		{"dash_accessibilities", s.DASHAccessibilities},
+		{"hls_characteristics", s.HLSCharacteristics},
+	}
+	for _, l := range lists {
+		for _, v := range l.values {
+			if strings.ContainsAny(v, ",;") {
+				return fmt.Errorf("shaka packager: %s value %q cannot contain a comma or semicolon", l.name, v)
+			}
+		}
+	}
+	return nil
+}
+
+// Job is a complete packager invocation.
+type Job struct {
+	Streams []Stream
+	// OutputDir is the host directory mounted at /output.
+	OutputDir string
+	// MPDOutput is the DASH manifest name relative to OutputDir.
+	MPDOutput string
+	// HLSMasterPlaylistOutput is the HLS master playlist name relative to
+	// OutputDir.
+	HLSMasterPlaylistOutput string
+	// SegmentDuration and FragmentDuration are in seconds; zero keeps the
+	// packager default.
+	SegmentDuration  float64
+	FragmentDuration float64
+	// DefaultLanguage and DefaultTextLanguage mark the audio and text
+	// streams in that language as the default: DEFAULT=YES in HLS and
+	// Role "main" in DASH.
+	DefaultLanguage     string
+	DefaultTextLanguage string
+	// Encryption enables raw key encryption when set.
+	Encryption *RawKeyEncryption
+	// Widevine enables key server encryption when set.
+	Widevine *WidevineEncryption
+	// HostAccessPorts exposes host ports, such as a local key server, to the
+	// packager as host.testcontainers.internal.
+	HostAccessPorts []int
+	// Flags are appended verbatim after all generated flags.
+	Flags []string
+}
+
+// Args returns the packager command line for the job.
+func (j Job) Args() []string {
+	var args []string
+	for _, s := range j.Streams {
+		args = append(args, s.String())
+	}
+	if j.MPDOutput != "" {
+		args = append(args, "--mpd_output", containerOutput(j.MPDOutput))
+	}
+	if j.HLSMasterPlaylistOutput != "" {
+		args = append(args, "--hls_master_playlist_output", containerOutput(j.HLSMasterPlaylistOutput))
+	}
+	if j.SegmentDuration > 0 {
+		args = append(args, "--segment_duration", formatSeconds(j.SegmentDuration))
+	}
+	if j.FragmentDuration > 0 {
+		args = append(args, "--fragment_duration", formatSeconds(j.FragmentDuration))
+	}
+	if j.DefaultLanguage != "" {
+		args = append(args, "--default_language", j.DefaultLanguage)
+	}
+	if j.DefaultTextLanguage != "" {
+		args = append(args, "--default_text_language", j.DefaultTextLanguage)
+	}
+	if j.Encryption != nil {
+		args = append(args, j.Encryption.Args()...)
+	}
+	if j.Widevine != nil {
+		args = append(args, j.Widevine.Args()...)
+	}
+	return append(args, j.Flags...)
+}
+
+// Run executes the job in the Shaka Packager image. Host inputs are copied
+// into the container and outputs are written to j.OutputDir.
+func Run(ctx context.Context, j Job) (*runner.Result, error) {
+	if j.OutputDir == "" {
+		return nil, fmt.Errorf("shaka packager: output directory is required")
+	}
+	for _, s := range j.Streams {
+		if err := s.validateFields(); err != nil {
+			return nil, err
+		}
+		if s.DASHOnly && s.HLSOnly {
+			return nil, fmt.Errorf("shaka packager: stream %s cannot be both DASH-only and HLS-only", s.Input)
+		}
+		if s.Forced && s.Selector != "text" {
+			return nil, fmt.Errorf("shaka packager: only text streams can be forced subtitles")
+		}
+		if (s.TrickPlayFactor > 0 || s.IFramePlaylistName != "") && (s.Selector == "audio" || s.Selector == "text") {
+			return nil, fmt.Errorf("shaka packager: trick play and I-frame playlists need a video stream")
+		}
+	}
+	if j.Encryption != nil && j.Widevine != nil {
+		return nil, fmt.Errorf("shaka packager: raw key and widevine encryption are mutually exclusive")
+	}
+	if j.Encryption != nil {
+		if err := j.Encryption.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if j.Widevine != nil {
+		if err := j.Widevine.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return runner.Run(ctx, j.request())
+}
+
+func (j Job) request() runner.Request {
+	req := runner.Request{
+		Image:  Image,
+		Cmd:    j.Args(),
+		Mounts: []mount.Mount{runner.Bind(j.OutputDir, "/output")},
+
+		HostAccessPorts: j.HostAccessPorts,
+	}
+	seen := map[string]bool{}
+	for _, s := range j.Streams {
+		if isURL(s.Input) || seen[s.Input] {
+			continue
+		}
+		seen[s.Input] = true
+		req.Files = append(req.Files, runner.File(s.Input, containerInput(s.Input)))
+	}
+	return req
+}
+
+// containerInput maps a host input path to its location inside the
+// container. Each input gets a directory named after a hash of its full
+// path, so inputs that share a base name or parent directory name do not
+// collide.
+func containerInput(input string) string {
+	if isURL(input) {
+		return input
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(input)))
+	return "/input/" + hex.EncodeToString(sum[:4]) + "/" + filepath.Base(input)
+}
+
+func containerOutput(name string) string {
+	return "/output/" + filepath.ToSlash(name)
+}
+
+func isURL(s string) bool {
+	return strings.Contains(s, "://")
+}
+
+func formatSeconds(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
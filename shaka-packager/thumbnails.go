@@ -0,0 +1,213 @@
+package shakapackager
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+	"github.com/veloxpack/tools/manifest"
+)
+
+// InjectThumbnails adds a DASH-IF thumbnail tile AdaptationSet for the
+// storyboard's sprite sheets to the single-period MPD at mpdPath. Each
+// sheet is one segment lasting Columns*Rows thumbnail intervals, addressed
+// by a SegmentTemplate relative to the MPD; sheetDir is where the sheets
+// were written. The MPD is edited textually so the packager's own output is
+// left byte-for-byte intact.
+func InjectThumbnails(mpdPath string, sb *thumbnail.Storyboard, sheetDir string) error {
+	if len(sb.Sheets) == 0 {
+		return errors.New("inject thumbnails: storyboard has no sheets")
+	}
+	if sb.Interval <= 0 {
+		return fmt.Errorf("inject thumbnails: storyboard interval %v is not positive", sb.Interval)
+	}
+	if sb.Columns <= 0 || sb.Rows <= 0 || sb.TileWidth <= 0 || sb.TileHeight <= 0 {
+		return fmt.Errorf("inject thumbnails: invalid %dx%d grid of %dx%d tiles",
+			sb.Columns, sb.Rows, sb.TileWidth, sb.TileHeight)
+	}
+	data, err := os.ReadFile(mpdPath)
+	if err != nil {
+		return err
+	}
+	mpd, err := manifest.ParseMPD(data)
+	if err != nil {
+		return err
+	}
+	if len(mpd.Periods) != 1 {
+		return fmt.Errorf("inject thumbnails: MPD has %d periods, want 1", len(mpd.Periods))
+	}
+	if mpd.Type == "dynamic" {
+		return errors.New("inject thumbnails: live MPDs are not supported")
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(mpdPath), sheetDir)
+	if err != nil {
+		return fmt.Errorf("inject thumbnails: %w", err)
+	}
+	media, err := sheetTemplate(sb.Sheets[0])
+	if err != nil {
+		return err
+	}
+	if rel != "." {
+		media = filepath.ToSlash(rel) + "/" + media
+	}
+
+	// One sheet covers Columns*Rows intervals; the timescale keeps
+	// fractional intervals exact to the millisecond.
+	const timescale = 1000
+	sheetDuration := sb.Interval * float64(sb.Columns*sb.Rows)
+	var total int64
+	for _, sheet := range sb.Sheets {
+		info, err := os.Stat(filepath.Join(sheetDir, sheet))
+		if err != nil {
+			return fmt.Errorf("inject thumbnails: %w", err)
+		}
+		total += info.Size()
+	}
+	bandwidth := int64(math.Ceil(float64(total*8) / (sheetDuration * float64(len(sb.Sheets)))))
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "    <AdaptationSet id=\"%s\" contentType=\"image\" mimeType=\"%s\">\n",
+		escapeAttr(nextAdaptationSetID(mpd)), escapeAttr(sheetMimeType(sb.Sheets[0])))
+	fmt.Fprintf(&b, "      <SegmentTemplate media=\"%s\" timescale=\"%d\" duration=\"%d\" startNumber=\"1\"/>\n",
+		escapeAttr(media), timescale, int64(math.Round(sheetDuration*timescale)))
+	fmt.Fprintf(&b, "      <Representation id=\"thumbnails_%dx%d\" bandwidth=\"%d\" width=\"%d\" height=\"%d\">\n",
+		sb.TileWidth, sb.TileHeight, bandwidth, sb.Columns*sb.TileWidth, sb.Rows*sb.TileHeight)
+	fmt.Fprintf(&b, "        <EssentialProperty schemeIdUri=\"%s\" value=\"%dx%d\"/>\n",
+		escapeAttr(manifest.SchemeThumbnailTile), sb.Columns, sb.Rows)
+	b.WriteString("      </Representation>\n")
+	b.WriteString("    </AdaptationSet>\n")
+
+	end := bytes.LastIndex(data, []byte("</Period>"))
+	if end < 0 {
+		return errors.New("inject thumbnails: no closing Period tag")
+	}
+	// Keep the closing tag's indentation on its own line.
+	lineStart := bytes.LastIndexByte(data[:end], '\n') + 1
+	if len(bytes.TrimSpace(data[lineStart:end])) > 0 {
+		lineStart = end
+	}
+	out := make([]byte, 0, len(data)+b.Len())
+	out = append(out, data[:lineStart]...)
+	out = append(out, b.Bytes()...)
+	out = append(out, data[lineStart:]...)
+	return os.WriteFile(mpdPath, out, 0o644)
+}
+
+// escapeAttr escapes s for a double-quoted XML attribute value.
+func escapeAttr(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// sheetTemplate turns the first sheet name, e.g. "sprite_001.jpg", into a
+// $Number$ template, "sprite_$Number%03d$.jpg".
+func sheetTemplate(first string) (string, error) {
+	ext := filepath.Ext(first)
+	base := strings.TrimSuffix(first, ext)
+	digits := 0
+	for digits < len(base) && base[len(base)-1-digits] >= '0' && base[len(base)-1-digits] <= '9' {
+		digits++
+	}
+	if digits == 0 {
+		return "", fmt.Errorf("inject thumbnails: sheet %q is not numbered", first)
+	}
+	if n, _ := strconv.Atoi(base[len(base)-digits:]); n != 1 {
+		return "", fmt.Errorf("inject thumbnails: first sheet %q is not number 1", first)
+	}
+	return fmt.Sprintf("%s$Number%%0%dd$%s", base[:len(base)-digits], digits, ext), nil
+}
+
+// sheetMimeType derives the sheet media type from its extension.
+func sheetMimeType(sheet string) string {
+	switch strings.ToLower(filepath.Ext(sheet)) {
+	case ".png":
+		return thumbnail.FormatPNG.MimeType()
+	case ".webp":
+		return thumbnail.FormatWebP.MimeType()
+	default:
+		return thumbnail.FormatJPEG.MimeType()
+	}
+}
+
+func nextAdaptationSetID(mpd *manifest.MPD) string {
+	next := 0
+	for _, as := range mpd.AdaptationSets() {
+		if id, err := strconv.Atoi(as.ID); err == nil && id >= next {
+			next = id + 1
+		}
+	}
+	return strconv.Itoa(next)
+}
+
+// VerifyThumbnails checks the thumbnail tile AdaptationSet of the MPD at
+// mpdPath against the presentation: the grid and sheet size agree, the
+// sheets cover the whole presentation duration and every referenced sheet
+// exists next to the MPD.
+func VerifyThumbnails(mpdPath string) error {
+	mpd, err := manifest.ReadMPD(mpdPath)
+	if err != nil {
+		return err
+	}
+	images := mpd.AdaptationSetsByType("image")
+	if len(images) != 1 {
+		return fmt.Errorf("%s: %d image adaptation sets, want 1", mpdPath, len(images))
+	}
+	as := images[0]
+	if len(as.Representations) != 1 {
+		return fmt.Errorf("%s: thumbnail adaptation set has %d representations, want 1", mpdPath, len(as.Representations))
+	}
+	rep := as.Representations[0]
+	cols, rows, ok := rep.ThumbnailTile()
+	if !ok {
+		return fmt.Errorf("%s: representation %s has no thumbnail tile property", mpdPath, rep.ID)
+	}
+	if rep.Width%cols != 0 || rep.Height%rows != 0 {
+		return fmt.Errorf("%s: %dx%d sheet does not divide into a %dx%d grid", mpdPath, rep.Width, rep.Height, cols, rows)
+	}
+	tmpl := as.SegmentTemplate
+	if tmpl == nil || tmpl.Duration <= 0 || tmpl.Timescale <= 0 {
+		return fmt.Errorf("%s: thumbnail adaptation set needs a SegmentTemplate with a duration", mpdPath)
+	}
+
+	duration, err := manifest.ParseDuration(mpd.MediaPresentationDuration)
+	if err != nil {
+		return fmt.Errorf("%s: %w", mpdPath, err)
+	}
+	sheetDuration := float64(tmpl.Duration) / float64(tmpl.Timescale)
+	sheets := int(math.Ceil(duration.Seconds() / sheetDuration))
+	start := max(tmpl.StartNumber, 1)
+	for n := start; n < start+sheets; n++ {
+		name := expandNumber(tmpl.Media, n)
+		if _, err := os.Stat(filepath.Join(filepath.Dir(mpdPath), filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("%s: sheet %d: %w", mpdPath, n, err)
+		}
+	}
+	return nil
+}
+
+// expandNumber substitutes n into a $Number$ or $Number%0Nd$ template.
+func expandNumber(tmpl string, n int) string {
+	start := strings.Index(tmpl, "$Number")
+	if start < 0 {
+		return tmpl
+	}
+	end := strings.Index(tmpl[start+1:], "$")
+	if end < 0 {
+		return tmpl
+	}
+	end += start + 1
+	format := "%d"
+	if f := tmpl[start+len("$Number") : end]; f != "" {
+		format = f
+	}
+	return tmpl[:start] + fmt.Sprintf(format, n) + tmpl[end+1:]
+}
@@ -0,0 +1,189 @@
+package shakapackager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	thumbnail "github.com/veloxpack/tools/ffmpeg-thumbnail"
+	"github.com/veloxpack/tools/manifest"
+)
+
+const vodMPD = `<?xml version="1.0" encoding="UTF-8"?>
+<!--Generated with https://github.com/shaka-project/shaka-packager version v3.4.2-->
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-on-demand:2011" minBufferTime="PT2S" type="static" mediaPresentationDuration="PT95.5S">
+  <Period id="0">
+    <AdaptationSet id="0" contentType="video" width="1280" height="720" frameRate="12288/512" subsegmentAlignment="true" par="16:9">
+      <Representation id="0" bandwidth="1200000" codecs="avc1.64001f" mimeType="video/mp4" sar="1:1">
+        <BaseURL>video.mp4</BaseURL>
+        <SegmentBase indexRange="800-1000" timescale="12288">
+          <Initialization range="0-799"/>
+        </SegmentBase>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet id="1" contentType="audio" subsegmentAlignment="true">
+      <Representation id="1" bandwidth="130000" codecs="mp4a.40.2" mimeType="audio/mp4" audioSamplingRate="48000">
+        <BaseURL>audio.mp4</BaseURL>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+`
+
+func TestInjectThumbnails(t *testing.T) {
+	// Given: A packager MPD and the sprite sheets of a 95.5 second storyboard
+	dir := t.TempDir()
+	mpdPath := filepath.Join(dir, "manifest.mpd")
+	require.NoError(t, os.WriteFile(mpdPath, []byte(vodMPD), 0o644))
+
+	sb, err := thumbnail.PlanStoryboard(95.5, 1280, 720, thumbnail.StoryboardOptions{Interval: 2, Columns: 5, Rows: 5})
+	require.NoError(t, err)
+	sheetDir := filepath.Join(dir, "thumbs")
+	require.NoError(t, os.Mkdir(sheetDir, 0o755))
+	for _, sheet := range sb.Sheets {
+		require.NoError(t, os.WriteFile(filepath.Join(sheetDir, sheet), make([]byte, 5000), 0o644))
+	}
+
+	// When: Injecting the thumbnail AdaptationSet
+	require.NoError(t, InjectThumbnails(mpdPath, sb, sheetDir))
+
+	// Then: The packager's adaptation sets are untouched and the image set follows them
+	data, err := os.ReadFile(mpdPath)
+	require.NoError(t, err)
+	head := vodMPD[:strings.Index(vodMPD, "  </Period>")]
+	assert.True(t, strings.HasPrefix(string(data), head))
+
+	mpd, err := manifest.ParseMPD(data)
+	require.NoError(t, err)
+	images := mpd.AdaptationSetsByType("image")
+	require.Len(t, images, 1)
+	as := images[0]
+	assert.Equal(t, "2", as.ID)
+	assert.Equal(t, "image/jpeg", as.MimeType)
+	require.NotNil(t, as.SegmentTemplate)
+	assert.Equal(t, "thumbs/sprite_$Number%03d$.jpg", as.SegmentTemplate.Media)
+	assert.Equal(t, 50000, as.SegmentTemplate.Duration, "25 tiles of 2 seconds at timescale 1000")
+
+	require.Len(t, as.Representations, 1)
+	rep := as.Representations[0]
+	cols, rows, ok := rep.ThumbnailTile()
+	require.True(t, ok)
+	assert.Equal(t, 5, cols)
+	assert.Equal(t, 5, rows)
+	assert.Equal(t, 800, rep.Width)
+	assert.Equal(t, 450, rep.Height)
+	assert.Equal(t, 800, rep.Bandwidth, "5000 bytes per 50 second sheet")
+
+	// Then: The injected set covers the presentation with existing sheets
+	assert.NoError(t, VerifyThumbnails(mpdPath))
+
+	// Then: A missing sheet fails verification
+	require.NoError(t, os.Remove(filepath.Join(sheetDir, sb.Sheets[1])))
+	assert.ErrorContains(t, VerifyThumbnails(mpdPath), "sheet 2")
+}
+
+func TestInjectThumbnails_EscapesAndValidates(t *testing.T) {
+	// Given: Sheets in a directory whose name needs escaping in XML
+	dir := t.TempDir()
+	mpdPath := filepath.Join(dir, "manifest.mpd")
+	require.NoError(t, os.WriteFile(mpdPath, []byte(vodMPD), 0o644))
+	sb, err := thumbnail.PlanStoryboard(95.5, 1280, 720, thumbnail.StoryboardOptions{Interval: 2, Columns: 5, Rows: 5})
+	require.NoError(t, err)
+	sheetDir := filepath.Join(dir, `a&b "<thumbs>"`)
+	require.NoError(t, os.Mkdir(sheetDir, 0o755))
+	for _, sheet := range sb.Sheets {
+		require.NoError(t, os.WriteFile(filepath.Join(sheetDir, sheet), make([]byte, 5000), 0o644))
+	}
+
+	// When: A storyboard has no interval or an empty grid
+	for name, broken := range map[string]thumbnail.Storyboard{
+		"zero interval":     {Interval: 0, Columns: 5, Rows: 5, TileWidth: 160, TileHeight: 90},
+		"negative interval": {Interval: -2, Columns: 5, Rows: 5, TileWidth: 160, TileHeight: 90},
+		"zero columns":      {Interval: 2, Columns: 0, Rows: 5, TileWidth: 160, TileHeight: 90},
+		"zero rows":         {Interval: 2, Columns: 5, Rows: 0, TileWidth: 160, TileHeight: 90},
+		"zero tile":         {Interval: 2, Columns: 5, Rows: 5, TileWidth: 0, TileHeight: 90},
+	} {
+		broken.Sheets = sb.Sheets
+
+		// Then: Injection fails and leaves the MPD alone
+		assert.ErrorContains(t, InjectThumbnails(mpdPath, &broken, sheetDir), "inject thumbnails", name)
+	}
+	data, err := os.ReadFile(mpdPath)
+	require.NoError(t, err)
+	assert.Equal(t, vodMPD, string(data))
+
+	// When: Injecting the valid storyboard
+	require.NoError(t, InjectThumbnails(mpdPath, sb, sheetDir))
+
+	// Then: The MPD still parses and the template round-trips
+	mpd, err := manifest.ReadMPD(mpdPath)
+	require.NoError(t, err)
+	images := mpd.AdaptationSetsByType("image")
+	require.Len(t, images, 1)
+	assert.Equal(t, `a&b "<thumbs>"/sprite_$Number%03d$.jpg`, images[0].SegmentTemplate.Media)
+	assert.NoError(t, VerifyThumbnails(mpdPath))
+}
+
+func TestSheetTemplate(t *testing.T) {
+	tmpl, err := sheetTemplate("sprite_001.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, "sprite_$Number%03d$.jpg", tmpl)
+	assert.Equal(t, "sprite_012.jpg", expandNumber(tmpl, 12))
+	assert.Equal(t, "tile_7.jpg", expandNumber("tile_$Number$.jpg", 7))
+
+	_, err = sheetTemplate("sprite.jpg")
+	assert.Error(t, err)
+}
+
+// Test 18: Thumbnail tiles from the thumbnail image in the packager's MPD
+func TestShakaPackager_ThumbnailAdaptationSet(t *testing.T) {
+	// Given: A test video packaged for DASH
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	ctx := context.Background()
+	res, err := Run(ctx, Job{
+		Streams: []Stream{
+			{Input: absPath, Selector: "audio", Output: "audio.mp4"},
+			{Input: absPath, Selector: "video", Output: "video.mp4"},
+		},
+		OutputDir: outputPath,
+		MPDOutput: "manifest.mpd",
+	})
+	if res != nil {
+		t.Log("Shaka Packager output:", res.Stderr)
+	}
+	require.NoError(t, err)
+
+	// When: Rendering sprite sheets and injecting them into the MPD
+	sheetDir := filepath.Join(outputPath, "thumbnails")
+	require.NoError(t, os.Mkdir(sheetDir, 0o755))
+	sb, err := thumbnail.GenerateStoryboard(ctx, absPath, thumbnail.StoryboardOptions{
+		OutputDir: sheetDir,
+		Interval:  5,
+	})
+	require.NoError(t, err)
+	require.NoError(t, InjectThumbnails(filepath.Join(outputPath, "manifest.mpd"), sb, sheetDir))
+
+	// Then: The MPD still describes the packaged streams
+	mpd, err := manifest.ReadMPD(filepath.Join(outputPath, "manifest.mpd"))
+	require.NoError(t, err)
+	assert.Len(t, mpd.AdaptationSetsByType("video"), 1)
+	assert.Len(t, mpd.AdaptationSetsByType("audio"), 1)
+
+	// Then: The thumbnail tiles are timed to cover the presentation
+	require.NoError(t, VerifyThumbnails(filepath.Join(outputPath, "manifest.mpd")))
+	images := mpd.AdaptationSetsByType("image")
+	require.Len(t, images, 1)
+	cols, rows, ok := images[0].Representations[0].ThumbnailTile()
+	require.True(t, ok)
+	assert.Equal(t, sb.Columns, cols)
+	assert.Equal(t, sb.Rows, rows)
+}
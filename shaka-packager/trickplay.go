@@ -0,0 +1,164 @@
+package shakapackager
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/veloxpack/tools/internal/mp4"
+	"github.com/veloxpack/tools/manifest"
+)
+
+// DASHTrickModeScheme is the EssentialProperty scheme of a DASH trick play
+// adaptation set; its value is the id of the main adaptation set.
+const DASHTrickModeScheme = "http://dashif.org/guidelines/trickmode"
+
+// TrickPlay returns a trick play stream derived from rendition s: the same
+// input and selector, keeping every factor-th key frame. Output names get a
+// "_trick<factor>" suffix. In DASH the stream becomes a trick mode
+// adaptation set; in HLS it is listed as an EXT-X-I-FRAME-STREAM-INF.
+func (s Stream) TrickPlay(factor int) Stream {
+	suffix := "_trick" + strconv.Itoa(factor)
+	return Stream{
+		Input:           s.Input,
+		Selector:        s.Selector,
+		Output:          withSuffix(s.Output, suffix),
+		InitSegment:     withSuffix(s.InitSegment, suffix),
+		SegmentTemplate: withSuffix(s.SegmentTemplate, suffix),
+		PlaylistName:    withSuffix(s.PlaylistName, suffix),
+		DRMLabel:        s.DRMLabel,
+		SkipEncryption:  s.SkipEncryption,
+		TrickPlayFactor: factor,
+	}
+}
+
+func withSuffix(name, suffix string) string {
+	if name == "" {
+		return ""
+	}
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + suffix + ext
+}
+
+// VerifyIFramePlaylist checks that every byte range of an HLS I-frame
+// playlist locates a key frame: the first sample starting within the range
+// must end inside it, be a sync sample and contain an H.264 IDR NAL unit.
+// Segment URIs are resolved relative to the playlist.
+func VerifyIFramePlaylist(playlistPath string) error {
+	p, err := manifest.ReadMediaPlaylist(playlistPath)
+	if err != nil {
+		return err
+	}
+	if !p.IFramesOnly {
+		return fmt.Errorf("%s: missing EXT-X-I-FRAMES-ONLY", playlistPath)
+	}
+	if len(p.Segments) == 0 {
+		return fmt.Errorf("%s: no I-frames", playlistPath)
+	}
+
+	// Segmented output keeps the avcC in the EXT-X-MAP initialization
+	// section; single files carry their own.
+	lengthSize := 4
+	if p.Map != nil {
+		initBoxes, err := mp4.ReadFile(filepath.Join(filepath.Dir(playlistPath), filepath.FromSlash(p.Map.URI)))
+		if err != nil {
+			return err
+		}
+		if size, ok := nalLengthSize(initBoxes); ok {
+			lengthSize = size
+		}
+	}
+
+	media := map[string]*iframeMedia{}
+	for i, seg := range p.Segments {
+		if seg.ByteRange == nil {
+			return fmt.Errorf("%s: I-frame %d has no byte range", playlistPath, i)
+		}
+		file := filepath.Join(filepath.Dir(playlistPath), filepath.FromSlash(seg.URI))
+		m, ok := media[file]
+		if !ok {
+			if m, err = readIFrameMedia(file, lengthSize); err != nil {
+				return err
+			}
+			media[file] = m
+		}
+		if err := m.checkKeyFrame(*seg.ByteRange); err != nil {
+			return fmt.Errorf("%s: I-frame %d (%s @%d): %w", playlistPath, i, seg.URI, seg.ByteRange.Offset, err)
+		}
+	}
+	return nil
+}
+
+type iframeMedia struct {
+	data       []byte
+	samples    []mp4.Sample
+	lengthSize int
+}
+
+func readIFrameMedia(file string, lengthSize int) (*iframeMedia, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	boxes, err := mp4.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if size, ok := nalLengthSize(boxes); ok {
+		lengthSize = size
+	}
+	samples, err := mp4.FragmentSamples(boxes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return &iframeMedia{data: data, samples: samples, lengthSize: lengthSize}, nil
+}
+
+// nalLengthSize reads the NAL length size from the avcC box, if boxes hold
+// one.
+func nalLengthSize(boxes []*mp4.Box) (int, bool) {
+	avcc := mp4.FindAll(boxes, "avcC")
+	if len(avcc) == 0 {
+		return 0, false
+	}
+	cfg, err := mp4.ParseAVCC(avcc[0])
+	if err != nil {
+		return 0, false
+	}
+	return cfg.NALLengthSize, true
+}
+
+func (m *iframeMedia) checkKeyFrame(r manifest.ByteRange) error {
+	end := r.Offset + r.Length
+	if end > int64(len(m.data)) {
+		return fmt.Errorf("range ends at %d beyond the file size %d", end, len(m.data))
+	}
+	for _, s := range m.samples {
+		if s.Offset < r.Offset {
+			continue
+		}
+		if s.Offset >= end {
+			break
+		}
+		if s.Offset+s.Size > end {
+			return fmt.Errorf("key frame sample at %d is cut off by the range end %d", s.Offset, end)
+		}
+		if !s.Sync {
+			return fmt.Errorf("sample at %d is not a sync sample", s.Offset)
+		}
+		types, err := mp4.AVCNALTypes(m.data[s.Offset:s.Offset+s.Size], m.lengthSize)
+		if err != nil {
+			return err
+		}
+		for _, t := range types {
+			if t == mp4.NALIDR {
+				return nil
+			}
+		}
+		return fmt.Errorf("sample at %d has NAL types %v, no IDR", s.Offset, types)
+	}
+	return fmt.Errorf("no sample starts within the range")
+}
@@ -0,0 +1,176 @@
+package shakapackager
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/tools/internal/mp4"
+	"github.com/veloxpack/tools/manifest"
+)
+
+func testBox(typ string, payload ...[]byte) []byte {
+	var body []byte
+	for _, p := range payload {
+		body = append(body, p...)
+	}
+	out := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(out, uint32(8+len(body)))
+	copy(out[4:], typ)
+	return append(out, body...)
+}
+
+// testFragment builds a moof+mdat holding one sync sample made of the
+// given H.264 NAL unit types.
+func testFragment(nalTypes ...byte) []byte {
+	var sample []byte
+	for _, typ := range nalTypes {
+		sample = binary.BigEndian.AppendUint32(sample, 8)
+		sample = append(sample, 0x60|typ, 0, 0, 0, 0, 0, 0, 0)
+	}
+	u32 := func(vs ...uint32) []byte {
+		var b []byte
+		for _, v := range vs {
+			b = binary.BigEndian.AppendUint32(b, v)
+		}
+		return b
+	}
+	moof := func(dataOffset uint32) []byte {
+		return testBox("moof", testBox("traf",
+			testBox("tfhd", u32(0x020000, 1)),
+			testBox("trun", u32(0x000201, 1, dataOffset, uint32(len(sample)))),
+		))
+	}
+	m := moof(0)
+	m = moof(uint32(len(m) + 8))
+	return append(m, testBox("mdat", sample)...)
+}
+
+func TestStream_TrickPlay(t *testing.T) {
+	// Given: A segmented HLS/DASH video rendition
+	video := Stream{
+		Input:              "/media/title/main.mp4",
+		Selector:           "video",
+		InitSegment:        "video_init.mp4",
+		SegmentTemplate:    "video_$Number$.m4s",
+		PlaylistName:       "video.m3u8",
+		IFramePlaylistName: "video_iframe.m3u8",
+	}
+
+	// When: Deriving a trick play stream
+	trick := video.TrickPlay(4)
+
+	// Then: Outputs are renamed and the I-frame playlist is not duplicated
+	assert.Equal(t, "video_init_trick4.mp4", trick.InitSegment)
+	assert.Equal(t, "video_$Number$_trick4.m4s", trick.SegmentTemplate)
+	assert.Equal(t, "video_trick4.m3u8", trick.PlaylistName)
+	assert.Empty(t, trick.IFramePlaylistName)
+	assert.Contains(t, trick.String(), ",trick_play_factor=4")
+	assert.Contains(t, video.String(), ",iframe_playlist_name=video_iframe.m3u8")
+}
+
+func TestVerifyIFramePlaylist(t *testing.T) {
+	// Given: A file with an IDR fragment followed by a non-IDR fragment
+	dir := t.TempDir()
+	idr := testFragment(mp4.NALSPS, mp4.NALPPS, mp4.NALIDR)
+	nonIDR := testFragment(mp4.NALSlice)
+	ftyp := testBox("ftyp", []byte("iso6"))
+	data := append(append(append([]byte{}, ftyp...), idr...), nonIDR...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "video.mp4"), data, 0o644))
+
+	write := func(name string, offset, length int) string {
+		playlist := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-TARGETDURATION:10\n#EXT-X-I-FRAMES-ONLY\n"+
+			"#EXTINF:10.000,\n#EXT-X-BYTERANGE:%d@%d\nvideo.mp4\n#EXT-X-ENDLIST\n", length, offset)
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(playlist), 0o644))
+		return path
+	}
+
+	// Then: A range over the IDR fragment verifies
+	assert.NoError(t, VerifyIFramePlaylist(write("good.m3u8", len(ftyp), len(idr))))
+
+	// Then: A range over the non-IDR fragment is rejected
+	err := VerifyIFramePlaylist(write("slice.m3u8", len(ftyp)+len(idr), len(nonIDR)))
+	assert.ErrorContains(t, err, "no IDR")
+
+	// Then: A range that cuts the key frame short is rejected
+	err = VerifyIFramePlaylist(write("short.m3u8", len(ftyp), len(idr)-4))
+	assert.ErrorContains(t, err, "cut off")
+}
+
+// Test 17: DASH trick play representations and HLS I-frame playlists
+func TestShakaPackager_TrickPlay(t *testing.T) {
+	// Given: A test video file
+	absPath, err := filepath.Abs(filepath.Join("..", "testdata", "sample.mp4"))
+	require.NoError(t, err)
+
+	outputPath := createTempDir(t)
+	defer cleanupFiles(t, outputPath)
+
+	video := Stream{
+		Input:              absPath,
+		Selector:           "video",
+		Output:             "video.mp4",
+		PlaylistName:       "video.m3u8",
+		IFramePlaylistName: "video_iframe.m3u8",
+	}
+
+	// When: Packaging the rendition with a trick play stream at factor 4
+	res, err := Run(context.Background(), Job{
+		Streams: []Stream{
+			{Input: absPath, Selector: "audio", Output: "audio.mp4", PlaylistName: "audio.m3u8"},
+			video,
+			video.TrickPlay(4),
+		},
+		OutputDir:               outputPath,
+		MPDOutput:               "manifest.mpd",
+		HLSMasterPlaylistOutput: "master.m3u8",
+	})
+	if res != nil {
+		t.Log("Shaka Packager output:", res.Stderr)
+	}
+	require.NoError(t, err)
+	verifyFileSize(t, filepath.Join(outputPath, "video_trick4.mp4"), 1000)
+
+	// Then: The MPD has a trick mode adaptation set pointing at the main video
+	mpd, err := manifest.ReadMPD(filepath.Join(outputPath, "manifest.mpd"))
+	require.NoError(t, err)
+
+	var main, trick *manifest.AdaptationSet
+	sets := mpd.AdaptationSetsByType("video")
+	for i := range sets {
+		isTrick := false
+		for _, p := range sets[i].EssentialProperties {
+			if p.SchemeIDURI == DASHTrickModeScheme {
+				isTrick = true
+			}
+		}
+		if isTrick {
+			trick = &sets[i]
+		} else {
+			main = &sets[i]
+		}
+	}
+	require.NotNil(t, main, "main video adaptation set")
+	require.NotNil(t, trick, "trick mode adaptation set")
+	assert.Equal(t, main.ID, trick.EssentialProperties[0].Value)
+	require.NotEmpty(t, trick.Representations)
+	assert.Equal(t, "4", trick.Representations[0].MaxPlayoutRate)
+
+	// Then: The master playlist lists the I-frame playlist
+	master, err := manifest.ReadMasterPlaylist(filepath.Join(outputPath, "master.m3u8"))
+	require.NoError(t, err)
+	var uris []string
+	for _, s := range master.IFrameStreams {
+		uris = append(uris, s.URI)
+	}
+	assert.Contains(t, uris, "video_iframe.m3u8")
+
+	// Then: Every I-frame byte range points at an IDR frame
+	require.NoError(t, VerifyIFramePlaylist(filepath.Join(outputPath, "video_iframe.m3u8")))
+}